@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"stag/codegen/rust16vm/asm"
+	"strings"
+)
+
+func runAsm(args []string) error {
+	flags := flag.NewFlagSet("asm", flag.ExitOnError)
+	output := flags.String("o", "", "output file, the input with the .bin extension by default")
+	raw := flags.Bool("raw", false, "write the bare words without the header")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: stag asm [-o file] [-raw] file.s")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("asm: expected one input file")
+	}
+	input := flags.Arg(0)
+
+	src, err := os.ReadFile(input)
+	if err != nil {
+		return err
+	}
+
+	img, err := asm.Assemble(string(src))
+	if err != nil {
+		return asmErrors(input, err)
+	}
+
+	if *output == "" {
+		*output = strings.TrimSuffix(input, ".s") + ".bin"
+	}
+	return writeImage(img, *output, *raw)
+}
+
+// asmErrors prints the errors of the assembler sorted by line
+// and prefixed with the file, the labels are only resolved at
+// the end so their errors come after the others
+func asmErrors(file string, err error) error {
+	var errs []*asm.Error
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	for _, one := range joined.Unwrap() {
+		var e *asm.Error
+		if !errors.As(one, &e) {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		errs = append(errs, e)
+	}
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Line < errs[j].Line })
+
+	lines := make([]string, 0, len(errs))
+	for _, e := range errs {
+		lines = append(lines, fmt.Sprintf("%s:%d: %s", file, e.Line, e.Err))
+	}
+	return errors.New(strings.Join(lines, "\n"))
+}
+
+func writeImage(img *asm.Image, path string, raw bool) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if raw {
+		err = img.WriteRaw(out)
+	} else {
+		err = img.WriteBinary(out)
+	}
+	return errors.Join(err, out.Close())
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"stag/lexer"
+	"stag/pratt_parser"
+	"stag/pratt_parser/ast"
+)
+
+func runAst(args []string) error {
+	flags := flag.NewFlagSet("ast", flag.ExitOnError)
+	format := flags.String("format", "sexp", "output format, json or sexp")
+	positions := flags.Bool("pos", true, "keep the token positions in the sexp output")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: stag ast [-format=json|sexp] [-pos=false] [file.el]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() > 1 {
+		flags.Usage()
+		return fmt.Errorf("ast: expected at most one input file")
+	}
+	file, src, err := readInput(flags)
+	if err != nil {
+		return err
+	}
+
+	p := pratt_parser.New(lexer.New(src))
+	program := p.ParseProgram()
+	if errs := p.ErrorList(); len(errs) > 0 {
+		return fileErrors(file, errs)
+	}
+
+	switch *format {
+	case "json":
+		data, err := ast.EncodeJSON(program)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	case "sexp":
+		fmt.Println(ast.EncodeSexp(program, *positions))
+	default:
+		return fmt.Errorf("ast: unknown format %q", *format)
+	}
+	return nil
+}
+
+// readInput reads the file given to the command, or the standard
+// input without one
+func readInput(flags *flag.FlagSet) (file string, src string, err error) {
+	if flags.NArg() == 0 {
+		data, err := io.ReadAll(os.Stdin)
+		return "<stdin>", string(data), err
+	}
+	data, err := os.ReadFile(flags.Arg(0))
+	return flags.Arg(0), string(data), err
+}
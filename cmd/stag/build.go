@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"stag/codegen"
+	_ "stag/codegen/rust16vm/asm"
+	_ "stag/codegen/stack"
+	"stag/frontend"
+	"stag/ir"
+	"strings"
+)
+
+// compile runs the front end over the source of a file, lowers
+// it to IR and optimizes it with the passes, with none it is left
+// as lowered. The errors are prefixed with the file name
+func compile(file string, src string, passes []ir.Pass) (*ir.Program, error) {
+	program, info, errs := frontend.Source(src, frontend.Options{})
+	if len(errs) > 0 {
+		return nil, fileErrors(file, errs)
+	}
+
+	code := ir.Lower(program, info)
+	if passes == nil {
+		return code, nil
+	}
+	if err := ir.Optimize(code, passes...); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return code, nil
+}
+
+// fileErrors prints each error at its position in the file
+func fileErrors[E error](file string, errs []E) error {
+	lines := make([]string, 0, len(errs))
+	for _, err := range errs {
+		lines = append(lines, file+":"+err.Error())
+	}
+	return errors.New(strings.Join(lines, "\n"))
+}
+
+// optimizations returns the passes of the level set, or the ones
+// of the list when it is not empty. The inliner takes the estimates
+// of the backend when it has them
+func optimizations(levels map[string]*bool, list string, backend codegen.Backend) ([]ir.Pass, error) {
+	var costs ir.CostModel
+	if e, ok := backend.(codegen.Estimator); ok {
+		costs = e.Costs()
+	}
+	if list != "" {
+		return ir.Pipeline(list, costs)
+	}
+	level := ""
+	for name, set := range levels {
+		if !*set {
+			continue
+		}
+		if level != "" {
+			return nil, errors.New("build: more than one optimization level")
+		}
+		level = name
+	}
+	if level == "" {
+		level = ir.DefaultLevel
+	}
+	return ir.Levels(costs)[level], nil
+}
+
+func runBuild(args []string) error {
+	flags := flag.NewFlagSet("build", flag.ExitOnError)
+	target := flags.String("target", codegen.Default, "the target to compile for, one of "+strings.Join(codegen.Names(), ", "))
+	output := flags.String("o", "", "output file, the input with the extension of the target or .s by default")
+	assembly := flags.Bool("S", false, "write the assembly instead of the binary")
+	debug := flags.Bool("g", false, "keep the source lines in the output")
+	raw := flags.Bool("raw", false, "write the bare words without the header")
+	stats := flags.Bool("stats", false, "print what the register allocator did in each function")
+	levels := map[string]*bool{
+		"O0": flags.Bool("O0", false, "compile the program as it was lowered"),
+		"O1": flags.Bool("O1", false, "propagate the constants, drop the dead code and turn the tail calls into jumps, the default"),
+		"O2": flags.Bool("O2", false, "also inline the calls, number the values, drop the dead stores and thread the jumps"),
+		"Os": flags.Bool("Os", false, "like -O2 but without growing the code"),
+	}
+	list := flags.String("passes", "", "comma separated passes to run instead of the ones of the level, of "+strings.Join(ir.PassNames(), ", "))
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: stag build [-target name] [-o file] [-S] [-g] [-raw] [-stats] [-O0|-O1|-O2|-Os] [-passes list] file.el")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("build: expected one input file")
+	}
+	input := flags.Arg(0)
+
+	src, err := os.ReadFile(input)
+	if err != nil {
+		return err
+	}
+	backend, err := codegen.Lookup(*target)
+	if err != nil {
+		return err
+	}
+	passes, err := optimizations(levels, *list, backend)
+	if err != nil {
+		return err
+	}
+	program, err := compile(input, string(src), passes)
+	if err != nil {
+		return err
+	}
+	artifact, err := backend.Compile(program, codegen.Options{Debug: *debug, File: input, Raw: *raw})
+	if err != nil {
+		return fmt.Errorf("%s: %w", input, err)
+	}
+	if *stats {
+		if r, ok := artifact.(codegen.Reporter); ok {
+			fmt.Fprint(os.Stderr, r.Report())
+		} else {
+			fmt.Fprintf(os.Stderr, "%s: the target %s has no report\n", input, backend.Name())
+		}
+	}
+
+	base := strings.TrimSuffix(input, ".el")
+	if *assembly {
+		if *output == "" {
+			*output = base + ".s"
+		}
+		return os.WriteFile(*output, []byte(artifact.Assembly()), 0o644)
+	}
+
+	if *output == "" {
+		*output = base + backend.Ext()
+	}
+	out, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if _, err := artifact.WriteTo(out); err != nil {
+		out.Close()
+		return fmt.Errorf("%s: %w", input, err)
+	}
+	return out.Close()
+}
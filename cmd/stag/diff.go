@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is how many unchanged lines surround a change
+const diffContext = 3
+
+type edit struct {
+	op   byte // ' ', '-' or '+'
+	line string
+}
+
+// lineDiff returns the edits turning a into b, found with the
+// longest common subsequence of their lines
+func lineDiff(a, b []string) []edit {
+	// lcs[i][j] is the length of the subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var edits []edit
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			edits = append(edits, edit{' ', a[i]})
+			i++
+			j++
+		case j == len(b) || i < len(a) && lcs[i+1][j] >= lcs[i][j+1]:
+			edits = append(edits, edit{'-', a[i]})
+			i++
+		default:
+			edits = append(edits, edit{'+', b[j]})
+			j++
+		}
+	}
+	return edits
+}
+
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// unifiedDiff prints the changes from a to b in the unified
+// format, empty when they are the same
+func unifiedDiff(file, a, b string) string {
+	edits := lineDiff(splitLines(a), splitLines(b))
+
+	// the edits close enough to a change go in a hunk
+	keep := make([]bool, len(edits))
+	changed := false
+	for i, e := range edits {
+		if e.op == ' ' {
+			continue
+		}
+		changed = true
+		for k := max(0, i-diffContext); k <= min(len(edits)-1, i+diffContext); k++ {
+			keep[k] = true
+		}
+	}
+
+	var out strings.Builder
+	if changed {
+		fmt.Fprintf(&out, "--- a/%s\n+++ b/%s\n", file, file)
+	}
+
+	oldLine, newLine := 1, 1
+	for i := 0; i < len(edits); {
+		if !keep[i] {
+			oldLine++
+			newLine++
+			i++
+			continue
+		}
+
+		end := i
+		oldLen, newLen := 0, 0
+		for ; end < len(edits) && keep[end]; end++ {
+			if edits[end].op != '+' {
+				oldLen++
+			}
+			if edits[end].op != '-' {
+				newLen++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(oldLine, oldLen), hunkRange(newLine, newLen))
+
+		for ; i < end; i++ {
+			line := edits[i].line
+			if !strings.HasSuffix(line, "\n") {
+				line += "\n\\ No newline at end of file\n"
+			}
+			out.WriteString(string(edits[i].op) + line)
+		}
+		oldLine += oldLen
+		newLine += newLen
+	}
+	return out.String()
+}
+
+// hunkRange is the start and length of a hunk side, an empty
+// side starts at the line before it
+func hunkRange(start, length int) string {
+	if length == 0 {
+		return fmt.Sprintf("%d,0", start-1)
+	}
+	return fmt.Sprintf("%d,%d", start, length)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	a := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj"
+	b := "a\nB\nc\nd\ne\nf\ng\nh\ni\nJ\nk\n"
+
+	require.Equal(t, `--- a/x.el
++++ b/x.el
+@@ -1,5 +1,5 @@
+ a
+-b
++B
+ c
+ d
+ e
+@@ -7,4 +7,5 @@
+ g
+ h
+ i
+-j
+\ No newline at end of file
++J
++k
+`, unifiedDiff("x.el", a, b))
+
+	require.Equal(t, "", unifiedDiff("x.el", a, a))
+	require.Equal(t, "--- a/x.el\n+++ b/x.el\n@@ -0,0 +1,1 @@\n+a\n", unifiedDiff("x.el", "", "a\n"))
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"stag/codegen/rust16vm"
+	"stag/dot"
+	"stag/ir"
+	"stag/lexer"
+	"stag/pratt_parser"
+)
+
+func runDot(args []string) error {
+	flags := flag.NewFlagSet("dot", flag.ExitOnError)
+	what := flags.String("what", "ast", "graph to render, ast, cfg or callgraph")
+	regs := flags.Bool("regs", false, "show where rust16vm keeps the registers of the cfg")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: stag dot [-what=ast|cfg|callgraph] [-regs] [file.el]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() > 1 {
+		flags.Usage()
+		return errors.New("dot: expected at most one input file")
+	}
+	file, src, err := readInput(flags)
+	if err != nil {
+		return err
+	}
+
+	p := pratt_parser.New(lexer.New(src))
+	program := p.ParseProgram()
+	if errs := p.ErrorList(); len(errs) > 0 {
+		return fileErrors(file, errs)
+	}
+
+	switch *what {
+	case "ast":
+		fmt.Print(dot.AST(program))
+	case "cfg", "callgraph":
+		code, err := compile(file, src, ir.Levels(rust16vm.RV16)[ir.DefaultLevel])
+		if err != nil {
+			return err
+		}
+		if *what == "callgraph" {
+			fmt.Print(dot.CallGraph(program, code))
+			return nil
+		}
+		var assignment dot.Assignment
+		if *regs {
+			assignment = rust16vm.Assignment(code)
+		}
+		fmt.Print(dot.CFG(code, assignment))
+	default:
+		return fmt.Errorf("dot: unknown graph %q", *what)
+	}
+	return nil
+}
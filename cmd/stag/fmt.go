@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"stag/format"
+	"strings"
+)
+
+func runFmt(args []string) error {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	list := flags.Bool("l", false, "list the files whose formatting differs")
+	diff := flags.Bool("d", false, "print the diffs of the files whose formatting differs")
+	write := flags.Bool("w", false, "write the result back to the files")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: stag fmt [-l] [-d] [-w] [file.el ...]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		if *write {
+			return errors.New("fmt: can not write back the standard input")
+		}
+		src, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		return formatFile("<stdin>", string(src), *list, *diff, false)
+	}
+
+	var errs []error
+	for _, file := range flags.Args() {
+		src, err := os.ReadFile(file)
+		if err == nil {
+			err = formatFile(file, string(src), *list, *diff, *write)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// positioned prefixes each of the joined errors with the file,
+// they start with their position
+func positioned(file string, err error) error {
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	errs := joined.Unwrap()
+	lines := make([]string, 0, len(errs))
+	for _, err := range errs {
+		lines = append(lines, file+":"+err.Error())
+	}
+	return errors.New(strings.Join(lines, "\n"))
+}
+
+// formatFile prints the formatted source unless one of the
+// modes is set, they only act on the files that change
+func formatFile(file, src string, list, diff, write bool) error {
+	out, err := format.Source(src)
+	if err != nil {
+		return positioned(file, err)
+	}
+
+	if !list && !diff && !write {
+		fmt.Print(out)
+		return nil
+	}
+	if out == src {
+		return nil
+	}
+
+	if list {
+		fmt.Println(file)
+	}
+	if diff {
+		fmt.Print(unifiedDiff(file, src, out))
+	}
+	if write {
+		return os.WriteFile(file, []byte(out), 0o644)
+	}
+	return nil
+}
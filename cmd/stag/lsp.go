@@ -0,0 +1,18 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"stag/lsp"
+)
+
+func runLsp(args []string) error {
+	flags := flag.NewFlagSet("lsp", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: stag lsp\n\nspeaks the language server protocol over stdin and stdout")
+	}
+	flags.Parse(args)
+
+	return lsp.NewServer(os.Stdin, os.Stdout).Serve()
+}
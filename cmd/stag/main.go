@@ -1,5 +1,57 @@
 package main
 
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// command is a stag subcommand, it gets the
+// arguments that follow its name
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands = map[string]command{
+	"asm":     {"assemble rust16vm assembly into a binary", runAsm},
+	"ast":     {"print the syntax tree of a program", runAst},
+	"build":   {"compile a program for one of the targets", runBuild},
+	"dot":     {"render the tree, control flow or call graph in DOT", runDot},
+	"fmt":     {"format programs in the canonical layout", runFmt},
+	"lsp":     {"run the language server over stdio", runLsp},
+	"objdump": {"disassemble a rust16vm binary", runObjdump},
+	"repl":    {"evaluate programs interactively", runRepl},
+}
+
 func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "stag: unknown command %q\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+
+	if err := cmd.run(os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
+	fmt.Fprintln(os.Stderr, "usage: stag <command> [arguments]\n\ncommands:")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].usage)
+	}
 }
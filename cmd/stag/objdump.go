@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"stag/codegen/rust16vm/asm"
+	"stag/codegen/rust16vm/disasm"
+	"strings"
+)
+
+func runObjdump(args []string) error {
+	flags := flag.NewFlagSet("objdump", flag.ExitOnError)
+	raw := flags.Bool("raw", false, "the input holds the bare words without the header")
+	origin := flags.Uint("origin", 0, "address a raw input is loaded at")
+	source := flags.Bool("source", true, "interleave the source lines when the binary has debug info")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: stag objdump [-raw [-origin addr]] [-source=false] file.bin")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("objdump: expected one input file")
+	}
+
+	in, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	var img *asm.Image
+	if *raw {
+		img, err = asm.ReadRaw(in, uint16(*origin))
+	} else {
+		img, err = asm.ReadBinary(in)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", flags.Arg(0), err)
+	}
+
+	opts := disasm.Options{Addresses: true}
+	if *source && img.File != "" {
+		// like binutils the source is read from where it was
+		// compiled, the listing goes on without it when it moved
+		if src, err := os.ReadFile(img.File); err == nil {
+			opts.Source = strings.Split(string(src), "\n")
+		} else {
+			fmt.Fprintf(os.Stderr, "objdump: %v\n", err)
+		}
+	}
+
+	fmt.Print(disasm.Disassemble(img, opts))
+	return nil
+}
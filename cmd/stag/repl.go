@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"stag/repl"
+)
+
+func runRepl(args []string) error {
+	flags := flag.NewFlagSet("repl", flag.ExitOnError)
+	history := flags.String("history", defaultHistory(), "file keeping the inputs across sessions, empty to disable")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: stag repl [-history file]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	// a pipe runs the inputs as a script, without prompts or history
+	opts := repl.Options{}
+	if stat, err := os.Stdin.Stat(); err == nil && stat.Mode()&os.ModeCharDevice != 0 {
+		opts.Interactive = true
+		opts.HistoryFile = *history
+		fmt.Println("stag repl, :help lists the commands")
+	}
+
+	return repl.Start(os.Stdin, os.Stdout, opts)
+}
+
+func defaultHistory() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".stag_history")
+}
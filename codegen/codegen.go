@@ -0,0 +1,83 @@
+package codegen
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"stag/ir"
+	"strings"
+)
+
+// Backend translates programs lowered to IR to the code of a target
+type Backend interface {
+	// Name selects the backend, stag build --target=name
+	Name() string
+	// Ext is the extension of the binaries of the target
+	Ext() string
+	Compile(program *ir.Program, opts Options) (Artifact, error)
+}
+
+// Options tune what a backend produces
+type Options struct {
+	// Debug keeps the lines of File in the output
+	Debug bool
+	File  string
+	// Raw leaves the header out of the binary
+	Raw bool
+}
+
+// Artifact is a program compiled for a target
+type Artifact interface {
+	// Assembly is the text form of the program
+	Assembly() string
+	// WriteTo writes the binary form of the program
+	io.WriterTo
+	// Run executes the program for at most maxSteps instructions,
+	// the result is what main returns as a 16 bit word
+	Run(maxSteps int) (uint16, error)
+}
+
+// Default is the target when none is given
+const Default = "rust16vm"
+
+var backends = map[string]Backend{}
+
+// Register makes a backend available by its name, the targets
+// register themselves when their package is imported
+func Register(b Backend) {
+	if _, dup := backends[b.Name()]; dup {
+		panic("codegen: Register called twice for " + b.Name())
+	}
+	backends[b.Name()] = b
+}
+
+// Lookup returns the backend registered with name
+func Lookup(name string) (Backend, error) {
+	b, ok := backends[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown target %q, the targets are %s", name, strings.Join(Names(), ", "))
+	}
+	return b, nil
+}
+
+// Names returns the names of the registered backends in order
+func Names() []string {
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Reporter is an Artifact that tells how its code was produced,
+// stag build -stats prints the report of the targets that have one
+type Reporter interface {
+	Report() string
+}
+
+// Estimator is a Backend that estimates the cost of the code it
+// makes of the IR, the inliner weighs the calls with the estimates
+type Estimator interface {
+	Costs() ir.CostModel
+}
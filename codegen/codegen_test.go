@@ -0,0 +1,23 @@
+package codegen_test
+
+import (
+	"stag/codegen"
+	"stag/codegen/rust16vm/asm"
+	_ "stag/codegen/stack"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	require.Equal(t, []string{"rust16vm", "stack"}, codegen.Names())
+
+	b, err := codegen.Lookup(codegen.Default)
+	require.NoError(t, err)
+	require.Equal(t, codegen.Default, b.Name())
+
+	_, err = codegen.Lookup("x86")
+	require.EqualError(t, err, `unknown target "x86", the targets are rust16vm, stack`)
+
+	require.Panics(t, func() { codegen.Register(asm.Backend{}) })
+}
@@ -0,0 +1,439 @@
+package asm
+
+import (
+	"errors"
+	"fmt"
+	"stag/codegen/rust16vm"
+	"strconv"
+	"strings"
+)
+
+var (
+	errSyntax             = errors.New("syntax error")
+	errUnknownInstruction = errors.New("unknown instruction")
+	errImmediateRange     = errors.New("out of range")
+	errUndefinedLabel     = errors.New("undefined label")
+	errDuplicateLabel     = errors.New("label redefined")
+	errOverlap            = errors.New("address assigned twice")
+)
+
+// Error is a problem found at a line of the assembly source
+type Error struct {
+	Line int
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// word is an element of the image before the labels are known,
+// either an instruction or a value given by a directive
+type word struct {
+	line  int
+	addr  uint16
+	instr *rust16vm.Instr
+	value int
+	label string // a .word naming a label stores its address
+	src   int    // source line set by .line
+}
+
+type assembler struct {
+	symbols map[string]uint16
+	words   []word
+	used    map[uint16]bool
+	pc      int
+	line    int
+	errs    []error
+
+	file    string
+	srcLine int
+}
+
+func (a *assembler) errorf(err error, format string, args ...any) {
+	a.errs = append(a.errs, &Error{Line: a.line, Err: fmt.Errorf("%w: %s", err, fmt.Sprintf(format, args...))})
+}
+
+// Assemble parses rust16vm assembly and encodes it. A line holds
+// an optional label followed by an instruction or a directive:
+//
+//	.org 256          the next word goes at address 256
+//	.word 1, -2, l    one word per value, labels give their address
+//	.string "hi\n"    one word per byte followed by a zero word
+//	.file "add.el"    the source the program was compiled from
+//	.line 3           the next words come from line 3, 0 for none
+//
+// Text after ; is a comment. Every error is reported together
+// with the line it was found at
+func Assemble(src string) (*Image, error) {
+	a := &assembler{symbols: map[string]uint16{}, used: map[uint16]bool{}}
+
+	for i, text := range strings.Split(src, "\n") {
+		a.line = i + 1
+		a.parseLine(text)
+	}
+
+	img := a.encode()
+	if len(a.errs) > 0 {
+		return nil, errors.Join(a.errs...)
+	}
+	return img, nil
+}
+
+func (a *assembler) parseLine(text string) {
+	if idx := strings.Index(text, ";"); idx >= 0 && !strings.Contains(text[:idx], `"`) {
+		text = text[:idx]
+	}
+	text = strings.TrimSpace(text)
+
+	if name, rest, ok := strings.Cut(text, ":"); ok && isIdent(name) {
+		if _, defined := a.symbols[name]; defined {
+			a.errorf(errDuplicateLabel, "%s", name)
+		}
+		a.symbols[name] = uint16(a.pc)
+		text = strings.TrimSpace(rest)
+	}
+
+	if text == "" {
+		return
+	}
+
+	mnemonic, operands, _ := strings.Cut(text, " ")
+	operands = strings.TrimSpace(operands)
+
+	switch strings.ToLower(mnemonic) {
+	case ".org":
+		value, err := parseNumber(operands)
+		if err != nil || value < 0 || value > 0xFFFF {
+			a.errorf(errSyntax, "invalid address %q", operands)
+			return
+		}
+		a.pc = value
+	case ".word":
+		for _, operand := range splitOperands(operands) {
+			if isIdent(operand) {
+				a.emit(word{label: operand})
+				continue
+			}
+			value, err := parseNumber(operand)
+			if err != nil {
+				a.errorf(errSyntax, "invalid word %q", operand)
+				continue
+			}
+			if value < -0x8000 || value > 0xFFFF {
+				a.errorf(errImmediateRange, "word %d, want -32768 to 65535", value)
+				continue
+			}
+			a.emit(word{value: value})
+		}
+	case ".file":
+		file, err := strconv.Unquote(operands)
+		if err != nil {
+			a.errorf(errSyntax, "invalid file name %s", operands)
+			return
+		}
+		a.file = file
+	case ".line":
+		value, err := parseNumber(operands)
+		if err != nil || value < 0 || value > 0xFFFF {
+			a.errorf(errSyntax, "invalid line %q", operands)
+			return
+		}
+		a.srcLine = value
+	case ".string":
+		s, err := strconv.Unquote(operands)
+		if err != nil {
+			a.errorf(errSyntax, "invalid string %s", operands)
+			return
+		}
+		for _, b := range []byte(s) {
+			a.emit(word{value: int(b)})
+		}
+		a.emit(word{value: 0})
+	default:
+		instr, err := parseInstr(strings.ToUpper(mnemonic), splitOperands(operands))
+		if err != nil {
+			a.errs = append(a.errs, &Error{Line: a.line, Err: err})
+			return
+		}
+		a.emit(word{instr: instr})
+	}
+}
+
+func (a *assembler) emit(w word) {
+	if a.pc > 0xFFFF {
+		a.errorf(errImmediateRange, "address %d past the end of memory", a.pc)
+		return
+	}
+	if a.used[uint16(a.pc)] {
+		a.errorf(errOverlap, "%d", a.pc)
+	}
+	a.used[uint16(a.pc)] = true
+
+	w.line = a.line
+	w.src = a.srcLine
+	w.addr = uint16(a.pc)
+	a.words = append(a.words, w)
+	a.pc++
+}
+
+// encode resolves the labels and builds the image spanning
+// from the lowest to the highest address written
+func (a *assembler) encode() *Image {
+	img := &Image{Symbols: a.symbols, File: a.file}
+	if len(a.words) == 0 {
+		return img
+	}
+
+	lo, hi := a.words[0].addr, a.words[0].addr
+	for _, w := range a.words {
+		lo, hi = min(lo, w.addr), max(hi, w.addr)
+	}
+	img.Origin = lo
+	img.Words = make([]uint16, int(hi-lo)+1)
+
+	for _, w := range a.words {
+		a.line = w.line
+		img.Words[w.addr-lo] = a.encodeWord(w)
+		if w.src != 0 {
+			if img.Lines == nil {
+				img.Lines = map[uint16]int{}
+			}
+			img.Lines[w.addr] = w.src
+		}
+	}
+	return img
+}
+
+func (a *assembler) encodeWord(w word) uint16 {
+	if w.instr == nil {
+		if w.label == "" {
+			return uint16(w.value)
+		}
+		addr, ok := a.symbols[w.label]
+		if !ok {
+			a.errorf(errUndefinedLabel, "%s", w.label)
+		}
+		return addr
+	}
+
+	instr := *w.instr
+	if instr.Label != "" {
+		addr, ok := a.symbols[instr.Label]
+		if !ok {
+			a.errorf(errUndefinedLabel, "%s", instr.Label)
+			return 0
+		}
+		instr.Imm = int(addr)
+		// MOV and MOVT load the halves of the address
+		if lo, hi := rust16vm.RV16.Halves(addr); instr.Op == rust16vm.MOV {
+			instr.Imm = lo
+		} else if instr.Op == rust16vm.MOVT {
+			instr.Imm = hi
+		}
+	}
+
+	encoded, err := Encode(instr, w.addr)
+	if err != nil {
+		a.errs = append(a.errs, &Error{Line: w.line, Err: err})
+	}
+	return encoded
+}
+
+// splitOperands splits at the commas outside of brackets
+func splitOperands(s string) []string {
+	var operands []string
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ',':
+			if depth == 0 {
+				operands = append(operands, strings.TrimSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	if rest := strings.TrimSpace(s[start:]); rest != "" || len(operands) > 0 {
+		operands = append(operands, rest)
+	}
+	return operands
+}
+
+var opcodes = func() map[string]rust16vm.Opcode {
+	m := map[string]rust16vm.Opcode{}
+	for op := rust16vm.MOV; op <= rust16vm.NOP; op++ {
+		if op != rust16vm.MOVR {
+			m[op.String()] = op
+		}
+	}
+	return m
+}()
+
+func parseInstr(mnemonic string, operands []string) (*rust16vm.Instr, error) {
+	op, ok := opcodes[mnemonic]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errUnknownInstruction, mnemonic)
+	}
+
+	p := &operandParser{op: op, operands: operands}
+	instr := &rust16vm.Instr{Op: op}
+
+	switch {
+	case op == rust16vm.MOV:
+		p.expect(2)
+		instr.Rd = p.reg(0)
+		switch {
+		case strings.HasPrefix(p.operand(1), "#"):
+			instr.Imm = p.imm(1)
+		case isIdent(p.operand(1)):
+			instr.Label = p.operand(1)
+		default:
+			instr.Op = rust16vm.MOVR
+			instr.Ra = p.reg(1)
+		}
+	case op == rust16vm.MOVT:
+		p.expect(2)
+		instr.Rd = p.reg(0)
+		instr.Label, instr.Imm = p.target(1)
+	case op.IsRegReg():
+		p.expect(3)
+		instr.Rd, instr.Ra, instr.Rb = p.reg(0), p.reg(1), p.reg(2)
+	case op == rust16vm.ADDI:
+		p.expect(3)
+		instr.Rd, instr.Ra, instr.Imm = p.reg(0), p.reg(1), p.imm(2)
+	case op == rust16vm.LDR || op == rust16vm.STR:
+		p.expect(2)
+		instr.Rd, instr.Imm = p.reg(0), p.mem(1)
+	case op == rust16vm.PUSH || op == rust16vm.POP:
+		p.expect(1)
+		instr.Rd = p.reg(0)
+	case op == rust16vm.JMP || op == rust16vm.CALL:
+		p.expect(1)
+		instr.Label, instr.Imm = p.target(0)
+	case op == rust16vm.JZ || op == rust16vm.JNZ:
+		p.expect(2)
+		instr.Ra = p.reg(0)
+		instr.Label, instr.Imm = p.target(1)
+	default:
+		p.expect(0)
+	}
+
+	return instr, p.err
+}
+
+// operandParser keeps the first error found so the
+// instruction forms above read as a sequence of operands
+type operandParser struct {
+	op       rust16vm.Opcode
+	operands []string
+	err      error
+}
+
+func (p *operandParser) fail(format string, args ...any) {
+	if p.err == nil {
+		p.err = fmt.Errorf("%w: %s", errSyntax, fmt.Sprintf(format, args...))
+	}
+}
+
+func (p *operandParser) expect(n int) {
+	if len(p.operands) != n {
+		p.fail("%s takes %d operands, got %d", p.op, n, len(p.operands))
+	}
+}
+
+func (p *operandParser) operand(i int) string {
+	if i < len(p.operands) {
+		return p.operands[i]
+	}
+	return ""
+}
+
+func (p *operandParser) reg(i int) rust16vm.Reg {
+	r, ok := ParseReg(p.operand(i))
+	if !ok {
+		p.fail("expected register, got %q", p.operand(i))
+	}
+	return r
+}
+
+func (p *operandParser) imm(i int) int {
+	text, ok := strings.CutPrefix(p.operand(i), "#")
+	value, err := parseNumber(text)
+	if !ok || err != nil {
+		p.fail("expected immediate, got %q", p.operand(i))
+	}
+	return value
+}
+
+// mem parses [BP, #off] and [BP]
+func (p *operandParser) mem(i int) int {
+	text := p.operand(i)
+	inner, ok := strings.CutPrefix(text, "[")
+	inner, closed := strings.CutSuffix(inner, "]")
+	parts := splitOperands(inner)
+	if !ok || !closed || len(parts) == 0 || len(parts) > 2 || strings.ToUpper(parts[0]) != "BP" {
+		p.fail("expected [BP, #offset], got %q", text)
+		return 0
+	}
+	if len(parts) == 1 {
+		return 0
+	}
+
+	offset, ok := strings.CutPrefix(parts[1], "#")
+	value, err := parseNumber(offset)
+	if !ok || err != nil {
+		p.fail("expected [BP, #offset], got %q", text)
+	}
+	return value
+}
+
+// target is either a label or an #immediate, the absolute
+// address of jumps
+func (p *operandParser) target(i int) (string, int) {
+	text := p.operand(i)
+	if isIdent(text) {
+		return text, 0
+	}
+	return "", p.imm(i)
+}
+
+// ParseReg returns the register with the given name
+func ParseReg(name string) (rust16vm.Reg, bool) {
+	for r := rust16vm.A; r <= rust16vm.FLAGS; r++ {
+		if strings.EqualFold(r.String(), name) {
+			return r, true
+		}
+	}
+	return 0, false
+}
+
+func parseNumber(text string) (int, error) {
+	value, err := strconv.ParseInt(strings.TrimSpace(text), 0, 32)
+	return int(value), err
+}
+
+// isIdent reports if s is a label name, block labels like
+// main.if.then.1 contain dots
+func isIdent(s string) bool {
+	if s == "" {
+		return false
+	}
+	if _, isReg := ParseReg(s); isReg {
+		return false
+	}
+	for i, c := range s {
+		letter := c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+		if !letter && (i == 0 || c != '.' && (c < '0' || c > '9')) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,267 @@
+package asm
+
+import (
+	"bytes"
+	"io"
+	"stag/codegen"
+	"stag/codegen/rust16vm"
+	"stag/ir"
+	"stag/lexer"
+	"stag/pratt_parser"
+	"stag/types"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected uint16
+	}{
+		{"NOP", 0b0000_000_000_000_000},
+		{"HALT", 0b0000_001_000_000_000},
+		{"RET", 0b0000_010_000_000_000},
+		{"MOV BP, SP", 0b0000_011_100_101_000},
+		{"PUSH A", 0b0000_100_000_000_000},
+		{"POP BP", 0b0000_101_100_000_000},
+		{"MOV C, #306", 0b0001_010_100110010},
+		{"MOVT A, #107", 0b0010_000_00_1101011},
+		{"ADDR C, A, B", 0b0011_010_000_001_000},
+		{"XORR A, B, C", 0b0011_000_001_010_111},
+		{"SLER M, A, B", 0b0100_011_000_001_101},
+		{"SARR A, A, B", 0b0101_000_000_001_010},
+		{"ADDI SP, SP, #-3", 0b0110_101_101_111101},
+		{"LDR A, [BP, #-1]", 0b0111_000_111111111},
+		{"STR C, [BP, #2]", 0b1000_010_000000010},
+		{"STR C, [BP]", 0b1000_010_000000000},
+		{"l: JMP l", 0b1001_111111111111},
+		{"CALL #3", 0b1010_000000000010},
+		{"JZ A, #0", 0b1011_000_111111111},
+		{"jnz b, #9", 0b1100_001_000001000},
+	}
+
+	for _, tt := range tests {
+		img, err := Assemble(tt.input)
+		require.NoError(t, err, tt.input)
+		require.Equal(t, []uint16{tt.expected}, img.Words, tt.input)
+	}
+}
+
+func TestDecodeEncodesBack(t *testing.T) {
+	decoded := 0
+	for w := 0; w <= 0xFFFF; w++ {
+		instr, err := Decode(uint16(w), 1000)
+		if err != nil {
+			require.ErrorIs(t, err, errInvalidWord)
+			continue
+		}
+		decoded++
+
+		encoded, err := Encode(instr, 1000)
+		require.NoError(t, err, instr.String())
+		require.Equal(t, uint16(w), encoded, instr.String())
+	}
+	require.Greater(t, decoded, 0x9000)
+}
+
+func TestDirectives(t *testing.T) {
+	img, err := Assemble(`
+		; a table after the code
+		.org 16
+		start: JMP end
+		table: .word 1, -1, 0x10, table
+		msg:   .string "hi"
+		.org 32
+		end: HALT
+	`)
+	require.NoError(t, err)
+
+	require.Equal(t, uint16(16), img.Origin)
+	require.Len(t, img.Words, 17)
+	require.Equal(t, []uint16{1, 0xFFFF, 0x10, 17, 'h', 'i', 0}, img.Words[1:8])
+	require.Equal(t, uint16(0), img.Words[8])
+	require.Equal(t, map[string]uint16{"start": 16, "table": 17, "msg": 21, "end": 32}, img.Symbols)
+
+	// MOV and MOVT split the address of a label
+	img, err = Assemble(".org 1000\nl: MOV A, l\nMOVT A, l")
+	require.NoError(t, err)
+	require.Equal(t, []uint16{0b0001_000_111101000, 0b0010_000_00_0000001}, img.Words)
+}
+
+func TestAssembleErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"MOV A, #512", "line 1: out of range: immediate 512 for MOV, want 0 to 511"},
+		{"\nADDI SP, SP, #32", "line 2: out of range: immediate 32 for ADDI, want -32 to 31"},
+		{"LDR A, [BP, #-257]", "line 1: out of range: offset -257 for LDR, want -256 to 255"},
+		{"JMP nowhere", "line 1: undefined label: nowhere"},
+		{".word nowhere", "line 1: undefined label: nowhere"},
+		{"JZ A, far\n.org 300\nfar: HALT", "line 1: out of range: jump offset 299 for JZ, want -256 to 255"},
+		{"FOO A", "line 1: unknown instruction: FOO"},
+		{"ADDR A, B", "line 1: syntax error: ADDR takes 3 operands, got 2"},
+		{"PUSH X", `line 1: syntax error: expected register, got "X"`},
+		{"LDR A, [SP, #1]", `line 1: syntax error: expected [BP, #offset], got "[SP, #1]"`},
+		{"l: NOP\nl: NOP", "line 2: label redefined: l"},
+		{"NOP\n.org 0\nNOP", "line 3: address assigned twice: 0"},
+		{".word 70000", "line 1: out of range: word 70000, want -32768 to 65535"},
+		{"MOV A, #512\nJMP x", "line 1: out of range: immediate 512 for MOV, want 0 to 511\n" +
+			"line 2: undefined label: x"},
+	}
+
+	for _, tt := range tests {
+		_, err := Assemble(tt.input)
+		require.EqualError(t, err, tt.expected, tt.input)
+	}
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	img, err := Assemble(".org 4\nmain: MOV A, #1\nloop: JMP loop\n")
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, img.WriteBinary(&buf))
+	require.Equal(t, Magic, buf.String()[:4])
+
+	read, err := ReadBinary(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, img, read)
+
+	buf.Reset()
+	require.NoError(t, img.WriteRaw(&buf))
+	require.Equal(t, []byte{0x01, 0x10, 0xFF, 0x9F}, buf.Bytes())
+
+	raw, err := ReadRaw(&buf, 4)
+	require.NoError(t, err)
+	require.Equal(t, img.Words, raw.Words)
+
+	_, err = ReadBinary(bytes.NewReader([]byte("ELF\x7f....")))
+	require.ErrorIs(t, err, errBadBinary)
+
+	// a code section longer than the memory and a section
+	// longer than the file are rejected without the allocation
+	header := []byte("R16\x00\x01\x00\x01\x00")
+	for _, section := range [][]byte{
+		{0x01, 0x00, 0xFF, 0xFF, 0xFF, 0xFF},
+		{0x02, 0x00, 0xFF, 0xFF, 0xFF, 0xFF, 0x00, 0x00},
+	} {
+		_, err = ReadBinary(bytes.NewReader(append(header, section...)))
+		require.ErrorIs(t, err, errBadBinary)
+	}
+}
+
+func TestAssembleGenerated(t *testing.T) {
+	p := pratt_parser.New(lexer.New(`
+		fn fact(n: u16) -> u16 {
+			if n <= 1 {
+				return 1;
+			}
+			return n * fact(n - 1);
+		}
+		let x = fact(5) + 55090;
+	`))
+	program := p.ParseProgram()
+	require.Empty(t, p.Errors())
+	info, errs := types.Check(program)
+	require.Empty(t, errs)
+
+	code, err := rust16vm.Compile(ir.Lower(program, info))
+	require.NoError(t, err)
+
+	img, err := Assemble(rust16vm.Format(code))
+	require.NoError(t, err)
+
+	linked, err := rust16vm.Link(code)
+	require.NoError(t, err)
+	require.Len(t, img.Words, len(linked))
+
+	// the linker of the simulator and the assembler agree on the addresses
+	for addr, instr := range linked {
+		word, err := Encode(instr, uint16(addr))
+		require.NoError(t, err)
+		require.Equal(t, word, img.Words[addr], instr.String())
+	}
+}
+
+// the body of the branch is out of the reach of JZ and the one of
+// big out of the reach of CALL main, the backend relaxes the jumps
+// so the program assembles and runs
+func TestAssembleFarJumps(t *testing.T) {
+	src := `
+		fn big(n: u16) -> u16 {
+			let x: u16 = n;
+			if n > 1 {
+				` + strings.Repeat("x = x + 3;\n", 1200) + `
+			}
+			return x;
+		}
+		let y = big(2) + big(1);
+	`
+	p := pratt_parser.New(lexer.New(src))
+	program := p.ParseProgram()
+	require.Empty(t, p.Errors())
+	info, errs := types.Check(program)
+	require.Empty(t, errs)
+
+	art, err := Backend{}.Compile(ir.Lower(program, info), codegen.Options{})
+	require.NoError(t, err)
+	require.Contains(t, art.Assembly(), "relax.")
+
+	img, err := Assemble(art.Assembly())
+	require.NoError(t, err)
+	require.Greater(t, len(img.Words), 1<<11)
+	_, err = art.WriteTo(io.Discard)
+	require.NoError(t, err)
+
+	value, err := art.Run(1_000_000)
+	require.NoError(t, err)
+	require.Equal(t, uint16(2+3*1200+1), value)
+}
+
+// Run goes through the assembler, the code the encoder rejects
+// does not run
+func TestRunAssembles(t *testing.T) {
+	a := &artifact{code: []rust16vm.Instr{{Op: rust16vm.MOV, Rd: rust16vm.A, Imm: 42}, {Op: rust16vm.HALT}}}
+	value, err := a.Run(10)
+	require.NoError(t, err)
+	require.Equal(t, uint16(42), value)
+
+	a = &artifact{code: []rust16vm.Instr{{Op: rust16vm.MOV, Rd: rust16vm.A, Imm: 1000}, {Op: rust16vm.HALT}}}
+	_, err = a.Run(10)
+	require.ErrorIs(t, err, errImmediateRange)
+
+	img, err := Assemble(".org 2\nMOV A, #7\nHALT")
+	require.NoError(t, err)
+	code, err := img.Instrs()
+	require.NoError(t, err)
+	require.Equal(t, []rust16vm.Instr{{Op: rust16vm.NOP}, {Op: rust16vm.NOP}, {Op: rust16vm.MOV, Imm: 7}, {Op: rust16vm.HALT}}, code)
+
+	img.Words[1] = 0xFFFF
+	_, err = img.Instrs()
+	require.ErrorIs(t, err, errInvalidWord)
+}
+
+// the code generator picks the instructions by the widths of
+// RV16, the encoding has to have room for all of them
+func TestEncodingMatchesTarget(t *testing.T) {
+	fields := map[rust16vm.Opcode]immRange{
+		rust16vm.MOV:  imm9,
+		rust16vm.MOVT: imm7,
+		rust16vm.ADDI: simm6,
+		rust16vm.LDR:  simm9,
+		rust16vm.STR:  simm9,
+		rust16vm.JMP:  simm12,
+		rust16vm.CALL: simm12,
+		rust16vm.JZ:   simm9,
+		rust16vm.JNZ:  simm9,
+	}
+	require.Len(t, rust16vm.RV16.Imms, len(fields))
+	for op, field := range fields {
+		imm, ok := rust16vm.RV16.Imm(op)
+		require.True(t, ok, op)
+		require.Equal(t, immRange{bits: imm.Bits, signed: imm.Signed}, field, op)
+	}
+}
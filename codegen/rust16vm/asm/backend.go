@@ -0,0 +1,102 @@
+package asm
+
+import (
+	"io"
+	"stag/codegen"
+	"stag/codegen/rust16vm"
+	"stag/ir"
+	"strings"
+)
+
+// the rust16vm backend lives with the assembler since
+// its binaries are the images the assembler encodes
+func init() {
+	codegen.Register(Backend{})
+}
+
+// Backend compiles for rust16vm, the code goes through the
+// peephole optimizer and the jumps out of range are relaxed,
+// the binary is an assembled Image
+type Backend struct{}
+
+func (Backend) Name() string { return "rust16vm" }
+func (Backend) Ext() string  { return ".bin" }
+
+// Costs are the estimates of the target description
+func (Backend) Costs() ir.CostModel { return rust16vm.RV16 }
+
+func (Backend) Compile(program *ir.Program, opts codegen.Options) (codegen.Artifact, error) {
+	allocs := rust16vm.Allocations(program, rust16vm.RV16)
+	code, err := rust16vm.CompileAllocated(program, rust16vm.RV16, allocs)
+	if err != nil {
+		return nil, err
+	}
+	code = rust16vm.Relax(rust16vm.Peephole(code, rust16vm.Rules), rust16vm.RV16)
+	return &artifact{code: code, allocs: allocs, opts: opts}, nil
+}
+
+type artifact struct {
+	code   []rust16vm.Instr
+	allocs []*rust16vm.Allocation
+	opts   codegen.Options
+}
+
+// Report lists what the register allocator did in each function
+func (a *artifact) Report() string {
+	var out strings.Builder
+	for _, alloc := range a.allocs {
+		out.WriteString(alloc.Stats.String() + "\n")
+	}
+	return out.String()
+}
+
+func (a *artifact) Assembly() string {
+	if a.opts.Debug {
+		return rust16vm.FormatDebug(a.code, a.opts.File)
+	}
+	return rust16vm.Format(a.code)
+}
+
+func (a *artifact) WriteTo(w io.Writer) (int64, error) {
+	img, err := Assemble(a.Assembly())
+	if err != nil {
+		return 0, err
+	}
+
+	cw := &countingWriter{w: w}
+	if a.opts.Raw {
+		err = img.WriteRaw(cw)
+	} else {
+		err = img.WriteBinary(cw)
+	}
+	return cw.n, err
+}
+
+// Run assembles the program and simulates the decoded words, what
+// runs is the binary WriteTo writes
+func (a *artifact) Run(maxSteps int) (uint16, error) {
+	img, err := Assemble(a.Assembly())
+	if err != nil {
+		return 0, err
+	}
+	code, err := img.Instrs()
+	if err != nil {
+		return 0, err
+	}
+	m := &rust16vm.Machine{}
+	if err := m.Run(code, maxSteps); err != nil {
+		return 0, err
+	}
+	return m.Regs[rust16vm.A], nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
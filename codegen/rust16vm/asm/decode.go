@@ -0,0 +1,144 @@
+package asm
+
+import (
+	"errors"
+	"fmt"
+	"stag/codegen/rust16vm"
+)
+
+var errInvalidWord = errors.New("not an instruction")
+
+// miscCodes are the operations of the sub codes of GroupMisc
+var miscCodes = map[uint16]rust16vm.Opcode{
+	MiscNop:  rust16vm.NOP,
+	MiscHalt: rust16vm.HALT,
+	MiscRet:  rust16vm.RET,
+	MiscMov:  rust16vm.MOVR,
+	MiscPush: rust16vm.PUSH,
+	MiscPop:  rust16vm.POP,
+}
+
+// regRegOps maps a group to its first operation and the amount of them
+var regRegOps = map[uint16]struct {
+	first rust16vm.Opcode
+	count uint16
+}{
+	GroupALU:   {rust16vm.ADDR, 8},
+	GroupCmp:   {rust16vm.EQR, 6},
+	GroupShift: {rust16vm.SHLR, 3},
+}
+
+// Decode returns the instruction encoded in the word found at
+// addr, jumps get their absolute target in Imm. Words Encode
+// never produces are reported as errors, so every decoded
+// instruction encodes back to the same word
+func Decode(word uint16, addr uint16) (rust16vm.Instr, error) {
+	group := word >> 12
+	d := rust16vm.Reg(word >> 9 & 0b111)
+	a := rust16vm.Reg(word >> 6 & 0b111)
+	b := rust16vm.Reg(word >> 3 & 0b111)
+
+	invalid := func() (rust16vm.Instr, error) {
+		return rust16vm.Instr{}, fmt.Errorf("%w: %#04x", errInvalidWord, word)
+	}
+
+	switch group {
+	case GroupMisc:
+		op, ok := miscCodes[word>>9&0b111]
+		if !ok || word&0b111 != 0 {
+			return invalid()
+		}
+		// the register fields sit one field lower than in the other
+		// groups and the ones the operation does not use must be zero
+		rd, ra := a, b
+		switch op {
+		case rust16vm.MOVR:
+			return rust16vm.Instr{Op: op, Rd: rd, Ra: ra}, nil
+		case rust16vm.PUSH, rust16vm.POP:
+			if ra != 0 {
+				return invalid()
+			}
+			return rust16vm.Instr{Op: op, Rd: rd}, nil
+		default:
+			if rd != 0 || ra != 0 {
+				return invalid()
+			}
+			return rust16vm.Instr{Op: op}, nil
+		}
+
+	case GroupMov:
+		return rust16vm.Instr{Op: rust16vm.MOV, Rd: d, Imm: int(word & 0x1FF)}, nil
+
+	case GroupMovt:
+		if word&0x180 != 0 {
+			return invalid()
+		}
+		return rust16vm.Instr{Op: rust16vm.MOVT, Rd: d, Imm: int(word & 0x7F)}, nil
+
+	case GroupALU, GroupCmp, GroupShift:
+		ops := regRegOps[group]
+		fn := word & 0b111
+		if fn >= ops.count {
+			return invalid()
+		}
+		return rust16vm.Instr{Op: ops.first + rust16vm.Opcode(fn), Rd: d, Ra: a, Rb: b}, nil
+
+	case GroupAddi:
+		return rust16vm.Instr{Op: rust16vm.ADDI, Rd: d, Ra: a, Imm: signExtend(word, 6)}, nil
+
+	case GroupLdr:
+		return rust16vm.Instr{Op: rust16vm.LDR, Rd: d, Imm: signExtend(word, 9)}, nil
+
+	case GroupStr:
+		return rust16vm.Instr{Op: rust16vm.STR, Rd: d, Imm: signExtend(word, 9)}, nil
+
+	case GroupJmp, GroupCall, GroupJz, GroupJnz:
+		instr := rust16vm.Instr{Op: rust16vm.JMP}
+		offset := signExtend(word, 12)
+		switch group {
+		case GroupCall:
+			instr.Op = rust16vm.CALL
+		case GroupJz, GroupJnz:
+			instr.Op, instr.Ra, offset = rust16vm.JZ, d, signExtend(word, 9)
+			if group == GroupJnz {
+				instr.Op = rust16vm.JNZ
+			}
+		}
+
+		target := int(addr) + 1 + offset
+		if target < 0 || target > 0xFFFF {
+			return invalid()
+		}
+		instr.Imm = target
+		return instr, nil
+	}
+
+	return invalid()
+}
+
+func signExtend(word uint16, bits int) int {
+	value := int(word & (1<<bits - 1))
+	if value >= 1<<(bits-1) {
+		value -= 1 << bits
+	}
+	return value
+}
+
+// Instrs decodes the image as the machine sees it from address 0,
+// the words before Origin are zero and decode to NOP. The index of
+// an instruction is its address like in linked code
+func (img *Image) Instrs() ([]rust16vm.Instr, error) {
+	code := make([]rust16vm.Instr, int(img.Origin)+len(img.Words))
+	for i := range img.Origin {
+		code[i] = rust16vm.Instr{Op: rust16vm.NOP}
+	}
+	for i, word := range img.Words {
+		addr := img.Origin + uint16(i)
+		instr, err := Decode(word, addr)
+		if err != nil {
+			return nil, fmt.Errorf("address %d: %w", addr, err)
+		}
+		code[addr] = instr
+	}
+	return code, nil
+}
@@ -0,0 +1,153 @@
+package asm
+
+import (
+	"fmt"
+	"stag/codegen/rust16vm"
+)
+
+// Every instruction is a single 16 bit word, the top 4 bits
+// select the group and the layout of the remaining 12:
+//
+//	Misc   0000 sss ddd aaa ---  NOP HALT RET MOV d,a PUSH d POP d
+//	Mov    0001 ddd iiiiiiiii    MOV d, #imm9
+//	Movt   0010 ddd -- iiiiiii   MOVT d, #imm7
+//	ALU    0011 ddd aaa bbb fff  ADDR SUBR MULR DIVR SDIVR ANDR ORR XORR
+//	Cmp    0100 ddd aaa bbb fff  EQR NER LTR LER SLTR SLER
+//	Shift  0101 ddd aaa bbb fff  SHLR SHRR SARR
+//	Addi   0110 ddd aaa iiiiii   ADDI d, a, #simm6
+//	Ldr    0111 ddd iiiiiiiii    LDR d, [BP, #simm9]
+//	Str    1000 ddd iiiiiiiii    STR d, [BP, #simm9]
+//	Jmp    1001 oooooooooooo     JMP, offset relative to the next word
+//	Call   1010 oooooooooooo
+//	Jz     1011 aaa ooooooooo    JZ a, offset relative to the next word
+//	Jnz    1100 aaa ooooooooo
+const (
+	GroupMisc uint16 = iota
+	GroupMov
+	GroupMovt
+	GroupALU
+	GroupCmp
+	GroupShift
+	GroupAddi
+	GroupLdr
+	GroupStr
+	GroupJmp
+	GroupCall
+	GroupJz
+	GroupJnz
+)
+
+// sub operations of GroupMisc
+const (
+	MiscNop uint16 = iota
+	MiscHalt
+	MiscRet
+	MiscMov
+	MiscPush
+	MiscPop
+)
+
+var miscOps = map[rust16vm.Opcode]uint16{
+	rust16vm.NOP:  MiscNop,
+	rust16vm.HALT: MiscHalt,
+	rust16vm.RET:  MiscRet,
+	rust16vm.MOVR: MiscMov,
+	rust16vm.PUSH: MiscPush,
+	rust16vm.POP:  MiscPop,
+}
+
+// RegRegGroup returns the group of a register to register
+// operation and its function within the group
+func RegRegGroup(op rust16vm.Opcode) (group uint16, fn uint16) {
+	switch {
+	case op >= rust16vm.EQR && op <= rust16vm.SLER:
+		return GroupCmp, uint16(op - rust16vm.EQR)
+	case op >= rust16vm.SHLR && op <= rust16vm.SARR:
+		return GroupShift, uint16(op - rust16vm.SHLR)
+	default:
+		return GroupALU, uint16(op - rust16vm.ADDR)
+	}
+}
+
+type immRange struct {
+	bits   int
+	signed bool
+}
+
+func (r immRange) bounds() (int, int) {
+	if r.signed {
+		return -(1 << (r.bits - 1)), 1<<(r.bits-1) - 1
+	}
+	return 0, 1<<r.bits - 1
+}
+
+// field checks value fits in r and returns its low bits
+func (r immRange) field(what string, op rust16vm.Opcode, value int) (uint16, error) {
+	lo, hi := r.bounds()
+	if value < lo || value > hi {
+		return 0, fmt.Errorf("%w: %s %d for %s, want %d to %d", errImmediateRange, what, value, op, lo, hi)
+	}
+	return uint16(value) & (1<<r.bits - 1), nil
+}
+
+var (
+	imm9   = immRange{bits: 9}
+	imm7   = immRange{bits: 7}
+	simm6  = immRange{bits: 6, signed: true}
+	simm9  = immRange{bits: 9, signed: true}
+	simm12 = immRange{bits: 12, signed: true}
+)
+
+// Encode returns the word of an instruction placed at addr,
+// the target of the jumps is the absolute address in Imm
+func Encode(i rust16vm.Instr, addr uint16) (uint16, error) {
+	d, a, b := uint16(i.Rd), uint16(i.Ra), uint16(i.Rb)
+
+	switch {
+	case i.Op == rust16vm.MOV:
+		imm, err := imm9.field("immediate", i.Op, i.Imm)
+		return GroupMov<<12 | d<<9 | imm, err
+
+	case i.Op == rust16vm.MOVT:
+		imm, err := imm7.field("immediate", i.Op, i.Imm)
+		return GroupMovt<<12 | d<<9 | imm, err
+
+	case i.Op.IsRegReg():
+		group, fn := RegRegGroup(i.Op)
+		return group<<12 | d<<9 | a<<6 | b<<3 | fn, nil
+
+	case i.Op == rust16vm.ADDI:
+		imm, err := simm6.field("immediate", i.Op, i.Imm)
+		return GroupAddi<<12 | d<<9 | a<<6 | imm, err
+
+	case i.Op == rust16vm.LDR || i.Op == rust16vm.STR:
+		group := GroupLdr
+		if i.Op == rust16vm.STR {
+			group = GroupStr
+		}
+		imm, err := simm9.field("offset", i.Op, i.Imm)
+		return group<<12 | d<<9 | imm, err
+
+	case i.Op == rust16vm.JMP || i.Op == rust16vm.CALL:
+		group := GroupJmp
+		if i.Op == rust16vm.CALL {
+			group = GroupCall
+		}
+		off, err := simm12.field("jump offset", i.Op, i.Imm-int(addr)-1)
+		return group<<12 | off, err
+
+	case i.Op == rust16vm.JZ || i.Op == rust16vm.JNZ:
+		group := GroupJz
+		if i.Op == rust16vm.JNZ {
+			group = GroupJnz
+		}
+		off, err := simm9.field("jump offset", i.Op, i.Imm-int(addr)-1)
+		return group<<12 | a<<9 | off, err
+	}
+
+	sub, ok := miscOps[i.Op]
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", errUnknownInstruction, i.Op)
+	}
+	return GroupMisc<<12 | sub<<9 | d<<6 | a<<3, nil
+}
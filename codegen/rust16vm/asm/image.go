@@ -0,0 +1,253 @@
+package asm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Image is an assembled program, Words are loaded at Origin
+type Image struct {
+	Origin  uint16
+	Words   []uint16
+	Symbols map[string]uint16
+
+	// debug info, the source file and the line each address came from
+	File  string
+	Lines map[uint16]int
+}
+
+// Magic starts every headered binary
+const Magic = "R16\x00"
+
+// Version of the headered binary layout
+const Version = 1
+
+// section kinds of the headered binary
+const (
+	sectionCode uint16 = iota + 1
+	sectionSymbols
+	sectionDebug
+)
+
+// maxCode is the length of a code section filling the memory,
+// the origin and a word for each of the 65536 addresses
+const maxCode = 2 + 2*65536
+
+var errBadBinary = errors.New("not a rust16vm binary")
+
+// WriteRaw writes the words as little endian 16 bit values,
+// nothing else is kept so they must be loaded at Origin
+func (img *Image) WriteRaw(w io.Writer) error {
+	return binary.Write(w, binary.LittleEndian, img.Words)
+}
+
+// WriteBinary writes the image with a small header:
+//
+//	magic    "R16\x00"
+//	version  u16
+//	sections u16
+//
+// followed by the sections, each one a u16 kind, a u32 length
+// in bytes and the payload. The code section holds the origin
+// and the words, the symbols section holds an address, the
+// length of the name and the name for each symbol. The debug
+// section is only present with debug info, it holds the length
+// of the file name, the name and an address and a line for each
+// address with a line. Everything is little endian
+func (img *Image) WriteBinary(w io.Writer) error {
+	type section struct {
+		kind    uint16
+		payload []byte
+	}
+	sections := []section{
+		{sectionCode, img.code()},
+		{sectionSymbols, img.symbols()},
+	}
+	if img.File != "" || len(img.Lines) > 0 {
+		sections = append(sections, section{sectionDebug, img.debug()})
+	}
+
+	out := bufio.NewWriter(w)
+	out.WriteString(Magic)
+	write16(out, Version)
+	write16(out, uint16(len(sections)))
+	for _, s := range sections {
+		write16(out, s.kind)
+		binary.Write(out, binary.LittleEndian, uint32(len(s.payload)))
+		out.Write(s.payload)
+	}
+	return out.Flush()
+}
+
+func (img *Image) code() []byte {
+	payload := binary.LittleEndian.AppendUint16(nil, img.Origin)
+	for _, word := range img.Words {
+		payload = binary.LittleEndian.AppendUint16(payload, word)
+	}
+	return payload
+}
+
+// symbols are sorted by address then name so the same
+// image always gives the same bytes
+func (img *Image) symbols() []byte {
+	names := make([]string, 0, len(img.Symbols))
+	for name := range img.Symbols {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := img.Symbols[names[i]], img.Symbols[names[j]]
+		return a < b || a == b && names[i] < names[j]
+	})
+
+	var payload []byte
+	for _, name := range names {
+		payload = binary.LittleEndian.AppendUint16(payload, img.Symbols[name])
+		payload = binary.LittleEndian.AppendUint16(payload, uint16(len(name)))
+		payload = append(payload, name...)
+	}
+	return payload
+}
+
+func (img *Image) debug() []byte {
+	payload := binary.LittleEndian.AppendUint16(nil, uint16(len(img.File)))
+	payload = append(payload, img.File...)
+
+	addrs := make([]int, 0, len(img.Lines))
+	for addr := range img.Lines {
+		addrs = append(addrs, int(addr))
+	}
+	sort.Ints(addrs)
+	for _, addr := range addrs {
+		payload = binary.LittleEndian.AppendUint16(payload, uint16(addr))
+		payload = binary.LittleEndian.AppendUint16(payload, uint16(img.Lines[uint16(addr)]))
+	}
+	return payload
+}
+
+func write16(w io.Writer, value uint16) {
+	binary.Write(w, binary.LittleEndian, value)
+}
+
+// ReadBinary reads an image written by WriteBinary, unknown
+// sections are skipped
+func ReadBinary(r io.Reader) (*Image, error) {
+	var header struct {
+		Magic    [4]byte
+		Version  uint16
+		Sections uint16
+	}
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("%w: %w", errBadBinary, err)
+	}
+	if string(header.Magic[:]) != Magic {
+		return nil, errBadBinary
+	}
+	if header.Version != Version {
+		return nil, fmt.Errorf("%w: unsupported version %d", errBadBinary, header.Version)
+	}
+
+	img := &Image{Symbols: map[string]uint16{}}
+	for range header.Sections {
+		var section struct {
+			Kind   uint16
+			Length uint32
+		}
+		if err := binary.Read(r, binary.LittleEndian, &section); err != nil {
+			return nil, fmt.Errorf("%w: %w", errBadBinary, err)
+		}
+		if section.Kind == sectionCode && section.Length > maxCode {
+			return nil, fmt.Errorf("%w: code section of %d bytes", errBadBinary, section.Length)
+		}
+		// the length comes from the file, the payload only grows
+		// with the bytes there are
+		payload, err := io.ReadAll(io.LimitReader(r, int64(section.Length)))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errBadBinary, err)
+		}
+		if len(payload) != int(section.Length) {
+			return nil, fmt.Errorf("%w: %w", errBadBinary, io.ErrUnexpectedEOF)
+		}
+
+		switch section.Kind {
+		case sectionCode:
+			err = img.readCode(payload)
+		case sectionSymbols:
+			err = img.readSymbols(payload)
+		case sectionDebug:
+			err = img.readDebug(payload)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return img, nil
+}
+
+// ReadRaw reads the words written by WriteRaw
+func ReadRaw(r io.Reader, origin uint16) (*Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%2 != 0 {
+		return nil, fmt.Errorf("%w: odd raw image size %d", errBadBinary, len(data))
+	}
+
+	img := &Image{Origin: origin, Symbols: map[string]uint16{}}
+	for i := 0; i < len(data); i += 2 {
+		img.Words = append(img.Words, binary.LittleEndian.Uint16(data[i:]))
+	}
+	return img, nil
+}
+
+func (img *Image) readCode(payload []byte) error {
+	if len(payload) < 2 || len(payload)%2 != 0 {
+		return fmt.Errorf("%w: malformed code section", errBadBinary)
+	}
+	img.Origin = binary.LittleEndian.Uint16(payload)
+	img.Words = make([]uint16, 0, len(payload)/2-1)
+	for i := 2; i < len(payload); i += 2 {
+		img.Words = append(img.Words, binary.LittleEndian.Uint16(payload[i:]))
+	}
+	return nil
+}
+
+func (img *Image) readSymbols(payload []byte) error {
+	for len(payload) > 0 {
+		if len(payload) < 4 {
+			return fmt.Errorf("%w: malformed symbols section", errBadBinary)
+		}
+		addr := binary.LittleEndian.Uint16(payload)
+		size := int(binary.LittleEndian.Uint16(payload[2:]))
+		if len(payload) < 4+size {
+			return fmt.Errorf("%w: malformed symbols section", errBadBinary)
+		}
+		img.Symbols[string(payload[4:4+size])] = addr
+		payload = payload[4+size:]
+	}
+	return nil
+}
+
+func (img *Image) readDebug(payload []byte) error {
+	malformed := fmt.Errorf("%w: malformed debug section", errBadBinary)
+	if len(payload) < 2 {
+		return malformed
+	}
+	size := int(binary.LittleEndian.Uint16(payload))
+	if len(payload) < 2+size || (len(payload)-2-size)%4 != 0 {
+		return malformed
+	}
+	img.File = string(payload[2 : 2+size])
+
+	for rest := payload[2+size:]; len(rest) > 0; rest = rest[4:] {
+		if img.Lines == nil {
+			img.Lines = map[uint16]int{}
+		}
+		img.Lines[binary.LittleEndian.Uint16(rest)] = int(binary.LittleEndian.Uint16(rest[2:]))
+	}
+	return nil
+}
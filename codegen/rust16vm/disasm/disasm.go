@@ -0,0 +1,109 @@
+package disasm
+
+import (
+	"fmt"
+	"sort"
+	"stag/codegen/rust16vm/asm"
+	"strings"
+)
+
+// Options control the listing Disassemble produces
+type Options struct {
+	// Source holds the lines of the file named by the debug
+	// info, each one is shown before the code generated from it
+	Source []string
+	// Addresses comments every word with its address and value
+	Addresses bool
+}
+
+// Disassemble renders the image as assembly that asm.Assemble turns
+// back into the very same image. Jump targets and addresses with a
+// symbol get its name, words that are not instructions are shown
+// as .word and the debug info becomes .file and .line directives
+func Disassemble(img *asm.Image, opts Options) string {
+	d := &disassembler{img: img, opts: opts, names: map[uint16][]string{}}
+	for name, addr := range img.Symbols {
+		d.names[addr] = append(d.names[addr], name)
+	}
+	for _, names := range d.names {
+		sort.Strings(names)
+	}
+
+	if img.File != "" {
+		d.printf(".file %q", img.File)
+	}
+
+	// symbols outside of the image still need a place, the
+	// assembler gives a label the address it is found at
+	end := int(img.Origin) + len(img.Words)
+	var before, after []uint16
+	for addr := range d.names {
+		switch {
+		case addr < img.Origin:
+			before = append(before, addr)
+		case int(addr) >= end:
+			after = append(after, addr)
+		}
+	}
+	d.labelsAt(before)
+
+	d.printf(".org %d", img.Origin)
+	for i, word := range img.Words {
+		d.word(img.Origin+uint16(i), word)
+	}
+
+	d.labelsAt(after)
+	return d.out.String()
+}
+
+type disassembler struct {
+	img   *asm.Image
+	opts  Options
+	names map[uint16][]string
+	line  int
+	out   strings.Builder
+}
+
+func (d *disassembler) printf(format string, args ...any) {
+	d.out.WriteString(fmt.Sprintf(format, args...))
+	d.out.WriteString("\n")
+}
+
+func (d *disassembler) labelsAt(addrs []uint16) {
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	for _, addr := range addrs {
+		d.printf(".org %d", addr)
+		for _, name := range d.names[addr] {
+			d.printf("%s:", name)
+		}
+	}
+}
+
+func (d *disassembler) word(addr uint16, word uint16) {
+	for _, name := range d.names[addr] {
+		d.printf("%s:", name)
+	}
+
+	if line := d.img.Lines[addr]; line != d.line {
+		d.line = line
+		d.printf(".line %d", line)
+		if line > 0 && line <= len(d.opts.Source) {
+			d.printf("; %s:%d: %s", d.img.File, line, strings.TrimSpace(d.opts.Source[line-1]))
+		}
+	}
+
+	text := fmt.Sprintf(".word %#04x", word)
+	if instr, err := asm.Decode(word, addr); err == nil {
+		if instr.Op.IsJump() {
+			if names := d.names[uint16(instr.Imm)]; len(names) > 0 {
+				instr.Label = names[0]
+			}
+		}
+		text = instr.String()
+	}
+
+	if d.opts.Addresses {
+		text = fmt.Sprintf("%-24s ; %04x: %04x", text, addr, word)
+	}
+	d.printf("%s", text)
+}
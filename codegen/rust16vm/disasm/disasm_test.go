@@ -0,0 +1,124 @@
+package disasm
+
+import (
+	"bytes"
+	"math/rand"
+	"stag/codegen/rust16vm"
+	"stag/codegen/rust16vm/asm"
+	"stag/ir"
+	"stag/lexer"
+	"stag/pratt_parser"
+	"stag/types"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDisassemble(t *testing.T) {
+	img, err := asm.Assemble(`.file "add.el"
+		CALL main
+		HALT
+		.line 1
+		main: MOV A, #306
+		MOVT A, #107
+		JZ A, done
+		.word 0xF000
+		.line 0
+		done: RET
+	`)
+	require.NoError(t, err)
+
+	expected := `.file "add.el"
+.org 0
+CALL main                ; 0000: a001
+HALT                     ; 0001: 0200
+main:
+.line 1
+; add.el:1: let x = 55090 + 5;
+MOV A, #306              ; 0002: 1132
+MOVT A, #107             ; 0003: 206b
+JZ A, done               ; 0004: b001
+.word 0xf000             ; 0005: f000
+done:
+.line 0
+RET                      ; 0006: 0400
+`
+	listing := Disassemble(img, Options{Source: []string{"let x = 55090 + 5;"}, Addresses: true})
+	require.Equal(t, expected, listing)
+}
+
+func compile(t *testing.T, input string) string {
+	t.Helper()
+
+	p := pratt_parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	require.Empty(t, p.Errors())
+	info, errs := types.Check(program)
+	require.Empty(t, errs)
+
+	code, err := rust16vm.Compile(ir.Lower(program, info))
+	require.NoError(t, err)
+	return rust16vm.FormatDebug(rust16vm.Peephole(code, rust16vm.Rules), "test.el")
+}
+
+func binary(t *testing.T, img *asm.Image) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	require.NoError(t, img.WriteBinary(&buf))
+	return buf.Bytes()
+}
+
+// roundTrip checks disasm -> asm -> encode gives the same bytes
+func roundTrip(t *testing.T, img *asm.Image, opts Options) {
+	t.Helper()
+
+	listing := Disassemble(img, opts)
+	again, err := asm.Assemble(listing)
+	require.NoError(t, err, listing)
+	require.Equal(t, binary(t, img), binary(t, again), listing)
+}
+
+func TestRoundTrip(t *testing.T) {
+	inputs := []string{
+		"let x = 55090 + 5;",
+		`fn fact(n: u16) -> u16 {
+			if n <= 1 {
+				return 1;
+			}
+			return n * fact(n - 1);
+		}
+		let i: u16 = 0;
+		while i < 3 {
+			i = i + 1;
+		}
+		fact(i);`,
+	}
+
+	for _, input := range inputs {
+		img, err := asm.Assemble(compile(t, input))
+		require.NoError(t, err)
+		require.NotEmpty(t, img.Lines)
+
+		roundTrip(t, img, Options{})
+		roundTrip(t, img, Options{Source: strings.Split(input, "\n"), Addresses: true})
+	}
+}
+
+func TestRoundTripAnyWords(t *testing.T) {
+	rng := rand.New(rand.NewSource(16))
+
+	for range 20 {
+		img := &asm.Image{
+			Origin:  uint16(rng.Intn(1 << 12)),
+			Symbols: map[string]uint16{"before": 0, "after": 0xFFFF},
+		}
+		for range 256 {
+			img.Words = append(img.Words, uint16(rng.Intn(1<<16)))
+		}
+		img.Symbols["inside"] = img.Origin + uint16(rng.Intn(256))
+
+		roundTrip(t, img, Options{Addresses: true})
+	}
+}
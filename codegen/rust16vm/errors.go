@@ -2,4 +2,7 @@ package rust16vm
 
 import "errors"
 
-var errNumericValueOutOfBounds = errors.New("numeric value does not fit in the operation")
+var (
+	errNumericValueOutOfBounds = errors.New("numeric value does not fit in the operation")
+	errUnsupported             = errors.New("not supported by the rust16vm backend yet")
+)
@@ -0,0 +1,43 @@
+package rust16vm
+
+import (
+	"stag/frontend"
+	"stag/ir"
+	"stag/lexer"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// the seed corpus in testdata/fuzz comes from examples and the
+// tests, what the front end accepts has to compile and link with
+// every jump in reach once relaxed
+func FuzzGenerate(f *testing.F) {
+	f.Fuzz(func(t *testing.T, src string) {
+		budget := 2*len(lexer.Tokenize(src)) + 8
+		program, info, errs := frontend.Source(src, frontend.Options{Lexer: []lexer.Option{lexer.MaxTokens(budget)}})
+		if len(errs) > 0 {
+			return
+		}
+
+		code := ir.Lower(program, info)
+		if _, err := Generate(code); err != nil {
+			t.Fatalf("%q: %v", src, err)
+		}
+		instrs, err := Compile(code)
+		require.NoError(t, err)
+		_, err = Link(instrs)
+		require.NoError(t, err, src)
+
+		linked, err := Link(Relax(Peephole(instrs, Rules), RV16))
+		require.NoError(t, err, src)
+		for i, instr := range linked {
+			if imm, ok := RV16.Imm(instr.Op); ok && instr.Op.IsJump() {
+				require.True(t, imm.Fits(instr.Imm-i-1), "%q: %d: %s", src, i, instr)
+			}
+		}
+
+		// running out of steps or dividing by zero is fine
+		Simulate(instrs, maxSteps)
+	})
+}
@@ -1,12 +1,13 @@
 package rust16vm
 
 import (
+	"errors"
 	"fmt"
-	"math/bits"
-	"stag/shunting_yard"
-	"strings"
+	"slices"
+	"stag/ir"
 )
 
+// Bits is the width of the words of the machine
 const Bits = 16
 
 type Reg uint8
@@ -23,111 +24,567 @@ const (
 )
 
 func (r Reg) String() string {
-	switch r {
-	case 0:
-		return "A"
-	case 1:
-		return "B"
-	case 2:
-		return "C"
-	case 3:
-		return "M"
-	case 4:
-		return "BP"
-	case 5:
-		return "SP"
-	case 6:
-		return "PC"
-	case 7:
-		return "FLAGS"
-	default:
+	if int(r) >= len(RV16.Regs) {
 		panic(fmt.Sprintf("unknown register %d", r))
 	}
+	return RV16.Regs[r].Name
 }
 
 type vmCtx struct {
-	errs []error
+	errs   []error
+	target *Target
+	// globals is the allocation of main, the functions switch
+	// BP to its frame to reach the top level variables
+	globals *Allocation
 	//registers [8]uint16 // A B C M BP SP PC FLAGS
 }
 
-type regStack struct {
-	arr []Reg
+// Allocations assigns the registers of the functions of the program
+// in order, main keeps the registers the other functions reach
+// through Load and Store in memory
+func Allocations(program *ir.Program, target *Target) []*Allocation {
+	globals := program.Globals()
+	out := make([]*Allocation, 0, len(program.Funcs))
+	for _, fn := range program.Funcs {
+		memory := ir.RegSet{}
+		if fn.Name == ir.MainFunc {
+			memory = globals
+		}
+		out = append(out, Allocate(fn, target, memory))
+	}
+	return out
+}
+
+// Assignment returns where the code of Compile keeps the virtual
+// registers of the functions of the program, the registers by name
+// and the stack slots written like the operands of LDR and STR
+func Assignment(program *ir.Program) func(fn *ir.Func) map[ir.Reg]string {
+	allocs := map[*ir.Func]*Allocation{}
+	for i, a := range Allocations(program, RV16) {
+		allocs[program.Funcs[i]] = a
+	}
+	return func(fn *ir.Func) map[ir.Reg]string {
+		a := allocs[fn]
+		if a == nil {
+			return nil
+		}
+		out := make(map[ir.Reg]string, len(a.Regs)+len(a.Slots))
+		for r, reg := range a.Regs {
+			out[r] = reg.String()
+		}
+		for r, offset := range a.Slots {
+			out[r] = fmt.Sprintf("[%s, #%d]", RV16.Call.Frame, offset)
+		}
+		return out
+	}
 }
 
-func (s *regStack) pop() Reg {
-	value := s.arr[len(s.arr)-1]
-	s.arr = s.arr[0 : len(s.arr)-1]
-	return value
+// Generate emits rust16vm assembly for the program after running
+// the peephole optimizer over it, the jumps out of range are relaxed
+// last since the rules shorten the code and rewrite branches
+func Generate(program *ir.Program) (string, error) {
+	code, err := Compile(program)
+	return Format(Relax(Peephole(code, Rules), RV16)), err
 }
 
-func (s *regStack) push(r Reg) {
-	s.arr = append(s.arr, r)
+// Compile translates the program to instructions of RV16. The
+// entry point calls main and halts, leaving its result in A
+func Compile(program *ir.Program) ([]Instr, error) {
+	return CompileFor(program, RV16)
 }
 
-func Generate(ast []shunting_yard.Statement) string {
-	// // initialize the context will all the registers empty
-	ctx := &vmCtx{}
-	asm := strings.Builder{}
+// CompileFor translates the program to instructions of the target,
+// the entry point calls main and halts leaving its result in the
+// result register of the calling convention
+func CompileFor(program *ir.Program, target *Target) ([]Instr, error) {
+	if err := target.check(); err != nil {
+		return nil, err
+	}
+	return compile(program, target, Allocations(program, target))
+}
 
-	usedRegs := &regStack{}
+// CompileAllocated is CompileFor with the allocations of the functions
+// given, the ones Allocations returns for the program and the target
+func CompileAllocated(program *ir.Program, target *Target, allocs []*Allocation) ([]Instr, error) {
+	if err := target.check(); err != nil {
+		return nil, err
+	}
+	return compile(program, target, allocs)
+}
 
-	for _, stmt := range ast {
-		switch v := stmt.(type) {
-		case *shunting_yard.BinaryOperation:
-			resolveInnerBinOp(ctx, usedRegs, v, &asm)
+// compile is CompileAllocated once the target was checked
+func compile(program *ir.Program, target *Target, allocs []*Allocation) ([]Instr, error) {
+	ctx := &vmCtx{target: target}
+	for i, fn := range program.Funcs {
+		if fn.Name == ir.MainFunc {
+			ctx.globals = allocs[i]
 		}
 	}
 
-	return asm.String()
+	code := []Instr{}
+	emitJump(CALL, ir.MainFunc, &code)
+	emit(Instr{Op: HALT}, &code)
+
+	for i, fn := range program.Funcs {
+		g := &funcGen{vmCtx: ctx, fn: fn, alloc: allocs[i], code: &code}
+		g.generate()
+	}
+
+	return code, errors.Join(ctx.errs...)
 }
 
-func resolveInnerBinOp(ctx *vmCtx, s *regStack, v *shunting_yard.BinaryOperation, asm *strings.Builder) {
-	lhsValue, lhsIsTerminal := v.Lhs.(*shunting_yard.Number)
-	if !lhsIsTerminal {
-		resolveInnerBinOp(ctx, s, v.Lhs.(*shunting_yard.BinaryOperation), asm)
-	} else {
-		emitMov(ctx, A, uint16(lhsValue.Value), asm)
-		s.push(A)
+// funcGen generates the code of a function, n is the number of the
+// instruction being generated like the allocation numbers them
+type funcGen struct {
+	*vmCtx
+	fn    *ir.Func
+	alloc *Allocation
+	code  *[]Instr
+	n     int
+	// held are the registers borrowed for the instruction,
+	// saved how many of them wait in the save slots
+	held  []Reg
+	saved int
+	// tailed is set when the block jumped to a tail call, the
+	// callee returns for it
+	tailed bool
+}
+
+func (g *funcGen) generate() {
+	fn, call := g.fn, g.target.Call
+
+	emitLabel(fn.Name, g.code)
+	emit(Instr{Op: PUSH, Rd: call.Frame}, g.code)
+	emitMovReg(call.Frame, call.Stack, g.code)
+	emitAdjustSP(g.vmCtx, -g.alloc.Size, g.code)
+
+	g.n = 0
+	for i, p := range fn.Params {
+		if r, ok := g.alloc.Regs[p]; ok {
+			g.mem(LDR, r, call.ArgOffset+i)
+		}
+	}
+
+	g.n = 1
+	for i, b := range fn.Blocks {
+		if i > 0 {
+			emitLabel(blockLabel(fn, b), g.code)
+		}
+
+		for _, instr := range b.Instrs {
+			g.n++
+			start := len(*g.code)
+			g.instr(instr)
+			setLine((*g.code)[start:], instr.Line)
+		}
+
+		var next *ir.Block
+		if i+1 < len(fn.Blocks) {
+			next = fn.Blocks[i+1]
+		}
+		g.n++
+		start := len(*g.code)
+		g.term(b, next)
+		setLine((*g.code)[start:], b.Term.Line)
 	}
+}
+
+func setLine(code []Instr, line int) {
+	for i := range code {
+		code[i].Line = line
+	}
+}
+
+func blockLabel(fn *ir.Func, b *ir.Block) string {
+	return fn.Name + "." + b.Name
+}
+
+// temp borrows a register other than the ones to avoid, a register
+// with no value live at the instruction when there is one or else
+// one saved in a save slot until release
+func (g *funcGen) temp(avoid ...Reg) (r Reg, release func()) {
+	busy := g.alloc.busy(2*g.n, 2*g.n+1)
+	usable := func(r Reg) bool {
+		return !slices.Contains(avoid, r) && !slices.Contains(g.held, r)
+	}
+
+	regs := g.target.Allocatable()
+	for _, r := range regs {
+		if usable(r) && !busy[r] {
+			g.held = append(g.held, r)
+			return r, func() { g.held = g.held[:len(g.held)-1] }
+		}
+	}
+
+	i := slices.IndexFunc(regs, usable)
+	if i < 0 || g.saved >= g.alloc.Saves {
+		g.errs = append(g.errs, fmt.Errorf("%s: no register left for a temporary", g.fn.Name))
+		return regs[0], func() {}
+	}
+	r = regs[i]
+	g.saved++
+	slot := -g.saved
+	g.held = append(g.held, r)
+	g.mem(STR, r, slot)
+	return r, func() {
+		g.mem(LDR, r, slot)
+		g.held = g.held[:len(g.held)-1]
+		g.saved--
+	}
+}
+
+// use returns a register holding the value of v, the ones in memory
+// are loaded in a temporary until release
+func (g *funcGen) use(v ir.Reg, avoid ...Reg) (Reg, func()) {
+	if r, ok := g.alloc.Regs[v]; ok {
+		return r, func() {}
+	}
+	r, release := g.temp(avoid...)
+	g.mem(LDR, r, g.alloc.Slots[v])
+	return r, release
+}
+
+// def returns the register to write the value of v to, the ones in
+// memory are written to a temporary stored by finish. reuse is a
+// temporary already held that can take the value
+func (g *funcGen) def(v ir.Reg, reuse []Reg, avoid ...Reg) (Reg, func()) {
+	if r, ok := g.alloc.Regs[v]; ok {
+		return r, func() {}
+	}
+	if len(reuse) > 0 {
+		r := reuse[0]
+		return r, func() { g.mem(STR, r, g.alloc.Slots[v]) }
+	}
+	r, release := g.temp(avoid...)
+	return r, func() {
+		g.mem(STR, r, g.alloc.Slots[v])
+		release()
+	}
+}
+
+// regs returns the registers the values of vs are kept in
+func (g *funcGen) regs(vs ...ir.Reg) []Reg {
+	var out []Reg
+	for _, v := range vs {
+		if r, ok := g.alloc.Regs[v]; ok {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+func (g *funcGen) instr(instr *ir.Instr) {
+	switch {
+	case instr.Op == ir.Const:
+		rd, finish := g.def(instr.Dst, nil)
+		emitConst(g.vmCtx, rd, uint16(instr.Value), g.code)
+		finish()
+
+	case instr.Op == ir.Copy:
+		g.copy(instr.Dst, instr.Args[0])
+
+	case instr.Op.IsBinary():
+		op, ok := g.target.Ops[instr.Op]
+		if !ok {
+			g.errs = append(g.errs, fmt.Errorf("%w: operation %s on %s", errUnsupported, instr.Op, g.target.Name))
+			return
+		}
+		a, b := instr.Args[0], instr.Args[1]
+		avoid := g.regs(instr.Dst, a, b)
+		ra, releaseA := g.use(a, avoid...)
+		rb, releaseB := g.use(b, append(avoid, ra)...)
+
+		// a temporary of an operand can take the result
+		var reuse []Reg
+		for _, v := range []ir.Reg{b, a} {
+			if _, ok := g.alloc.Regs[v]; !ok {
+				reuse = append(reuse, g.held[len(g.held)-1-len(reuse)])
+			}
+		}
+		rd, finish := g.def(instr.Dst, reuse, append(avoid, ra, rb)...)
+		emitArithRegReg(op, rd, ra, rb, g.code)
+		finish()
+		releaseB()
+		releaseA()
 
-	rhsValue, rhsIsTerminal := v.Rhs.(*shunting_yard.Number)
-	if !rhsIsTerminal {
-		resolveInnerBinOp(ctx, s, v.Rhs.(*shunting_yard.BinaryOperation), asm)
+	case instr.Op == ir.Call:
+		g.callInstr(instr)
+
+	case instr.Op == ir.Load:
+		rd, finish := g.def(instr.Dst, nil)
+		g.global(LDR, rd, instr.Global)
+		finish()
+
+	case instr.Op == ir.Store:
+		ra, release := g.use(instr.Args[0])
+		g.global(STR, ra, instr.Global)
+		release()
+
+	default:
+		g.errs = append(g.errs, fmt.Errorf("%w: operation %s", errUnsupported, instr.Op))
+	}
+}
+
+// copy moves the value of src to dst, nothing is left to do when
+// the allocator gave them the same register
+func (g *funcGen) copy(dst, src ir.Reg) {
+	rd, dstInReg := g.alloc.Regs[dst]
+	rs, srcInReg := g.alloc.Regs[src]
+	switch {
+	case dstInReg && srcInReg:
+		if rd != rs {
+			emitMovReg(rd, rs, g.code)
+		}
+	case dstInReg:
+		g.mem(LDR, rd, g.alloc.Slots[src])
+	case srcInReg:
+		g.mem(STR, rs, g.alloc.Slots[dst])
+	default:
+		r, release := g.temp()
+		g.mem(LDR, r, g.alloc.Slots[src])
+		g.mem(STR, r, g.alloc.Slots[dst])
+		release()
+	}
+}
+
+// callInstr saves the registers holding values live across the
+// call, pushes the arguments from the last to the first and takes
+// the result from the result register
+func (g *funcGen) callInstr(instr *ir.Instr) {
+	call := g.target.Call
+	if instr.Tail && len(instr.Args) <= len(g.fn.Params) {
+		g.tailCall(instr)
+		return
+	}
+
+	dst, dstInReg := g.alloc.Regs[instr.Dst]
+	var saved []Reg
+	for _, r := range g.alloc.across(g.n) {
+		// the call overwrites the value of its destination
+		if !(dstInReg && r == dst) {
+			saved = append(saved, r)
+		}
+	}
+	for _, r := range saved {
+		emit(Instr{Op: PUSH, Rd: r}, g.code)
+	}
+
+	for i := len(instr.Args) - 1; i >= 0; i-- {
+		r, release := g.use(instr.Args[i])
+		emit(Instr{Op: PUSH, Rd: r}, g.code)
+		release()
+	}
+	emitJump(CALL, instr.Callee, g.code)
+	emitAdjustSP(g.vmCtx, len(instr.Args), g.code)
+
+	switch {
+	case instr.Dst == ir.NoReg:
+	case dstInReg:
+		if dst != call.Result {
+			emitMovReg(dst, call.Result, g.code)
+		}
+	default:
+		g.mem(STR, call.Result, g.alloc.Slots[instr.Dst])
+	}
+
+	for i := len(saved) - 1; i >= 0; i-- {
+		emit(Instr{Op: POP, Rd: saved[i]}, g.code)
+	}
+}
+
+// tailCall jumps to the callee reusing the frame of the caller. The
+// arguments take the slots of the parameters of the caller, it has
+// as many or more, and the callee returns straight to the caller of
+// the caller, which drops the words it pushed
+func (g *funcGen) tailCall(instr *ir.Instr) {
+	call := g.target.Call
+
+	// the arguments go through the stack, they may be computed
+	// from the slots they overwrite
+	for i := len(instr.Args) - 1; i >= 0; i-- {
+		r, release := g.use(instr.Args[i])
+		emit(Instr{Op: PUSH, Rd: r}, g.code)
+		release()
+	}
+	for i := range instr.Args {
+		emit(Instr{Op: POP, Rd: call.Result}, g.code)
+		g.mem(STR, call.Result, call.ArgOffset+i)
+	}
+	emitMovReg(call.Stack, call.Frame, g.code)
+	emit(Instr{Op: POP, Rd: call.Frame}, g.code)
+	emitJump(JMP, instr.Callee, g.code)
+	g.tailed = true
+}
+
+// global loads or stores reg from the slot of the register r of
+// main, the frame pointer points to the frame of main while it
+// runs
+func (g *funcGen) global(op Opcode, reg Reg, r ir.Reg) {
+	offset, ok := 0, false
+	if g.globals != nil {
+		offset, ok = g.globals.Slots[r]
+	}
+	if !ok {
+		g.errs = append(g.errs, fmt.Errorf("main has no register %s in memory", r))
+		return
+	}
+
+	bp := g.target.Call.Frame
+	emit(Instr{Op: PUSH, Rd: bp}, g.code)
+	if imm, _ := g.target.Imm(op); imm.Fits(offset) {
+		emitConst(g.vmCtx, bp, g.target.MainFrame(), g.code)
+		emit(Instr{Op: op, Rd: reg, Imm: offset}, g.code)
 	} else {
-		emitMov(ctx, B, uint16(rhsValue.Value), asm)
-		s.push(B)
+		emitConst(g.vmCtx, bp, g.target.MainFrame()+uint16(offset), g.code)
+		emit(Instr{Op: op, Rd: reg, Imm: 0}, g.code)
 	}
+	emit(Instr{Op: POP, Rd: bp}, g.code)
+}
+
+// term emits the terminator of a block, jumps to the
+// block laid out right after it fall through
+func (g *funcGen) term(b *ir.Block, next *ir.Block) {
+	fn, term, call := g.fn, b.Term, g.target.Call
+	switch term.Kind {
+	case ir.Jump:
+		if term.Targets[0] != next {
+			emitJump(JMP, blockLabel(fn, term.Targets[0]), g.code)
+		}
+
+	case ir.Branch:
+		then, els := term.Targets[0], term.Targets[1]
+		if r, ok := g.alloc.Regs[term.Cond]; ok {
+			emit(Instr{Op: JZ, Ra: r, Label: blockLabel(fn, els)}, g.code)
+			if then != next {
+				emitJump(JMP, blockLabel(fn, then), g.code)
+			}
+			return
+		}
+
+		saves := g.saved
+		r, release := g.temp()
+		g.mem(LDR, r, g.alloc.Slots[term.Cond])
+		if g.saved == saves {
+			release()
+			emit(Instr{Op: JZ, Ra: r, Label: blockLabel(fn, els)}, g.code)
+			if then != next {
+				emitJump(JMP, blockLabel(fn, then), g.code)
+			}
+			return
+		}
 
-	rhs := s.pop()
-	lhs := s.pop()
+		// the borrowed register is given back on both ways out
+		restore := blockLabel(fn, b) + ".else"
+		emit(Instr{Op: JZ, Ra: r, Label: restore}, g.code)
+		release()
+		emitJump(JMP, blockLabel(fn, then), g.code)
+		emitLabel(restore, g.code)
+		g.mem(LDR, r, -g.saved-1)
+		emitJump(JMP, blockLabel(fn, els), g.code)
 
-	switch v.Op {
-	case shunting_yard.Add:
-		emitArithRegReg("ADDR", C, lhs, rhs, asm)
-	case shunting_yard.Sub:
-		emitArithRegReg("ADDR", C, lhs, rhs, asm)
-	case shunting_yard.Mul:
-		emitArithRegReg("MULR", C, lhs, rhs, asm)
-	case shunting_yard.Div:
-		emitArithRegReg("DIVR", C, lhs, rhs, asm)
+	case ir.Return:
+		if g.tailed {
+			g.tailed = false
+			return
+		}
+		if term.Value != ir.NoReg {
+			if r, ok := g.alloc.Regs[term.Value]; ok {
+				if r != call.Result {
+					emitMovReg(call.Result, r, g.code)
+				}
+			} else {
+				g.mem(LDR, call.Result, g.alloc.Slots[term.Value])
+			}
+		} else {
+			// nothing returned is 0, like on the stack target
+			emitConst(g.vmCtx, call.Result, 0, g.code)
+		}
+		emitMovReg(call.Stack, call.Frame, g.code)
+		emit(Instr{Op: POP, Rd: call.Frame}, g.code)
+		emit(Instr{Op: RET}, g.code)
 	}
+}
 
-	s.push(C)
+func emit(instr Instr, code *[]Instr) {
+	*code = append(*code, instr)
 }
 
-func emitMov(vm *vmCtx, reg Reg, value uint16, asm *strings.Builder) {
-	if !fitInOperation(value, 9) {
+func emitMov(vm *vmCtx, reg Reg, value uint16, code *[]Instr) {
+	imm, _ := vm.target.Imm(MOV)
+	if !imm.Fits(int(value)) {
+		_, hi := imm.Bounds()
 		vm.errs = append(vm.errs, fmt.Errorf("%w: max %d, got %d",
-			errNumericValueOutOfBounds, 0b111111111, value))
+			errNumericValueOutOfBounds, hi, value))
 	}
 
-	asm.WriteString(fmt.Sprintf("MOV %s, #%d\n", reg.String(), value))
+	emit(Instr{Op: MOV, Rd: reg, Imm: int(value)}, code)
+}
+
+// emitConst loads any word, the ones wider than the immediate
+// of MOV get their top bits set by MOVT
+func emitConst(vm *vmCtx, reg Reg, value uint16, code *[]Instr) {
+	lo, hi := vm.target.Halves(value)
+	emitMov(vm, reg, uint16(lo), code)
+	if hi != 0 {
+		top, ok := vm.target.Imm(MOVT)
+		if !ok || !top.Fits(hi) {
+			vm.errs = append(vm.errs, fmt.Errorf("%w: %d needs MOVT on %s",
+				errNumericValueOutOfBounds, value, vm.target.Name))
+		}
+		emit(Instr{Op: MOVT, Rd: reg, Imm: hi}, code)
+	}
+}
+
+func emitMovReg(dst Reg, src Reg, code *[]Instr) {
+	emit(Instr{Op: MOVR, Rd: dst, Ra: src}, code)
+}
+
+func emitArithRegReg(op Opcode, dstReg Reg, fstReg Reg, sndReg Reg, code *[]Instr) {
+	emit(Instr{Op: op, Rd: dstReg, Ra: fstReg, Rb: sndReg}, code)
+}
+
+// mem emits a load or store of reg from the stack slot at
+// BP+offset, the slots out of reach of the offset of LDR and
+// STR are reached moving BP by the offset, reg holds it for
+// a load and it is saved on the stack for a store
+func (g *funcGen) mem(op Opcode, reg Reg, offset int) {
+	if imm, _ := g.target.Imm(op); imm.Fits(offset) {
+		emit(Instr{Op: op, Rd: reg, Imm: offset}, g.code)
+		return
+	}
+
+	bp := g.target.Call.Frame
+	emit(Instr{Op: PUSH, Rd: bp}, g.code)
+	if op == STR {
+		emit(Instr{Op: PUSH, Rd: reg}, g.code)
+	}
+	emitConst(g.vmCtx, reg, uint16(offset), g.code)
+	emitArithRegReg(ADDR, bp, bp, reg, g.code)
+	if op == STR {
+		emit(Instr{Op: POP, Rd: reg}, g.code)
+	}
+	emit(Instr{Op: op, Rd: reg, Imm: 0}, g.code)
+	emit(Instr{Op: POP, Rd: bp}, g.code)
+}
+
+// emitAdjustSP moves the stack pointer by delta words, the
+// immediate of ADDI is narrow so big frames take more than
+// one instruction
+func emitAdjustSP(vm *vmCtx, delta int, code *[]Instr) {
+	imm, _ := vm.target.Imm(ADDI)
+	lo, hi := imm.Bounds()
+	sp := vm.target.Call.Stack
+	for delta != 0 {
+		step := max(min(delta, hi), lo)
+		emit(Instr{Op: ADDI, Rd: sp, Ra: sp, Imm: step}, code)
+		delta -= step
+	}
 }
 
-func emitArithRegReg(op string, dstReg Reg, fstReg Reg, sndReg Reg, asm *strings.Builder) {
-	asm.WriteString(fmt.Sprintf("%s %s, %s, %s\n", op, dstReg.String(), fstReg.String(), sndReg.String()))
+func emitJump(op Opcode, label string, code *[]Instr) {
+	emit(Instr{Op: op, Label: label}, code)
 }
 
-func fitInOperation(value uint16, bitAmount int) bool {
-	return bits.LeadingZeros16(value) >= (Bits - bitAmount)
+func emitLabel(label string, code *[]Instr) {
+	emit(Instr{Op: Label, Label: label}, code)
 }
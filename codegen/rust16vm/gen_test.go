@@ -2,50 +2,196 @@ package rust16vm
 
 import (
 	"fmt"
-	"stag/lexer"
-	"stag/primitives"
-	"stag/shunting_yard"
+	"stag/frontend"
+	"stag/ir"
 	"testing"
 
 	"github.com/stretchr/testify/require"
 )
 
+func lower(t *testing.T, input string) *ir.Program {
+	t.Helper()
+
+	program, info, errs := frontend.Source(input, frontend.Options{Unfolded: true})
+	require.Empty(t, errs)
+	return ir.Lower(program, info)
+}
+
 func TestSimple(t *testing.T) {
-	input := "3 + 4"
-	l := lexer.New(input)
-
-	var tokens []*primitives.Token
-	for {
-		tok := l.NextToken()
-		if tok.Kind == primitives.EOF {
-			break
-		}
-		tokens = append(tokens, tok)
-	}
+	code, err := Compile(lower(t, "3 + 4"))
+	require.NoError(t, err)
 
-	ast := shunting_yard.ShuntingYard(tokens)
+	// the values live in registers, the sum goes where
+	// main returns it and the frame has no slots
+	exp := "CALL main\nHALT\n" +
+		"main:\nPUSH BP\nMOV BP, SP\n" +
+		"MOV A, #3\nMOV B, #4\nADDR A, A, B\n" +
+		"MOV SP, BP\nPOP BP\nRET\n"
 
-	exp := "MOV A, #3\nMOV B, #4\nADDR C, A, B\n"
+	require.Equal(t, exp, Format(code))
 
-	require.Equal(t, exp, Generate(ast))
+	asm, err := Generate(lower(t, "3 + 4"))
+	require.NoError(t, err)
+	require.Equal(t, exp, asm)
 }
 
 func TestBinaryOpWithManyNodes(t *testing.T) {
-	input := "3 * 4 + 2"
-	l := lexer.New(input)
-
-	var tokens []*primitives.Token
-	for {
-		tok := l.NextToken()
-		if tok.Kind == primitives.EOF {
-			break
+	asm, err := Generate(lower(t, "3 * 4 - 2"))
+	require.NoError(t, err)
+
+	// the product stays in A for the subtraction
+	require.Contains(t, asm, "MULR A, A, B\nMOV B, #2\nSUBR A, A, B\n")
+}
+
+func TestSignedness(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let a: u16 = 6; a / 2;", "DIVR A, A, B"},
+		{"let a: i16 = 6; a / 2;", "SDIVR A, A, B"},
+		{"let a: u8 = 6; a < 2;", "LTR A, A, B"},
+		{"let a: i16 = 6; a <= 2;", "SLER A, A, B"},
+	}
+
+	for _, tt := range tests {
+		asm, err := Generate(lower(t, tt.input))
+		require.NoError(t, err)
+		require.Contains(t, asm, tt.expected, tt.input)
+	}
+}
+
+func TestCallsAndControlFlow(t *testing.T) {
+	asm, err := Generate(lower(t, `
+		fn pick(c: bool, a: u16, b: u16) -> u16 {
+			if c {
+				return a;
+			}
+			return b;
 		}
-		tokens = append(tokens, tok)
+		pick(true, 1, 2);
+	`))
+	require.NoError(t, err)
+
+	require.Contains(t, asm, "pick:\nPUSH BP\nMOV BP, SP\n")
+	// parameters live above the saved BP and the return
+	// address, they are loaded in registers on entry
+	require.Contains(t, asm, "LDR A, [BP, #2]\nLDR B, [BP, #3]\nLDR C, [BP, #4]\nJZ A, pick.if.end.2\n")
+	require.Contains(t, asm, "pick.if.then.1:\nMOV A, B\nMOV SP, BP\nPOP BP\nRET\n")
+	require.Contains(t, asm, "PUSH C\nPUSH B\nPUSH A\nCALL pick\n")
+}
+
+func TestWideConstants(t *testing.T) {
+	asm, err := Generate(lower(t, "let x = 55090 + 5;"))
+	require.NoError(t, err)
+
+	// 55090 = 107 << 9 | 306
+	require.Contains(t, asm, "MOV A, #306\nMOVT A, #107\nMOV B, #5\nADDR A, A, B\n")
+}
+
+func TestValueOutOfBounds(t *testing.T) {
+	ctx := &vmCtx{target: RV16}
+	emitMov(ctx, A, 512, &[]Instr{})
+	require.Len(t, ctx.errs, 1)
+	require.ErrorIs(t, ctx.errs[0], errNumericValueOutOfBounds)
+}
+
+func TestAssignment(t *testing.T) {
+	program := lower(t, "fn f(a: u16, b: u16) -> u16 { return a + b; }")
+	require.Equal(t, map[ir.Reg]string{
+		0: "A",
+		1: "B",
+		2: "A",
+	}, Assignment(program)(program.Func("f")))
+
+	// main keeps the variables the functions reach in memory,
+	// below the save slots
+	program = lower(t, "let g: u16 = 1; fn f() -> u16 { return g; } f();")
+	require.Equal(t, "[BP, #-3]", Assignment(program)(program.Func("main"))[0])
+}
+
+func TestAllocate(t *testing.T) {
+	program := lower(t, `fn sum(n: u16) -> u16 {
+	let total = 0;
+	let i = 1;
+	while i <= n {
+		total = total + i;
+		i = i + 1;
 	}
+	return total;
+}
+sum(4);`)
 
-	ast := shunting_yard.ShuntingYard(tokens)
+	// the copies back to the variables of the loop share their registers
+	alloc := Allocate(program.Func("sum"), RV16, nil)
+	require.Equal(t, Stats{Func: "sum", Values: 7, Regs: 7, Coalesced: 2}, alloc.Stats)
+	require.Zero(t, alloc.Size)
 
-	//exp := "MOV A, #3\nMOV B, #4\nADDR C, A, B\n"
+	// more values live than registers, the ones live the longest
+	// go to slots below the save slots
+	program = lower(t, liveVariables(6))
+	alloc = Allocate(program.Func(ir.MainFunc), RV16, nil)
+	require.Positive(t, alloc.Stats.Spilled)
+	require.Equal(t, saveSlots, alloc.Saves)
+	for v, slot := range alloc.Slots {
+		require.Less(t, slot, -saveSlots, "%%%d", v)
+		require.NotContains(t, alloc.Regs, v)
+	}
 
-	fmt.Println(Generate(ast))
+	code, err := Compile(program)
+	require.NoError(t, err)
+	m, err := Simulate(code, 10_000)
+	require.NoError(t, err)
+	require.Equal(t, uint16(15), m.Regs[A])
+}
+
+func TestTailCalls(t *testing.T) {
+	// run returns the result of the program and the most words
+	// the stack held, counting down from n
+	run := func(src string, n int, passes ...ir.Pass) (uint16, int) {
+		program := lower(t, fmt.Sprintf(src, n))
+		require.NoError(t, ir.Optimize(program, passes...))
+		code, err := Compile(program)
+		require.NoError(t, err)
+		m, err := Simulate(Peephole(code, Rules), 1_000_000)
+		require.NoError(t, err)
+		return m.Regs[A], m.Deepest
+	}
+
+	// the call to itself jumps back to the start of count
+	count := `fn count(n: u16, acc: u16) -> u16 {
+	if n == 0 {
+		return acc;
+	}
+	return count(n - 1, acc + 2);
+}
+count(%d, 0)`
+	result, deepest := run(count, 10_000, ir.TailCalls)
+	require.Equal(t, uint16(20_000), result)
+	_, shallow := run(count, 10, ir.TailCalls)
+	require.Equal(t, shallow, deepest)
+
+	// every call takes a frame of its own without them
+	_, deep := run(count, 10_000)
+	require.Greater(t, deep, 4*10_000)
+
+	// even and odd jump to each other in the frame of the first call
+	evenOdd := `fn even(n: u16) -> bool {
+	if n == 0 {
+		return true;
+	}
+	return odd(n - 1);
+}
+fn odd(n: u16) -> bool {
+	if n == 0 {
+		return false;
+	}
+	return even(n - 1);
+}
+even(%d)`
+	result, deepest = run(evenOdd, 10_001, ir.TailCalls)
+	require.Equal(t, uint16(0), result)
+	result, shallow = run(evenOdd, 10, ir.TailCalls)
+	require.Equal(t, uint16(1), result)
+	require.Equal(t, shallow, deepest)
 }
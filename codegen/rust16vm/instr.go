@@ -0,0 +1,222 @@
+package rust16vm
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Opcode uint8
+
+const (
+	Label Opcode = iota // pseudo instruction defining Instr.Label
+
+	MOV  // MOV Rd, #imm9
+	MOVT // MOVT Rd, #imm7 sets the top 7 bits of Rd
+	MOVR // MOV Rd, Ra
+
+	ADDR // ADDR Rd, Ra, Rb
+	SUBR
+	MULR
+	DIVR
+	SDIVR
+	ANDR
+	ORR
+	XORR
+	EQR
+	NER
+	LTR
+	LER
+	SLTR
+	SLER
+	SHLR
+	SHRR
+	SARR
+
+	ADDI // ADDI Rd, Ra, #imm6
+
+	LDR // LDR Rd, [BP, #imm9]
+	STR // STR Rd, [BP, #imm9]
+
+	PUSH // PUSH Rd
+	POP  // POP Rd
+
+	JMP  // JMP label
+	JZ   // JZ Ra, label
+	JNZ  // JNZ Ra, label
+	CALL // CALL label
+	RET
+	HALT
+	NOP
+)
+
+var mnemonics = map[Opcode]string{
+	MOV:   "MOV",
+	MOVT:  "MOVT",
+	MOVR:  "MOV",
+	ADDR:  "ADDR",
+	SUBR:  "SUBR",
+	MULR:  "MULR",
+	DIVR:  "DIVR",
+	SDIVR: "SDIVR",
+	ANDR:  "ANDR",
+	ORR:   "ORR",
+	XORR:  "XORR",
+	EQR:   "EQR",
+	NER:   "NER",
+	LTR:   "LTR",
+	LER:   "LER",
+	SLTR:  "SLTR",
+	SLER:  "SLER",
+	SHLR:  "SHLR",
+	SHRR:  "SHRR",
+	SARR:  "SARR",
+	ADDI:  "ADDI",
+	LDR:   "LDR",
+	STR:   "STR",
+	PUSH:  "PUSH",
+	POP:   "POP",
+	JMP:   "JMP",
+	JZ:    "JZ",
+	JNZ:   "JNZ",
+	CALL:  "CALL",
+	RET:   "RET",
+	HALT:  "HALT",
+	NOP:   "NOP",
+}
+
+func (op Opcode) String() string {
+	if op == Label {
+		return "label"
+	}
+	if m, ok := mnemonics[op]; ok {
+		return m
+	}
+	return fmt.Sprintf("Opcode(%d)", op)
+}
+
+// IsRegReg reports if the operation is Rd = Ra op Rb
+func (op Opcode) IsRegReg() bool {
+	return op >= ADDR && op <= SARR
+}
+
+// IsJump reports if the operation transfers the control to Instr.Label
+func (op Opcode) IsJump() bool {
+	return op == JMP || op == JZ || op == JNZ || op == CALL
+}
+
+// Instr is a single rust16vm instruction, for jumps the target is
+// Label before linking and the instruction index in Imm after it.
+// MOV and MOVT with a Label load the low and the top bits of its
+// address
+type Instr struct {
+	Op    Opcode
+	Rd    Reg
+	Ra    Reg
+	Rb    Reg
+	Imm   int
+	Label string
+
+	Line int // source line it was generated from, 0 when unknown
+}
+
+func (i Instr) String() string {
+	switch {
+	case i.Op == Label:
+		return i.Label + ":"
+	case (i.Op == MOV || i.Op == MOVT) && i.Label != "":
+		return fmt.Sprintf("%s %s, %s", i.Op, i.Rd, i.Label)
+	case i.Op == MOV || i.Op == MOVT:
+		return fmt.Sprintf("%s %s, #%d", i.Op, i.Rd, i.Imm)
+	case i.Op == MOVR:
+		return fmt.Sprintf("MOV %s, %s", i.Rd, i.Ra)
+	case i.Op.IsRegReg():
+		return fmt.Sprintf("%s %s, %s, %s", i.Op, i.Rd, i.Ra, i.Rb)
+	case i.Op == ADDI:
+		return fmt.Sprintf("ADDI %s, %s, #%d", i.Rd, i.Ra, i.Imm)
+	case i.Op == LDR || i.Op == STR:
+		return fmt.Sprintf("%s %s, [BP, #%d]", i.Op, i.Rd, i.Imm)
+	case i.Op == PUSH || i.Op == POP:
+		return fmt.Sprintf("%s %s", i.Op, i.Rd)
+	case i.Op == JZ || i.Op == JNZ:
+		return fmt.Sprintf("%s %s, %s", i.Op, i.Ra, i.target())
+	case i.Op == JMP || i.Op == CALL:
+		return fmt.Sprintf("%s %s", i.Op, i.target())
+	default:
+		return i.Op.String()
+	}
+}
+
+func (i Instr) target() string {
+	if i.Label != "" {
+		return i.Label
+	}
+	return fmt.Sprintf("#%d", i.Imm)
+}
+
+// Writes returns the register the instruction writes, ok is
+// false when it does not write any general purpose register
+func (i Instr) Writes() (Reg, bool) {
+	switch {
+	case i.Op == MOV, i.Op == MOVT, i.Op == MOVR, i.Op.IsRegReg(),
+		i.Op == ADDI, i.Op == LDR, i.Op == POP:
+		return i.Rd, true
+	}
+	return 0, false
+}
+
+// Reads reports if the instruction reads the register
+func (i Instr) Reads(r Reg) bool {
+	switch {
+	case i.Op == MOVT:
+		return i.Rd == r
+	case i.Op == MOVR:
+		return i.Ra == r
+	case i.Op.IsRegReg():
+		return i.Ra == r || i.Rb == r
+	case i.Op == ADDI:
+		return i.Ra == r
+	case i.Op == LDR:
+		return r == BP
+	case i.Op == STR, i.Op == PUSH:
+		return i.Rd == r || r == BP || r == SP
+	case i.Op == POP:
+		return r == SP
+	case i.Op == JZ || i.Op == JNZ:
+		return i.Ra == r
+	case i.Op == CALL, i.Op == RET, i.Op == HALT:
+		// the callee, caller or whoever inspects
+		// the machine after it halts may read anything
+		return true
+	}
+	return false
+}
+
+// Format renders the instructions as rust16vm assembly
+func Format(code []Instr) string {
+	var out strings.Builder
+	for _, instr := range code {
+		out.WriteString(instr.String())
+		out.WriteString("\n")
+	}
+	return out.String()
+}
+
+// FormatDebug renders the instructions like Format with the
+// debug directives the assembler keeps in the binary, .file
+// names the source and .line precedes the instructions
+// generated from a different source line than the previous ones
+func FormatDebug(code []Instr, file string) string {
+	var out strings.Builder
+	out.WriteString(fmt.Sprintf(".file %q\n", file))
+
+	line := 0
+	for _, instr := range code {
+		if instr.Op != Label && instr.Line != line {
+			line = instr.Line
+			out.WriteString(fmt.Sprintf(".line %d\n", line))
+		}
+		out.WriteString(instr.String())
+		out.WriteString("\n")
+	}
+	return out.String()
+}
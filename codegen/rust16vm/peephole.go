@@ -0,0 +1,161 @@
+package rust16vm
+
+// Rule rewrites a window of Size consecutive instructions, Rewrite
+// returns the replacement and false when the window does not match.
+// A window only spans a label when the rule matches it explicitly,
+// so the rules never see two instructions of different blocks
+type Rule struct {
+	Name    string
+	Size    int
+	Rewrite func(w []Instr) ([]Instr, bool)
+}
+
+// Rules are the peephole rules Generate runs, every one of them
+// is checked against the simulator by the tests
+var Rules = []Rule{
+	{Name: "self-move", Size: 1, Rewrite: selfMove},
+	{Name: "store-load", Size: 2, Rewrite: storeLoad},
+	{Name: "load-store", Size: 2, Rewrite: loadStore},
+	{Name: "dead-write", Size: 2, Rewrite: deadWrite},
+	{Name: "push-pop", Size: 2, Rewrite: pushPop},
+	{Name: "jump-next", Size: 2, Rewrite: jumpNext},
+	{Name: "unreachable", Size: 2, Rewrite: unreachable},
+	{Name: "store-x-load", Size: 3, Rewrite: storeXLoad},
+	{Name: "branch-over-jump", Size: 3, Rewrite: branchOverJump},
+}
+
+// Peephole applies the rules to the code until none of them
+// matches, the earlier rules are tried first
+func Peephole(code []Instr, rules []Rule) []Instr {
+	out := append([]Instr(nil), code...)
+
+	for i := 0; i < len(out); {
+		rewritten := false
+		for _, rule := range rules {
+			if i+rule.Size > len(out) {
+				continue
+			}
+			replacement, ok := rule.Rewrite(out[i : i+rule.Size])
+			if !ok {
+				continue
+			}
+
+			rest := append(append([]Instr(nil), replacement...), out[i+rule.Size:]...)
+			out = append(out[:i], rest...)
+			rewritten = true
+			break
+		}
+
+		if rewritten {
+			// the replacement may complete a window
+			// starting in the previous instructions
+			i = max(i-3, 0)
+			continue
+		}
+		i++
+	}
+	return out
+}
+
+// MOV r, r
+func selfMove(w []Instr) ([]Instr, bool) {
+	if w[0].Op == MOVR && w[0].Rd == w[0].Ra {
+		return nil, true
+	}
+	return nil, false
+}
+
+// STR r, [BP, #k]; LDR s, [BP, #k] reuses the stored register
+func storeLoad(w []Instr) ([]Instr, bool) {
+	st, ld := w[0], w[1]
+	if st.Op != STR || ld.Op != LDR || st.Imm != ld.Imm || ld.Rd == BP {
+		return nil, false
+	}
+	if ld.Rd == st.Rd {
+		return []Instr{st}, true
+	}
+	return []Instr{st, {Op: MOVR, Rd: ld.Rd, Ra: st.Rd, Line: ld.Line}}, true
+}
+
+// LDR r, [BP, #k]; STR r, [BP, #k] stores back the same value
+func loadStore(w []Instr) ([]Instr, bool) {
+	ld, st := w[0], w[1]
+	if ld.Op == LDR && st.Op == STR && ld.Rd == st.Rd && ld.Imm == st.Imm && ld.Rd != BP {
+		return []Instr{ld}, true
+	}
+	return nil, false
+}
+
+// a register written and overwritten before being read, the
+// divisions are kept since they trap on a zero divisor
+func deadWrite(w []Instr) ([]Instr, bool) {
+	first, second := w[0], w[1]
+	if !pureWrite(first) {
+		return nil, false
+	}
+	r, _ := first.Writes()
+	if w, ok := second.Writes(); !ok || w != r || second.Reads(r) {
+		return nil, false
+	}
+	return []Instr{second}, true
+}
+
+func pureWrite(i Instr) bool {
+	switch {
+	case i.Op == DIVR, i.Op == SDIVR:
+		return false
+	case i.Op == MOV, i.Op == MOVT, i.Op == MOVR, i.Op.IsRegReg(), i.Op == ADDI, i.Op == LDR:
+		return true
+	}
+	return false
+}
+
+// PUSH r; POP s only moves the value through the stack
+func pushPop(w []Instr) ([]Instr, bool) {
+	push, pop := w[0], w[1]
+	if push.Op != PUSH || pop.Op != POP || push.Rd == SP || pop.Rd == SP {
+		return nil, false
+	}
+	return []Instr{{Op: MOVR, Rd: pop.Rd, Ra: push.Rd, Line: pop.Line}}, true
+}
+
+// JMP l; l:
+func jumpNext(w []Instr) ([]Instr, bool) {
+	if w[0].Op == JMP && w[1].Op == Label && w[0].Label == w[1].Label {
+		return []Instr{w[1]}, true
+	}
+	return nil, false
+}
+
+// nothing reaches the instructions between an
+// unconditional transfer and the next label
+func unreachable(w []Instr) ([]Instr, bool) {
+	switch w[0].Op {
+	case JMP, RET, HALT:
+		if w[1].Op != Label {
+			return []Instr{w[0]}, true
+		}
+	}
+	return nil, false
+}
+
+// STR r, [BP, #k]; x; LDR s, [BP, #k] when x keeps both r and the slot
+func storeXLoad(w []Instr) ([]Instr, bool) {
+	st, x, ld := w[0], w[1], w[2]
+	if st.Op != STR || ld.Op != LDR || st.Imm != ld.Imm || ld.Rd == BP {
+		return nil, false
+	}
+	if r, ok := x.Writes(); !ok || r == st.Rd || r == BP {
+		return nil, false
+	}
+	return []Instr{st, x, {Op: MOVR, Rd: ld.Rd, Ra: st.Rd, Line: ld.Line}}, true
+}
+
+// JZ r, l; JMP m; l: becomes JNZ r, m; l:
+func branchOverJump(w []Instr) ([]Instr, bool) {
+	br, jmp, label := w[0], w[1], w[2]
+	if br.Op != JZ || jmp.Op != JMP || label.Op != Label || br.Label != label.Label {
+		return nil, false
+	}
+	return []Instr{{Op: JNZ, Ra: br.Ra, Label: jmp.Label, Line: br.Line}, label}, true
+}
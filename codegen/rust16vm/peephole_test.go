@@ -0,0 +1,218 @@
+package rust16vm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func mov(r Reg, imm int) Instr          { return Instr{Op: MOV, Rd: r, Imm: imm} }
+func movr(d, s Reg) Instr               { return Instr{Op: MOVR, Rd: d, Ra: s} }
+func ldr(r Reg, off int) Instr          { return Instr{Op: LDR, Rd: r, Imm: off} }
+func str(r Reg, off int) Instr          { return Instr{Op: STR, Rd: r, Imm: off} }
+func alu3(op Opcode, d, a, b Reg) Instr { return Instr{Op: op, Rd: d, Ra: a, Rb: b} }
+func jump(op Opcode, l string) Instr    { return Instr{Op: op, Label: l} }
+func label(l string) Instr              { return Instr{Op: Label, Label: l} }
+
+var halt = Instr{Op: HALT}
+
+// frame4 sets up a stack frame with four slots around the body
+// so the stores land in the live part of the stack
+func frame4(body ...Instr) []Instr {
+	code := []Instr{
+		{Op: PUSH, Rd: BP},
+		movr(BP, SP),
+		{Op: ADDI, Rd: SP, Ra: SP, Imm: -4},
+		mov(B, 11),
+		mov(C, 13),
+		mov(M, 17),
+	}
+	return append(code, body...)
+}
+
+// requireEquivalent runs both versions in the simulator and
+// compares the registers and the live part of the stack, the
+// program counter is left out since the code size changes
+func requireEquivalent(t *testing.T, before, after []Instr) {
+	t.Helper()
+
+	m1, err1 := Simulate(before, maxSteps)
+	m2, err2 := Simulate(after, maxSteps)
+	require.Equal(t, err1, err2)
+
+	for _, r := range []Reg{A, B, C, M, BP, SP, FLAGS} {
+		require.Equal(t, m1.Regs[r], m2.Regs[r], "register %s", r)
+	}
+	for addr := int(m1.Regs[SP]); addr != 0 && addr < len(m1.Mem); addr++ {
+		require.Equal(t, m1.Mem[addr], m2.Mem[addr], "memory at %d", addr)
+	}
+}
+
+func ruleNamed(t *testing.T, name string) Rule {
+	for _, rule := range Rules {
+		if rule.Name == name {
+			return rule
+		}
+	}
+	t.Fatalf("no rule %s", name)
+	return Rule{}
+}
+
+func TestPeepholeRules(t *testing.T) {
+	tests := []struct {
+		rule     string
+		code     []Instr
+		expected []Instr
+	}{
+		{
+			rule:     "self-move",
+			code:     frame4(mov(A, 5), movr(A, A), halt),
+			expected: frame4(mov(A, 5), halt),
+		},
+		{
+			rule:     "store-load",
+			code:     frame4(mov(A, 7), str(A, -1), ldr(A, -1), halt),
+			expected: frame4(mov(A, 7), str(A, -1), halt),
+		},
+		{
+			rule:     "store-load",
+			code:     frame4(mov(A, 7), str(A, -1), ldr(B, -1), halt),
+			expected: frame4(mov(A, 7), str(A, -1), movr(B, A), halt),
+		},
+		{
+			rule:     "load-store",
+			code:     frame4(str(B, -2), ldr(A, -2), str(A, -2), halt),
+			expected: frame4(str(B, -2), ldr(A, -2), halt),
+		},
+		{
+			rule:     "dead-write",
+			code:     frame4(mov(A, 1), mov(A, 2), halt),
+			expected: frame4(mov(A, 2), halt),
+		},
+		{
+			rule:     "dead-write",
+			code:     frame4(ldr(A, 2), alu3(ADDR, A, B, C), halt),
+			expected: frame4(alu3(ADDR, A, B, C), halt),
+		},
+		{
+			rule:     "push-pop",
+			code:     frame4(mov(A, 9), Instr{Op: PUSH, Rd: A}, Instr{Op: POP, Rd: B}, halt),
+			expected: frame4(mov(A, 9), movr(B, A), halt),
+		},
+		{
+			rule:     "jump-next",
+			code:     frame4(jump(JMP, "l"), label("l"), mov(A, 2), halt),
+			expected: frame4(label("l"), mov(A, 2), halt),
+		},
+		{
+			rule:     "unreachable",
+			code:     frame4(jump(JMP, "l"), mov(A, 3), mov(B, 4), label("l"), halt),
+			expected: frame4(jump(JMP, "l"), label("l"), halt),
+		},
+		{
+			rule:     "store-x-load",
+			code:     frame4(mov(A, 4), str(A, -1), mov(B, 1), ldr(C, -1), halt),
+			expected: frame4(mov(A, 4), str(A, -1), mov(B, 1), movr(C, A), halt),
+		},
+		{
+			rule: "branch-over-jump",
+			code: frame4(mov(A, 0), jump(JZ, "l"), jump(JMP, "m"),
+				label("l"), mov(B, 1), label("m"), halt),
+			expected: frame4(mov(A, 0), Instr{Op: JNZ, Ra: A, Label: "m"},
+				label("l"), mov(B, 1), label("m"), halt),
+		},
+		{
+			rule: "branch-over-jump",
+			code: frame4(mov(A, 1), jump(JZ, "l"), jump(JMP, "m"),
+				label("l"), mov(B, 1), label("m"), halt),
+			expected: frame4(mov(A, 1), Instr{Op: JNZ, Ra: A, Label: "m"},
+				label("l"), mov(B, 1), label("m"), halt),
+		},
+	}
+
+	for _, tt := range tests {
+		rule := ruleNamed(t, tt.rule)
+		optimized := Peephole(tt.code, []Rule{rule})
+		require.Equal(t, Format(tt.expected), Format(optimized), tt.rule)
+		requireEquivalent(t, tt.code, optimized)
+	}
+}
+
+func TestPeepholeKeeps(t *testing.T) {
+	tests := [][]Instr{
+		// a different slot
+		frame4(mov(A, 7), str(A, -1), ldr(A, -2), halt),
+		// the second write reads the first one
+		frame4(mov(A, 1), alu3(ADDR, A, A, B), halt),
+		// MOVT keeps the low bits it does not write
+		frame4(mov(A, 1), Instr{Op: MOVT, Rd: A, Imm: 3}, halt),
+		// the division traps on zero
+		frame4(alu3(DIVR, A, B, C), mov(A, 1), halt),
+		// a label in between may be jumped to
+		frame4(mov(A, 1), label("l"), mov(A, 2), halt),
+		// the intermediate instruction overwrites the stored register
+		frame4(str(A, -1), ldr(A, -2), ldr(B, -1), halt),
+		// JZ over a jump to another label
+		frame4(jump(JZ, "l"), jump(JMP, "m"), label("m"), label("l"), halt),
+	}
+
+	for _, code := range tests {
+		var rules []Rule
+		for _, rule := range Rules {
+			if rule.Name != "jump-next" {
+				rules = append(rules, rule)
+			}
+		}
+		require.Equal(t, Format(code), Format(Peephole(code, rules)))
+	}
+}
+
+func TestPeepholePrograms(t *testing.T) {
+	tests := []string{
+		"3 + 4",
+		"3 * 4 - 2",
+		"let x: i16 = -7; let y: i16 = x / 2; y * y;",
+		"let a: u8 = 250; let b: u8 = a + 10; b << 2;",
+		`
+			fn fib(n: u16) -> u16 {
+				if n < 2 {
+					return n;
+				} else {
+					return fib(n - 1) + fib(n - 2);
+				}
+			}
+			fib(12);
+		`,
+		`
+			fn collatz(n: u16) -> u16 {
+				let steps: u16 = 0;
+				while n != 1 {
+					if n - n / 2 * 2 == 0 {
+						n = n / 2;
+					} else {
+						n = 3 * n + 1;
+					}
+					steps = steps + 1;
+				}
+				return steps;
+			}
+			collatz(27);
+		`,
+	}
+
+	for _, input := range tests {
+		code, err := Compile(lower(t, input))
+		require.NoError(t, err)
+
+		optimized := Peephole(code, Rules)
+		require.LessOrEqual(t, len(optimized), len(code), input)
+
+		m1, err := Simulate(code, maxSteps)
+		require.NoError(t, err, input)
+		m2, err := Simulate(optimized, maxSteps)
+		require.NoError(t, err, input)
+
+		require.Equal(t, m1.Regs[A], m2.Regs[A], input)
+		require.LessOrEqual(t, m2.Steps, m1.Steps, input)
+	}
+}
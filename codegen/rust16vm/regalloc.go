@@ -0,0 +1,316 @@
+package rust16vm
+
+import (
+	"fmt"
+	"slices"
+	"stag/ir"
+)
+
+// saveSlots are the slots right below BP where the code keeps the
+// registers it borrows to reach the values that live in memory
+const saveSlots = 2
+
+// interval is the range of positions where a virtual register is
+// live. The instructions of a function are numbered in the order of
+// the blocks from 1, the instruction n reads at 2n and writes at
+// 2n+1 and the parameters are written at 1
+type interval struct {
+	vreg       ir.Reg
+	start, end int
+	reg        Reg
+	inReg      bool
+	// hint is the interval of the register copied to this one,
+	// sharing its register makes the copy go away
+	hint   *interval
+	hintAt int
+	// into is the interval of the register this one is copied to,
+	// dead from the write of this one to the copy so the value can
+	// be computed right into its register
+	into   *interval
+	defAt  int
+	intoAt int
+	// prefer is the register the value wants to be in
+	prefer    Reg
+	hasPrefer bool
+}
+
+func (i *interval) extend(pos int) {
+	i.start = min(i.start, pos)
+	i.end = max(i.end, pos)
+}
+
+func (i *interval) covers(from, to int) bool {
+	return i.start <= to && i.end >= from
+}
+
+// Stats is what the register allocator did in a function
+type Stats struct {
+	Func      string
+	Values    int // virtual registers with a value
+	Regs      int // the ones kept in registers
+	Spilled   int // the ones kept in stack slots
+	Coalesced int // copies whose both ends share a register
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("%s: %d values, %d in registers, %d spilled, %d copies coalesced",
+		s.Func, s.Values, s.Regs, s.Spilled, s.Coalesced)
+}
+
+// Allocation is where each virtual register of a function lives,
+// a register of the target or a slot relative to BP for the whole
+// function
+type Allocation struct {
+	Regs  map[ir.Reg]Reg
+	Slots map[ir.Reg]int
+	// Saves are the save slots, 0 when nothing lives in memory
+	Saves int
+	// Size is the amount of slots below BP
+	Size  int
+	Stats Stats
+
+	intervals []*interval
+}
+
+// Allocate assigns the virtual registers of fn with a linear scan
+// over their live intervals. When no register is free the value
+// that stays live the longest goes to memory, the ones in memory
+// are forced there, e.g. the registers of main other functions
+// reach through Load and Store
+func Allocate(fn *ir.Func, target *Target, memory ir.RegSet) *Allocation {
+	intervals := liveIntervals(fn, target)
+
+	a := &Allocation{Regs: map[ir.Reg]Reg{}, Slots: map[ir.Reg]int{}, Stats: Stats{Func: fn.Name}, intervals: intervals}
+	regs := target.Allocatable()
+	free := map[Reg]bool{}
+	for _, r := range regs {
+		free[r] = true
+	}
+
+	var active []*interval
+	var spilled []*interval
+	// the intervals sharing the register of an active one
+	sharing := map[*interval][]*interval{}
+	spill := func(i *interval) {
+		i.inReg = false
+		spilled = append(spilled, i)
+		for _, s := range sharing[i] {
+			s.inReg = false
+			spilled = append(spilled, s)
+		}
+	}
+	take := func(i *interval, r Reg) {
+		i.reg, i.inReg = r, true
+		free[r] = false
+		active = append(active, i)
+	}
+
+	for _, cur := range intervals {
+		// the intervals that ended before this one free their registers
+		kept := active[:0]
+		for _, i := range active {
+			if i.end < cur.start {
+				free[i.reg] = true
+			} else {
+				kept = append(kept, i)
+			}
+		}
+		active = kept
+
+		if memory[cur.vreg] {
+			spill(cur)
+			continue
+		}
+
+		switch {
+		case cur.into != nil && cur.into.inReg:
+			// the register it is copied to holds no value until the
+			// copy, the one being computed can go there
+			cur.reg, cur.inReg = cur.into.reg, true
+			sharing[cur.into] = append(sharing[cur.into], cur)
+			a.Stats.Coalesced++
+			continue
+		case cur.hint != nil && cur.hint.inReg && free[cur.hint.reg]:
+			take(cur, cur.hint.reg)
+			a.Stats.Coalesced++
+			continue
+		case cur.hasPrefer && free[cur.prefer]:
+			take(cur, cur.prefer)
+			continue
+		}
+		if i := slices.IndexFunc(regs, func(r Reg) bool { return free[r] }); i >= 0 {
+			take(cur, regs[i])
+			continue
+		}
+
+		// no register is free, the one live the longest goes to memory
+		victim := cur
+		for _, i := range active {
+			if i.end > victim.end {
+				victim = i
+			}
+		}
+		if victim == cur {
+			spill(cur)
+			continue
+		}
+		active = slices.DeleteFunc(active, func(i *interval) bool { return i == victim })
+		free[victim.reg] = true
+		spill(victim)
+		take(cur, victim.reg)
+	}
+
+	params := map[ir.Reg]int{}
+	for i, p := range fn.Params {
+		params[p] = target.Call.ArgOffset + i
+	}
+	if len(spilled) > 0 {
+		a.Saves = saveSlots
+	}
+	a.Size = a.Saves
+	slices.SortFunc(spilled, func(x, y *interval) int { return int(x.vreg - y.vreg) })
+	for _, i := range spilled {
+		// the parameters stay where the caller pushed them
+		if offset, ok := params[i.vreg]; ok {
+			a.Slots[i.vreg] = offset
+			continue
+		}
+		a.Size++
+		a.Slots[i.vreg] = -a.Size
+	}
+	for _, i := range intervals {
+		if i.inReg {
+			a.Regs[i.vreg] = i.reg
+		}
+	}
+
+	a.Stats.Values = len(intervals)
+	a.Stats.Spilled = len(spilled)
+	a.Stats.Regs = len(intervals) - len(spilled)
+	return a
+}
+
+// liveIntervals returns the intervals of the registers of fn in the
+// order they start, a register is live from its first write or the
+// start of the first block it is live into to its last read or the
+// end of the last block it is live out of
+func liveIntervals(fn *ir.Func, target *Target) []*interval {
+	live := ir.Live(fn)
+	byReg := map[ir.Reg]*interval{}
+	at := func(r ir.Reg, pos int) *interval {
+		i, ok := byReg[r]
+		if !ok {
+			i = &interval{vreg: r, start: pos, end: pos}
+			byReg[r] = i
+		}
+		i.extend(pos)
+		return i
+	}
+
+	for _, p := range fn.Params {
+		at(p, 1)
+	}
+
+	n := 1
+	for _, b := range fn.Blocks {
+		first := n + 1
+		for r := range live.In[b] {
+			at(r, 2*first)
+		}
+
+		// where each register was last read or written and the
+		// last call in the block, a value computed into the
+		// register it is copied to can not cross either
+		touched := map[ir.Reg]int{}
+		called := 0
+		for _, instr := range b.Instrs {
+			n++
+			for _, r := range instr.Uses() {
+				at(r, 2*n)
+				touched[r] = 2 * n
+			}
+			if d := instr.Def(); d != ir.NoReg {
+				if instr.Op == ir.Copy {
+					src := byReg[instr.Args[0]]
+					if src != nil && src.defAt > 2*first && called <= src.defAt && touched[d] < src.defAt {
+						src.into, src.intoAt = byReg[d], 2*n
+					}
+				}
+				i := at(d, 2*n+1)
+				i.defAt = 2*n + 1
+				touched[d] = 2*n + 1
+				switch {
+				case instr.Op == ir.Copy:
+					if src := byReg[instr.Args[0]]; src != nil && src != i && i.start == 2*n+1 {
+						i.hint, i.hintAt = src, 2*n+1
+					}
+				case instr.Op == ir.Call:
+					i.prefer, i.hasPrefer = target.Call.Result, true
+				}
+			}
+			if instr.Op == ir.Call {
+				called = 2*n + 1
+			}
+		}
+
+		n++
+		if b.Term != nil {
+			for _, r := range b.Term.Uses() {
+				i := at(r, 2*n)
+				if b.Term.Kind == ir.Return {
+					i.prefer, i.hasPrefer = target.Call.Result, true
+				}
+			}
+		}
+		for r := range live.Out[b] {
+			at(r, 2*n+1)
+		}
+	}
+
+	intervals := make([]*interval, 0, len(byReg))
+	for _, i := range byReg {
+		// a copy only goes away when it starts the interval
+		// and its source dies at it
+		if i.hint != nil && (i.start != i.hintAt || i.hint.end != i.hintAt-1) {
+			i.hint = nil
+		}
+		// and the register it is copied to must hold a value all
+		// along, so no other value is in its register meanwhile
+		if i.into != nil && (i.start != i.defAt || i.end != i.intoAt || i.into.start >= i.start || i.into == i) {
+			i.into = nil
+		}
+		intervals = append(intervals, i)
+	}
+	slices.SortFunc(intervals, func(x, y *interval) int {
+		if x.start != y.start {
+			return x.start - y.start
+		}
+		return int(x.vreg - y.vreg)
+	})
+	return intervals
+}
+
+// busy returns the registers holding values live between the
+// positions from and to
+func (a *Allocation) busy(from, to int) map[Reg]bool {
+	out := map[Reg]bool{}
+	for _, i := range a.intervals {
+		if i.inReg && i.covers(from, to) {
+			out[i.reg] = true
+		}
+	}
+	return out
+}
+
+// across returns the registers holding values that are live before
+// and after the instruction n, a call has to save them
+func (a *Allocation) across(n int) []Reg {
+	var out []Reg
+	for _, i := range a.intervals {
+		if i.inReg && i.start <= 2*n && i.end >= 2*n+2 {
+			out = append(out, i.reg)
+		}
+	}
+	slices.Sort(out)
+	return slices.Compact(out)
+}
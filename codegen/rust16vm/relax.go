@@ -0,0 +1,110 @@
+package rust16vm
+
+import "fmt"
+
+// Relax rewrites the jumps whose offset does not fit the field of the
+// target. A far JZ becomes a JNZ over a JMP, a far JMP pushes the
+// address of its label and returns to it and a far CALL pushes the
+// address after it before jumping. The code is rewritten until every
+// jump fits since growing it may push other jumps out of range
+func Relax(code []Instr, target *Target) []Instr {
+	labels := map[string]bool{}
+	for _, instr := range code {
+		if instr.Op == Label {
+			labels[instr.Label] = true
+		}
+	}
+	n := 0
+	fresh := func() string {
+		for {
+			n++
+			name := fmt.Sprintf("relax.%d", n)
+			if !labels[name] {
+				labels[name] = true
+				return name
+			}
+		}
+	}
+
+	for {
+		addrs := addresses(code)
+		out := make([]Instr, 0, len(code))
+		changed := false
+		addr := 0
+		for _, instr := range code {
+			if instr.Op == Label {
+				out = append(out, instr)
+				continue
+			}
+			to, ok := addrs[instr.Label]
+			imm, limited := target.Imm(instr.Op)
+			if !instr.Op.IsJump() || !ok || !limited || imm.Fits(to-addr-1) {
+				out = append(out, instr)
+				addr++
+				continue
+			}
+
+			changed = true
+			addr++
+			line := instr.Line
+			switch instr.Op {
+			case JZ, JNZ:
+				skip := fresh()
+				inverse := JNZ
+				if instr.Op == JNZ {
+					inverse = JZ
+				}
+				out = append(out,
+					Instr{Op: inverse, Ra: instr.Ra, Label: skip, Line: line},
+					Instr{Op: JMP, Label: instr.Label, Line: line},
+					Instr{Op: Label, Label: skip})
+			case JMP:
+				out = append(out, pushAddr(instr.Label, target, line)...)
+				out = append(out, Instr{Op: RET, Line: line})
+			case CALL:
+				ret := fresh()
+				out = append(out, pushAddr(ret, target, line)...)
+				out = append(out,
+					Instr{Op: JMP, Label: instr.Label, Line: line},
+					Instr{Op: Label, Label: ret})
+			}
+		}
+		code = out
+		if !changed {
+			return code
+		}
+	}
+}
+
+// addresses returns the address of each label, the labels take no
+// room so it is the amount of instructions before them
+func addresses(code []Instr) map[string]int {
+	addrs := map[string]int{}
+	addr := 0
+	for _, instr := range code {
+		if instr.Op == Label {
+			addrs[instr.Label] = addr
+			continue
+		}
+		addr++
+	}
+	return addrs
+}
+
+// pushAddr pushes the address of label leaving every register as it
+// was: a word is pushed for the address and the frame pointer and
+// the result register are saved around writing it through the frame
+func pushAddr(label string, target *Target, line int) []Instr {
+	bp, sp, r := target.Call.Frame, target.Call.Stack, target.Call.Result
+	return []Instr{
+		{Op: PUSH, Rd: bp, Line: line},
+		{Op: PUSH, Rd: bp, Line: line},
+		{Op: MOVR, Rd: bp, Ra: sp, Line: line},
+		{Op: PUSH, Rd: r, Line: line},
+		{Op: MOV, Rd: r, Label: label, Line: line},
+		{Op: MOVT, Rd: r, Label: label, Line: line},
+		{Op: STR, Rd: r, Imm: 1, Line: line},
+		{Op: POP, Rd: r, Line: line},
+		{Op: POP, Rd: bp, Line: line},
+	}
+}
@@ -0,0 +1,57 @@
+package rust16vm
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func nops(n int) []Instr {
+	return slices.Repeat([]Instr{{Op: NOP}}, n)
+}
+
+func TestRelax(t *testing.T) {
+	// the branch skips more than JZ reaches and the call and the
+	// jump cross more than JMP reaches
+	var code []Instr
+	code = append(code, Instr{Op: CALL, Label: "f"}, Instr{Op: HALT})
+	code = append(code, nops(3000)...)
+	code = append(code,
+		Instr{Op: Label, Label: "f"},
+		Instr{Op: MOV, Rd: A, Imm: 0},
+		Instr{Op: MOV, Rd: C, Imm: 3},
+		Instr{Op: JZ, Ra: A, Label: "far"})
+	code = append(code, nops(300)...)
+	code = append(code,
+		Instr{Op: Label, Label: "far"},
+		Instr{Op: MOV, Rd: B, Imm: 5},
+		Instr{Op: JMP, Label: "back"})
+	code = append(code, nops(3000)...)
+	code = append(code,
+		Instr{Op: Label, Label: "back"},
+		Instr{Op: ADDR, Rd: A, Ra: B, Rb: C},
+		Instr{Op: RET})
+
+	relaxed := Relax(code, RV16)
+	linked, err := Link(relaxed)
+	require.NoError(t, err)
+	for i, instr := range linked {
+		if imm, ok := RV16.Imm(instr.Op); ok && instr.Op.IsJump() {
+			require.True(t, imm.Fits(instr.Imm-i-1), "%d: %s", i, instr)
+		}
+	}
+
+	m, err := Simulate(relaxed, maxSteps)
+	require.NoError(t, err)
+	require.Equal(t, uint16(8), m.Regs[A])
+	require.Equal(t, uint16(5), m.Regs[B])
+	require.Equal(t, uint16(3), m.Regs[C])
+	require.Zero(t, m.Regs[SP])
+	require.Zero(t, m.Regs[BP])
+	require.Less(t, m.Steps, 100)
+
+	// the jumps in reach are left alone
+	near := []Instr{{Op: JZ, Ra: A, Label: "end"}, {Op: NOP}, {Op: Label, Label: "end"}, {Op: HALT}}
+	require.Equal(t, near, Relax(near, RV16))
+}
@@ -0,0 +1,216 @@
+package rust16vm
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	errUndefinedLabel = errors.New("undefined label")
+	errDivisionByZero = errors.New("division by zero")
+	errPCOutOfRange   = errors.New("program counter out of range")
+	errStepLimit      = errors.New("step limit exceeded")
+)
+
+// Link resolves the jump targets to instruction indexes and the
+// labels MOV and MOVT load to the halves of theirs, the label
+// pseudo instructions are dropped from the result
+func Link(code []Instr) ([]Instr, error) {
+	labels := map[string]int{}
+	linked := make([]Instr, 0, len(code))
+	for _, instr := range code {
+		if instr.Op == Label {
+			labels[instr.Label] = len(linked)
+			continue
+		}
+		linked = append(linked, instr)
+	}
+
+	for i, instr := range linked {
+		load := (instr.Op == MOV || instr.Op == MOVT) && instr.Label != ""
+		if !instr.Op.IsJump() && !load {
+			continue
+		}
+		target, ok := labels[instr.Label]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", errUndefinedLabel, instr.Label)
+		}
+		linked[i].Imm = target
+		linked[i].Label = ""
+		if load {
+			lo, hi := RV16.Halves(uint16(target))
+			linked[i].Imm = lo
+			if instr.Op == MOVT {
+				linked[i].Imm = hi
+			}
+		}
+	}
+	return linked, nil
+}
+
+// Machine simulates rust16vm, the code lives apart from the
+// memory and PC indexes the linked instructions. The stack
+// starts at the top of the memory, SP = 0 and grows down
+type Machine struct {
+	Regs  [8]uint16 // A B C M BP SP PC FLAGS
+	Mem   [1 << Bits]uint16
+	Steps int
+	// Deepest is the most words the stack held
+	Deepest int
+	// Target gives the widths of the immediates, RV16 when nil
+	Target *Target
+}
+
+// Simulate links the code and runs it on a fresh machine
+func Simulate(code []Instr, maxSteps int) (*Machine, error) {
+	linked, err := Link(code)
+	if err != nil {
+		return nil, err
+	}
+	m := &Machine{}
+	return m, m.Run(linked, maxSteps)
+}
+
+// Run executes linked code from PC until HALT, maxSteps
+// bounds the amount of instructions executed
+func (m *Machine) Run(code []Instr, maxSteps int) error {
+	for {
+		pc := int(m.Regs[PC])
+		if pc >= len(code) {
+			return fmt.Errorf("%w: %d", errPCOutOfRange, pc)
+		}
+		if m.Steps >= maxSteps {
+			return errStepLimit
+		}
+		m.Steps++
+
+		instr := code[pc]
+		if instr.Op == HALT {
+			return nil
+		}
+		m.Regs[PC]++
+		if err := m.step(instr); err != nil {
+			return fmt.Errorf("pc %d, %s: %w", pc, instr, err)
+		}
+		m.Deepest = max(m.Deepest, int(-m.Regs[SP]))
+	}
+}
+
+func (m *Machine) step(i Instr) error {
+	r := &m.Regs
+	switch {
+	case i.Op == MOV:
+		r[i.Rd] = uint16(i.Imm)
+	case i.Op == MOVT:
+		r[i.Rd] = m.target().SetTop(r[i.Rd], i.Imm)
+	case i.Op == MOVR:
+		r[i.Rd] = r[i.Ra]
+	case i.Op.IsRegReg():
+		value, err := alu(i.Op, r[i.Ra], r[i.Rb])
+		if err != nil {
+			return err
+		}
+		r[i.Rd] = value
+	case i.Op == ADDI:
+		r[i.Rd] = r[i.Ra] + uint16(i.Imm)
+	case i.Op == LDR:
+		r[i.Rd] = m.Mem[r[BP]+uint16(i.Imm)]
+	case i.Op == STR:
+		m.Mem[r[BP]+uint16(i.Imm)] = r[i.Rd]
+	case i.Op == PUSH:
+		m.push(r[i.Rd])
+	case i.Op == POP:
+		r[i.Rd] = m.pop()
+	case i.Op == JMP:
+		r[PC] = uint16(i.Imm)
+	case i.Op == JZ:
+		if r[i.Ra] == 0 {
+			r[PC] = uint16(i.Imm)
+		}
+	case i.Op == JNZ:
+		if r[i.Ra] != 0 {
+			r[PC] = uint16(i.Imm)
+		}
+	case i.Op == CALL:
+		m.push(r[PC])
+		r[PC] = uint16(i.Imm)
+	case i.Op == RET:
+		r[PC] = m.pop()
+	case i.Op == NOP:
+	default:
+		return fmt.Errorf("%w: %s", errUnsupported, i.Op)
+	}
+	return nil
+}
+
+func (m *Machine) target() *Target {
+	if m.Target == nil {
+		return RV16
+	}
+	return m.Target
+}
+
+func (m *Machine) push(value uint16) {
+	m.Regs[SP]--
+	m.Mem[m.Regs[SP]] = value
+}
+
+func (m *Machine) pop() uint16 {
+	value := m.Mem[m.Regs[SP]]
+	m.Regs[SP]++
+	return value
+}
+
+func alu(op Opcode, a, b uint16) (uint16, error) {
+	switch op {
+	case ADDR:
+		return a + b, nil
+	case SUBR:
+		return a - b, nil
+	case MULR:
+		return a * b, nil
+	case DIVR:
+		if b == 0 {
+			return 0, errDivisionByZero
+		}
+		return a / b, nil
+	case SDIVR:
+		if b == 0 {
+			return 0, errDivisionByZero
+		}
+		// -32768 / -1 overflows and wraps back to -32768
+		return uint16(int16(a) / int16(b)), nil
+	case ANDR:
+		return a & b, nil
+	case ORR:
+		return a | b, nil
+	case XORR:
+		return a ^ b, nil
+	case EQR:
+		return flag(a == b), nil
+	case NER:
+		return flag(a != b), nil
+	case LTR:
+		return flag(a < b), nil
+	case LER:
+		return flag(a <= b), nil
+	case SLTR:
+		return flag(int16(a) < int16(b)), nil
+	case SLER:
+		return flag(int16(a) <= int16(b)), nil
+	case SHLR:
+		return a << b, nil
+	case SHRR:
+		return a >> b, nil
+	case SARR:
+		return uint16(int16(a) >> b), nil
+	}
+	return 0, fmt.Errorf("%w: %s", errUnsupported, op)
+}
+
+func flag(b bool) uint16 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,83 @@
+package rust16vm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const maxSteps = 100_000
+
+func run(t *testing.T, input string) uint16 {
+	t.Helper()
+
+	code, err := Compile(lower(t, input))
+	require.NoError(t, err)
+
+	m, err := Simulate(code, maxSteps)
+	require.NoError(t, err, input)
+	return m.Regs[A]
+}
+
+func TestSimulate(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected uint16
+	}{
+		{"3 + 4", 7},
+		{"let x = 55090 + 5;", 55095},
+		{"let x: u16 = 0; x - 1;", 65535},
+		{"let x: i16 = -7; x / 2;", uint16(0xFFFD)},
+		{"let x: u16 = 65529; x / 2;", 32764},
+		{"let x: i16 = -8; x >> 1;", uint16(0xFFFC)},
+		{"let x: u16 = 65528; x >> 1;", 32764},
+		{"let x: u8 = 200; x + 100;", 44},
+		{"let x: i16 = -1; x < 0;", 1},
+		{"let x: u16 = 1; x == 2 or x < 2;", 1},
+		{`
+			fn fact(n: u16) -> u16 {
+				if n <= 1 {
+					return 1;
+				}
+				return n * fact(n - 1);
+			}
+			fact(6);
+		`, 720},
+		{`
+			let i: u16 = 0;
+			let sum: u16 = 0;
+			while i < 100 {
+				i = i + 1;
+				sum = sum + i;
+			}
+			sum;
+		`, 5050},
+		{`
+			let count: u16 = 0;
+			fn bump(n: u16) -> u16 {
+				count = count + n;
+				return count;
+			}
+			bump(2);
+			bump(3) * 10 + count;
+		`, 55},
+	}
+
+	for _, tt := range tests {
+		require.Equal(t, tt.expected, run(t, tt.input), tt.input)
+	}
+}
+
+func TestSimulateErrors(t *testing.T) {
+	_, err := Simulate([]Instr{{Op: JMP, Label: "nowhere"}}, maxSteps)
+	require.ErrorIs(t, err, errUndefinedLabel)
+
+	_, err = Simulate([]Instr{{Op: Label, Label: "loop"}, {Op: JMP, Label: "loop"}}, maxSteps)
+	require.ErrorIs(t, err, errStepLimit)
+
+	_, err = Simulate([]Instr{{Op: MOV, Rd: A, Imm: 1}, {Op: DIVR, Rd: A, Ra: A, Rb: B}, {Op: HALT}}, maxSteps)
+	require.ErrorIs(t, err, errDivisionByZero)
+
+	_, err = Simulate([]Instr{{Op: NOP}}, maxSteps)
+	require.ErrorIs(t, err, errPCOutOfRange)
+}
@@ -0,0 +1,278 @@
+package rust16vm
+
+import (
+	"fmt"
+	"stag/ir"
+)
+
+// RegClass tells how the code generator may use a register
+type RegClass uint8
+
+const (
+	// Allocatable registers hold the values of the program
+	Allocatable RegClass = iota
+	// Reserved registers belong to the machine, the code
+	// only touches them through the calling convention
+	Reserved
+)
+
+// RegInfo describes a register of the register file
+type RegInfo struct {
+	Name  string
+	Class RegClass
+}
+
+// Imm is the width of an immediate field
+type Imm struct {
+	Bits   int
+	Signed bool
+}
+
+// Bounds returns the smallest and the largest value of the field
+func (imm Imm) Bounds() (int, int) {
+	if imm.Signed {
+		return -(1 << (imm.Bits - 1)), 1<<(imm.Bits-1) - 1
+	}
+	return 0, 1<<imm.Bits - 1
+}
+
+// Fits reports if value can be encoded in the field
+func (imm Imm) Fits(value int) bool {
+	lo, hi := imm.Bounds()
+	return value >= lo && value <= hi
+}
+
+// CallConv is how functions are called. The arguments are pushed
+// from the last to the first and the caller drops them after the
+// call, the callee saves the frame pointer and addresses its
+// parameters and locals relative to it
+type CallConv struct {
+	Result Reg // the register the return value comes back in
+	Frame  Reg // the frame pointer
+	Stack  Reg // the stack pointer, the stack grows down from 0
+	// ArgOffset is the offset from Frame of the first parameter,
+	// the saved frame pointer and the return address are below it
+	ArgOffset int
+	// Clobbered are the registers a call may change
+	Clobbered []Reg
+}
+
+// Target describes a variant of rust16vm, the code generator reads
+// the registers, the immediates and the operations it may use from
+// it so a variant of the machine is a different table
+type Target struct {
+	Name string
+	// Bits is the width of the words and the addresses
+	Bits int
+	// Regs is the register file indexed by Reg
+	Regs []RegInfo
+	// Imms are the widths of the immediate fields, the ones of
+	// jumps are offsets from the next instruction
+	Imms map[Opcode]Imm
+	// Costs are the cycles each instruction takes
+	Costs map[Opcode]int
+	// Ops selects the instruction of each binary IR operation,
+	// the ones missing are not supported by the target
+	Ops  map[ir.Op]Opcode
+	Call CallConv
+}
+
+// RV16 is the rust16vm the simulator and the assembler implement
+var RV16 = &Target{
+	Name: "rust16vm",
+	Bits: Bits,
+	Regs: []RegInfo{
+		A:     {"A", Allocatable},
+		B:     {"B", Allocatable},
+		C:     {"C", Allocatable},
+		M:     {"M", Allocatable},
+		BP:    {"BP", Reserved},
+		SP:    {"SP", Reserved},
+		PC:    {"PC", Reserved},
+		FLAGS: {"FLAGS", Reserved},
+	},
+	Imms: map[Opcode]Imm{
+		MOV:  {Bits: 9},
+		MOVT: {Bits: 7},
+		ADDI: {Bits: 6, Signed: true},
+		LDR:  {Bits: 9, Signed: true},
+		STR:  {Bits: 9, Signed: true},
+		JMP:  {Bits: 12, Signed: true},
+		CALL: {Bits: 12, Signed: true},
+		JZ:   {Bits: 9, Signed: true},
+		JNZ:  {Bits: 9, Signed: true},
+	},
+	Costs: map[Opcode]int{
+		MULR:  3,
+		DIVR:  8,
+		SDIVR: 8,
+		LDR:   2,
+		STR:   2,
+		PUSH:  2,
+		POP:   2,
+		CALL:  2,
+		RET:   2,
+	},
+	Ops: map[ir.Op]Opcode{
+		ir.Add:  ADDR,
+		ir.Sub:  SUBR,
+		ir.Mul:  MULR,
+		ir.UDiv: DIVR,
+		ir.SDiv: SDIVR,
+		ir.And:  ANDR,
+		ir.Or:   ORR,
+		ir.Xor:  XORR,
+		ir.Eq:   EQR,
+		ir.Ne:   NER,
+		ir.ULt:  LTR,
+		ir.ULe:  LER,
+		ir.SLt:  SLTR,
+		ir.SLe:  SLER,
+		ir.Shl:  SHLR,
+		ir.Shr:  SHRR,
+		ir.Sar:  SARR,
+	},
+	Call: CallConv{
+		Result:    A,
+		Frame:     BP,
+		Stack:     SP,
+		ArgOffset: 2,
+		Clobbered: []Reg{A, B, C, M},
+	},
+}
+
+// Allocatable returns the registers that may hold values in order
+func (t *Target) Allocatable() []Reg {
+	var regs []Reg
+	for r, info := range t.Regs {
+		if info.Class == Allocatable {
+			regs = append(regs, Reg(r))
+		}
+	}
+	return regs
+}
+
+// Imm returns the immediate field of op, ok is false when
+// the instruction has none
+func (t *Target) Imm(op Opcode) (Imm, bool) {
+	imm, ok := t.Imms[op]
+	return imm, ok
+}
+
+// Halves splits a word in the immediate of MOV, its low bits,
+// and the one of MOVT, the bits above them
+func (t *Target) Halves(word uint16) (lo, hi int) {
+	imm, _ := t.Imm(MOV)
+	return int(word & (1<<imm.Bits - 1)), int(word >> imm.Bits)
+}
+
+// SetTop returns word with the bits above the immediate of MOV
+// replaced by imm, what MOVT makes of a register
+func (t *Target) SetTop(word uint16, imm int) uint16 {
+	low, _ := t.Imm(MOV)
+	top, _ := t.Imm(MOVT)
+	return word&(1<<low.Bits-1) | uint16(imm&(1<<top.Bits-1))<<low.Bits
+}
+
+// Cost returns the cycles of an instruction, the ones
+// missing from Costs take one and labels none
+func (t *Target) Cost(op Opcode) int {
+	if op == Label {
+		return 0
+	}
+	if cost, ok := t.Costs[op]; ok {
+		return cost
+	}
+	return 1
+}
+
+// CodeCost adds up the cost of the instructions
+func (t *Target) CodeCost(code []Instr) int {
+	total := 0
+	for _, instr := range code {
+		total += t.Cost(instr.Op)
+	}
+	return total
+}
+
+// InstrCost estimates the code generated for an IR instruction with
+// its operands in registers, the inliner weighs the calls with it
+func (t *Target) InstrCost(instr *ir.Instr) int {
+	switch {
+	case instr.Op == ir.Const:
+		code := []Instr{{Op: MOV}}
+		if imm, _ := t.Imm(MOV); !imm.Fits(int(uint16(instr.Value))) {
+			code = append(code, Instr{Op: MOVT})
+		}
+		return t.CodeCost(code)
+	case instr.Op.IsBinary():
+		return t.Cost(t.Ops[instr.Op])
+	case instr.Op == ir.Load:
+		// the frame pointer moves to the frame of main and back
+		return t.CodeCost([]Instr{{Op: PUSH}, {Op: MOV}, {Op: LDR}, {Op: POP}})
+	case instr.Op == ir.Store:
+		return t.CodeCost([]Instr{{Op: PUSH}, {Op: MOV}, {Op: STR}, {Op: POP}})
+	case instr.Op == ir.Call:
+		return len(instr.Args)*t.Cost(PUSH) + t.CodeCost([]Instr{{Op: CALL}, {Op: ADDI}})
+	}
+	// the copies and the phis are moves
+	return t.Cost(MOVR)
+}
+
+// TermCost estimates the code generated for a terminator, a return
+// moves its value to the result register
+func (t *Target) TermCost(term *ir.Terminator) int {
+	switch term.Kind {
+	case ir.Branch:
+		return t.CodeCost([]Instr{{Op: JZ}, {Op: JMP}})
+	case ir.Return:
+		return t.Cost(MOVR)
+	}
+	return t.Cost(JMP)
+}
+
+// CallCost estimates what a call costs besides the body of the
+// callee: the caller pushes the arguments, calls and drops them and
+// the callee saves the frame pointer, loads its parameters and
+// restores the frame to return
+func (t *Target) CallCost(args int) int {
+	return args*(t.Cost(PUSH)+t.Cost(LDR)) + t.CodeCost([]Instr{
+		{Op: CALL}, {Op: ADDI},
+		{Op: PUSH}, {Op: MOVR}, {Op: MOVR}, {Op: POP}, {Op: RET},
+	})
+}
+
+// MainFrame is where the frame of main starts, the stack starts
+// at the top of memory and CALL main and the saved frame pointer
+// of main take the two words below it
+func (t *Target) MainFrame() uint16 {
+	return uint16(1<<t.Bits - t.Call.ArgOffset)
+}
+
+// check reports the first inconsistency of the description,
+// the code generator relies on all of them
+func (t *Target) check() error {
+	if t.Bits <= 0 || t.Bits > Bits {
+		return fmt.Errorf("target %s: words of %d bits, the machine has %d", t.Name, t.Bits, Bits)
+	}
+	if len(t.Regs) > int(FLAGS)+1 {
+		return fmt.Errorf("target %s: %d registers, the machine has %d", t.Name, len(t.Regs), FLAGS+1)
+	}
+	for _, r := range []Reg{t.Call.Frame, t.Call.Stack} {
+		if int(r) >= len(t.Regs) || t.Regs[r].Class != Reserved {
+			return fmt.Errorf("target %s: the calling convention needs %s reserved", t.Name, r)
+		}
+	}
+	if len(t.Allocatable()) < 4 {
+		return fmt.Errorf("target %s: the instructions need 4 allocatable registers", t.Name)
+	}
+	if regs := t.Allocatable(); regs[len(regs)-1] == t.Call.Result {
+		return fmt.Errorf("target %s: the last allocatable register can not hold the result", t.Name)
+	}
+	for _, op := range []Opcode{MOV, ADDI, LDR, STR} {
+		if _, ok := t.Imms[op]; !ok {
+			return fmt.Errorf("target %s: no immediate for %s", t.Name, op)
+		}
+	}
+	return nil
+}
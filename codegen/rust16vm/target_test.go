@@ -0,0 +1,104 @@
+package rust16vm
+
+import (
+	"fmt"
+	"maps"
+	"stag/ir"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// variant returns a copy of RV16 changed by edit
+func variant(edit func(t *Target)) *Target {
+	t := *RV16
+	t.Regs = append([]RegInfo(nil), RV16.Regs...)
+	t.Imms = maps.Clone(RV16.Imms)
+	t.Costs = maps.Clone(RV16.Costs)
+	t.Ops = maps.Clone(RV16.Ops)
+	edit(&t)
+	return &t
+}
+
+func TestTargetRegisters(t *testing.T) {
+	require.Equal(t, []Reg{A, B, C, M}, RV16.Allocatable())
+	require.Equal(t, "FLAGS", FLAGS.String())
+	require.Equal(t, uint16(0xFFFE), RV16.MainFrame())
+	require.NoError(t, RV16.check())
+
+	bad := variant(func(t *Target) { t.Regs[BP].Class = Allocatable })
+	_, err := CompileFor(lower(t, "1"), bad)
+	require.ErrorContains(t, err, "needs BP reserved")
+}
+
+func TestTargetCosts(t *testing.T) {
+	code := []Instr{{Op: Label, Label: "f"}, {Op: MOV}, {Op: LDR}, {Op: DIVR}}
+	require.Equal(t, 1+2+8, RV16.CodeCost(code))
+}
+
+func TestTargetWithoutOperation(t *testing.T) {
+	noSigned := variant(func(t *Target) { delete(t.Ops, ir.SDiv) })
+	_, err := CompileFor(lower(t, "let a: i16 = 6; a / 2;"), noSigned)
+	require.ErrorIs(t, err, errUnsupported)
+
+	_, err = CompileFor(lower(t, "let a: u16 = 6; a / 2;"), noSigned)
+	require.NoError(t, err)
+}
+
+func TestTargetNarrowImmediates(t *testing.T) {
+	// ADDI takes 4 bits, the frame of 18 slots takes three of them
+	narrow := variant(func(t *Target) { t.Imms[ADDI] = Imm{Bits: 4, Signed: true} })
+
+	src := liveVariables(20)
+	code, err := CompileFor(lower(t, src), narrow)
+	require.NoError(t, err)
+	require.Contains(t, Format(code), "main:\nPUSH BP\nMOV BP, SP\nADDI SP, SP, #-8\nADDI SP, SP, #-8\nADDI SP, SP, #-2\n")
+
+	m, err := Simulate(code, maxSteps)
+	require.NoError(t, err)
+	require.Equal(t, uint16(190), m.Regs[A])
+}
+
+func TestTargetWideMovt(t *testing.T) {
+	// MOV takes 8 bits and MOVT the 8 above them
+	wide := variant(func(t *Target) {
+		t.Imms[MOV] = Imm{Bits: 8}
+		t.Imms[MOVT] = Imm{Bits: 8}
+	})
+	code, err := CompileFor(lower(t, "let x = 55090 + 5;"), wide)
+	require.NoError(t, err)
+	require.Contains(t, Format(code), "MOV A, #50\nMOVT A, #215\n")
+
+	linked, err := Link(code)
+	require.NoError(t, err)
+	m := &Machine{Target: wide}
+	require.NoError(t, m.Run(linked, maxSteps))
+	require.Equal(t, uint16(55095), m.Regs[A])
+}
+
+func TestFarSlots(t *testing.T) {
+	// more slots than the offsets of LDR and STR reach
+	src := liveVariables(300)
+	code, err := Compile(lower(t, src))
+	require.NoError(t, err)
+	// -257 is 127 << 9 | 255
+	require.Contains(t, Format(code), "PUSH BP\nPUSH A\nMOV A, #255\nMOVT A, #127\nADDR BP, BP, A\nPOP A\nSTR A, [BP, #0]\nPOP BP\n")
+	require.Contains(t, Format(code), "PUSH BP\nMOV B, #255\nMOVT B, #127\nADDR BP, BP, B\nLDR B, [BP, #0]\nPOP BP\n")
+	require.Equal(t, uint16(300*299/2), run(t, src))
+}
+
+// liveVariables returns a program with n variables all of
+// them live until the end, where it adds them up
+func liveVariables(n int) string {
+	var src strings.Builder
+	for i := range n {
+		fmt.Fprintf(&src, "let x%d: u16 = %d;\n", i, i)
+	}
+	src.WriteString("x0")
+	for i := 1; i < n; i++ {
+		fmt.Fprintf(&src, " + x%d", i)
+	}
+	src.WriteString(";")
+	return src.String()
+}
@@ -0,0 +1,56 @@
+package stack
+
+import (
+	"io"
+	"stag/codegen"
+	"stag/ir"
+)
+
+func init() {
+	codegen.Register(Backend{})
+}
+
+// Backend compiles for the stack machine, the binary is the
+// bytecode VM runs behind a small header
+type Backend struct{}
+
+func (Backend) Name() string { return "stack" }
+func (Backend) Ext() string  { return ".sbc" }
+
+func (Backend) Compile(program *ir.Program, opts codegen.Options) (codegen.Artifact, error) {
+	code, err := Compile(program)
+	if err != nil {
+		return nil, err
+	}
+	bytecode, err := Encode(code)
+	if err != nil {
+		return nil, err
+	}
+	return &artifact{code: code, bytecode: bytecode, opts: opts}, nil
+}
+
+type artifact struct {
+	code     []Instr
+	bytecode []byte
+	opts     codegen.Options
+}
+
+func (a *artifact) Assembly() string {
+	if a.opts.Debug {
+		return FormatDebug(a.code, a.opts.File)
+	}
+	return Format(a.code)
+}
+
+func (a *artifact) WriteTo(w io.Writer) (int64, error) {
+	out := a.bytecode
+	if !a.opts.Raw {
+		out = append(Header(), a.bytecode...)
+	}
+	n, err := w.Write(out)
+	return int64(n), err
+}
+
+func (a *artifact) Run(maxSteps int) (uint16, error) {
+	return Run(a.bytecode, maxSteps)
+}
@@ -0,0 +1,76 @@
+package stack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Magic starts every headered binary
+const Magic = "SBC\x00"
+
+// Version of the bytecode
+const Version = 1
+
+var (
+	errUndefinedLabel = errors.New("undefined label")
+	errBadBinary      = errors.New("not a stack bytecode binary")
+)
+
+// Encode resolves the labels to byte addresses and encodes the
+// instructions, each one is its opcode byte followed by its
+// operands as little endian 16 bit values
+func Encode(code []Instr) ([]byte, error) {
+	labels := map[string]uint16{}
+	addr := 0
+	for _, instr := range code {
+		if instr.Op == Label {
+			labels[instr.Label] = uint16(addr)
+			continue
+		}
+		addr += instr.Op.size()
+	}
+	if addr > 1<<16 {
+		return nil, fmt.Errorf("the bytecode takes %d bytes, more than it can address", addr)
+	}
+
+	out := make([]byte, 0, addr)
+	for _, instr := range code {
+		if instr.Op == Label {
+			continue
+		}
+		args := instr.Args
+		if instr.Label != "" {
+			target, ok := labels[instr.Label]
+			if !ok {
+				return nil, fmt.Errorf("%w: %s", errUndefinedLabel, instr.Label)
+			}
+			args[0] = target
+		}
+
+		out = append(out, byte(instr.Op))
+		for i := range instr.Op.operands() {
+			out = binary.LittleEndian.AppendUint16(out, args[i])
+		}
+	}
+	return out, nil
+}
+
+// Header is written before the bytecode by the binaries that are
+// not raw, the magic and the version as a little endian u16
+func Header() []byte {
+	return binary.LittleEndian.AppendUint16([]byte(Magic), Version)
+}
+
+// Strip returns the bytecode of a binary written with Header
+func Strip(data []byte) ([]byte, error) {
+	header := Header()
+	if !bytes.HasPrefix(data, header[:len(Magic)]) {
+		return nil, errBadBinary
+	}
+	if !bytes.HasPrefix(data, header) {
+		return nil, fmt.Errorf("%w: unsupported version", errBadBinary)
+	}
+	return data[len(header):], nil
+}
@@ -0,0 +1,200 @@
+package stack
+
+import (
+	"errors"
+	"fmt"
+	"stag/ir"
+)
+
+var errUnsupported = errors.New("not supported by the stack backend")
+
+var binaryOps = map[ir.Op]Opcode{
+	ir.Add:  ADD,
+	ir.Sub:  SUB,
+	ir.Mul:  MUL,
+	ir.UDiv: UDIV,
+	ir.SDiv: SDIV,
+	ir.And:  AND,
+	ir.Or:   OR,
+	ir.Xor:  XOR,
+	ir.Eq:   EQ,
+	ir.Ne:   NE,
+	ir.ULt:  ULT,
+	ir.ULe:  ULE,
+	ir.SLt:  SLT,
+	ir.SLe:  SLE,
+	ir.Shl:  SHL,
+	ir.Shr:  SHR,
+	ir.Sar:  SAR,
+}
+
+// frame maps the virtual registers of a function to the slots of
+// its frame, the parameters take the first ones in order
+type frame struct {
+	slots map[ir.Reg]uint16
+}
+
+func newFrame(fn *ir.Func) *frame {
+	f := &frame{slots: map[ir.Reg]uint16{}}
+	for _, p := range fn.Params {
+		f.slots[p] = uint16(len(f.slots))
+	}
+	for r := ir.Reg(0); int(r) < fn.NumRegs; r++ {
+		if _, isParam := f.slots[r]; !isParam {
+			f.slots[r] = uint16(len(f.slots))
+		}
+	}
+	return f
+}
+
+type genCtx struct {
+	errs []error
+	// globals is the frame of main, GLOAD and GSTORE reach it
+	// from any function
+	globals *frame
+}
+
+// Compile translates the program to stack machine instructions,
+// the entry point calls main and halts with its result on the stack
+func Compile(program *ir.Program) ([]Instr, error) {
+	ctx := &genCtx{}
+	if main := program.Func(ir.MainFunc); main != nil {
+		ctx.globals = newFrame(main)
+	}
+
+	code := []Instr{{Op: CALL, Label: ir.MainFunc}, {Op: HALT}}
+	for _, fn := range program.Funcs {
+		generateFunc(ctx, fn, &code)
+	}
+	return code, errors.Join(ctx.errs...)
+}
+
+func generateFunc(ctx *genCtx, fn *ir.Func, code *[]Instr) {
+	f := newFrame(fn)
+
+	emit(Instr{Op: Label, Label: fn.Name}, code)
+	emit(Instr{Op: ENTER, Args: [2]uint16{uint16(len(fn.Params)), uint16(len(f.slots))}}, code)
+
+	for i, b := range fn.Blocks {
+		if i > 0 {
+			emit(Instr{Op: Label, Label: blockLabel(fn, b)}, code)
+		}
+
+		for _, instr := range b.Instrs {
+			start := len(*code)
+			generateInstr(ctx, f, instr, code)
+			setLine((*code)[start:], instr.Line)
+		}
+
+		var next *ir.Block
+		if i+1 < len(fn.Blocks) {
+			next = fn.Blocks[i+1]
+		}
+		start := len(*code)
+		generateTerm(fn, f, b.Term, next, code)
+		setLine((*code)[start:], b.Term.Line)
+	}
+}
+
+func setLine(code []Instr, line int) {
+	for i := range code {
+		code[i].Line = line
+	}
+}
+
+func blockLabel(fn *ir.Func, b *ir.Block) string {
+	return fn.Name + "." + b.Name
+}
+
+func generateInstr(ctx *genCtx, f *frame, instr *ir.Instr, code *[]Instr) {
+	switch {
+	case instr.Op == ir.Const:
+		emitOp(PUSH, uint16(instr.Value), code)
+		emitOp(STORE, f.slots[instr.Dst], code)
+
+	case instr.Op == ir.Copy:
+		emitOp(LOAD, f.slots[instr.Args[0]], code)
+		emitOp(STORE, f.slots[instr.Dst], code)
+
+	case instr.Op.IsBinary():
+		emitOp(LOAD, f.slots[instr.Args[0]], code)
+		emitOp(LOAD, f.slots[instr.Args[1]], code)
+		emit(Instr{Op: binaryOps[instr.Op]}, code)
+		emitOp(STORE, f.slots[instr.Dst], code)
+
+	case instr.Op == ir.Call:
+		// the arguments are pushed in order and the
+		// result is always left on the stack
+		for _, arg := range instr.Args {
+			emitOp(LOAD, f.slots[arg], code)
+		}
+		emit(Instr{Op: CALL, Label: instr.Callee}, code)
+		if instr.Dst != ir.NoReg {
+			emitOp(STORE, f.slots[instr.Dst], code)
+		} else {
+			emit(Instr{Op: DROP}, code)
+		}
+
+	case instr.Op == ir.Load:
+		if slot, ok := ctx.global(instr.Global); ok {
+			emitOp(GLOAD, slot, code)
+			emitOp(STORE, f.slots[instr.Dst], code)
+		}
+
+	case instr.Op == ir.Store:
+		if slot, ok := ctx.global(instr.Global); ok {
+			emitOp(LOAD, f.slots[instr.Args[0]], code)
+			emitOp(GSTORE, slot, code)
+		}
+
+	default:
+		ctx.errs = append(ctx.errs, fmt.Errorf("%w: operation %s", errUnsupported, instr.Op))
+	}
+}
+
+func (ctx *genCtx) global(r ir.Reg) (uint16, bool) {
+	if ctx.globals != nil {
+		if slot, ok := ctx.globals.slots[r]; ok {
+			return slot, true
+		}
+	}
+	ctx.errs = append(ctx.errs, fmt.Errorf("main has no register %s", r))
+	return 0, false
+}
+
+// generateTerm emits the terminator of a block, jumps to
+// the block laid out right after it fall through
+func generateTerm(fn *ir.Func, f *frame, term *ir.Terminator, next *ir.Block, code *[]Instr) {
+	switch term.Kind {
+	case ir.Jump:
+		if term.Targets[0] != next {
+			emit(Instr{Op: JMP, Label: blockLabel(fn, term.Targets[0])}, code)
+		}
+
+	case ir.Branch:
+		then, els := term.Targets[0], term.Targets[1]
+		emitOp(LOAD, f.slots[term.Cond], code)
+		emit(Instr{Op: JZ, Label: blockLabel(fn, els)}, code)
+		if then != next {
+			emit(Instr{Op: JMP, Label: blockLabel(fn, then)}, code)
+		}
+
+	case ir.Return:
+		// functions without a value return 0 so RET
+		// always has something to pop
+		if term.Value != ir.NoReg {
+			emitOp(LOAD, f.slots[term.Value], code)
+		} else {
+			emitOp(PUSH, 0, code)
+		}
+		emit(Instr{Op: RET}, code)
+	}
+}
+
+func emit(instr Instr, code *[]Instr) {
+	*code = append(*code, instr)
+}
+
+func emitOp(op Opcode, arg uint16, code *[]Instr) {
+	emit(Instr{Op: op, Args: [2]uint16{arg}}, code)
+}
@@ -0,0 +1,154 @@
+package stack
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Opcode is an instruction of the stack machine, the operands
+// of the operations come from the top of the stack and their
+// result goes back on it
+type Opcode uint8
+
+const (
+	HALT   Opcode = iota // stops, the top of the stack is the result
+	PUSH                 // PUSH v pushes the constant v
+	DROP                 // drops the top of the stack
+	LOAD                 // LOAD n pushes the slot n of the frame
+	STORE                // STORE n pops into the slot n of the frame
+	GLOAD                // GLOAD n pushes the slot n of the frame of main
+	GSTORE               // GSTORE n pops into the slot n of the frame of main
+	ADD
+	SUB
+	MUL
+	UDIV
+	SDIV
+	AND
+	OR
+	XOR
+	EQ
+	NE
+	ULT
+	ULE
+	SLT
+	SLE
+	SHL
+	SHR
+	SAR
+	JMP   // JMP l
+	JZ    // JZ l pops and jumps when it is zero
+	CALL  // CALL l
+	ENTER // ENTER p, n moves p arguments to a new frame of n slots
+	RET   // RET pops the result, drops the frame and pushes it back
+
+	// Label is not an instruction, it names the address of
+	// the instruction after it
+	Label Opcode = 0xFF
+)
+
+var opcodeNames = map[Opcode]string{
+	HALT:   "HALT",
+	PUSH:   "PUSH",
+	DROP:   "DROP",
+	LOAD:   "LOAD",
+	STORE:  "STORE",
+	GLOAD:  "GLOAD",
+	GSTORE: "GSTORE",
+	ADD:    "ADD",
+	SUB:    "SUB",
+	MUL:    "MUL",
+	UDIV:   "UDIV",
+	SDIV:   "SDIV",
+	AND:    "AND",
+	OR:     "OR",
+	XOR:    "XOR",
+	EQ:     "EQ",
+	NE:     "NE",
+	ULT:    "ULT",
+	ULE:    "ULE",
+	SLT:    "SLT",
+	SLE:    "SLE",
+	SHL:    "SHL",
+	SHR:    "SHR",
+	SAR:    "SAR",
+	JMP:    "JMP",
+	JZ:     "JZ",
+	CALL:   "CALL",
+	ENTER:  "ENTER",
+	RET:    "RET",
+}
+
+func (op Opcode) String() string {
+	if name, ok := opcodeNames[op]; ok {
+		return name
+	}
+	return fmt.Sprintf("Opcode(%d)", op)
+}
+
+// operands returns how many 16 bit operands follow the opcode
+func (op Opcode) operands() int {
+	switch op {
+	case PUSH, LOAD, STORE, GLOAD, GSTORE, JMP, JZ, CALL:
+		return 1
+	case ENTER:
+		return 2
+	}
+	return 0
+}
+
+// size is the length of the encoded instruction in bytes
+func (op Opcode) size() int {
+	return 1 + 2*op.operands()
+}
+
+type Instr struct {
+	Op    Opcode
+	Args  [2]uint16
+	Label string // the target of JMP, JZ and CALL or the name of a Label
+
+	Line int // source line it was compiled from, 0 when unknown
+}
+
+func (i Instr) String() string {
+	switch {
+	case i.Op == Label:
+		return i.Label + ":"
+	case i.Op == JMP, i.Op == JZ, i.Op == CALL:
+		return fmt.Sprintf("%s %s", i.Op, i.Label)
+	case i.Op == ENTER:
+		return fmt.Sprintf("%s %d, %d", i.Op, i.Args[0], i.Args[1])
+	case i.Op.operands() == 1:
+		return fmt.Sprintf("%s %d", i.Op, i.Args[0])
+	}
+	return i.Op.String()
+}
+
+// Format renders the instructions as assembly
+func Format(code []Instr) string {
+	var out strings.Builder
+	for _, instr := range code {
+		out.WriteString(instr.String())
+		out.WriteString("\n")
+	}
+	return out.String()
+}
+
+// FormatDebug renders the instructions like Format with a comment
+// naming the source line before the instructions generated from a
+// different line than the previous ones, the bytecode has no room
+// for them so they are lost in the binary
+func FormatDebug(code []Instr, file string) string {
+	var out strings.Builder
+	line := 0
+	for _, instr := range code {
+		if instr.Op != Label && instr.Line != line {
+			line = instr.Line
+			if line != 0 {
+				out.WriteString(fmt.Sprintf("; %s:%d\n", file, line))
+			}
+		}
+		out.WriteString(instr.String())
+		out.WriteString("\n")
+	}
+	return out.String()
+}
@@ -0,0 +1,259 @@
+package stack
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var (
+	errDivisionByZero = errors.New("division by zero")
+	errPCOutOfRange   = errors.New("program counter out of range")
+	errStepLimit      = errors.New("step limit exceeded")
+	errStackOverflow  = errors.New("stack overflow")
+	errStackUnderflow = errors.New("stack underflow")
+	errBadOpcode      = errors.New("bad opcode")
+	errBadSlot        = errors.New("slot out of the frame")
+)
+
+// maxStack bounds the operand stack and the slots together,
+// the same 64K words rust16vm has for its memory
+const maxStack = 1 << 16
+
+type call struct {
+	ret  int // address to return to
+	base int // first slot of the frame
+	size int // slots of the frame
+}
+
+// VM runs bytecode, the operands live on Stack and the registers
+// of the functions in Slots, a frame for each call in progress
+type VM struct {
+	Stack []uint16
+	Slots []uint16
+	Steps int
+
+	calls []call
+}
+
+// Run executes bytecode from its first byte on a fresh VM and
+// returns what is on top of the stack when it halts
+func Run(code []byte, maxSteps int) (uint16, error) {
+	vm := &VM{}
+	return vm.Run(code, maxSteps)
+}
+
+// Run executes bytecode until HALT, maxSteps bounds the
+// amount of instructions executed
+func (vm *VM) Run(code []byte, maxSteps int) (uint16, error) {
+	pc := 0
+	for {
+		if pc >= len(code) {
+			return 0, fmt.Errorf("%w: %d", errPCOutOfRange, pc)
+		}
+		op := Opcode(code[pc])
+		if _, ok := opcodeNames[op]; !ok {
+			return 0, fmt.Errorf("pc %d: %w %d", pc, errBadOpcode, op)
+		}
+		if pc+op.size() > len(code) {
+			return 0, fmt.Errorf("pc %d, %s: %w", pc, op, errPCOutOfRange)
+		}
+		if vm.Steps >= maxSteps {
+			return 0, errStepLimit
+		}
+		vm.Steps++
+
+		var args [2]uint16
+		for i := range op.operands() {
+			args[i] = binary.LittleEndian.Uint16(code[pc+1+2*i:])
+		}
+
+		if op == HALT {
+			if len(vm.Stack) == 0 {
+				return 0, nil
+			}
+			return vm.Stack[len(vm.Stack)-1], nil
+		}
+		next, err := vm.step(op, args, pc+op.size())
+		if err != nil {
+			return 0, fmt.Errorf("pc %d, %s: %w", pc, op, err)
+		}
+		pc = next
+	}
+}
+
+// step executes an instruction and returns the address of the next one
+func (vm *VM) step(op Opcode, args [2]uint16, next int) (int, error) {
+	switch op {
+	case PUSH:
+		return next, vm.push(args[0])
+
+	case DROP:
+		_, err := vm.pop()
+		return next, err
+
+	case LOAD, GLOAD:
+		slot, err := vm.slot(op == GLOAD, args[0])
+		if err != nil {
+			return 0, err
+		}
+		return next, vm.push(*slot)
+
+	case STORE, GSTORE:
+		slot, err := vm.slot(op == GSTORE, args[0])
+		if err != nil {
+			return 0, err
+		}
+		value, err := vm.pop()
+		*slot = value
+		return next, err
+
+	case JMP:
+		return int(args[0]), nil
+
+	case JZ:
+		value, err := vm.pop()
+		if value == 0 {
+			return int(args[0]), err
+		}
+		return next, err
+
+	case CALL:
+		if len(vm.calls) >= maxStack {
+			return 0, errStackOverflow
+		}
+		vm.calls = append(vm.calls, call{ret: next, base: len(vm.Slots)})
+		return int(args[0]), nil
+
+	case ENTER:
+		params, size := int(args[0]), int(args[1])
+		if len(vm.calls) == 0 || params > size || params > len(vm.Stack) {
+			return 0, errStackUnderflow
+		}
+		if len(vm.Stack)+len(vm.Slots)+size > maxStack {
+			return 0, errStackOverflow
+		}
+		frame := &vm.calls[len(vm.calls)-1]
+		frame.size = size
+		vm.Slots = append(vm.Slots, make([]uint16, size)...)
+		copy(vm.Slots[frame.base:], vm.Stack[len(vm.Stack)-params:])
+		vm.Stack = vm.Stack[:len(vm.Stack)-params]
+		return next, nil
+
+	case RET:
+		if len(vm.calls) == 0 {
+			return 0, errStackUnderflow
+		}
+		value, err := vm.pop()
+		if err != nil {
+			return 0, err
+		}
+		frame := vm.calls[len(vm.calls)-1]
+		vm.calls = vm.calls[:len(vm.calls)-1]
+		vm.Slots = vm.Slots[:frame.base]
+		return frame.ret, vm.push(value)
+	}
+
+	b, err := vm.pop()
+	if err != nil {
+		return 0, err
+	}
+	a, err := vm.pop()
+	if err != nil {
+		return 0, err
+	}
+	result, err := alu(op, a, b)
+	if err != nil {
+		return 0, err
+	}
+	return next, vm.push(result)
+}
+
+// slot returns the slot n of the current frame,
+// or of the frame of main when global is set
+func (vm *VM) slot(global bool, n uint16) (*uint16, error) {
+	if len(vm.calls) == 0 {
+		return nil, errBadSlot
+	}
+	frame := vm.calls[len(vm.calls)-1]
+	if global {
+		frame = vm.calls[0]
+	}
+	if int(n) >= frame.size {
+		return nil, fmt.Errorf("%w: %d", errBadSlot, n)
+	}
+	return &vm.Slots[frame.base+int(n)], nil
+}
+
+func (vm *VM) push(value uint16) error {
+	if len(vm.Stack)+len(vm.Slots) >= maxStack {
+		return errStackOverflow
+	}
+	vm.Stack = append(vm.Stack, value)
+	return nil
+}
+
+func (vm *VM) pop() (uint16, error) {
+	if len(vm.Stack) == 0 {
+		return 0, errStackUnderflow
+	}
+	value := vm.Stack[len(vm.Stack)-1]
+	vm.Stack = vm.Stack[:len(vm.Stack)-1]
+	return value, nil
+}
+
+// alu computes the binary operations like rust16vm does,
+// the results of both targets must be the same
+func alu(op Opcode, a, b uint16) (uint16, error) {
+	switch op {
+	case ADD:
+		return a + b, nil
+	case SUB:
+		return a - b, nil
+	case MUL:
+		return a * b, nil
+	case UDIV:
+		if b == 0 {
+			return 0, errDivisionByZero
+		}
+		return a / b, nil
+	case SDIV:
+		if b == 0 {
+			return 0, errDivisionByZero
+		}
+		// -32768 / -1 overflows and wraps back to -32768
+		return uint16(int16(a) / int16(b)), nil
+	case AND:
+		return a & b, nil
+	case OR:
+		return a | b, nil
+	case XOR:
+		return a ^ b, nil
+	case EQ:
+		return flag(a == b), nil
+	case NE:
+		return flag(a != b), nil
+	case ULT:
+		return flag(a < b), nil
+	case ULE:
+		return flag(a <= b), nil
+	case SLT:
+		return flag(int16(a) < int16(b)), nil
+	case SLE:
+		return flag(int16(a) <= int16(b)), nil
+	case SHL:
+		return a << b, nil
+	case SHR:
+		return a >> b, nil
+	case SAR:
+		return uint16(int16(a) >> b), nil
+	}
+	return 0, fmt.Errorf("%w %d", errBadOpcode, op)
+}
+
+func flag(b bool) uint16 {
+	if b {
+		return 1
+	}
+	return 0
+}
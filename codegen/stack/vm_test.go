@@ -0,0 +1,137 @@
+package stack
+
+import (
+	"stag/frontend"
+	"stag/ir"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const maxSteps = 100_000
+
+func lower(t *testing.T, input string) *ir.Program {
+	t.Helper()
+
+	program, info, errs := frontend.Source(input, frontend.Options{Unfolded: true})
+	require.Empty(t, errs)
+	return ir.Lower(program, info)
+}
+
+func run(t *testing.T, input string) uint16 {
+	t.Helper()
+
+	code, err := Compile(lower(t, input))
+	require.NoError(t, err)
+	bytecode, err := Encode(code)
+	require.NoError(t, err)
+
+	value, err := Run(bytecode, maxSteps)
+	require.NoError(t, err, input)
+	return value
+}
+
+func TestCompile(t *testing.T) {
+	code, err := Compile(lower(t, "3 + 4"))
+	require.NoError(t, err)
+
+	exp := "CALL main\nHALT\n" +
+		"main:\nENTER 0, 3\n" +
+		"PUSH 3\nSTORE 0\n" +
+		"PUSH 4\nSTORE 1\n" +
+		"LOAD 0\nLOAD 1\nADD\nSTORE 2\n" +
+		"LOAD 2\nRET\n"
+	require.Equal(t, exp, Format(code))
+
+	bytecode, err := Encode(code)
+	require.NoError(t, err)
+	require.Equal(t, []byte{byte(CALL), 4, 0, byte(HALT), byte(ENTER), 0, 0, 3, 0}, bytecode[:9])
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected uint16
+	}{
+		{"3 + 4", 7},
+		{"let x = 55090 + 5;", 55095},
+		{"let x: u16 = 0; x - 1;", 65535},
+		{"let x: i16 = -7; x / 2;", uint16(0xFFFD)},
+		{"let x: u16 = 65529; x / 2;", 32764},
+		{"let x: i16 = -8; x >> 1;", uint16(0xFFFC)},
+		{"let x: u16 = 65528; x >> 1;", 32764},
+		{"let x: u8 = 200; x + 100;", 44},
+		{"let x: i16 = -1; x < 0;", 1},
+		{"let x: u16 = 1; x == 2 or x < 2;", 1},
+		{`
+			fn fact(n: u16) -> u16 {
+				if n <= 1 {
+					return 1;
+				}
+				return n * fact(n - 1);
+			}
+			fact(6);
+		`, 720},
+		{`
+			fn sub(a: u16, b: u16) -> u16 {
+				return a - b;
+			}
+			sub(10, 3);
+		`, 7},
+		{`
+			let i: u16 = 0;
+			let sum: u16 = 0;
+			while i < 100 {
+				i = i + 1;
+				sum = sum + i;
+			}
+			sum;
+		`, 5050},
+		{`
+			let count: u16 = 0;
+			fn bump(n: u16) -> u16 {
+				count = count + n;
+				return count;
+			}
+			bump(2);
+			bump(3) * 10 + count;
+		`, 55},
+	}
+
+	for _, tt := range tests {
+		require.Equal(t, tt.expected, run(t, tt.input), tt.input)
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	_, err := Encode([]Instr{{Op: JMP, Label: "nowhere"}})
+	require.ErrorIs(t, err, errUndefinedLabel)
+
+	_, err = Run([]byte{byte(JMP), 0, 0}, maxSteps)
+	require.ErrorIs(t, err, errStepLimit)
+
+	_, err = Run([]byte{byte(PUSH), 1, 0, byte(PUSH), 0, 0, byte(UDIV), byte(HALT)}, maxSteps)
+	require.ErrorIs(t, err, errDivisionByZero)
+
+	_, err = Run([]byte{byte(ADD)}, maxSteps)
+	require.ErrorIs(t, err, errStackUnderflow)
+
+	_, err = Run([]byte{byte(PUSH), 1}, maxSteps)
+	require.ErrorIs(t, err, errPCOutOfRange)
+
+	_, err = Run([]byte{0xFE}, maxSteps)
+	require.ErrorIs(t, err, errBadOpcode)
+
+	// a function calling itself forever runs out of stack
+	_, err = Run([]byte{byte(CALL), 0, 0}, 1<<20)
+	require.ErrorIs(t, err, errStackOverflow)
+}
+
+func TestStrip(t *testing.T) {
+	bytecode, err := Strip(append(Header(), byte(HALT)))
+	require.NoError(t, err)
+	require.Equal(t, []byte{byte(HALT)}, bytecode)
+
+	_, err = Strip([]byte("R16\x00"))
+	require.ErrorIs(t, err, errBadBinary)
+}
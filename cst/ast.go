@@ -0,0 +1,186 @@
+package cst
+
+import (
+	"stag/pratt_parser/ast"
+	"stag/primitives"
+	"strconv"
+)
+
+// AST derives the abstract syntax tree the pratt parser builds for
+// the same source, the statements with syntax errors are left out.
+// The identifiers share their tokens with the leaves of the tree
+func (t *Tree) AST() *ast.Program {
+	program := &ast.Program{Statements: []ast.Statement{}}
+	for _, child := range t.Root.Children {
+		if child.Kind == Leaf || child.HasError() {
+			continue
+		}
+		if stmt := statement(child); stmt != nil {
+			program.Statements = append(program.Statements, stmt)
+		}
+	}
+	return program
+}
+
+func statement(n *Node) ast.Statement {
+	switch n.Kind {
+	case LetStatement:
+		s := &ast.LetStatement{Token: n.Children[0].Token, Name: identifier(n.Children[1])}
+		for i, c := range n.Children {
+			switch {
+			case c.Is(primitives.Colon):
+				s.Type = identifier(n.Children[i+1])
+			case c.Is(primitives.Assign):
+				s.Value = expression(n.Children[i+1])
+			}
+		}
+		if s.Value == nil {
+			return nil
+		}
+		return s
+
+	case AssignStatement:
+		value := expression(n.Children[2])
+		if value == nil {
+			return nil
+		}
+		return &ast.AssignStatement{Token: n.Children[1].Token, Name: identifier(n.Children[0]), Value: value}
+
+	case ReturnStatement:
+		s := &ast.ReturnStatement{Token: n.Children[0].Token}
+		if len(n.Children) > 1 && !n.Children[1].Is(primitives.Semicolon) {
+			s.ReturnValue = expression(n.Children[1])
+		}
+		return s
+
+	case ExpressionStatement:
+		e := expression(n.Children[0])
+		if e == nil {
+			return nil
+		}
+		return &ast.ExpressionStatement{Token: *n.First(), Expression: e}
+
+	case BlockStatement:
+		return block(n)
+
+	case IfStatement:
+		s := &ast.IfStatement{Token: n.Children[0].Token, Condition: expression(n.Children[1]), Consequence: block(n.Children[2])}
+		if s.Condition == nil {
+			return nil
+		}
+		if len(n.Children) == 5 {
+			alt := n.Children[4]
+			if alt.Kind == IfStatement {
+				// else if is sugar for a block holding the if
+				nested := statement(alt)
+				if nested == nil {
+					return nil
+				}
+				s.Alternative = &ast.BlockStatement{
+					Token:      *alt.First(),
+					Statements: []ast.Statement{nested},
+					Rbrace:     *alt.Last(),
+				}
+			} else {
+				s.Alternative = block(alt)
+			}
+		}
+		return s
+
+	case WhileStatement:
+		s := &ast.WhileStatement{Token: n.Children[0].Token, Condition: expression(n.Children[1]), Body: block(n.Children[2])}
+		if s.Condition == nil {
+			return nil
+		}
+		return s
+
+	case FunctionStatement:
+		s := &ast.FunctionStatement{Token: n.Children[0].Token, Name: identifier(n.Children[1]), Parameters: []*ast.Parameter{}}
+		for _, c := range n.Children[2].Children {
+			if c.Kind == Parameter {
+				s.Parameters = append(s.Parameters, &ast.Parameter{Name: identifier(c.Children[0]), Type: identifier(c.Children[2])})
+			}
+		}
+		if n.Children[3].Is(primitives.Arrow) {
+			s.ReturnType = identifier(n.Children[4])
+		}
+		s.Body = block(n.Children[len(n.Children)-1])
+		return s
+	}
+	return nil
+}
+
+func block(n *Node) *ast.BlockStatement {
+	b := &ast.BlockStatement{Token: n.Children[0].Token, Statements: []ast.Statement{}, Rbrace: *n.Last()}
+	for _, c := range n.Children[1 : len(n.Children)-1] {
+		if stmt := statement(c); stmt != nil {
+			b.Statements = append(b.Statements, stmt)
+		}
+	}
+	return b
+}
+
+func identifier(n *Node) *ast.Identifier {
+	tok := n.Children[0].Token
+	return &ast.Identifier{Token: tok, Value: tok.Literal}
+}
+
+// expression returns nil when a literal does not fit, the
+// pratt parser drops those statements as well
+func expression(n *Node) ast.Expression {
+	switch n.Kind {
+	case Identifier:
+		return identifier(n)
+
+	case IntegerLiteral:
+		tok := n.Children[0].Token
+		value, err := strconv.ParseInt(tok.Literal, 0, 64)
+		if err != nil {
+			return nil
+		}
+		return &ast.IntegerLiteral{Token: tok, Value: value}
+
+	case Boolean:
+		tok := n.Children[0].Token
+		return &ast.Boolean{Token: tok, Value: tok.Literal == "true"}
+
+	case PrefixExpression:
+		right := expression(n.Children[1])
+		if right == nil {
+			return nil
+		}
+		tok := n.Children[0].Token
+		return &ast.PrefixExpression{Token: tok, Operator: tok.Literal, Right: right}
+
+	case InfixExpression:
+		left, right := expression(n.Children[0]), expression(n.Children[2])
+		if left == nil || right == nil {
+			return nil
+		}
+		tok := n.Children[1].Token
+		return &ast.InfixExpression{Token: tok, Left: left, Operator: tok.Literal, Right: right}
+
+	case ParenExpression:
+		return expression(n.Children[1])
+
+	case CallExpression:
+		function := expression(n.Children[0])
+		if function == nil {
+			return nil
+		}
+		args := n.Children[1]
+		call := &ast.CallExpression{Token: args.Children[0].Token, Function: function, Arguments: []ast.Expression{}}
+		for _, c := range args.Children {
+			if c.Kind == Leaf {
+				continue
+			}
+			arg := expression(c)
+			if arg == nil {
+				return nil
+			}
+			call.Arguments = append(call.Arguments, arg)
+		}
+		return call
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package cst
+
+import (
+	"math/rand"
+	"stag/lexer"
+	"stag/pratt_parser"
+	"stag/pratt_parser/ast"
+	"stag/primitives"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var sources = []string{
+	"",
+	"let x = 55090 + 5;",
+	"  // only trivia\n\n",
+	"let x: u16 = (1 + 2) * -f(3, (4));\r\nx = x << 2 >> 1;",
+	"fn sum(n: u16) -> u16 { // loop\n\tlet total: u16 = 0;\n\twhile n > 0 {\n\t\ttotal = total + n;\n\t\tn = n - 1;\n\t}\n\treturn total;\n}\nsum(10)\n",
+	"if a and !b or c == 1 { x = 1; } else if b { x = 2; } else { x = 3; }",
+	"fn f() { return; }\nfn g(a: u8, b: i16) { return }\n;;\n{ { } }\n",
+	"let t = true; let f = false != t\nf",
+	"3 + 4",
+}
+
+func checkSameAST(t *testing.T, src string) {
+	t.Helper()
+
+	p := pratt_parser.New(lexer.New(src))
+	want := p.ParseProgram()
+	require.Empty(t, p.Errors(), src)
+
+	tree := Parse(src)
+	require.Empty(t, tree.Errors, src)
+	got := tree.AST()
+
+	require.Equal(t, want.String(), got.String(), src)
+	require.Len(t, got.Statements, len(want.Statements))
+	for i := range want.Statements {
+		wantLine, wantColumn := ast.Pos(want.Statements[i])
+		gotLine, gotColumn := ast.Pos(got.Statements[i])
+		require.Equal(t, [2]int{wantLine, wantColumn}, [2]int{gotLine, gotColumn}, src)
+	}
+}
+
+func TestLossless(t *testing.T) {
+	for _, src := range sources {
+		require.Equal(t, src, Parse(src).Text())
+		checkSameAST(t, src)
+	}
+
+	// what does not parse is kept as well
+	broken := []string{"let = 5;", "fn f(a u16) {", "}}", "x = ;\n(1, 2", "fn f(a: u8,) {}", "let x = 99999999999999999999;", "$ @"}
+	for _, src := range broken {
+		tree := Parse(src)
+		require.Equal(t, src, tree.Text())
+		require.NotEmpty(t, tree.Errors, src)
+	}
+}
+
+func TestTree(t *testing.T) {
+	tree := Parse("let x = (1 + 2); // three\nf(x)")
+	require.Equal(t, `(Program `+
+		`(LetStatement "let"@1:1 (Identifier "x"@1:5) "="@1:7 `+
+		`(ParenExpression "("@1:9 (InfixExpression (IntegerLiteral "1"@1:10) "+"@1:12 (IntegerLiteral "2"@1:14)) ")"@1:15) ";"@1:16) `+
+		`(ExpressionStatement (CallExpression (Identifier "f"@2:1) (ArgumentList "("@2:2 (Identifier "x"@2:3) ")"@2:4))) `+
+		`""@2:5)`, tree.Root.String())
+
+	let := tree.Root.Children[0]
+	semicolon := let.Children[len(let.Children)-1]
+	require.True(t, semicolon.Is(primitives.Semicolon))
+	require.Equal(t, " // three", semicolon.Text()[1:])
+
+	tree = Parse("fn f(a u16) {")
+	require.Equal(t, []*Error{{Line: 1, Column: 8, Msg: "expected Colon, got Ident instead"}}, tree.Errors[:1])
+	// the function is left out, parsing goes on after the error
+	require.Equal(t, "u16", tree.AST().String())
+}
+
+// randomProgram writes a valid program with random trivia
+func randomProgram(r *rand.Rand) string {
+	blank := func() string {
+		return []string{"", " ", "  ", "\n", " // c\n", "\t", "\r\n"}[r.Intn(7)]
+	}
+	var expr func(depth int) string
+	expr = func(depth int) string {
+		if depth == 0 || r.Intn(3) == 0 {
+			return []string{"x", "1", "true", "y"}[r.Intn(4)]
+		}
+		switch r.Intn(4) {
+		case 0:
+			return "-" + expr(depth-1)
+		case 1:
+			return "(" + blank() + expr(depth-1) + ")"
+		case 2:
+			return "f(" + expr(depth-1) + "," + blank() + expr(depth-1) + ")"
+		}
+		return expr(depth-1) + blank() + " " + []string{"+", "*", "<<", "==", "and", "-"}[r.Intn(6)] + " " + expr(depth-1)
+	}
+
+	var stmts func(depth int) string
+	stmts = func(depth int) string {
+		out := ""
+		for i := r.Intn(4); i >= 0; i-- {
+			switch r.Intn(6) {
+			case 0:
+				out += "let x" + blank() + "=" + blank() + expr(2) + ";"
+			case 1:
+				out += "y = " + expr(2) + ";"
+			case 2:
+				if depth > 0 {
+					out += "if " + expr(1) + " {" + stmts(depth-1) + "}" + blank()
+					if r.Intn(2) == 0 {
+						out += "else {" + stmts(depth-1) + "}"
+					}
+				}
+			case 3:
+				if depth > 0 {
+					out += "while " + expr(1) + blank() + "{" + stmts(depth-1) + "}"
+				}
+			case 4:
+				out += expr(3) + ";"
+			default:
+				out += "return " + expr(1) + ";"
+			}
+			out += blank()
+		}
+		return out
+	}
+
+	out := blank()
+	for i := r.Intn(5); i >= 0; i-- {
+		if r.Intn(3) == 0 {
+			out += "fn f(a: u16, b: u16) -> u16 {" + blank() + stmts(2) + "}"
+		} else {
+			out += stmts(1)
+		}
+		out += blank()
+	}
+	return out
+}
+
+func TestRandomPrograms(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		src := randomProgram(r)
+		require.Equal(t, src, Parse(src).Text())
+		checkSameAST(t, src)
+	}
+}
+
+func TestEdit(t *testing.T) {
+	src := "let a = 1;\nlet b = 2; // two\nfn f() {\n\treturn a;\n}\nlet c = b;\n"
+	tree := Parse(src)
+	before := append([]*Node{}, tree.Root.Children...)
+
+	// 2 becomes 20, only the second statement is parsed again
+	incremental, err := tree.Edit(19, 1, "20")
+	require.NoError(t, err)
+	require.True(t, incremental)
+	require.Same(t, before[0], tree.Root.Children[0])
+	require.NotSame(t, before[1], tree.Root.Children[1])
+	require.Same(t, before[2], tree.Root.Children[2])
+	require.Equal(t, Parse(tree.Text()).Root.String(), tree.Root.String())
+
+	// a new line moves the positions of what follows
+	incremental, err = tree.Edit(10, 0, "\nlet z = 0;\n")
+	require.NoError(t, err)
+	require.True(t, incremental)
+	require.Equal(t, "let a = 1;\nlet z = 0;\n\nlet b = 20; // two\nfn f() {\n\treturn a;\n}\nlet c = b;\n", tree.Text())
+	require.Equal(t, Parse(tree.Text()).Root.String(), tree.Root.String())
+
+	// the statement after a missing semicolon still starts with let
+	incremental, err = tree.Edit(9, 1, "")
+	require.NoError(t, err)
+	require.True(t, incremental)
+	require.Equal(t, Parse(tree.Text()).Root.String(), tree.Root.String())
+
+	// a tree with errors is parsed again as a whole
+	incremental, err = tree.Edit(8, 1, "")
+	require.NoError(t, err)
+	require.False(t, incremental)
+	require.NotEmpty(t, tree.Errors)
+	incremental, err = tree.Edit(8, 0, "1;")
+	require.NoError(t, err)
+	require.False(t, incremental)
+	require.Empty(t, tree.Errors)
+
+	_, err = tree.Edit(0, 1000, "")
+	require.ErrorIs(t, err, ErrEditRange)
+}
+
+// offsets lists where each token of the tree starts
+func offsets(n *Node) []int {
+	var out []int
+	n.Tokens(func(tok *primitives.Token) {
+		out = append(out, tok.Offset)
+	})
+	return out
+}
+
+func TestRandomEdits(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	pieces := []string{"", " ", "\n", ";", "}", "{", "x", "1 + ", "let q = 3;", "// c\n", "(", ")", "else", "if y {}", "fn", "="}
+
+	incremental := 0
+	for i := 0; i < 300; i++ {
+		tree := Parse(randomProgram(r))
+		for j := 0; j < 10; j++ {
+			text := tree.Text()
+			offset := r.Intn(len(text) + 1)
+			length := r.Intn(min(4, len(text)-offset) + 1)
+			insert := pieces[r.Intn(len(pieces))]
+
+			want := text[:offset] + insert + text[offset+length:]
+
+			ok, err := tree.Edit(offset, length, insert)
+			require.NoError(t, err)
+			if ok {
+				incremental++
+			}
+
+			require.Equal(t, want, tree.Text())
+			full := Parse(want)
+			require.Equal(t, full.Root.String(), tree.Root.String(), "%q", want)
+			require.Equal(t, offsets(full.Root), offsets(tree.Root), "%q", want)
+			require.Equal(t, len(full.Errors), len(tree.Errors))
+		}
+	}
+	require.Greater(t, incremental, 100)
+}
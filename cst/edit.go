@@ -0,0 +1,107 @@
+package cst
+
+import (
+	"errors"
+	"stag/lexer"
+	"stag/primitives"
+	"strings"
+)
+
+var ErrEditRange = errors.New("cst: edit out of range")
+
+// Edit replaces length bytes at offset with text and updates the
+// tree, it reports if only the statements around the edit had to
+// be parsed again. The whole source is parsed again when the tree
+// has errors or the edit could change how the rest of it parses
+func (t *Tree) Edit(offset, length int, text string) (incremental bool, err error) {
+	old := t.Text()
+	if offset < 0 || length < 0 || offset+length > len(old) {
+		return false, ErrEditRange
+	}
+
+	src := old[:offset] + text + old[offset+length:]
+	if len(t.Errors) == 0 && t.reparse(old, src, offset, length, len(text)) {
+		return true, nil
+	}
+	*t = *Parse(src)
+	return false, nil
+}
+
+// reparse parses again the top level statements touching the edit,
+// the statements outside of them keep their nodes
+func (t *Tree) reparse(old, src string, offset, length, inserted int) bool {
+	children := t.Root.Children
+
+	// starts[i] is the offset of the child i, the last one is the end
+	starts := make([]int, len(children)+1)
+	for i, child := range children {
+		starts[i+1] = starts[i] + child.Width()
+	}
+
+	first, last := -1, -1
+	for i := range children {
+		if first < 0 && starts[i+1] >= offset {
+			first = i
+		}
+		if starts[i] <= offset+length {
+			last = i
+		}
+	}
+	// a statement without a terminator could go on into the region
+	for first > 0 && !closed(children[first-1]) {
+		first--
+	}
+	eof := last == len(children)-1
+
+	oldStart, oldEnd := starts[first], starts[last+1]
+	newEnd := oldEnd + inserted - length
+
+	line, column := position(src[:oldStart])
+	p := newParser(lexer.New(src[oldStart:newEnd], lexer.WithTrivia(), lexer.StartAt(oldStart, line, column)))
+	stmts := p.statements(false)
+	end := p.next()
+	if len(p.errors) > 0 {
+		return false
+	}
+
+	if eof {
+		stmts = append(stmts, end)
+	} else {
+		// the region has to end like before, between two
+		// statements and without trivia of its own
+		if len(end.Leading) > 0 || len(stmts) > 0 && !closed(stmts[len(stmts)-1]) {
+			return false
+		}
+
+		oldLine, oldColumn := position(old[:oldEnd])
+		newLine, newColumn := position(src[:newEnd])
+		for _, child := range children[last+1:] {
+			child.Tokens(func(tok *primitives.Token) {
+				tok.Offset += inserted - length
+				if tok.SourceLine == oldLine {
+					tok.SourceColumn += newColumn - oldColumn
+				}
+				tok.SourceLine += newLine - oldLine
+			})
+		}
+	}
+
+	spliced := make([]*Node, 0, len(children)-(last-first+1)+len(stmts))
+	spliced = append(spliced, children[:first]...)
+	spliced = append(spliced, stmts...)
+	spliced = append(spliced, children[last+1:]...)
+	t.Root.Children = spliced
+	return true
+}
+
+// closed reports if the statement ends with a ; or a }, the
+// tokens after it can not be part of it then
+func closed(n *Node) bool {
+	last := n.Last()
+	return last != nil && (last.Kind == primitives.Semicolon || last.Kind == primitives.CloseCurlyBrace)
+}
+
+// position returns the line and column right after the text
+func position(text string) (line, column int) {
+	return strings.Count(text, "\n") + 1, len(text) - strings.LastIndex(text, "\n")
+}
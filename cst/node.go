@@ -0,0 +1,197 @@
+package cst
+
+import (
+	"fmt"
+	"stag/primitives"
+	"strings"
+)
+
+type Kind uint8
+
+const (
+	Leaf Kind = iota // a token with its trivia
+	Program
+	LetStatement
+	AssignStatement
+	ReturnStatement
+	ExpressionStatement
+	EmptyStatement // a lone ;
+	BlockStatement
+	IfStatement
+	WhileStatement
+	FunctionStatement
+	ParameterList
+	Parameter
+	Identifier
+	IntegerLiteral
+	Boolean
+	PrefixExpression
+	InfixExpression
+	ParenExpression
+	CallExpression
+	ArgumentList
+	// Bad holds the tokens the parser could not place, it is
+	// empty where something was missing
+	Bad
+)
+
+var kindNames = [...]string{
+	Leaf:                "Leaf",
+	Program:             "Program",
+	LetStatement:        "LetStatement",
+	AssignStatement:     "AssignStatement",
+	ReturnStatement:     "ReturnStatement",
+	ExpressionStatement: "ExpressionStatement",
+	EmptyStatement:      "EmptyStatement",
+	BlockStatement:      "BlockStatement",
+	IfStatement:         "IfStatement",
+	WhileStatement:      "WhileStatement",
+	FunctionStatement:   "FunctionStatement",
+	ParameterList:       "ParameterList",
+	Parameter:           "Parameter",
+	Identifier:          "Identifier",
+	IntegerLiteral:      "IntegerLiteral",
+	Boolean:             "Boolean",
+	PrefixExpression:    "PrefixExpression",
+	InfixExpression:     "InfixExpression",
+	ParenExpression:     "ParenExpression",
+	CallExpression:      "CallExpression",
+	ArgumentList:        "ArgumentList",
+	Bad:                 "Bad",
+}
+
+func (k Kind) String() string {
+	if int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return fmt.Sprintf("Kind(%d)", k)
+}
+
+// Node is a node of the concrete syntax tree, the leaves hold every
+// token of the source in order, punctuation and trivia included
+type Node struct {
+	Kind  Kind
+	Token primitives.Token // only on leaves
+	// Leading and Trailing are the trivia around the token of a
+	// leaf, the trailing trivia goes up to the end of the line
+	Leading  []primitives.Trivia
+	Trailing []primitives.Trivia
+	Children []*Node
+}
+
+func leaf(tok primitives.Token, leading, trailing []primitives.Trivia) *Node {
+	return &Node{Kind: Leaf, Token: tok, Leading: leading, Trailing: trailing}
+}
+
+// Is reports if the node is a leaf holding a token of the kind
+func (n *Node) Is(kind primitives.TokenKind) bool {
+	return n.Kind == Leaf && n.Token.Kind == kind
+}
+
+// Text returns the source the node spans, trivia included
+func (n *Node) Text() string {
+	var out strings.Builder
+	n.write(&out)
+	return out.String()
+}
+
+func (n *Node) write(out *strings.Builder) {
+	if n.Kind == Leaf {
+		for _, trivia := range n.Leading {
+			out.WriteString(trivia.Text)
+		}
+		out.WriteString(n.Token.Literal)
+		for _, trivia := range n.Trailing {
+			out.WriteString(trivia.Text)
+		}
+		return
+	}
+	for _, child := range n.Children {
+		child.write(out)
+	}
+}
+
+// Width is the length in bytes of the text of the node, the
+// lengths are added up without building the text
+func (n *Node) Width() int {
+	width := 0
+	if n.Kind == Leaf {
+		width = len(n.Token.Literal)
+		for _, trivia := range n.Leading {
+			width += len(trivia.Text)
+		}
+		for _, trivia := range n.Trailing {
+			width += len(trivia.Text)
+		}
+		return width
+	}
+	for _, child := range n.Children {
+		width += child.Width()
+	}
+	return width
+}
+
+// Tokens calls f for every token under the node in source order
+func (n *Node) Tokens(f func(*primitives.Token)) {
+	if n.Kind == Leaf {
+		f(&n.Token)
+		return
+	}
+	for _, child := range n.Children {
+		child.Tokens(f)
+	}
+}
+
+// First and Last return the first and the last token under
+// the node, nil when it has none
+func (n *Node) First() *primitives.Token {
+	if n.Kind == Leaf {
+		return &n.Token
+	}
+	for _, child := range n.Children {
+		if tok := child.First(); tok != nil {
+			return tok
+		}
+	}
+	return nil
+}
+
+func (n *Node) Last() *primitives.Token {
+	if n.Kind == Leaf {
+		return &n.Token
+	}
+	for i := len(n.Children) - 1; i >= 0; i-- {
+		if tok := n.Children[i].Last(); tok != nil {
+			return tok
+		}
+	}
+	return nil
+}
+
+// HasError reports if a Bad node is found under the node
+func (n *Node) HasError() bool {
+	if n.Kind == Bad {
+		return true
+	}
+	for _, child := range n.Children {
+		if child.HasError() {
+			return true
+		}
+	}
+	return false
+}
+
+// String prints the tree as an s-expression, the leaves show
+// their literal and position without the trivia
+func (n *Node) String() string {
+	if n.Kind == Leaf {
+		return fmt.Sprintf("%q@%d:%d", n.Token.Literal, n.Token.SourceLine, n.Token.SourceColumn)
+	}
+
+	parts := make([]string, 0, len(n.Children)+1)
+	parts = append(parts, n.Kind.String())
+	for _, child := range n.Children {
+		parts = append(parts, child.String())
+	}
+	return "(" + strings.Join(parts, " ") + ")"
+}
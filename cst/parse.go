@@ -0,0 +1,315 @@
+package cst
+
+import (
+	"fmt"
+	"stag/lexer"
+	"stag/pratt_parser"
+	"stag/primitives"
+	"strconv"
+)
+
+// Error is a syntax error at the token where it was found
+type Error struct {
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Msg)
+}
+
+// Tree is the concrete syntax tree of a source, the children of
+// the root are the top level statements followed by the EOF leaf
+type Tree struct {
+	Root   *Node
+	Errors []*Error
+}
+
+// Parse builds the tree of the source, it never fails: what does
+// not parse goes in Bad nodes so the text is always kept
+func Parse(src string) *Tree {
+	p := newParser(lexer.New(src, lexer.WithTrivia()))
+	root := &Node{Kind: Program, Children: p.statements(false)}
+	root.Children = append(root.Children, p.next())
+	return &Tree{Root: root, Errors: p.errors}
+}
+
+// Text returns the source of the tree
+func (t *Tree) Text() string {
+	return t.Root.Text()
+}
+
+// parser is a recursive descent parser binding the operators like
+// the pratt parser does, the tokens it consumes become leaves
+type parser struct {
+	l   *lexer.Lexer
+	tok primitives.Token // the next token to consume
+	// the trivia around tok, the lexer only keeps it until
+	// the next token
+	leading, trailing []primitives.Trivia
+	errors            []*Error
+}
+
+func newParser(l *lexer.Lexer) *parser {
+	p := &parser{l: l}
+	p.advance()
+	return p
+}
+
+func (p *parser) advance() {
+	p.tok = p.l.NextToken()
+	p.leading, p.trailing = p.l.Trivia()
+}
+
+// next consumes the current token as a leaf
+func (p *parser) next() *Node {
+	n := leaf(p.tok, p.leading, p.trailing)
+	if p.tok.Kind != primitives.EOF {
+		p.advance()
+	}
+	return n
+}
+
+func (p *parser) errorf(format string, args ...any) {
+	p.errors = append(p.errors, &Error{Line: p.tok.SourceLine, Column: p.tok.SourceColumn, Msg: fmt.Sprintf(format, args...)})
+}
+
+// expect consumes the token when it has the kind, otherwise an
+// empty Bad node marks where it was missing
+func (p *parser) expect(kind primitives.TokenKind, n *Node) bool {
+	if p.tok.Kind == kind {
+		n.Children = append(n.Children, p.next())
+		return true
+	}
+	p.errorf("expected %s, got %s instead", kind, p.tok.Kind)
+	n.Children = append(n.Children, &Node{Kind: Bad})
+	return false
+}
+
+// optional consumes the token when it has the kind
+func (p *parser) optional(kind primitives.TokenKind, n *Node) {
+	if p.tok.Kind == kind {
+		n.Children = append(n.Children, p.next())
+	}
+}
+
+func (p *parser) keyword(literal string) bool {
+	return p.tok.Kind == primitives.Keyword && p.tok.Literal == literal
+}
+
+// statements parses up to EOF or the brace closing the block,
+// a token no statement can start with goes alone in a Bad node
+func (p *parser) statements(block bool) []*Node {
+	var stmts []*Node
+	for p.tok.Kind != primitives.EOF && !(block && p.tok.Kind == primitives.CloseCurlyBrace) {
+		start := p.tok.Offset
+		stmt := p.statement()
+		if p.tok.Offset == start {
+			p.errorf("unexpected %s", p.tok.Kind)
+			stmt = &Node{Kind: Bad, Children: []*Node{p.next()}}
+		}
+		stmts = append(stmts, stmt)
+	}
+	return stmts
+}
+
+func (p *parser) statement() *Node {
+	switch {
+	case p.keyword(lexer.Let):
+		return p.let()
+	case p.keyword(lexer.Return):
+		return p.ret()
+	case p.keyword(lexer.Fn):
+		return p.function()
+	case p.keyword(lexer.If):
+		return p.ifStatement()
+	case p.keyword(lexer.While):
+		n := &Node{Kind: WhileStatement, Children: []*Node{p.next(), p.expression(pratt_parser.LOWEST)}}
+		p.block(n)
+		return n
+	case p.tok.Kind == primitives.Ident && p.l.Peek(0).Kind == primitives.Assign:
+		n := &Node{Kind: AssignStatement, Children: []*Node{p.identifier(), p.next(), p.expression(pratt_parser.LOWEST)}}
+		p.optional(primitives.Semicolon, n)
+		return n
+	case p.tok.Kind == primitives.OpenCurlyBrace:
+		n := &Node{}
+		p.block(n)
+		return n.Children[0]
+	case p.tok.Kind == primitives.Semicolon:
+		return &Node{Kind: EmptyStatement, Children: []*Node{p.next()}}
+	}
+
+	n := &Node{Kind: ExpressionStatement, Children: []*Node{p.expression(pratt_parser.LOWEST)}}
+	p.optional(primitives.Semicolon, n)
+	return n
+}
+
+func (p *parser) let() *Node {
+	n := &Node{Kind: LetStatement, Children: []*Node{p.next()}}
+	if !p.name(n) {
+		return n
+	}
+	if p.tok.Kind == primitives.Colon {
+		n.Children = append(n.Children, p.next())
+		if !p.name(n) {
+			return n
+		}
+	}
+	if !p.expect(primitives.Assign, n) {
+		return n
+	}
+	n.Children = append(n.Children, p.expression(pratt_parser.LOWEST))
+	p.optional(primitives.Semicolon, n)
+	return n
+}
+
+func (p *parser) ret() *Node {
+	n := &Node{Kind: ReturnStatement, Children: []*Node{p.next()}}
+	switch p.tok.Kind {
+	case primitives.Semicolon:
+		n.Children = append(n.Children, p.next())
+	case primitives.CloseCurlyBrace, primitives.EOF:
+	default:
+		n.Children = append(n.Children, p.expression(pratt_parser.LOWEST))
+		p.optional(primitives.Semicolon, n)
+	}
+	return n
+}
+
+// block appends a block statement to n
+func (p *parser) block(n *Node) {
+	block := &Node{Kind: BlockStatement}
+	n.Children = append(n.Children, block)
+	if !p.expect(primitives.OpenCurlyBrace, block) {
+		return
+	}
+	block.Children = append(block.Children, p.statements(true)...)
+	p.expect(primitives.CloseCurlyBrace, block)
+}
+
+func (p *parser) ifStatement() *Node {
+	n := &Node{Kind: IfStatement, Children: []*Node{p.next(), p.expression(pratt_parser.LOWEST)}}
+	p.block(n)
+	if !p.keyword(lexer.Else) {
+		return n
+	}
+
+	n.Children = append(n.Children, p.next())
+	if p.keyword(lexer.If) {
+		n.Children = append(n.Children, p.ifStatement())
+	} else {
+		p.block(n)
+	}
+	return n
+}
+
+func (p *parser) function() *Node {
+	n := &Node{Kind: FunctionStatement, Children: []*Node{p.next()}}
+	if !p.name(n) {
+		return n
+	}
+
+	params := &Node{Kind: ParameterList}
+	n.Children = append(n.Children, params)
+	if !p.expect(primitives.OpenParen, params) {
+		return n
+	}
+	for p.tok.Kind != primitives.CloseParen {
+		param := &Node{Kind: Parameter}
+		params.Children = append(params.Children, param)
+		if !p.name(param) || !p.expect(primitives.Colon, param) || !p.name(param) {
+			return n
+		}
+		if p.tok.Kind != primitives.Comma {
+			break
+		}
+		params.Children = append(params.Children, p.next())
+		// a comma needs a parameter after it
+		if p.tok.Kind == primitives.CloseParen {
+			p.errorf("expected %s, got %s instead", primitives.Ident, p.tok.Kind)
+			params.Children = append(params.Children, &Node{Kind: Bad})
+		}
+	}
+	if !p.expect(primitives.CloseParen, params) {
+		return n
+	}
+
+	if p.tok.Kind == primitives.Arrow {
+		n.Children = append(n.Children, p.next())
+		if !p.name(n) {
+			return n
+		}
+	}
+	p.block(n)
+	return n
+}
+
+func (p *parser) identifier() *Node {
+	return &Node{Kind: Identifier, Children: []*Node{p.next()}}
+}
+
+// name appends the identifier expected next to n
+func (p *parser) name(n *Node) bool {
+	if p.tok.Kind != primitives.Ident {
+		return p.expect(primitives.Ident, n)
+	}
+	n.Children = append(n.Children, p.identifier())
+	return true
+}
+
+func (p *parser) expression(precedence int) *Node {
+	left := p.prefix()
+	for left.Kind != Bad && precedence < pratt_parser.Precedence(p.tok) {
+		if p.tok.Kind == primitives.OpenParen {
+			left = p.call(left)
+			continue
+		}
+		op := p.tok
+		left = &Node{Kind: InfixExpression, Children: []*Node{left, p.next()}}
+		left.Children = append(left.Children, p.expression(pratt_parser.Precedence(op)))
+	}
+	return left
+}
+
+func (p *parser) prefix() *Node {
+	switch p.tok.Kind {
+	case primitives.Ident:
+		return p.identifier()
+	case primitives.Number:
+		if _, err := strconv.ParseInt(p.tok.Literal, 0, 64); err != nil {
+			p.errorf("could not parse %q as integer", p.tok.Literal)
+		}
+		return &Node{Kind: IntegerLiteral, Children: []*Node{p.next()}}
+	case primitives.Bang, primitives.Minus:
+		return &Node{Kind: PrefixExpression, Children: []*Node{p.next(), p.expression(pratt_parser.PREFIX)}}
+	case primitives.OpenParen:
+		n := &Node{Kind: ParenExpression, Children: []*Node{p.next(), p.expression(pratt_parser.LOWEST)}}
+		p.expect(primitives.CloseParen, n)
+		return n
+	case primitives.Keyword:
+		if p.keyword(lexer.True) || p.keyword(lexer.False) {
+			return &Node{Kind: Boolean, Children: []*Node{p.next()}}
+		}
+	}
+
+	p.errorf("unexpected %s %q in expression", p.tok.Kind, p.tok.Literal)
+	return &Node{Kind: Bad}
+}
+
+func (p *parser) call(function *Node) *Node {
+	args := &Node{Kind: ArgumentList, Children: []*Node{p.next()}}
+	n := &Node{Kind: CallExpression, Children: []*Node{function, args}}
+
+	if p.tok.Kind != primitives.CloseParen {
+		for {
+			args.Children = append(args.Children, p.expression(pratt_parser.LOWEST))
+			if p.tok.Kind != primitives.Comma {
+				break
+			}
+			args.Children = append(args.Children, p.next())
+		}
+	}
+	p.expect(primitives.CloseParen, args)
+	return n
+}
@@ -0,0 +1,285 @@
+package dot
+
+import (
+	"fmt"
+	"sort"
+	"stag/ir"
+	"stag/pratt_parser/ast"
+	"strconv"
+	"strings"
+)
+
+// graph collects the statements of a digraph
+type graph struct {
+	out strings.Builder
+}
+
+func newGraph(name string) *graph {
+	g := &graph{}
+	g.out.WriteString("digraph " + name + " {\n")
+	g.out.WriteString("\tnode [shape=box fontname=monospace];\n")
+	return g
+}
+
+func (g *graph) line(indent int, format string, args ...any) {
+	g.out.WriteString(strings.Repeat("\t", indent))
+	fmt.Fprintf(&g.out, format, args...)
+	g.out.WriteString("\n")
+}
+
+func (g *graph) String() string {
+	return g.out.String() + "}\n"
+}
+
+// label quotes the lines of a label, left aligns them with \l
+// instead of centering them with \n
+func label(lines []string, left bool) string {
+	sep := `\n`
+	if left {
+		sep = `\l`
+	}
+
+	var out strings.Builder
+	out.WriteString(`"`)
+	for i, line := range lines {
+		line = strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(line)
+		out.WriteString(line)
+		if left || i < len(lines)-1 {
+			out.WriteString(sep)
+		}
+	}
+	out.WriteString(`"`)
+	return out.String()
+}
+
+// Span writes the source span of the node as line:column-line:column
+func Span(node ast.Node) string {
+	line, column := ast.Pos(node)
+	endLine, endColumn := ast.End(node)
+	return fmt.Sprintf("%d:%d-%d:%d", line, column, endLine, endColumn)
+}
+
+// AST renders the tree with a node for each of its nodes, labelled
+// with the type, the name, literal or operator it holds and its span
+func AST(program *ast.Program) string {
+	g := newGraph("ast")
+
+	ids := map[ast.Node]int{}
+	var stack []ast.Node
+	ast.Inspect(program, func(node ast.Node) bool {
+		if node == nil {
+			stack = stack[:len(stack)-1]
+			return true
+		}
+
+		id := len(ids)
+		ids[node] = id
+		lines := []string{strings.TrimPrefix(fmt.Sprintf("%T", node), "*ast.")}
+		if detail := detail(node); detail != "" {
+			lines = append(lines, detail)
+		}
+		lines = append(lines, Span(node))
+		g.line(1, "n%d [label=%s];", id, label(lines, false))
+
+		if len(stack) > 0 {
+			g.line(1, "n%d -> n%d;", ids[stack[len(stack)-1]], id)
+		}
+		stack = append(stack, node)
+		return true
+	})
+	return g.String()
+}
+
+func detail(node ast.Node) string {
+	switch n := node.(type) {
+	case *ast.Identifier:
+		return n.Value
+	case *ast.IntegerLiteral:
+		return n.Token.Literal
+	case *ast.Boolean:
+		return n.Token.Literal
+	case *ast.PrefixExpression:
+		return n.Operator
+	case *ast.InfixExpression:
+		return n.Operator
+	case *ast.FunctionStatement:
+		return n.Name.Value
+	case *ast.LetStatement:
+		return n.Name.Value
+	case *ast.AssignStatement:
+		return n.Name.Value
+	}
+	return ""
+}
+
+// Assignment tells where the backend keeps the virtual registers
+// of a function, e.g. rust16vm.Assignment
+type Assignment func(fn *ir.Func) map[ir.Reg]string
+
+// CFG renders a cluster per function with its basic blocks, the
+// blocks list their instructions and the source lines they come
+// from. When regs is not nil the instructions show where each of
+// their registers is kept
+func CFG(program *ir.Program, regs Assignment) string {
+	g := newGraph("cfg")
+
+	for i, fn := range program.Funcs {
+		var where map[ir.Reg]string
+		if regs != nil {
+			where = regs(fn)
+		}
+
+		g.line(1, "subgraph cluster_%d {", i)
+		g.line(2, "label=%s;", label([]string{"func " + fn.Name + "(" + regList(fn.Params, where) + ")"}, false))
+		for _, b := range fn.Blocks {
+			lines := []string{b.Name + ":" + lineSpan(b)}
+			for _, instr := range b.Instrs {
+				lines = append(lines, "  "+instr.String()+annotate(where, instr.Dst, instr.Args...))
+			}
+			if b.Term != nil {
+				regs := []ir.Reg{}
+				switch b.Term.Kind {
+				case ir.Branch:
+					regs = append(regs, b.Term.Cond)
+				case ir.Return:
+					regs = append(regs, b.Term.Value)
+				}
+				lines = append(lines, "  "+b.Term.String()+annotate(where, ir.NoReg, regs...))
+			}
+			g.line(2, "%s [label=%s];", blockID(i, b), label(lines, true))
+		}
+
+		for _, b := range fn.Blocks {
+			for j, succ := range b.Succs() {
+				attrs := ""
+				if b.Term.Kind == ir.Branch {
+					attrs = []string{` [label="then"]`, ` [label="else"]`}[j]
+				}
+				g.line(2, "%s -> %s%s;", blockID(i, b), blockID(i, succ), attrs)
+			}
+		}
+		g.line(1, "}")
+	}
+	return g.String()
+}
+
+func blockID(fn int, b *ir.Block) string {
+	return strconv.Quote(fmt.Sprintf("f%d.%s", fn, b.Name))
+}
+
+// lineSpan returns the source lines the block was lowered from
+func lineSpan(b *ir.Block) string {
+	first, last := 0, 0
+	add := func(line int) {
+		if line == 0 {
+			return
+		}
+		if first == 0 || line < first {
+			first = line
+		}
+		last = max(last, line)
+	}
+	for _, instr := range b.Instrs {
+		add(instr.Line)
+	}
+	if b.Term != nil {
+		add(b.Term.Line)
+	}
+
+	switch {
+	case first == 0:
+		return ""
+	case first == last:
+		return fmt.Sprintf(" line %d", first)
+	}
+	return fmt.Sprintf(" lines %d-%d", first, last)
+}
+
+// annotate lists where the registers an instruction touches are kept
+func annotate(where map[ir.Reg]string, dst ir.Reg, args ...ir.Reg) string {
+	if where == nil {
+		return ""
+	}
+
+	seen := map[ir.Reg]bool{}
+	parts := []string{}
+	for _, r := range append([]ir.Reg{dst}, args...) {
+		if r == ir.NoReg || seen[r] {
+			continue
+		}
+		seen[r] = true
+		parts = append(parts, r.String()+"="+where[r])
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "  ; " + strings.Join(parts, " ")
+}
+
+func regList(regs []ir.Reg, where map[ir.Reg]string) string {
+	parts := make([]string, 0, len(regs))
+	for _, r := range regs {
+		if where != nil {
+			parts = append(parts, r.String()+"="+where[r])
+		} else {
+			parts = append(parts, r.String())
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// CallGraph renders a node for each function with the span of its
+// declaration and an edge for each function it calls, labelled with
+// the number of calls when there is more than one
+func CallGraph(program *ast.Program, code *ir.Program) string {
+	g := newGraph("callgraph")
+
+	spans := map[string]string{}
+	var top []ast.Statement
+	for _, stmt := range program.Statements {
+		if fn, ok := stmt.(*ast.FunctionStatement); ok {
+			spans[fn.Name.Value] = Span(fn)
+		} else {
+			top = append(top, stmt)
+		}
+	}
+	if len(top) > 0 {
+		first, last := top[0], top[len(top)-1]
+		line, column := ast.Pos(first)
+		endLine, endColumn := ast.End(last)
+		spans[ir.MainFunc] = fmt.Sprintf("%d:%d-%d:%d", line, column, endLine, endColumn)
+	}
+
+	for _, fn := range code.Funcs {
+		lines := []string{fn.Name}
+		if span, ok := spans[fn.Name]; ok {
+			lines = append(lines, span)
+		}
+		g.line(1, "%s [label=%s];", strconv.Quote(fn.Name), label(lines, false))
+	}
+
+	for _, fn := range code.Funcs {
+		calls := map[string]int{}
+		for _, b := range fn.Blocks {
+			for _, instr := range b.Instrs {
+				if instr.Op == ir.Call {
+					calls[instr.Callee]++
+				}
+			}
+		}
+
+		callees := make([]string, 0, len(calls))
+		for callee := range calls {
+			callees = append(callees, callee)
+		}
+		sort.Strings(callees)
+		for _, callee := range callees {
+			attrs := ""
+			if n := calls[callee]; n > 1 {
+				attrs = fmt.Sprintf(` [label="%d"]`, n)
+			}
+			g.line(1, "%s -> %s%s;", strconv.Quote(fn.Name), strconv.Quote(callee), attrs)
+		}
+	}
+	return g.String()
+}
@@ -0,0 +1,94 @@
+package dot
+
+import (
+	"stag/codegen/rust16vm"
+	"stag/ir"
+	"stag/lexer"
+	"stag/pratt_parser"
+	"stag/pratt_parser/ast"
+	"stag/types"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const fib = `fn fib(n: u16) -> u16 {
+	if n < 2 { return n; }
+	return fib(n - 1) + fib(n - 2);
+}
+let x = fib(5);
+x
+`
+
+func parse(t *testing.T, src string) (*ast.Program, *ir.Program) {
+	t.Helper()
+	p := pratt_parser.New(lexer.New(src))
+	program := p.ParseProgram()
+	require.Empty(t, p.Errors())
+
+	info, errs := types.Check(program)
+	require.Empty(t, errs)
+	return program, ir.Lower(program, info)
+}
+
+func TestAST(t *testing.T) {
+	program, _ := parse(t, "let x = -1 + 2;")
+	require.Equal(t, `digraph ast {
+	node [shape=box fontname=monospace];
+	n0 [label="Program\n1:1-1:15"];
+	n1 [label="LetStatement\nx\n1:1-1:15"];
+	n0 -> n1;
+	n2 [label="Identifier\nx\n1:5-1:6"];
+	n1 -> n2;
+	n3 [label="InfixExpression\n+\n1:9-1:15"];
+	n1 -> n3;
+	n4 [label="PrefixExpression\n-\n1:9-1:11"];
+	n3 -> n4;
+	n5 [label="IntegerLiteral\n1\n1:10-1:11"];
+	n4 -> n5;
+	n6 [label="IntegerLiteral\n2\n1:14-1:15"];
+	n3 -> n6;
+}
+`, AST(program))
+}
+
+func TestCFG(t *testing.T) {
+	_, code := parse(t, "let a = 1;\nwhile a < 10 { a = a * 2; }\na")
+	require.Equal(t, `digraph cfg {
+	node [shape=box fontname=monospace];
+	subgraph cluster_0 {
+		label="func main()";
+		"f0.entry" [label="entry: lines 1-2\l  %0 = const 1\l  jmp while.cond.1\l"];
+		"f0.while.cond.1" [label="while.cond.1: line 2\l  %1 = const 10\l  %2 = ult %0, %1\l  br %2, while.body.2, while.end.3\l"];
+		"f0.while.body.2" [label="while.body.2: line 2\l  %3 = const 2\l  %4 = mul %0, %3\l  %0 = copy %4\l  jmp while.cond.1\l"];
+		"f0.while.end.3" [label="while.end.3: line 3\l  ret %0\l"];
+		"f0.entry" -> "f0.while.cond.1";
+		"f0.while.cond.1" -> "f0.while.body.2" [label="then"];
+		"f0.while.cond.1" -> "f0.while.end.3" [label="else"];
+		"f0.while.body.2" -> "f0.while.cond.1";
+	}
+}
+`, CFG(code, nil))
+
+	// the registers show where rust16vm keeps them
+	_, code = parse(t, "fn id(a: u16) -> u16 { return a; }")
+	require.Contains(t, CFG(code, rust16vm.Assignment(code)), `label="func id(%0=A)";`)
+	require.Contains(t, CFG(code, rust16vm.Assignment(code)), `\l  ret %0  ; %0=A\l`)
+}
+
+func TestCallGraph(t *testing.T) {
+	program, code := parse(t, fib)
+	require.Equal(t, `digraph callgraph {
+	node [shape=box fontname=monospace];
+	"fib" [label="fib\n1:1-4:2"];
+	"main" [label="main\n5:1-6:2"];
+	"fib" -> "fib" [label="2"];
+	"main" -> "fib";
+}
+`, CallGraph(program, code))
+}
+
+func TestLabel(t *testing.T) {
+	require.Equal(t, `"a \"b\"\n\\c"`, label([]string{`a "b"`, `\c`}, false))
+	require.Equal(t, `"a\lb\l"`, label([]string{"a", "b"}, true))
+}
@@ -0,0 +1,436 @@
+package eval
+
+import (
+	"fmt"
+	"stag/pratt_parser/ast"
+	"stag/types"
+)
+
+// Error is a runtime error at a position of the source
+type Error struct {
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Msg)
+}
+
+func errorf(node ast.Node, format string, args ...any) error {
+	line, column := ast.Pos(node)
+	return &Error{Line: line, Column: column, Msg: fmt.Sprintf(format, args...)}
+}
+
+// DefaultMaxDepth bounds the nesting of calls, deeper recursion
+// is reported as a stack overflow instead of crashing the host
+const DefaultMaxDepth = 10_000
+
+// Interpreter runs programs over the same global environment,
+// the bindings of a run are visible to the next ones
+type Interpreter struct {
+	Globals  *Env
+	MaxDepth int
+	// MaxSteps bounds the statements a run executes,
+	// zero leaves it unbounded
+	MaxSteps int
+
+	depth int
+	steps int
+}
+
+func New() *Interpreter {
+	return &Interpreter{Globals: NewEnv(nil), MaxDepth: DefaultMaxDepth}
+}
+
+// Eval runs the program in a fresh interpreter
+func Eval(program *ast.Program) (Value, error) {
+	return New().Run(program)
+}
+
+// Run executes the top level statements of the program after
+// declaring all of its functions. The result is the value of the
+// last statement when it is a let or an expression, matching the
+// result of main in the compiled program, Unit otherwise
+func (in *Interpreter) Run(program *ast.Program) (Value, error) {
+	for _, stmt := range program.Statements {
+		if fn, ok := stmt.(*ast.FunctionStatement); ok {
+			in.Globals.define(fn.Name.Value, &Function{Decl: fn})
+		}
+	}
+
+	var result Value = Unit{}
+	for _, stmt := range program.Statements {
+		if _, ok := stmt.(*ast.FunctionStatement); ok {
+			continue
+		}
+
+		value, returned, err := in.exec(stmt, in.Globals)
+		if err != nil {
+			return nil, err
+		}
+		if returned {
+			return nil, errorf(stmt, "return outside function")
+		}
+		result = value
+	}
+	return result, nil
+}
+
+// exec runs a statement, returned is true when a return
+// statement was reached and value holds what it returned
+func (in *Interpreter) exec(stmt ast.Statement, env *Env) (value Value, returned bool, err error) {
+	if in.steps++; in.MaxSteps > 0 && in.steps > in.MaxSteps {
+		return nil, false, errorf(stmt, "step limit exceeded")
+	}
+
+	switch s := stmt.(type) {
+	case *ast.LetStatement:
+		value, err := in.eval(s.Value, env)
+		if err != nil {
+			return nil, false, err
+		}
+
+		var target types.Type
+		if s.Type != nil {
+			t, ok := types.Lookup(s.Type.Value)
+			if !ok {
+				return nil, false, errorf(s.Type, "unknown type %s", s.Type.Value)
+			}
+			target = t
+		}
+		value, err = convert(s.Value, value, target, "variable declaration")
+		if err != nil {
+			return nil, false, err
+		}
+		env.define(s.Name.Value, value)
+		return value, false, nil
+
+	case *ast.AssignStatement:
+		b := env.lookup(s.Name.Value)
+		if b == nil {
+			return nil, false, errorf(s.Name, "undefined: %s", s.Name.Value)
+		}
+		value, err := in.eval(s.Value, env)
+		if err != nil {
+			return nil, false, err
+		}
+		value, err = convert(s.Value, value, b.typ, "assignment")
+		if err != nil {
+			return nil, false, err
+		}
+		b.value = value
+		return Unit{}, false, nil
+
+	case *ast.ExpressionStatement:
+		value, err := in.eval(s.Expression, env)
+		if err != nil {
+			return nil, false, err
+		}
+		value, err = convert(s.Expression, value, nil, "")
+		return value, false, err
+
+	case *ast.ReturnStatement:
+		if s.ReturnValue == nil {
+			return Unit{}, true, nil
+		}
+		value, err := in.eval(s.ReturnValue, env)
+		return value, true, err
+
+	case *ast.BlockStatement:
+		inner := NewEnv(env)
+		for _, stmt := range s.Statements {
+			value, returned, err := in.exec(stmt, inner)
+			if err != nil || returned {
+				return value, returned, err
+			}
+		}
+		return Unit{}, false, nil
+
+	case *ast.IfStatement:
+		cond, err := in.condition(s.Condition, env, "if")
+		if err != nil {
+			return nil, false, err
+		}
+		if cond {
+			return in.exec(s.Consequence, env)
+		}
+		if s.Alternative != nil {
+			return in.exec(s.Alternative, env)
+		}
+		return Unit{}, false, nil
+
+	case *ast.WhileStatement:
+		for {
+			cond, err := in.condition(s.Condition, env, "while")
+			if err != nil || !cond {
+				return Unit{}, false, err
+			}
+			value, returned, err := in.exec(s.Body, env)
+			if err != nil || returned {
+				return value, returned, err
+			}
+		}
+
+	case *ast.FunctionStatement:
+		env.define(s.Name.Value, &Function{Decl: s})
+		return Unit{}, false, nil
+	}
+
+	return nil, false, errorf(stmt, "unexpected statement %T", stmt)
+}
+
+func (in *Interpreter) condition(e ast.Expression, env *Env, context string) (bool, error) {
+	value, err := in.eval(e, env)
+	if err != nil {
+		return false, err
+	}
+	b, ok := value.(*Boolean)
+	if !ok {
+		return false, errorf(e, "non-bool %s (type %s) used as %s condition", e, value.Type(), context)
+	}
+	return b.Value, nil
+}
+
+func (in *Interpreter) eval(e ast.Expression, env *Env) (Value, error) {
+	switch v := e.(type) {
+	case *ast.IntegerLiteral:
+		return &Integer{T: types.UntypedInt, Value: v.Value}, nil
+
+	case *ast.Boolean:
+		return &Boolean{Value: v.Value}, nil
+
+	case *ast.Identifier:
+		value, ok := env.Get(v.Value)
+		if !ok {
+			return nil, errorf(v, "undefined: %s", v.Value)
+		}
+		return value, nil
+
+	case *ast.PrefixExpression:
+		right, err := in.eval(v.Right, env)
+		if err != nil {
+			return nil, err
+		}
+		return prefix(v, right)
+
+	case *ast.InfixExpression:
+		if v.Operator == "and" || v.Operator == "or" {
+			return in.logical(v, env)
+		}
+		left, err := in.eval(v.Left, env)
+		if err != nil {
+			return nil, err
+		}
+		right, err := in.eval(v.Right, env)
+		if err != nil {
+			return nil, err
+		}
+		return infix(v, left, right)
+
+	case *ast.CallExpression:
+		return in.call(v, env)
+	}
+
+	return nil, errorf(e, "unexpected expression %T", e)
+}
+
+func prefix(e *ast.PrefixExpression, right Value) (Value, error) {
+	switch r := right.(type) {
+	case *Boolean:
+		if e.Operator == "!" {
+			return &Boolean{Value: !r.Value}, nil
+		}
+	case *Integer:
+		if e.Operator == "-" {
+			return &Integer{T: r.T, Value: r.T.Wrap(-r.Value)}, nil
+		}
+	}
+	return nil, errorf(e, "operator %s not defined on %s (type %s)", e.Operator, e.Right, right.Type())
+}
+
+// logical evaluates and/or, the right operand
+// only runs when the left one does not decide
+func (in *Interpreter) logical(e *ast.InfixExpression, env *Env) (Value, error) {
+	operand := func(side ast.Expression) (bool, error) {
+		value, err := in.eval(side, env)
+		if err != nil {
+			return false, err
+		}
+		b, ok := value.(*Boolean)
+		if !ok {
+			return false, errorf(side, "operator %s not defined on %s (type %s)", e.Operator, side, value.Type())
+		}
+		return b.Value, nil
+	}
+
+	left, err := operand(e.Left)
+	if err != nil {
+		return nil, err
+	}
+	if left == (e.Operator == "or") {
+		return &Boolean{Value: left}, nil
+	}
+	right, err := operand(e.Right)
+	if err != nil {
+		return nil, err
+	}
+	return &Boolean{Value: right}, nil
+}
+
+func infix(e *ast.InfixExpression, left, right Value) (Value, error) {
+	if l, ok := left.(*Boolean); ok {
+		if r, ok := right.(*Boolean); ok {
+			switch e.Operator {
+			case "==":
+				return &Boolean{Value: l.Value == r.Value}, nil
+			case "!=":
+				return &Boolean{Value: l.Value != r.Value}, nil
+			}
+			return nil, errorf(e, "operator %s not defined on %s (type bool)", e.Operator, e.Left)
+		}
+	}
+
+	l, lok := left.(*Integer)
+	r, rok := right.(*Integer)
+	if !lok || !rok {
+		return nil, errorf(e, "mismatched types %s and %s in %s", left.Type(), right.Type(), e)
+	}
+
+	t := l.T
+	switch {
+	case l.T == types.UntypedInt:
+		t = r.T
+	case r.T != types.UntypedInt && r.T != l.T:
+		return nil, errorf(e, "mismatched types %s and %s in %s", l.T, r.T, e)
+	}
+	return arith(e, t, t.Wrap(l.Value), t.Wrap(r.Value))
+}
+
+// arith computes the operation the way the compiled code does,
+// untyped operands are exact like the constants of the checker
+func arith(e *ast.InfixExpression, t *types.Basic, l, r int64) (Value, error) {
+	integer := func(value int64) (Value, error) {
+		return &Integer{T: t, Value: t.Wrap(value)}, nil
+	}
+	boolean := func(value bool) (Value, error) {
+		return &Boolean{Value: value}, nil
+	}
+
+	switch e.Operator {
+	case "+":
+		return integer(l + r)
+	case "-":
+		return integer(l - r)
+	case "*":
+		return integer(l * r)
+	case "/":
+		if r == 0 {
+			return nil, errorf(e.Right, "division by zero")
+		}
+		return integer(l / r)
+	case "<<", ">>":
+		// the count is taken as the 16 bit register holding it
+		count := uint16(r)
+		if t == types.UntypedInt && (r < 0 || r >= 64) {
+			return nil, errorf(e.Right, "invalid shift count %d", r)
+		}
+		if e.Operator == ">>" {
+			return integer(l >> min(count, 63))
+		}
+		if count >= 16 && t != types.UntypedInt {
+			return integer(0)
+		}
+		return integer(l << count)
+	case "<":
+		return boolean(l < r)
+	case "<=":
+		return boolean(l <= r)
+	case ">":
+		return boolean(l > r)
+	case ">=":
+		return boolean(l >= r)
+	case "==":
+		return boolean(l == r)
+	case "!=":
+		return boolean(l != r)
+	}
+	return nil, errorf(e, "unknown operator %s", e.Operator)
+}
+
+func (in *Interpreter) call(e *ast.CallExpression, env *Env) (Value, error) {
+	callee, err := in.eval(e.Function, env)
+	if err != nil {
+		return nil, err
+	}
+	fn, ok := callee.(*Function)
+	if !ok {
+		return nil, errorf(e, "cannot call non-function %s (type %s)", e.Function, callee.Type())
+	}
+	decl := fn.Decl
+
+	if len(e.Arguments) != len(decl.Parameters) {
+		return nil, errorf(e, "wrong number of arguments in call to %s, have %d want %d",
+			decl.Name.Value, len(e.Arguments), len(decl.Parameters))
+	}
+
+	// functions only see the globals and their parameters
+	frame := NewEnv(in.Globals)
+	for i, arg := range e.Arguments {
+		value, err := in.eval(arg, env)
+		if err != nil {
+			return nil, err
+		}
+		param := decl.Parameters[i]
+		t, ok := types.Lookup(param.Type.Value)
+		if !ok {
+			return nil, errorf(param.Type, "unknown type %s", param.Type.Value)
+		}
+		value, err = convert(arg, value, t, "argument to "+decl.Name.Value)
+		if err != nil {
+			return nil, err
+		}
+		frame.define(param.Name.Value, value)
+	}
+
+	if in.depth >= in.MaxDepth {
+		return nil, errorf(e, "stack overflow calling %s", decl.Name.Value)
+	}
+	in.depth++
+	value, returned, err := in.exec(decl.Body, frame)
+	in.depth--
+	if err != nil {
+		return nil, err
+	}
+
+	if decl.ReturnType == nil {
+		return Unit{}, nil
+	}
+	if !returned {
+		return nil, errorf(decl, "missing return at the end of function %s", decl.Name.Value)
+	}
+	t, ok := types.Lookup(decl.ReturnType.Value)
+	if !ok {
+		return nil, errorf(decl.ReturnType, "unknown type %s", decl.ReturnType.Value)
+	}
+	return convert(e, value, t, "return statement")
+}
+
+// convert binds a value to the target type, untyped integers
+// are wrapped to it and a nil target takes their default type
+func convert(node ast.Node, value Value, target types.Type, context string) (Value, error) {
+	i, isInt := value.(*Integer)
+	switch {
+	case target == nil:
+		if isInt && i.T == types.UntypedInt {
+			return &Integer{T: types.Default, Value: types.Default.Wrap(i.Value)}, nil
+		}
+		return value, nil
+	case isInt && i.T == types.UntypedInt && types.IsInteger(target):
+		t := target.(*types.Basic)
+		return &Integer{T: t, Value: t.Wrap(i.Value)}, nil
+	case value.Type() == target:
+		return value, nil
+	}
+	return nil, errorf(node, "cannot use %s (type %s) as %s value in %s", node, value.Type(), target, context)
+}
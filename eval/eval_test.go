@@ -0,0 +1,144 @@
+package eval
+
+import (
+	"stag/lexer"
+	"stag/pratt_parser"
+	"stag/pratt_parser/ast"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+
+	p := pratt_parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	require.Empty(t, p.Errors())
+	return program
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"3 + 4", "7"},
+		{"let x = 55090 + 5;", "55095"},
+		{"let x: u16 = 0; x - 1;", "65535"},
+		{"let x: u8 = 200; x + 100;", "44"},
+		{"let x: i16 = 32767; x + 1;", "-32768"},
+		{"let x: i16 = -7; x / 2;", "-3"},
+		{"let x: i16 = -32768; x / -1;", "-32768"},
+		{"let x: u16 = 65529; x / 2;", "32764"},
+		{"let x: i16 = -8; x >> 1;", "-4"},
+		{"let x: u16 = 65528; x >> 1;", "32764"},
+		{"let x: u8 = 1; x << 8;", "0"},
+		{"let x: u16 = 1; let n: u16 = 20; x << n;", "0"},
+		{"let x: i16 = -1; x < 0;", "true"},
+		{"let x: u16 = 1; x == 2 or x < 2;", "true"},
+		{"true and !false", "true"},
+		{"let t = true; t != false;", "true"},
+		{"-5 + 10", "5"},
+		{"let x = 1; { let x = 2; } x;", "1"},
+		{"let x = 1; { x = 2; } x;", "2"},
+		{"if true { 1; }", "()"},
+		{"", "()"},
+		{`
+			fn fact(n: u16) -> u16 {
+				if n <= 1 {
+					return 1;
+				}
+				return n * fact(n - 1);
+			}
+			fact(6);
+		`, "720"},
+		{`
+			let i: u16 = 0;
+			let sum: u16 = 0;
+			while i < 100 {
+				i = i + 1;
+				sum = sum + i;
+			}
+			sum;
+		`, "5050"},
+		{`
+			let r = twice(21);
+			fn twice(n: u8) -> u8 { return n * 2; }
+		`, "42"},
+		{`
+			fn first_odd(limit: u16) -> u16 {
+				let i: u16 = 0;
+				while i < limit {
+					if i / 2 * 2 != i {
+						return i;
+					}
+					i = i + 1;
+				}
+				return 0;
+			}
+			first_odd(10);
+		`, "1"},
+		{`
+			fn side() -> bool { return 1 / 0 == 0; }
+			false and side();
+		`, "false"},
+	}
+
+	for _, tt := range tests {
+		value, err := Eval(parse(t, tt.input))
+		require.NoError(t, err, tt.input)
+		require.Equal(t, tt.expected, value.String(), tt.input)
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x: u16 = 1;\nlet y = x / 0;", "2:13: division by zero"},
+		{"y + 1;", "1:1: undefined: y"},
+		{"z = 1;", "1:1: undefined: z"},
+		{"let x: u8 = 1; let y: u16 = 2; x + y;", "1:32: mismatched types u8 and u16 in (x + y)"},
+		{"1 + true", "1:1: mismatched types untyped int and bool in (1 + true)"},
+		{"let x = 1; x = true;", "1:16: cannot use true (type bool) as u16 value in assignment"},
+		{"if 1 { }", "1:4: non-bool 1 (type untyped int) used as if condition"},
+		{"!5", "1:1: operator ! not defined on 5 (type untyped int)"},
+		{"let x: u32 = 1;", "1:8: unknown type u32"},
+		{"fn f(a: u16) -> u16 { return a; } f(1, 2);", "1:35: wrong number of arguments in call to f, have 2 want 1"},
+		{"fn f(a: u16) -> u16 { return a; } f(true);", "1:37: cannot use true (type bool) as u16 value in argument to f"},
+		{"fn f() -> u16 { if false { return 1; } } f();", "1:1: missing return at the end of function f"},
+		{"let x = 1; x(2);", "1:12: cannot call non-function x (type u16)"},
+		{"return 1;", "1:1: return outside function"},
+		{"fn loop(n: u16) -> u16 { return loop(n); } loop(1);", "1:33: stack overflow calling loop"},
+	}
+
+	for _, tt := range tests {
+		_, err := Eval(parse(t, tt.input))
+		require.EqualError(t, err, tt.expected, tt.input)
+	}
+}
+
+func TestMaxSteps(t *testing.T) {
+	in := New()
+	in.MaxSteps = 100
+	_, err := in.Run(parse(t, "let x = 0;\nwhile true { x = x + 1; }"))
+	require.EqualError(t, err, "2:12: step limit exceeded")
+}
+
+func TestBindingsPersist(t *testing.T) {
+	in := New()
+
+	_, err := in.Run(parse(t, "let x: u8 = 250; fn inc(n: u8) -> u8 { return n + 1; }"))
+	require.NoError(t, err)
+
+	value, err := in.Run(parse(t, "inc(x) + 10"))
+	require.NoError(t, err)
+	require.Equal(t, "5", value.String())
+	require.Equal(t, uint16(5), Uint16(value))
+
+	x, ok := in.Globals.Get("x")
+	require.True(t, ok)
+	require.Equal(t, "250", x.String())
+}
@@ -0,0 +1,110 @@
+package eval
+
+import (
+	"stag/pratt_parser/ast"
+	"stag/types"
+	"strconv"
+)
+
+// Value is the result of evaluating an expression
+type Value interface {
+	Type() types.Type
+	String() string
+}
+
+// Integer holds its value already wrapped to its type, signed
+// types keep negative values. Integers coming from literals are
+// untyped until they meet a typed operand or get bound
+type Integer struct {
+	T     *types.Basic
+	Value int64
+}
+
+func (i *Integer) Type() types.Type { return i.T }
+func (i *Integer) String() string   { return strconv.FormatInt(i.Value, 10) }
+
+type Boolean struct {
+	Value bool
+}
+
+func (b *Boolean) Type() types.Type { return types.Bool }
+func (b *Boolean) String() string   { return strconv.FormatBool(b.Value) }
+
+// Unit is the value of statements and calls that produce nothing
+type Unit struct{}
+
+func (Unit) Type() types.Type { return types.Unit }
+func (Unit) String() string   { return "()" }
+
+type Function struct {
+	Decl *ast.FunctionStatement
+}
+
+func (f *Function) Type() types.Type { return nil }
+func (f *Function) String() string   { return "fn " + f.Decl.Name.Value }
+
+// Uint16 returns the bits the value takes in a 16 bit register,
+// which is how the compiled program would return it
+func Uint16(v Value) uint16 {
+	switch v := v.(type) {
+	case *Integer:
+		return uint16(v.Value)
+	case *Boolean:
+		if v.Value {
+			return 1
+		}
+	}
+	return 0
+}
+
+// Env maps the names in scope to their values, lookups
+// go through the parents when a name is not found
+type Env struct {
+	vars   map[string]*binding
+	parent *Env
+}
+
+type binding struct {
+	value Value
+	typ   types.Type // the type assignments convert to
+}
+
+func NewEnv(parent *Env) *Env {
+	return &Env{vars: map[string]*binding{}, parent: parent}
+}
+
+func (e *Env) lookup(name string) *binding {
+	for env := e; env != nil; env = env.parent {
+		if b, ok := env.vars[name]; ok {
+			return b
+		}
+	}
+	return nil
+}
+
+// Get returns the value bound to name
+func (e *Env) Get(name string) (Value, bool) {
+	if b := e.lookup(name); b != nil {
+		return b.value, true
+	}
+	return nil, false
+}
+
+// Names returns the names bound in e and its parents
+func (e *Env) Names() []string {
+	seen := map[string]bool{}
+	var names []string
+	for env := e; env != nil; env = env.parent {
+		for name := range env.vars {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+func (e *Env) define(name string, value Value) {
+	e.vars[name] = &binding{value: value, typ: value.Type()}
+}
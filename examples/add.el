@@ -1 +1,2 @@
-let x = 55090 + 5;
\ No newline at end of file
+let x = 55090 + 5;
+// expect: 55095
@@ -0,0 +1,2 @@
+let x: u16 = 10 / 0;
+// expect-error: E0301 at 1:19
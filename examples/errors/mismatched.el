@@ -0,0 +1,4 @@
+let a: u8 = 1;
+let b: u16 = 2;
+let c = a + b;
+// expect-error: E0213 at 3:9
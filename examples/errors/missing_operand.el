@@ -0,0 +1,2 @@
+let x = ;
+// expect-error: E0102 at 1:9
@@ -0,0 +1,2 @@
+let x: u8 = 300;
+// expect-error: E0217 at 1:13
@@ -0,0 +1,2 @@
+let x: u16 = y + 1;
+// expect-error: E0204 at 1:14
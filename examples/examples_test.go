@@ -0,0 +1,270 @@
+package examples
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"stag/codegen"
+	"stag/codegen/rust16vm"
+	_ "stag/codegen/rust16vm/asm"
+	_ "stag/codegen/stack"
+	"stag/frontend"
+	"stag/ir"
+	"stag/pratt_parser/ast"
+	"stag/types"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// The examples are the end to end tests of the compiler, each one
+// says what it does in comments:
+//
+//	// expect: 55095
+//	// expect-error: E0102 at 1:9
+//	// expect-asm:
+//	//	MOV A, #5
+//
+// A program that compiles runs on every target and the value it
+// returns is compared to expect, negative values are read as i16.
+// A program that does not compile lists the codes and the positions
+// of its errors. The lines after expect-asm starting with // and a
+// tab are the assembly the program compiles to for the default
+// target. go test ./examples -update rewrites the expectations with
+// what the programs do now
+
+var update = flag.Bool("update", false, "rewrite the expectations of the examples")
+
+const maxSteps = 1_000_000
+
+var (
+	expectRe      = regexp.MustCompile(`^// expect: (-?\d+)$`)
+	expectErrorRe = regexp.MustCompile(`^// expect-error: (E\d{4} at \d+:\d+)$`)
+	expectAsmRe   = regexp.MustCompile(`^// expect-asm:$`)
+	asmLineRe     = regexp.MustCompile("^//\t(.*)$")
+)
+
+// expectations are the annotations of an example, first is the
+// line where the first of them is
+type expectations struct {
+	value  *uint16
+	errors []string
+	asm    *string
+	first  int
+}
+
+// result is what the compiler and the targets make of a program,
+// value is what it returns on the default target and signed if
+// the program declares it of a signed type
+type result struct {
+	value  uint16
+	signed bool
+	values map[string]uint16
+	errors []string
+	asm    string
+}
+
+func parseExpectations(src string) (*expectations, error) {
+	e := &expectations{first: -1}
+	lines := strings.Split(src, "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		annotation := true
+
+		switch {
+		case expectRe.MatchString(line):
+			n, err := strconv.ParseInt(expectRe.FindStringSubmatch(line)[1], 10, 32)
+			if err != nil || n < -32768 || n > 65535 {
+				return nil, fmt.Errorf("%d: %q does not fit in 16 bits", i+1, line)
+			}
+			value := uint16(n)
+			e.value = &value
+		case expectErrorRe.MatchString(line):
+			e.errors = append(e.errors, expectErrorRe.FindStringSubmatch(line)[1])
+		case expectAsmRe.MatchString(line):
+			var asm strings.Builder
+			for i+1 < len(lines) && asmLineRe.MatchString(lines[i+1]) {
+				i++
+				asm.WriteString(asmLineRe.FindStringSubmatch(lines[i])[1] + "\n")
+			}
+			text := asm.String()
+			e.asm = &text
+		case strings.HasPrefix(line, "// expect"):
+			return nil, fmt.Errorf("%d: unknown annotation %q", i+1, line)
+		default:
+			annotation = false
+		}
+
+		if annotation && e.first < 0 {
+			e.first = i
+		}
+	}
+
+	if e.value == nil && e.errors == nil {
+		return nil, fmt.Errorf("no expect or expect-error annotation")
+	}
+	if e.value != nil && e.errors != nil {
+		return nil, fmt.Errorf("expect and expect-error can not go together")
+	}
+	return e, nil
+}
+
+// run compiles the program and runs it when it compiles
+func run(src string) (*result, error) {
+	r := &result{}
+
+	program, info, errs := frontend.Source(src, frontend.Options{})
+	for _, err := range errs {
+		r.errors = append(r.errors, fmt.Sprintf("%s at %d:%d", err.Code, err.Line, err.Column))
+	}
+	if len(r.errors) > 0 {
+		return r, nil
+	}
+
+	r.signed = signed(program, info)
+	code := ir.Lower(program, info)
+	if err := ir.Optimize(code, ir.Levels(rust16vm.RV16)[ir.DefaultLevel]...); err != nil {
+		return nil, err
+	}
+	r.values = map[string]uint16{}
+	for _, name := range codegen.Names() {
+		backend, err := codegen.Lookup(name)
+		if err != nil {
+			return nil, err
+		}
+		artifact, err := backend.Compile(code, codegen.Options{})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		value, err := artifact.Run(maxSteps)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		r.values[name] = value
+		if name == codegen.Default {
+			r.value, r.asm = value, artifact.Assembly()
+		}
+	}
+	return r, nil
+}
+
+// signed tells if the value the program returns, the one of the
+// last statement outside the functions, is of a signed type
+func signed(program *ast.Program, info *types.Info) bool {
+	var t types.Type
+	for _, stmt := range program.Statements {
+		switch s := stmt.(type) {
+		case *ast.FunctionStatement:
+			continue
+		case *ast.LetStatement:
+			t = info.ObjectOf(s.Name).Type
+		case *ast.ExpressionStatement:
+			t = info.TypeOf(s.Expression)
+		default:
+			t = nil
+		}
+	}
+	b, ok := t.(*types.Basic)
+	return ok && b.IsSigned()
+}
+
+// rewrite replaces the annotations of the source by the ones of
+// the result, they go where the first one was
+func rewrite(src string, e *expectations, r *result) string {
+	var annotations []string
+	if len(r.errors) > 0 {
+		for _, err := range r.errors {
+			annotations = append(annotations, "// expect-error: "+err)
+		}
+	} else {
+		value := fmt.Sprint(r.value)
+		if r.signed {
+			value = fmt.Sprint(int16(r.value))
+		}
+		annotations = append(annotations, "// expect: "+value)
+		if e.asm != nil {
+			annotations = append(annotations, "// expect-asm:")
+			for _, line := range strings.Split(strings.TrimSuffix(r.asm, "\n"), "\n") {
+				annotations = append(annotations, "//\t"+line)
+			}
+		}
+	}
+
+	var out []string
+	lines := strings.Split(src, "\n")
+	for i := 0; i < len(lines); i++ {
+		if i == e.first {
+			out = append(out, annotations...)
+		}
+		line := strings.TrimSpace(lines[i])
+		switch {
+		case expectRe.MatchString(line), expectErrorRe.MatchString(line):
+		case expectAsmRe.MatchString(line):
+			for i+1 < len(lines) && asmLineRe.MatchString(lines[i+1]) {
+				i++
+			}
+		default:
+			out = append(out, lines[i])
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+func check(t *testing.T, src string, e *expectations, r *result) {
+	t.Helper()
+
+	if len(r.errors) > 0 || e.errors != nil {
+		require.Equal(t, e.errors, r.errors, "errors")
+		return
+	}
+	for name, value := range r.values {
+		require.Equal(t, *e.value, value, "value on %s", name)
+	}
+	if e.asm != nil {
+		require.Equal(t, *e.asm, r.asm, "assembly")
+	}
+}
+
+func TestExamples(t *testing.T) {
+	var files []string
+	err := filepath.WalkDir(".", func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && filepath.Ext(path) == ".el" {
+			files = append(files, path)
+		}
+		return err
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+
+	for _, file := range files {
+		t.Run(filepath.ToSlash(file), func(t *testing.T) {
+			data, err := os.ReadFile(file)
+			require.NoError(t, err)
+			src := string(data)
+
+			e, err := parseExpectations(src)
+			require.NoError(t, err)
+			r, err := run(src)
+			require.NoError(t, err)
+
+			if *update {
+				// the positions of the errors move with the
+				// annotations above them, rewrite until they stay
+				for i := 0; i < 3; i++ {
+					src = rewrite(src, e, r)
+					e, err = parseExpectations(src)
+					require.NoError(t, err)
+					r, err = run(src)
+					require.NoError(t, err)
+				}
+				require.NoError(t, os.WriteFile(file, []byte(src), 0o644))
+			}
+			check(t, src, e, r)
+		})
+	}
+}
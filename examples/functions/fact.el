@@ -0,0 +1,9 @@
+fn fact(n: u16) -> u16 {
+	if n <= 1 {
+		return 1;
+	}
+	return n * fact(n - 1);
+}
+
+let x: u16 = fact(6);
+// expect: 720
@@ -0,0 +1,10 @@
+let count: u16 = 0;
+
+fn bump(n: u16) -> u16 {
+	count = count + n;
+	return count;
+}
+
+bump(2);
+let x: u16 = count + bump(3);
+// expect: 7
@@ -0,0 +1,40 @@
+fn max(a: u16, b: u16) -> u16 {
+	if a > b {
+		return a;
+	} else {
+		return b;
+	}
+}
+
+let x: u16 = max(3, 7);
+// expect: 7
+// expect-asm:
+//	CALL main
+//	HALT
+//	max:
+//	PUSH BP
+//	MOV BP, SP
+//	LDR A, [BP, #2]
+//	LDR B, [BP, #3]
+//	LTR C, B, A
+//	JZ C, max.if.else.2
+//	max.if.then.1:
+//	MOV SP, BP
+//	POP BP
+//	RET
+//	max.if.else.2:
+//	MOV A, B
+//	MOV SP, BP
+//	POP BP
+//	RET
+//	main:
+//	PUSH BP
+//	MOV BP, SP
+//	MOV A, #3
+//	MOV B, #7
+//	PUSH B
+//	PUSH A
+//	CALL max
+//	MOV SP, BP
+//	POP BP
+//	RET
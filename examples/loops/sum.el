@@ -0,0 +1,11 @@
+fn sum(n: u16) -> u16 {
+	let total: u16 = 0;
+	while n > 0 {
+		total = total + n;
+		n = n - 1;
+	}
+	return total;
+}
+
+let x: u16 = sum(100);
+// expect: 5050
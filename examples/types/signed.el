@@ -0,0 +1,6 @@
+fn half(n: i16) -> i16 {
+	return n / 2;
+}
+
+let x: i16 = half(-7);
+// expect: -3
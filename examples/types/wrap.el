@@ -0,0 +1,6 @@
+fn inc(n: u8) -> u8 {
+	return n + 1;
+}
+
+let x: u8 = inc(255);
+// expect: 0
@@ -0,0 +1,326 @@
+package fold
+
+import (
+	"fmt"
+	"math/bits"
+	"stag/pratt_parser/ast"
+	"stag/primitives"
+	"stag/types"
+	"strconv"
+)
+
+type folder struct {
+	info   *types.Info
+	errors []*types.Error
+}
+
+// Program folds the constant subtrees of a program checked by
+// types.Check in place. Constants are evaluated with the wrap
+// around of their type, algebraic identities are simplified and
+// multiplications and unsigned divisions by powers of two become
+// shifts. The nodes it creates are recorded in info. Dividing by
+// a constant zero is reported as an error
+func Program(program *ast.Program, info *types.Info) []*types.Error {
+	f := &folder{info: info}
+	for _, stmt := range program.Statements {
+		f.stmt(stmt)
+	}
+	return f.errors
+}
+
+func (f *folder) errorf(node ast.Node, code, format string, args ...any) {
+	line, column := ast.Pos(node)
+	f.errors = append(f.errors, &types.Error{Line: line, Column: column, Code: code, Msg: fmt.Sprintf(format, args...)})
+}
+
+func (f *folder) stmt(stmt ast.Statement) {
+	switch s := stmt.(type) {
+	case *ast.LetStatement:
+		s.Value = f.expr(s.Value)
+	case *ast.AssignStatement:
+		s.Value = f.expr(s.Value)
+	case *ast.ReturnStatement:
+		if s.ReturnValue != nil {
+			s.ReturnValue = f.expr(s.ReturnValue)
+		}
+	case *ast.ExpressionStatement:
+		s.Expression = f.expr(s.Expression)
+	case *ast.BlockStatement:
+		for _, inner := range s.Statements {
+			f.stmt(inner)
+		}
+	case *ast.IfStatement:
+		s.Condition = f.expr(s.Condition)
+		f.stmt(s.Consequence)
+		if s.Alternative != nil {
+			f.stmt(s.Alternative)
+		}
+	case *ast.WhileStatement:
+		s.Condition = f.expr(s.Condition)
+		f.stmt(s.Body)
+	case *ast.FunctionStatement:
+		f.stmt(s.Body)
+	}
+}
+
+func (f *folder) expr(e ast.Expression) ast.Expression {
+	switch v := e.(type) {
+	case *ast.PrefixExpression:
+		v.Right = f.expr(v.Right)
+		switch v.Operator {
+		case "-":
+			if value, ok := f.constant(v.Right); ok {
+				return f.integer(v, f.basic(v).Wrap(-value))
+			}
+		case "!":
+			if b, ok := v.Right.(*ast.Boolean); ok {
+				return f.boolean(v, !b.Value)
+			}
+		}
+		return v
+
+	case *ast.InfixExpression:
+		v.Left = f.expr(v.Left)
+		v.Right = f.expr(v.Right)
+		return f.infix(v)
+
+	case *ast.CallExpression:
+		for i, arg := range v.Arguments {
+			v.Arguments[i] = f.expr(arg)
+		}
+	}
+	return e
+}
+
+func (f *folder) infix(e *ast.InfixExpression) ast.Expression {
+	if e.Operator == "and" || e.Operator == "or" {
+		return f.logical(e)
+	}
+
+	t := f.basic(e.Left)
+	l, lconst := f.constant(e.Left)
+	r, rconst := f.constant(e.Right)
+
+	if rconst && r == 0 && e.Operator == "/" {
+		f.errorf(e.Right, types.CodeDivisionByZero, "division by zero")
+		return e
+	}
+
+	if lconst && rconst {
+		return f.evaluate(e, t, l, r)
+	}
+
+	if lb, ok := e.Left.(*ast.Boolean); ok {
+		if rb, ok := e.Right.(*ast.Boolean); ok {
+			switch e.Operator {
+			case "==":
+				return f.boolean(e, lb.Value == rb.Value)
+			case "!=":
+				return f.boolean(e, lb.Value != rb.Value)
+			}
+		}
+	}
+
+	return f.simplify(e, t, l, lconst, r, rconst)
+}
+
+// evaluate computes an operation on two constants the
+// same way the target does, wrapping at the width of t
+func (f *folder) evaluate(e *ast.InfixExpression, t *types.Basic, l, r int64) ast.Expression {
+	switch e.Operator {
+	case "+":
+		return f.integer(e, t.Wrap(l+r))
+	case "-":
+		return f.integer(e, t.Wrap(l-r))
+	case "*":
+		return f.integer(e, t.Wrap(l*r))
+	case "/":
+		return f.integer(e, t.Wrap(l/r))
+	case "<<":
+		// the count is taken as the 16 bit register holding
+		// it, negative counts are big ones
+		count := int64(uint16(r))
+		if count >= int64(t.Bits()) {
+			return f.integer(e, 0)
+		}
+		return f.integer(e, t.Wrap(l<<count))
+	case ">>":
+		return f.integer(e, t.Wrap(l>>min(int64(uint16(r)), 63)))
+	case "<":
+		return f.boolean(e, l < r)
+	case "<=":
+		return f.boolean(e, l <= r)
+	case ">":
+		return f.boolean(e, l > r)
+	case ">=":
+		return f.boolean(e, l >= r)
+	case "==":
+		return f.boolean(e, l == r)
+	case "!=":
+		return f.boolean(e, l != r)
+	}
+	return e
+}
+
+// simplify applies the algebraic identities, operands with side
+// effects are never dropped
+func (f *folder) simplify(e *ast.InfixExpression, t *types.Basic, l int64, lconst bool, r int64, rconst bool) ast.Expression {
+	switch e.Operator {
+	case "+":
+		if rconst && r == 0 {
+			return e.Left
+		}
+		if lconst && l == 0 {
+			return e.Right
+		}
+
+	case "-":
+		if rconst && r == 0 {
+			return e.Left
+		}
+		if sameVariable(f.info, e.Left, e.Right) {
+			return f.integer(e, 0)
+		}
+
+	case "*":
+		switch {
+		case rconst && r == 1:
+			return e.Left
+		case lconst && l == 1:
+			return e.Right
+		case rconst && r == 0 && pure(e.Left), lconst && l == 0 && pure(e.Right):
+			return f.integer(e, 0)
+		case rconst && isPowerOfTwo(t, r):
+			return f.shift(e, "<<", e.Left, r)
+		case lconst && isPowerOfTwo(t, l):
+			return f.shift(e, "<<", e.Right, l)
+		}
+
+	case "/":
+		switch {
+		case rconst && r == 1:
+			return e.Left
+		case rconst && !t.IsSigned() && isPowerOfTwo(t, r):
+			// signed division rounds towards zero while an
+			// arithmetic shift rounds down, only unsigned is safe
+			return f.shift(e, ">>", e.Left, r)
+		}
+
+	case "<<", ">>":
+		if rconst && r == 0 {
+			return e.Left
+		}
+	}
+
+	return e
+}
+
+func (f *folder) logical(e *ast.InfixExpression) ast.Expression {
+	lb, lconst := e.Left.(*ast.Boolean)
+	rb, rconst := e.Right.(*ast.Boolean)
+
+	// the neutral element keeps the other operand, the absorbing
+	// one decides the result, dropping the right operand is fine
+	// since it is not evaluated anyway
+	neutral := e.Operator == "and"
+
+	switch {
+	case lconst && lb.Value == neutral:
+		return e.Right
+	case lconst:
+		return f.boolean(e, lb.Value)
+	case rconst && rb.Value == neutral:
+		return e.Left
+	case rconst && pure(e.Left):
+		return f.boolean(e, rb.Value)
+	}
+	return e
+}
+
+func (f *folder) constant(e ast.Expression) (int64, bool) {
+	lit, ok := e.(*ast.IntegerLiteral)
+	if !ok {
+		return 0, false
+	}
+	return f.basic(lit).Wrap(lit.Value), true
+}
+
+func (f *folder) basic(e ast.Expression) *types.Basic {
+	if b, ok := f.info.TypeOf(e).(*types.Basic); ok && b.IsInteger() && b != types.UntypedInt {
+		return b
+	}
+	return types.Default
+}
+
+func (f *folder) integer(replaced ast.Expression, value int64) ast.Expression {
+	line, column := ast.Pos(replaced)
+	lit := &ast.IntegerLiteral{
+		Token: primitives.Token{
+			Kind:         primitives.Number,
+			Literal:      strconv.FormatInt(value, 10),
+			SourceLine:   line,
+			SourceColumn: column,
+		},
+		Value: value,
+	}
+	f.info.Types[lit] = f.info.TypeOf(replaced)
+	return lit
+}
+
+func (f *folder) boolean(replaced ast.Expression, value bool) ast.Expression {
+	line, column := ast.Pos(replaced)
+	b := &ast.Boolean{
+		Token: primitives.Token{
+			Kind:         primitives.Keyword,
+			Literal:      strconv.FormatBool(value),
+			SourceLine:   line,
+			SourceColumn: column,
+		},
+		Value: value,
+	}
+	f.info.Types[b] = types.Bool
+	return b
+}
+
+// shift replaces e by operand shifted by log2(power)
+func (f *folder) shift(e *ast.InfixExpression, op string, operand ast.Expression, power int64) ast.Expression {
+	kind := primitives.ShiftLeft
+	if op == ">>" {
+		kind = primitives.ShiftRight
+	}
+
+	shifted := &ast.InfixExpression{
+		Token:    primitives.Token{Kind: kind, Literal: op, SourceLine: e.Token.SourceLine, SourceColumn: e.Token.SourceColumn},
+		Left:     operand,
+		Operator: op,
+	}
+	shifted.Right = f.integer(e, int64(bits.TrailingZeros64(uint64(power))))
+	f.info.Types[shifted] = f.info.TypeOf(e)
+	return shifted
+}
+
+func isPowerOfTwo(t *types.Basic, value int64) bool {
+	return value > 1 && value&(value-1) == 0 && value < 1<<(t.Bits()-1)
+}
+
+// pure reports if evaluating e has no side effects
+func pure(e ast.Expression) bool {
+	switch v := e.(type) {
+	case *ast.CallExpression:
+		return false
+	case *ast.PrefixExpression:
+		return pure(v.Right)
+	case *ast.InfixExpression:
+		return pure(v.Left) && pure(v.Right)
+	}
+	return true
+}
+
+func sameVariable(info *types.Info, a, b ast.Expression) bool {
+	ia, ok := a.(*ast.Identifier)
+	if !ok {
+		return false
+	}
+	ib, ok := b.(*ast.Identifier)
+	return ok && info.Uses[ia] != nil && info.Uses[ia] == info.Uses[ib]
+}
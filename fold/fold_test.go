@@ -0,0 +1,100 @@
+package fold
+
+import (
+	"os"
+	"stag/ir"
+	"stag/lexer"
+	"stag/pratt_parser"
+	"stag/pratt_parser/ast"
+	"stag/types"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func check(t *testing.T, input string) (*ast.Program, *types.Info) {
+	t.Helper()
+
+	p := pratt_parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	require.Empty(t, p.Errors())
+
+	info, errs := types.Check(program)
+	require.Empty(t, errs)
+	return program, info
+}
+
+func TestFold(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x = 55090 + 5;", "let x = 55095;"},
+		{"let x: u8 = 200 * 2 - 300;", "let x: u8 = 100;"},
+		{"let x: u16 = 65535; let y = x + 1 * 1;", "let x: u16 = 65535;let y = (x + 1);"},
+		{"let x: u8 = 300 - 100;", "let x: u8 = 200;"},
+		{"let x: i16 = -5 * 3;", "let x: i16 = -15;"},
+		{"let x: i16 = -7 / 2;", "let x: i16 = -3;"},
+		{"let b = 3 > 2 and 1 == 1;", "let b = true;"},
+		{"let a = 1; let b = !(a < 2 and false);", "let a = 1;let b = true;"},
+		{"let a = 1; a * 1 + 0;", "let a = 1;a"},
+		{"let a = 1; 0 + a - 0;", "let a = 1;a"},
+		{"let a = 1; a * 0;", "let a = 1;0"},
+		{"let a = 1; a - a;", "let a = 1;0"},
+		{"let a = 1; a * 8;", "let a = 1;(a << 3)"},
+		{"let a = 1; 16 * a;", "let a = 1;(a << 4)"},
+		{"let a = 1; a / 4;", "let a = 1;(a >> 2)"},
+		{"let a: i16 = 1; a / 4;", "let a: i16 = 1;(a / 4)"},
+		{"let a: i16 = 1; a * 4;", "let a: i16 = 1;(a << 2)"},
+		{"let a: i16 = 1; (a - a + 4) << 15;", "let a: i16 = 1;0"},
+		{"let a = 1; a * 6;", "let a = 1;(a * 6)"},
+		{"let a = true; a and true;", "let a = true;a"},
+		{"let a = true; false or a;", "let a = true;a"},
+		{"let a = true; true or a;", "let a = true;true"},
+		{"fn f() -> u16 { return 1; } f() * 0;", "fn f() -> u16 { return 1; }(f() * 0)"},
+		{"fn f() -> bool { return true; } f() and false;", "fn f() -> bool { return true; }(f() and false)"},
+		{"let x = 1; if x > 2 * 3 { x = 4 - 4; }", "let x = 1;if (x > 6) { x = 0; }"},
+	}
+
+	for _, tt := range tests {
+		program, info := check(t, tt.input)
+		require.Empty(t, Program(program, info))
+		require.Equal(t, tt.expected, program.String(), tt.input)
+	}
+}
+
+func TestFoldRecordsTypes(t *testing.T) {
+	program, info := check(t, "let a: u8 = 2; let b = a * 4; let c: i16 = 3 - 5;")
+	require.Empty(t, Program(program, info))
+
+	b := program.Statements[1].(*ast.LetStatement).Value.(*ast.InfixExpression)
+	require.Equal(t, types.U8, info.TypeOf(b))
+	require.Equal(t, types.U8, info.TypeOf(b.Right))
+
+	c := program.Statements[2].(*ast.LetStatement).Value
+	require.Equal(t, types.I16, info.TypeOf(c))
+	require.Equal(t, int64(-2), c.(*ast.IntegerLiteral).Value)
+}
+
+func TestDivisionByZero(t *testing.T) {
+	program, info := check(t, "let a = 5;\nlet b = a / (3 - 3);")
+
+	errs := Program(program, info)
+	require.Len(t, errs, 1)
+	require.Equal(t, "2:14: division by zero", errs[0].Error())
+}
+
+func TestFoldAddExample(t *testing.T) {
+	input, err := os.ReadFile("../examples/add.el")
+	require.NoError(t, err)
+
+	program, info := check(t, string(input))
+	require.Empty(t, Program(program, info))
+
+	require.Equal(t, `func main() {
+entry:
+	%0 = const 55095
+	ret %0
+}
+`, ir.Lower(program, info).String())
+}
@@ -0,0 +1,301 @@
+package format
+
+import (
+	"errors"
+	"stag/lexer"
+	"stag/pratt_parser"
+	"stag/pratt_parser/ast"
+	"stag/primitives"
+	"strings"
+)
+
+// Source formats a program, the source must parse without errors
+func Source(src string) (string, error) {
+	l := lexer.New(src)
+	p := pratt_parser.New(l)
+	program := p.ParseProgram()
+	if list := p.ErrorList(); len(list) > 0 {
+		errs := make([]error, 0, len(list))
+		for _, err := range list {
+			errs = append(errs, err)
+		}
+		return "", errors.Join(errs...)
+	}
+	return Program(program, l.Comments()), nil
+}
+
+// Program prints the program in the canonical layout, a statement
+// per line indented by tabs with the braces on the same line, and
+// only the parentheses the precedence of the operators needs. The
+// comments go back between the statements by their position, the
+// blank lines between statements are kept but never more than one
+func Program(program *ast.Program, comments []primitives.Token) string {
+	p := &printer{comments: comments}
+	p.stmts(program.Statements, nil)
+	return p.out.String()
+}
+
+// Expression prints the expression with minimal parentheses
+func Expression(e ast.Expression) string {
+	switch e := e.(type) {
+	case *ast.Identifier:
+		return e.Value
+	case *ast.IntegerLiteral:
+		return e.Token.Literal
+	case *ast.Boolean:
+		return e.Token.Literal
+	case *ast.PrefixExpression:
+		// a space keeps -(-x) from printing as --x
+		right := operand(e.Right, pratt_parser.PREFIX, false)
+		if e.Operator == "-" && strings.HasPrefix(right, "-") {
+			return e.Operator + " " + right
+		}
+		return e.Operator + right
+	case *ast.InfixExpression:
+		prec := pratt_parser.Precedence(e.Token)
+		return operand(e.Left, prec, false) + " " + e.Operator + " " + operand(e.Right, prec, true)
+	case *ast.CallExpression:
+		args := make([]string, 0, len(e.Arguments))
+		for _, arg := range e.Arguments {
+			args = append(args, Expression(arg))
+		}
+		return operand(e.Function, pratt_parser.CALL, false) + "(" + strings.Join(args, ", ") + ")"
+	}
+	return e.String()
+}
+
+// precedence is how tight the expression binds, the operands
+// of the operators are higher than any of them
+func precedence(e ast.Expression) int {
+	switch e := e.(type) {
+	case *ast.InfixExpression:
+		return pratt_parser.Precedence(e.Token)
+	case *ast.PrefixExpression:
+		return pratt_parser.PREFIX
+	case *ast.CallExpression:
+		return pratt_parser.CALL
+	}
+	return pratt_parser.CALL + 1
+}
+
+// operand wraps e in parentheses when the parser would otherwise
+// bind it differently, the operators are left associative so an
+// operand on the right with the same precedence needs them too
+func operand(e ast.Expression, parent int, right bool) string {
+	s := Expression(e)
+	if prec := precedence(e); prec < parent || right && prec == parent {
+		return "(" + s + ")"
+	}
+	return s
+}
+
+type printer struct {
+	out    strings.Builder
+	indent int
+
+	comments []primitives.Token
+	next     int // the first comment not printed yet
+
+	// last is the source line of the last thing printed, zero
+	// at the start of a block where no blank line goes
+	last int
+}
+
+func (p *printer) write(s string) {
+	p.out.WriteString(s)
+}
+
+func (p *printer) tabs() {
+	p.write(strings.Repeat("\t", p.indent))
+}
+
+// blank keeps a blank line before something starting at line
+// when the source had at least one
+func (p *printer) blank(line int) {
+	if p.last > 0 && line > p.last+1 {
+		p.write("\n")
+	}
+}
+
+func before(tok primitives.Token, line, column int) bool {
+	return tok.SourceLine < line || tok.SourceLine == line && tok.SourceColumn < column
+}
+
+// commentsBefore prints on their own lines the comments found
+// before end, a nil end prints all that are left
+func (p *printer) commentsBefore(end *primitives.Token) {
+	for ; p.commentBefore(end); p.next++ {
+		c := p.comments[p.next]
+		p.blank(c.SourceLine)
+		p.tabs()
+		p.write(comment(c) + "\n")
+		p.last = c.SourceLine
+	}
+}
+
+// trailing prints the comment that follows code on the same line,
+// unless more code starts before it on the line
+func (p *printer) trailing(line int, limit *primitives.Token) {
+	if p.next < len(p.comments) && p.comments[p.next].SourceLine == line && p.commentBefore(limit) {
+		p.write(" " + comment(p.comments[p.next]))
+		p.next++
+	}
+}
+
+func comment(c primitives.Token) string {
+	return strings.TrimRight(c.Literal, " \t\r")
+}
+
+// stmts prints a statement per line, end is the token closing the
+// list so the comments before it stay inside
+func (p *printer) stmts(stmts []ast.Statement, end *primitives.Token) {
+	for i, stmt := range stmts {
+		p.commentsBefore(start(stmt))
+		p.blank(start(stmt).SourceLine)
+
+		p.tabs()
+		p.stmt(stmt)
+		p.last = lastLine(stmt)
+		next := end
+		if i+1 < len(stmts) {
+			next = start(stmts[i+1])
+		}
+		p.trailing(p.last, next)
+		p.write("\n")
+	}
+	p.commentsBefore(end)
+}
+
+func (p *printer) stmt(stmt ast.Statement) {
+	switch s := stmt.(type) {
+	case *ast.LetStatement:
+		p.write("let " + s.Name.Value)
+		if s.Type != nil {
+			p.write(": " + s.Type.Value)
+		}
+		p.write(" = " + Expression(s.Value) + ";")
+	case *ast.AssignStatement:
+		p.write(s.Name.Value + " = " + Expression(s.Value) + ";")
+	case *ast.ReturnStatement:
+		if s.ReturnValue == nil {
+			p.write("return;")
+			return
+		}
+		p.write("return " + Expression(s.ReturnValue) + ";")
+	case *ast.ExpressionStatement:
+		p.write(Expression(s.Expression) + ";")
+	case *ast.BlockStatement:
+		p.block(s)
+	case *ast.IfStatement:
+		p.write("if " + Expression(s.Condition) + " ")
+		p.block(s.Consequence)
+		if s.Alternative == nil {
+			return
+		}
+		p.write(" else ")
+		// else if is kept as written, the parser made it a block
+		if elseIf(s.Alternative) {
+			p.stmt(s.Alternative.Statements[0])
+			return
+		}
+		p.block(s.Alternative)
+	case *ast.WhileStatement:
+		p.write("while " + Expression(s.Condition) + " ")
+		p.block(s.Body)
+	case *ast.FunctionStatement:
+		params := make([]string, 0, len(s.Parameters))
+		for _, param := range s.Parameters {
+			params = append(params, param.String())
+		}
+		p.write("fn " + s.Name.Value + "(" + strings.Join(params, ", ") + ")")
+		if s.ReturnType != nil {
+			p.write(" -> " + s.ReturnType.Value)
+		}
+		p.write(" ")
+		p.block(s.Body)
+	default:
+		p.write(stmt.String())
+	}
+}
+
+func elseIf(block *ast.BlockStatement) bool {
+	return block.Token.Kind == primitives.Keyword && block.Token.Literal == lexer.If
+}
+
+func (p *printer) block(b *ast.BlockStatement) {
+	if len(b.Statements) == 0 && !p.commentBefore(&b.Rbrace) {
+		p.write("{}")
+		p.last = b.Rbrace.SourceLine
+		return
+	}
+
+	p.write("{")
+	next := &b.Rbrace
+	if len(b.Statements) > 0 {
+		next = start(b.Statements[0])
+	}
+	p.trailing(b.Token.SourceLine, next)
+	p.write("\n")
+
+	p.indent++
+	p.last = 0
+	p.stmts(b.Statements, &b.Rbrace)
+	p.indent--
+
+	p.tabs()
+	p.write("}")
+	p.last = b.Rbrace.SourceLine
+}
+
+// commentBefore reports if the next comment comes before end,
+// nil is the end of the source
+func (p *printer) commentBefore(end *primitives.Token) bool {
+	return p.next < len(p.comments) && (end == nil || before(p.comments[p.next], end.SourceLine, end.SourceColumn))
+}
+
+// start is a token at the position where the statement starts
+func start(stmt ast.Statement) *primitives.Token {
+	line, column := ast.Pos(stmt)
+	return &primitives.Token{SourceLine: line, SourceColumn: column}
+}
+
+// lastLine returns the source line where the statement ends, the
+// closing paren of a call is not kept so its last argument counts
+func lastLine(node ast.Node) int {
+	switch n := node.(type) {
+	case *ast.LetStatement:
+		return lastLine(n.Value)
+	case *ast.AssignStatement:
+		return lastLine(n.Value)
+	case *ast.ReturnStatement:
+		if n.ReturnValue != nil {
+			return lastLine(n.ReturnValue)
+		}
+		return n.Token.SourceLine
+	case *ast.ExpressionStatement:
+		return lastLine(n.Expression)
+	case *ast.BlockStatement:
+		return n.Rbrace.SourceLine
+	case *ast.IfStatement:
+		if n.Alternative != nil {
+			return lastLine(n.Alternative)
+		}
+		return lastLine(n.Consequence)
+	case *ast.WhileStatement:
+		return lastLine(n.Body)
+	case *ast.FunctionStatement:
+		return lastLine(n.Body)
+	case *ast.PrefixExpression:
+		return lastLine(n.Right)
+	case *ast.InfixExpression:
+		return lastLine(n.Right)
+	case *ast.CallExpression:
+		line := n.Token.SourceLine
+		if len(n.Arguments) > 0 {
+			line = max(line, lastLine(n.Arguments[len(n.Arguments)-1]))
+		}
+		return line
+	}
+	line, _ := ast.Pos(node)
+	return line
+}
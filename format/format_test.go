@@ -0,0 +1,204 @@
+package format
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"stag/lexer"
+	"stag/pratt_parser"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x=1+2*3;", "let x = 1 + 2 * 3;\n"},
+		{"let x = (1 + 2) * 3;", "let x = (1 + 2) * 3;\n"},
+		{"let x = ((1 + 2)) + (3);", "let x = 1 + 2 + 3;\n"},
+		{"let x = 1 - (2 - 3);", "let x = 1 - (2 - 3);\n"},
+		{"let x = (1 - 2) - 3;", "let x = 1 - 2 - 3;\n"},
+		{"let x = -(a + b) * -f(1, (2))", "let x = -(a + b) * -f(1, 2);\n"},
+		{"let x = - -a;", "let x = - -a;\n"},
+		{"let x = -(-a);", "let x = - -a;\n"},
+		{"let x = !!a;", "let x = !!a;\n"},
+		{"let y: u16 = a << (1 + b) >> c;", "let y: u16 = a << 1 + b >> c;\n"},
+		{"let y = (a << 1) + b;", "let y = (a << 1) + b;\n"},
+		{"a < b == (c < d)", "a < b == c < d;\n"},
+		{"a == (b == c)", "a == (b == c);\n"},
+		{"(a or b) and !(c and d) or e", "(a or b) and !(c and d) or e;\n"},
+		{"x   =   y;", "x = y;\n"},
+		{"return", "return;\n"},
+		{"fn   f ( a :u16,b: u16 )->u16{return a+b;}", "fn f(a: u16, b: u16) -> u16 {\n\treturn a + b;\n}\n"},
+		{"fn g() {}", "fn g() {}\n"},
+		{"{ { x = 1; } }", "{\n\t{\n\t\tx = 1;\n\t}\n}\n"},
+		{"while i < 10 { i = i + 1; }", "while i < 10 {\n\ti = i + 1;\n}\n"},
+		{
+			"if a { x = 1; } else if b { x = 2; } else { x = 3; }",
+			"if a {\n\tx = 1;\n} else if b {\n\tx = 2;\n} else {\n\tx = 3;\n}\n",
+		},
+		{"if a {} else { if b {} }", "if a {} else {\n\tif b {}\n}\n"},
+		{
+			"let a = 1;\n\n\n\nlet b = 2;\nlet c = 3;",
+			"let a = 1;\n\nlet b = 2;\nlet c = 3;\n",
+		},
+		{
+			"fn f() {\n\n  let a = 1;\n\n  let b = 2;\n\n}",
+			"fn f() {\n\tlet a = 1;\n\n\tlet b = 2;\n}\n",
+		},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		out, err := Source(tt.input)
+		require.NoError(t, err, tt.input)
+		require.Equal(t, tt.expected, out, tt.input)
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// sum adds up to n
+fn sum(n: u16) -> u16 { // the loop
+	let total: u16 = 0;   // running total
+	while n > 0 {
+		total = total + n; n = n - 1; // both
+		// counting down
+	}
+
+	// done
+	return total;
+}
+let x = sum(10); // 55
+// trailing
+`
+
+	expected := `// sum adds up to n
+fn sum(n: u16) -> u16 { // the loop
+	let total: u16 = 0; // running total
+	while n > 0 {
+		total = total + n;
+		n = n - 1; // both
+		// counting down
+	}
+
+	// done
+	return total;
+}
+let x = sum(10); // 55
+// trailing
+`
+
+	out, err := Source(input)
+	require.NoError(t, err)
+	require.Equal(t, expected, out)
+
+	out, err = Source("if x {\n} // empty\n{ // open\n}\n")
+	require.NoError(t, err)
+	require.Equal(t, "if x {} // empty\n{ // open\n}\n", out)
+}
+
+func TestErrors(t *testing.T) {
+	_, err := Source("let = 1;")
+	require.EqualError(t, err, "1:5: expected next token to be Ident, got Equals instead\n"+
+		"1:5: no prefix parse function for Equals found")
+
+	_, err = Source("let x =")
+	require.EqualError(t, err, "1:8: no prefix parse function for EOF found")
+}
+
+// sameAST fails when formatting changed the meaning of the source,
+// the string of the tree has every subexpression in parentheses
+func sameAST(t *testing.T, input, formatted string) {
+	t.Helper()
+
+	original := pratt_parser.New(lexer.New(input))
+	reparsed := pratt_parser.New(lexer.New(formatted))
+	a, b := original.ParseProgram(), reparsed.ParseProgram()
+	require.Empty(t, reparsed.Errors(), formatted)
+	require.Equal(t, a.String(), b.String(), "%s\nformatted as\n%s", input, formatted)
+}
+
+func idempotent(t *testing.T, formatted string) {
+	t.Helper()
+
+	again, err := Source(formatted)
+	require.NoError(t, err, formatted)
+	require.Equal(t, formatted, again)
+}
+
+var (
+	infixOps  = []string{"+", "-", "*", "/", "<<", ">>", "<", ">", "<=", ">=", "==", "!=", "and", "or"}
+	prefixOps = []string{"-", "!"}
+)
+
+// randomExpr writes a random expression with random parentheses
+func randomExpr(r *rand.Rand, depth int) string {
+	if depth == 0 || r.Intn(4) == 0 {
+		switch r.Intn(3) {
+		case 0:
+			return "x"
+		case 1:
+			return "7"
+		default:
+			return "true"
+		}
+	}
+
+	var e string
+	switch r.Intn(4) {
+	case 0:
+		e = prefixOps[r.Intn(len(prefixOps))] + randomExpr(r, depth-1)
+	case 1:
+		e = "f(" + randomExpr(r, depth-1) + ", " + randomExpr(r, depth-1) + ")"
+	default:
+		e = randomExpr(r, depth-1) + " " + infixOps[r.Intn(len(infixOps))] + " " + randomExpr(r, depth-1)
+	}
+	if r.Intn(2) == 0 {
+		e = "(" + e + ")"
+	}
+	return e
+}
+
+func TestSameAST(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		input := "let v = " + randomExpr(r, 5) + ";"
+		out, err := Source(input)
+		require.NoError(t, err, input)
+		sameAST(t, input, out)
+		idempotent(t, out)
+	}
+
+	examples, err := filepath.Glob("../examples/*.el")
+	require.NoError(t, err)
+	require.NotEmpty(t, examples)
+	for _, file := range examples {
+		src, err := os.ReadFile(file)
+		require.NoError(t, err)
+
+		out, err := Source(string(src))
+		require.NoError(t, err, file)
+		sameAST(t, string(src), out)
+		idempotent(t, out)
+	}
+}
+
+func TestIdempotent(t *testing.T) {
+	inputs := []string{
+		"fn f(a: u16) -> u16 {\n  if a > 1 { return a * f(a - 1); } // recurse\n  return 1;\n}\n\n\nf(5)",
+		"let a = 1; // one\n\n// two\n\n\nlet b = a;",
+		"{\n// only a comment\n}",
+		"if a { } else if b { // b\n} else { x = 1; }",
+	}
+	for _, input := range inputs {
+		out, err := Source(input)
+		require.NoError(t, err, input)
+		require.False(t, strings.Contains(out, "\n\n\n"), out)
+		sameAST(t, input, out)
+		idempotent(t, out)
+	}
+}
@@ -0,0 +1,68 @@
+package frontend
+
+import (
+	"fmt"
+	"stag/fold"
+	"stag/lexer"
+	"stag/pratt_parser"
+	"stag/pratt_parser/ast"
+	"stag/types"
+)
+
+// Error is a problem the parser or the checker found, the codes
+// of the parser are the E01 block and the others come after
+type Error struct {
+	Line   int
+	Column int
+	Code   string
+	Msg    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Msg)
+}
+
+// Options of the front end, the zero value runs all of it
+type Options struct {
+	// Lexer are the options the source is read with
+	Lexer []lexer.Option
+
+	// Unfolded leaves the constant expressions as they were
+	// written, the program is only type checked
+	Unfolded bool
+}
+
+// Source parses, type checks and folds the source, the program
+// and the info are what ir.Lower takes when there are no errors.
+// The checker only runs when the source parses, info is nil when
+// it does not
+func Source(src string, opts Options) (*ast.Program, *types.Info, []*Error) {
+	p := pratt_parser.New(lexer.New(src, opts.Lexer...))
+	program := p.ParseProgram()
+	if list := p.ErrorList(); len(list) > 0 {
+		errs := make([]*Error, 0, len(list))
+		for _, err := range list {
+			errs = append(errs, &Error{Line: err.Line, Column: err.Column, Code: err.Code, Msg: err.Msg})
+		}
+		return program, nil, errs
+	}
+
+	info, errs := Program(program, opts)
+	return program, info, errs
+}
+
+// Program type checks and folds a program that parsed, the repl
+// puts it together out of several inputs. The folding only runs
+// when the program type checks
+func Program(program *ast.Program, opts Options) (*types.Info, []*Error) {
+	info, list := types.Check(program)
+	if len(list) == 0 && !opts.Unfolded {
+		list = fold.Program(program, info)
+	}
+
+	var errs []*Error
+	for _, err := range list {
+		errs = append(errs, &Error{Line: err.Line, Column: err.Column, Code: err.Code, Msg: err.Msg})
+	}
+	return info, errs
+}
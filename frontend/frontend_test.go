@@ -0,0 +1,59 @@
+package frontend
+
+import (
+	"fmt"
+	"stag/lexer"
+	"stag/pratt_parser"
+	"stag/pratt_parser/ast"
+	"stag/types"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSource(t *testing.T) {
+	program, info, errs := Source("let x = 2 * 3;", Options{})
+	require.Empty(t, errs)
+	require.NotNil(t, info)
+	require.Equal(t, "let x = 6;", program.String())
+
+	program, _, errs = Source("let x = 2 * 3;", Options{Unfolded: true})
+	require.Empty(t, errs)
+	require.Equal(t, "let x = (2 * 3);", program.String())
+}
+
+func TestSourceErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		code  string
+		pos   string
+		info  bool
+	}{
+		{"let x = ;", pratt_parser.CodeNoPrefix, "1:9", false},
+		{"let x: u8 = true;", types.CodeAssignable, "1:13", true},
+		{"let a = 1; a / (a - a);", types.CodeDivisionByZero, "1:17", true},
+	}
+
+	for _, tt := range tests {
+		_, info, errs := Source(tt.input, Options{})
+		require.NotEmpty(t, errs, tt.input)
+		require.Equal(t, tt.code, errs[0].Code, tt.input)
+		require.Equal(t, tt.pos, fmt.Sprintf("%d:%d", errs[0].Line, errs[0].Column), tt.input)
+		require.Equal(t, tt.info, info != nil, tt.input)
+	}
+
+	// the folding is what finds the division by zero
+	_, _, errs := Source("let a = 1; a / (a - a);", Options{Unfolded: true})
+	require.Empty(t, errs)
+}
+
+func TestProgram(t *testing.T) {
+	program := &ast.Program{}
+	for _, input := range []string{"let a = 1;", "a + 2 * 3;"} {
+		p := pratt_parser.New(lexer.New(input))
+		program.Statements = append(program.Statements, p.ParseProgram().Statements...)
+	}
+	_, errs := Program(program, Options{})
+	require.Empty(t, errs)
+	require.Equal(t, "let a = 1;(a + 6)", program.String())
+}
@@ -0,0 +1,171 @@
+package ir
+
+import (
+	"slices"
+	"strings"
+)
+
+// CFG is the control flow graph of a function with its dominator
+// tree, the blocks the entry can not reach are left out of it
+type CFG struct {
+	Func *Func
+	// Order are the blocks reachable from the entry in reverse
+	// postorder, every block comes before its successors but
+	// the ones closing a loop
+	Order []*Block
+	Preds map[*Block][]*Block
+	// Idom is the immediate dominator of each block, the entry
+	// has none
+	Idom     map[*Block]*Block
+	Children map[*Block][]*Block
+	// Frontier are the blocks where the dominance of each
+	// block ends, the first ones it does not strictly dominate
+	Frontier map[*Block][]*Block
+
+	index map[*Block]int
+}
+
+// Preds returns the predecessors of each block of f in the order
+// of the blocks, a block appears twice when both targets of its
+// branch are the same
+func Preds(f *Func) map[*Block][]*Block {
+	preds := make(map[*Block][]*Block, len(f.Blocks))
+	for _, b := range f.Blocks {
+		for _, succ := range b.Succs() {
+			preds[succ] = append(preds[succ], b)
+		}
+	}
+	return preds
+}
+
+// NewCFG builds the graph of f and computes its dominators with
+// the iterative algorithm of Cooper, Harvey and Kennedy
+func NewCFG(f *Func) *CFG {
+	g := &CFG{
+		Func:     f,
+		Preds:    Preds(f),
+		Idom:     map[*Block]*Block{},
+		Children: map[*Block][]*Block{},
+		Frontier: map[*Block][]*Block{},
+		index:    map[*Block]int{},
+	}
+	if len(f.Blocks) == 0 {
+		return g
+	}
+
+	seen := map[*Block]bool{}
+	var post []*Block
+	var visit func(b *Block)
+	visit = func(b *Block) {
+		seen[b] = true
+		for _, succ := range b.Succs() {
+			if !seen[succ] {
+				visit(succ)
+			}
+		}
+		post = append(post, b)
+	}
+	visit(f.Blocks[0])
+	for i := len(post) - 1; i >= 0; i-- {
+		g.index[post[i]] = len(g.Order)
+		g.Order = append(g.Order, post[i])
+	}
+
+	entry := g.Order[0]
+	g.Idom[entry] = entry
+	for changed := true; changed; {
+		changed = false
+		for _, b := range g.Order[1:] {
+			var idom *Block
+			for _, p := range g.Preds[b] {
+				switch {
+				case g.Idom[p] == nil:
+				case idom == nil:
+					idom = p
+				default:
+					idom = g.intersect(p, idom)
+				}
+			}
+			if g.Idom[b] != idom {
+				g.Idom[b] = idom
+				changed = true
+			}
+		}
+	}
+	delete(g.Idom, entry)
+
+	for _, b := range g.Order[1:] {
+		g.Children[g.Idom[b]] = append(g.Children[g.Idom[b]], b)
+	}
+
+	for _, b := range g.Order {
+		preds := g.Preds[b]
+		if len(preds) < 2 {
+			continue
+		}
+		for _, p := range preds {
+			if !g.Reachable(p) {
+				continue
+			}
+			for runner := p; runner != nil && runner != g.Idom[b]; runner = g.Idom[runner] {
+				if !slices.Contains(g.Frontier[runner], b) {
+					g.Frontier[runner] = append(g.Frontier[runner], b)
+				}
+			}
+		}
+	}
+	return g
+}
+
+// intersect walks up from a and b to their closest common dominator
+func (g *CFG) intersect(a, b *Block) *Block {
+	for a != b {
+		for g.index[a] > g.index[b] {
+			a = g.Idom[a]
+		}
+		for g.index[b] > g.index[a] {
+			b = g.Idom[b]
+		}
+	}
+	return a
+}
+
+// Reachable reports if the control can get to b from the entry
+func (g *CFG) Reachable(b *Block) bool {
+	_, ok := g.index[b]
+	return ok
+}
+
+// Dominates reports if every path from the entry to b goes
+// through a, a block dominates itself
+func (g *CFG) Dominates(a, b *Block) bool {
+	if !g.Reachable(a) || !g.Reachable(b) {
+		return false
+	}
+	for ; b != nil; b = g.Idom[b] {
+		if a == b {
+			return true
+		}
+	}
+	return false
+}
+
+// Format lists the immediate dominator and the dominance frontier
+// of each reachable block in reverse postorder
+func (g *CFG) Format() string {
+	var out strings.Builder
+	for _, b := range g.Order {
+		out.WriteString(b.Name + ": idom ")
+		if idom := g.Idom[b]; idom != nil {
+			out.WriteString(idom.Name)
+		} else {
+			out.WriteString("-")
+		}
+		names := make([]string, 0, len(g.Frontier[b]))
+		for _, f := range g.Frontier[b] {
+			names = append(names, f.Name)
+		}
+		out.WriteString(" frontier {" + strings.Join(names, ", ") + "}\n")
+	}
+	return out.String()
+}
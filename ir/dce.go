@@ -0,0 +1,112 @@
+package ir
+
+import "slices"
+
+// DCE drops the instructions whose values nothing reads, the calls,
+// the stores, the writes of registers of main other functions read
+// and the divisions that may trap stay
+var DCE = Pass{Name: "dce", Run: dce}
+
+// DSE drops the writes of registers of main that are written again
+// in the same block before anything can read them, the stores of
+// other functions and the writes of main itself alike
+var DSE = Pass{Name: "dse", Run: dse}
+
+func dce(f *Func, memory RegSet) {
+	defs := definitions(f, memory)
+	live := map[*Instr]bool{}
+	var work []Reg
+	mark := func(instr *Instr) {
+		if !live[instr] {
+			live[instr] = true
+			work = append(work, instr.Args...)
+		}
+	}
+
+	for _, b := range f.Blocks {
+		for _, instr := range b.Instrs {
+			if effects(instr, defs, memory) {
+				mark(instr)
+			}
+		}
+		if b.Term != nil {
+			work = append(work, b.Term.Uses()...)
+		}
+	}
+	for len(work) > 0 {
+		r := work[len(work)-1]
+		work = work[:len(work)-1]
+		if instr, ok := defs[r]; ok {
+			mark(instr)
+		}
+	}
+
+	for _, b := range f.Blocks {
+		b.Instrs = slices.DeleteFunc(b.Instrs, func(instr *Instr) bool { return !live[instr] })
+	}
+}
+
+// effects reports if an instruction does more than computing its
+// value
+func effects(instr *Instr, defs map[Reg]*Instr, memory RegSet) bool {
+	switch {
+	case instr.Op == Call || instr.Op == Store:
+		return true
+	case instr.Dst != NoReg && memory[instr.Dst]:
+		return true
+	}
+	return traps(instr, defs)
+}
+
+// traps reports if an instruction may stop the program, a division
+// does unless its divisor is a constant other than 0
+func traps(instr *Instr, defs map[Reg]*Instr) bool {
+	if instr.Op != UDiv && instr.Op != SDiv {
+		return false
+	}
+	divisor, ok := defs[instr.Args[1]]
+	return !ok || divisor.Op != Const || uint16(divisor.Value) == 0
+}
+
+func dse(f *Func, memory RegSet) {
+	defs := definitions(f, memory)
+	for _, b := range f.Blocks {
+		// the registers of main written later in the block
+		// with no read in between, going backwards
+		written := RegSet{}
+		for i := len(b.Instrs) - 1; i >= 0; i-- {
+			instr := b.Instrs[i]
+			var global Reg = NoReg
+			switch {
+			case instr.Op == Store:
+				global = instr.Global
+			case instr.Dst != NoReg && memory[instr.Dst]:
+				global = instr.Dst
+			}
+
+			if global != NoReg && written[global] {
+				switch {
+				case instr.Op == Call:
+					instr.Dst = NoReg
+				case !traps(instr, defs):
+					b.Instrs = slices.Delete(b.Instrs, i, i+1)
+				}
+			} else if global != NoReg {
+				written[global] = true
+			}
+
+			switch {
+			case instr.Op == Call:
+				// the callee may read any of them
+				clear(written)
+			case instr.Op == Load:
+				delete(written, instr.Global)
+			}
+			for _, r := range instr.Args {
+				if memory[r] {
+					delete(written, r)
+				}
+			}
+		}
+	}
+}
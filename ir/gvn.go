@@ -0,0 +1,142 @@
+package ir
+
+import (
+	"fmt"
+	"strings"
+)
+
+// GVN numbers the values walking the dominator tree, an operation
+// computed again on the same values where the first one dominates
+// reads the first one instead. Constants are left alone, loading one
+// again is as cheap as keeping it in a register, but the operations
+// on them are numbered by the constant. The operations on registers
+// of main calls may change are left alone
+var GVN = Pass{Name: "gvn", Run: gvn}
+
+type numberer struct {
+	f      *Func
+	g      *CFG
+	memory RegSet
+	defs   map[Reg]*Instr
+	repl   map[Reg]Reg
+	// table holds the values computed in the blocks dominating
+	// the one being numbered
+	table map[string]Reg
+}
+
+func gvn(f *Func, memory RegSet) {
+	n := &numberer{
+		f:      f,
+		g:      NewCFG(f),
+		memory: memory,
+		defs:   definitions(f, memory),
+		repl:   map[Reg]Reg{},
+		table:  map[string]Reg{},
+	}
+	n.number(f.Blocks[0])
+	replace(f, n.repl)
+}
+
+func (n *numberer) find(r Reg) Reg {
+	for {
+		next, ok := n.repl[r]
+		if !ok {
+			return r
+		}
+		r = next
+	}
+}
+
+// key identifies the value an instruction computes, the empty key
+// is for the ones that can not be numbered
+func (n *numberer) key(b *Block, instr *Instr) string {
+	if instr.Dst == NoReg || n.memory[instr.Dst] {
+		return ""
+	}
+	// the constants read stand for their value, whichever register
+	// holds them
+	args := make([]string, len(instr.Args))
+	for k, r := range instr.Args {
+		if n.memory[r] {
+			return ""
+		}
+		r = n.find(r)
+		if d, ok := n.defs[r]; ok && d.Op == Const {
+			args[k] = fmt.Sprintf("#%d", uint16(d.Value))
+		} else {
+			args[k] = r.String()
+		}
+	}
+
+	switch {
+	case instr.Op.IsBinary():
+		if instr.Op.Commutative() && args[0] > args[1] {
+			args[0], args[1] = args[1], args[0]
+		}
+		return instr.Op.String() + " " + strings.Join(args, ", ")
+	case instr.Op == Phi:
+		// the phis of a block merging the same values are the same
+		parts := []string{"phi", b.Name}
+		for k, arg := range args {
+			parts = append(parts, fmt.Sprintf("[%s, %s]", arg, instr.Preds[k].Name))
+		}
+		return strings.Join(parts, " ")
+	}
+	return ""
+}
+
+func (n *numberer) number(b *Block) {
+	var added []string
+	kept := b.Instrs[:0]
+	for _, instr := range b.Instrs {
+		switch {
+		case instr.Op == Copy && !n.memory[instr.Dst] && !n.memory[instr.Args[0]]:
+			n.repl[instr.Dst] = n.find(instr.Args[0])
+			continue
+		case instr.Op == Phi && !n.memory[instr.Dst]:
+			// a phi merging a single value is that value
+			if same, ok := n.single(instr); ok {
+				n.repl[instr.Dst] = same
+				continue
+			}
+		}
+
+		key := n.key(b, instr)
+		if key == "" {
+			kept = append(kept, instr)
+			continue
+		}
+		if r, ok := n.table[key]; ok {
+			n.repl[instr.Dst] = r
+			continue
+		}
+		n.table[key] = instr.Dst
+		added = append(added, key)
+		kept = append(kept, instr)
+	}
+	b.Instrs = kept
+
+	for _, child := range n.g.Children[b] {
+		n.number(child)
+	}
+	for _, key := range added {
+		delete(n.table, key)
+	}
+}
+
+// single reports if every argument of a phi is the same value or
+// the phi itself, around a loop that does not change it
+func (n *numberer) single(phi *Instr) (Reg, bool) {
+	same := NoReg
+	for _, r := range phi.Args {
+		r = n.find(r)
+		if r == phi.Dst || r == same {
+			continue
+		}
+		if same != NoReg || n.memory[r] {
+			return NoReg, false
+		}
+		same = r
+	}
+	return same, same != NoReg
+}
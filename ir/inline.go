@@ -0,0 +1,257 @@
+package ir
+
+import "slices"
+
+// CostModel estimates the code a target makes of the IR, the inliner
+// weighs a call against the body of its callee with it
+type CostModel interface {
+	// InstrCost is the cost of the code of an instruction
+	InstrCost(instr *Instr) int
+	// TermCost is the cost of the code of a terminator, the frame
+	// left by a return is part of the call
+	TermCost(t *Terminator) int
+	// CallCost is what a call with args arguments costs besides the
+	// body of the callee, passing the arguments and the frame
+	CallCost(args int) int
+}
+
+// instrCount counts every instruction once, for the targets with no
+// estimates of their own
+type instrCount struct{}
+
+func (instrCount) InstrCost(*Instr) int     { return 1 }
+func (instrCount) TermCost(*Terminator) int { return 1 }
+func (instrCount) CallCost(args int) int    { return args + 2 }
+
+// Inline returns the inliner. A call is replaced by the body of its
+// callee when the body costs at most threshold more than the call,
+// the recursive functions are never inlined and the functions no
+// call is left to are dropped. With nil costs every instruction
+// counts once
+func Inline(costs CostModel, threshold int) Pass {
+	if costs == nil {
+		costs = instrCount{}
+	}
+	return Pass{Name: "inline", Whole: func(p *Program, globals RegSet) { inline(p, globals, costs, threshold) }}
+}
+
+func inline(p *Program, globals RegSet, costs CostModel, threshold int) {
+	calls := callGraph(p)
+	recursive := map[string]bool{}
+	for _, f := range p.Funcs {
+		recursive[f.Name] = reaches(calls, f.Name, f.Name)
+	}
+	worth := func(callee *Func) bool {
+		if callee.Name == MainFunc || recursive[callee.Name] {
+			return false
+		}
+		return bodyCost(callee, costs)-costs.CallCost(len(callee.Params)) <= threshold
+	}
+
+	// the callees go before their callers, the bodies copied have
+	// the calls worth inlining inlined already
+	done := map[string]bool{}
+	var visit func(f *Func)
+	visit = func(f *Func) {
+		if done[f.Name] {
+			return
+		}
+		done[f.Name] = true
+		for _, callee := range calls[f.Name] {
+			visit(p.Func(callee))
+		}
+
+		memory := RegSet{}
+		if f.Name == MainFunc {
+			memory = globals
+		}
+		for k := 0; k < len(f.Blocks); k++ {
+			b := f.Blocks[k]
+			for i, instr := range b.Instrs {
+				if callee := p.Func(instr.Callee); instr.Op == Call && callee != f && worth(callee) {
+					inlineCall(f, b, i, callee, memory)
+					break
+				}
+			}
+		}
+		RemoveUnreachable(f)
+	}
+	for _, f := range p.Funcs {
+		visit(f)
+	}
+
+	if p.Func(MainFunc) == nil {
+		return
+	}
+	calls = callGraph(p)
+	p.Funcs = slices.DeleteFunc(p.Funcs, func(f *Func) bool {
+		return f.Name != MainFunc && !reaches(calls, MainFunc, f.Name)
+	})
+}
+
+// callGraph returns the functions each function calls
+func callGraph(p *Program) map[string][]string {
+	calls := map[string][]string{}
+	for _, f := range p.Funcs {
+		for _, b := range f.Blocks {
+			for _, instr := range b.Instrs {
+				if instr.Op == Call && !slices.Contains(calls[f.Name], instr.Callee) {
+					calls[f.Name] = append(calls[f.Name], instr.Callee)
+				}
+			}
+		}
+	}
+	return calls
+}
+
+// reaches reports if a chain of one call or more leads from one
+// function to the other
+func reaches(calls map[string][]string, from, to string) bool {
+	seen := map[string]bool{}
+	work := slices.Clone(calls[from])
+	for len(work) > 0 {
+		name := work[len(work)-1]
+		work = work[:len(work)-1]
+		if name == to {
+			return true
+		}
+		if !seen[name] {
+			seen[name] = true
+			work = append(work, calls[name]...)
+		}
+	}
+	return false
+}
+
+// bodyCost estimates the code of a function without its frame
+func bodyCost(f *Func, costs CostModel) int {
+	total := 0
+	for _, b := range f.Blocks {
+		for _, instr := range b.Instrs {
+			total += costs.InstrCost(instr)
+		}
+		total += costs.TermCost(b.Term)
+	}
+	return total
+}
+
+// inlineCall replaces the call at b.Instrs[i] with a copy of the body
+// of callee laid out after b, the instructions after the call move
+// to a block of their own the returns of the copy jump to
+func inlineCall(f *Func, b *Block, i int, callee *Func, memory RegSet) {
+	call := b.Instrs[i]
+	at := slices.Index(f.Blocks, b) + 1
+
+	// the registers of the callee get new ones and the parameters
+	// are the arguments, but for the registers of main in memory the
+	// copy of the body may change before reading them
+	regs := map[Reg]Reg{}
+	reg := func(r Reg) Reg {
+		if n, ok := regs[r]; ok {
+			return n
+		}
+		regs[r] = f.NewReg()
+		return regs[r]
+	}
+	head := slices.Clone(b.Instrs[:i])
+	for k, param := range callee.Params {
+		arg := call.Args[k]
+		if memory[arg] {
+			value := f.NewReg()
+			head = append(head, &Instr{Op: Copy, Dst: value, Args: []Reg{arg}, Line: call.Line})
+			arg = value
+		}
+		regs[param] = arg
+	}
+
+	blocks := map[*Block]*Block{}
+	body := make([]*Block, len(callee.Blocks))
+	for k, cb := range callee.Blocks {
+		body[k] = &Block{Name: f.uniqueName(callee.Name + "." + cb.Name)}
+		blocks[cb] = body[k]
+		f.Blocks = slices.Insert(f.Blocks, at+k, body[k])
+	}
+	cont := &Block{Name: f.uniqueName(b.Name + ".ret"), Instrs: slices.Clone(b.Instrs[i+1:]), Term: b.Term}
+	f.Blocks = slices.Insert(f.Blocks, at+len(body), cont)
+	for _, succ := range cont.Succs() {
+		for _, phi := range succ.Instrs {
+			if phi.Op == Phi {
+				phi.Preds[slices.Index(phi.Preds, b)] = cont
+			}
+		}
+	}
+	b.Instrs = head
+	b.Term = &Terminator{Kind: Jump, Cond: NoReg, Value: NoReg, Targets: []*Block{body[0]}, Line: call.Line}
+
+	var results []Reg
+	var from []*Block
+	for k, cb := range callee.Blocks {
+		nb := body[k]
+		for _, instr := range cb.Instrs {
+			c := *instr
+			c.Tail = false
+			c.Args = make([]Reg, len(instr.Args))
+			for a, r := range instr.Args {
+				c.Args[a] = reg(r)
+			}
+			if c.Dst != NoReg {
+				c.Dst = reg(c.Dst)
+			}
+			c.Preds = nil
+			for _, pred := range instr.Preds {
+				c.Preds = append(c.Preds, blocks[pred])
+			}
+			// main reaches its registers without going through memory
+			switch {
+			case f.Name == MainFunc && c.Op == Load:
+				c = Instr{Op: Copy, Dst: c.Dst, Args: []Reg{instr.Global}, Line: c.Line}
+			case f.Name == MainFunc && c.Op == Store:
+				c = Instr{Op: Copy, Dst: instr.Global, Args: c.Args, Line: c.Line}
+			}
+			nb.Instrs = append(nb.Instrs, &c)
+		}
+
+		t := cb.Term
+		if t.Kind != Return {
+			nt := &Terminator{Kind: t.Kind, Cond: NoReg, Value: NoReg, Line: t.Line}
+			if t.Kind == Branch {
+				nt.Cond = reg(t.Cond)
+			}
+			for _, target := range t.Targets {
+				nt.Targets = append(nt.Targets, blocks[target])
+			}
+			nb.Term = nt
+			continue
+		}
+		nb.Term = &Terminator{Kind: Jump, Cond: NoReg, Value: NoReg, Targets: []*Block{cont}, Line: t.Line}
+		if call.Dst == NoReg {
+			continue
+		}
+		value := NoReg
+		if t.Value != NoReg {
+			value = reg(t.Value)
+		} else {
+			// nothing returned is 0
+			value = f.NewReg()
+			nb.Instrs = append(nb.Instrs, &Instr{Op: Const, Dst: value, Line: t.Line})
+		}
+		results = append(results, value)
+		from = append(from, nb)
+	}
+
+	// the value of the call comes from the returns, a register of
+	// main in memory is not written by phis
+	var result []*Instr
+	switch {
+	case len(results) == 1:
+		result = append(result, &Instr{Op: Copy, Dst: call.Dst, Args: results, Line: call.Line})
+	case len(results) > 1 && memory[call.Dst]:
+		merged := f.NewReg()
+		result = append(result,
+			&Instr{Op: Phi, Dst: merged, Args: results, Preds: from, Line: call.Line},
+			&Instr{Op: Copy, Dst: call.Dst, Args: []Reg{merged}, Line: call.Line})
+	case len(results) > 1:
+		result = append(result, &Instr{Op: Phi, Dst: call.Dst, Args: results, Preds: from, Line: call.Line})
+	}
+	cont.Instrs = append(result, cont.Instrs...)
+}
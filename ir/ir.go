@@ -0,0 +1,334 @@
+package ir
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Reg is a virtual register, a function can use as many as it needs
+// and the backend decides where each of them lives
+type Reg int
+
+// NoReg marks the absence of a register, e.g. the destination
+// of a call to a function that returns no value
+const NoReg Reg = -1
+
+func (r Reg) String() string {
+	if r == NoReg {
+		return "%_"
+	}
+	return fmt.Sprintf("%%%d", r)
+}
+
+type Op uint8
+
+const (
+	Const Op = iota // %d = const 5
+	Copy            // %d = copy %a
+	Add             // %d = add %a, %b
+	Sub
+	Mul
+	UDiv
+	SDiv
+	And
+	Or
+	Xor
+	Eq
+	Ne
+	ULt
+	ULe
+	SLt
+	SLe
+	Shl   // %d = shl %a, %b shifts %a left by %b bits
+	Shr   // logical shift right
+	Sar   // arithmetic shift right, keeps the sign
+	Call  // %d = call f(%a, %b), or %d = tail call f(%a) right before ret %d
+	Load  // %d = load @a reads the register %a of main
+	Store // store @a, %b writes %b to the register %a of main
+	Phi   // %d = phi [%a, from], [%b, other] takes the value of the block the control came from
+)
+
+var opNames = map[Op]string{
+	Const: "const",
+	Copy:  "copy",
+	Add:   "add",
+	Sub:   "sub",
+	Mul:   "mul",
+	UDiv:  "udiv",
+	SDiv:  "sdiv",
+	And:   "and",
+	Or:    "or",
+	Xor:   "xor",
+	Eq:    "eq",
+	Ne:    "ne",
+	ULt:   "ult",
+	ULe:   "ule",
+	SLt:   "slt",
+	SLe:   "sle",
+	Shl:   "shl",
+	Shr:   "shr",
+	Sar:   "sar",
+	Call:  "call",
+	Load:  "load",
+	Store: "store",
+	Phi:   "phi",
+}
+
+var opsByName = func() map[string]Op {
+	m := make(map[string]Op, len(opNames))
+	for op, name := range opNames {
+		m[name] = op
+	}
+	return m
+}()
+
+func (op Op) String() string {
+	if name, ok := opNames[op]; ok {
+		return name
+	}
+	return fmt.Sprintf("Op(%d)", op)
+}
+
+// IsBinary reports if the operation takes two register operands
+func (op Op) IsBinary() bool {
+	return op >= Add && op <= Sar
+}
+
+// Commutative reports if the operands of a binary operation
+// can be swapped
+func (op Op) Commutative() bool {
+	switch op {
+	case Add, Mul, And, Or, Xor, Eq, Ne:
+		return true
+	}
+	return false
+}
+
+// Eval computes a binary operation on 16 bit words like the
+// targets do, ok is false when it traps as a division by zero
+func (op Op) Eval(a, b uint16) (result uint16, ok bool) {
+	switch op {
+	case Add:
+		return a + b, true
+	case Sub:
+		return a - b, true
+	case Mul:
+		return a * b, true
+	case UDiv:
+		if b == 0 {
+			return 0, false
+		}
+		return a / b, true
+	case SDiv:
+		if b == 0 {
+			return 0, false
+		}
+		// -32768 / -1 overflows and wraps back to -32768
+		return uint16(int16(a) / int16(b)), true
+	case And:
+		return a & b, true
+	case Or:
+		return a | b, true
+	case Xor:
+		return a ^ b, true
+	case Eq:
+		return truth(a == b), true
+	case Ne:
+		return truth(a != b), true
+	case ULt:
+		return truth(a < b), true
+	case ULe:
+		return truth(a <= b), true
+	case SLt:
+		return truth(int16(a) < int16(b)), true
+	case SLe:
+		return truth(int16(a) <= int16(b)), true
+	case Shl:
+		return a << b, true
+	case Shr:
+		return a >> b, true
+	case Sar:
+		return uint16(int16(a) >> b), true
+	}
+	return 0, false
+}
+
+func truth(b bool) uint16 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+type Instr struct {
+	Op   Op
+	Dst  Reg
+	Args []Reg
+
+	Value  int64    // the constant loaded by Const
+	Callee string   // the function called by Call
+	Global Reg      // the register of main read by Load and written by Store
+	Preds  []*Block // the block each argument of Phi comes from
+	// Tail marks a call whose value the block returns, the backend
+	// may reuse the frame of the caller for it
+	Tail bool
+
+	Line int // source line it was lowered from, 0 when unknown
+}
+
+func (i *Instr) String() string {
+	var out strings.Builder
+
+	if i.Dst != NoReg {
+		out.WriteString(i.Dst.String() + " = ")
+	}
+	if i.Tail {
+		out.WriteString("tail ")
+	}
+	out.WriteString(i.Op.String())
+
+	switch i.Op {
+	case Const:
+		out.WriteString(fmt.Sprintf(" %d", i.Value))
+	case Call:
+		out.WriteString(" " + i.Callee + "(" + regList(i.Args) + ")")
+	case Load:
+		out.WriteString(fmt.Sprintf(" @%d", i.Global))
+	case Store:
+		out.WriteString(fmt.Sprintf(" @%d, %s", i.Global, regList(i.Args)))
+	case Phi:
+		parts := make([]string, 0, len(i.Args))
+		for k, r := range i.Args {
+			parts = append(parts, "["+r.String()+", "+i.Preds[k].Name+"]")
+		}
+		out.WriteString(" " + strings.Join(parts, ", "))
+	default:
+		out.WriteString(" " + regList(i.Args))
+	}
+	return out.String()
+}
+
+func regList(regs []Reg) string {
+	parts := make([]string, 0, len(regs))
+	for _, r := range regs {
+		parts = append(parts, r.String())
+	}
+	return strings.Join(parts, ", ")
+}
+
+type TermKind uint8
+
+const (
+	Jump   TermKind = iota // jmp target
+	Branch                 // br %c, then, else
+	Return                 // ret %v, or ret when there is no value
+)
+
+// Terminator ends a basic block transferring the control elsewhere
+type Terminator struct {
+	Kind    TermKind
+	Cond    Reg      // tested by Branch, the first target is taken when it is not zero
+	Value   Reg      // returned by Return, NoReg when there is no value
+	Targets []*Block // one for Jump, then and else for Branch
+	Line    int      // source line it was lowered from, 0 when unknown
+}
+
+func (t *Terminator) String() string {
+	switch t.Kind {
+	case Jump:
+		return "jmp " + t.Targets[0].Name
+	case Branch:
+		return fmt.Sprintf("br %s, %s, %s", t.Cond, t.Targets[0].Name, t.Targets[1].Name)
+	case Return:
+		if t.Value == NoReg {
+			return "ret"
+		}
+		return "ret " + t.Value.String()
+	default:
+		return fmt.Sprintf("TermKind(%d)", t.Kind)
+	}
+}
+
+// Block is a basic block, a straight sequence of
+// instructions ended by a single terminator
+type Block struct {
+	Name   string
+	Instrs []*Instr
+	Term   *Terminator
+}
+
+// Succs returns the blocks the control can go to after this one
+func (b *Block) Succs() []*Block {
+	if b.Term == nil {
+		return nil
+	}
+	return b.Term.Targets
+}
+
+type Func struct {
+	Name    string
+	Params  []Reg
+	Blocks  []*Block // the first block is the entry
+	NumRegs int
+}
+
+func (f *Func) NewReg() Reg {
+	r := Reg(f.NumRegs)
+	f.NumRegs++
+	return r
+}
+
+func (f *Func) NewBlock(name string) *Block {
+	b := &Block{Name: name}
+	f.Blocks = append(f.Blocks, b)
+	return b
+}
+
+// Block returns the block with the given name or nil
+func (f *Func) Block(name string) *Block {
+	for _, b := range f.Blocks {
+		if b.Name == name {
+			return b
+		}
+	}
+	return nil
+}
+
+func (f *Func) String() string {
+	var out strings.Builder
+
+	out.WriteString("func " + f.Name + "(" + regList(f.Params) + ") {\n")
+	for _, b := range f.Blocks {
+		out.WriteString(b.Name + ":\n")
+		for _, instr := range b.Instrs {
+			out.WriteString("\t" + instr.String() + "\n")
+		}
+		if b.Term != nil {
+			out.WriteString("\t" + b.Term.String() + "\n")
+		}
+	}
+	out.WriteString("}\n")
+	return out.String()
+}
+
+type Program struct {
+	Funcs []*Func
+}
+
+// Func returns the function with the given name or nil
+func (p *Program) Func(name string) *Func {
+	for _, f := range p.Funcs {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+func (p *Program) String() string {
+	parts := make([]string, 0, len(p.Funcs))
+	for _, f := range p.Funcs {
+		parts = append(parts, f.String())
+	}
+	return strings.Join(parts, "\n")
+}
@@ -0,0 +1,871 @@
+package ir
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"stag/frontend"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "rewrite the .ir golden files")
+
+func lower(t *testing.T, input string) *Program {
+	t.Helper()
+
+	program, info, errs := frontend.Source(input, frontend.Options{Unfolded: true})
+	require.Empty(t, errs)
+	return Lower(program, info)
+}
+
+func TestLowerGolden(t *testing.T) {
+	sources, err := filepath.Glob("testdata/*.el")
+	require.NoError(t, err)
+	require.NotEmpty(t, sources)
+
+	for _, source := range sources {
+		t.Run(filepath.Base(source), func(t *testing.T) {
+			input, err := os.ReadFile(source)
+			require.NoError(t, err)
+
+			actual := lower(t, string(input)).String()
+
+			golden := strings.TrimSuffix(source, ".el") + ".ir"
+			if *update {
+				require.NoError(t, os.WriteFile(golden, []byte(actual), 0o644))
+			}
+
+			expected, err := os.ReadFile(golden)
+			require.NoError(t, err)
+			require.Equal(t, string(expected), actual)
+		})
+	}
+}
+
+func TestParseRoundTrip(t *testing.T) {
+	goldens, err := filepath.Glob("testdata/*.ir")
+	require.NoError(t, err)
+	require.NotEmpty(t, goldens)
+
+	for _, golden := range goldens {
+		text, err := os.ReadFile(golden)
+		require.NoError(t, err)
+
+		program, err := Parse(string(text))
+		require.NoError(t, err, golden)
+		require.Equal(t, string(text), program.String(), golden)
+	}
+}
+
+func TestParse(t *testing.T) {
+	src := `
+	; comments and blank lines are ignored
+	func f(%0) {
+	entry:
+		%1 = const -3
+		%2 = add %0, %1
+		br %2, yes, no
+	yes:
+		call g(%2, %0)
+		jmp no
+	no:
+		ret
+	}`
+
+	program, err := Parse(src)
+	require.NoError(t, err)
+
+	f := program.Func("f")
+	require.NotNil(t, f)
+	require.Equal(t, []Reg{0}, f.Params)
+	require.Equal(t, 3, f.NumRegs)
+	require.Len(t, f.Blocks, 3)
+	require.Equal(t, int64(-3), f.Blocks[0].Instrs[0].Value)
+	require.Equal(t, []*Block{f.Block("yes"), f.Block("no")}, f.Blocks[0].Succs())
+	require.Equal(t, NoReg, f.Blocks[1].Instrs[0].Dst)
+	require.Equal(t, "g", f.Blocks[1].Instrs[0].Callee)
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []string{
+		"func f() {\nentry:\n\tjmp nowhere\n}",
+		"func f() {\nentry:\n\t%0 = frob %1\n\tret\n}",
+		"func f() {\n\t%0 = const 1\n}",
+		"func f() {\nentry:\n\tret\n\t%0 = const 1\n}",
+		"func f() {\nentry:\n\tadd %0, %1\n}",
+		"func f() {\nentry:\n\tret\n",
+		"func f() {\nentry:\n\t%0 = load %1\n\tret\n}",
+		"func f() {\nentry:\n\t%0 = store @1, %2\n\tret\n}",
+	}
+
+	for _, src := range tests {
+		_, err := Parse(src)
+		require.ErrorIs(t, err, errSyntax, src)
+	}
+}
+
+func TestLowerWrapsNarrowTypes(t *testing.T) {
+	program := lower(t, "let a: u8 = 1; let b = a + 2;")
+
+	main := program.Func(MainFunc)
+	require.Equal(t, `func main() {
+entry:
+	%0 = const 1
+	%1 = const 2
+	%2 = add %0, %1
+	%3 = const 255
+	%4 = and %2, %3
+	ret %4
+}
+`, main.String())
+}
+
+func TestLive(t *testing.T) {
+	program := lower(t, `fn sum(n: u16) -> u16 {
+	let total = 0;
+	let i = 1;
+	while i <= n {
+		total = total + i;
+		i = i + 1;
+	}
+	return total;
+}`)
+
+	f := program.Func("sum")
+	require.Equal(t, `entry: in {%0} out {%0, %1, %2}
+while.cond.1: in {%0, %1, %2} out {%0, %1, %2}
+while.body.2: in {%0, %1, %2} out {%0, %1, %2}
+while.end.3: in {%1} out {}
+`, Live(f).Format(f))
+}
+
+func TestCFG(t *testing.T) {
+	program, err := Parse(`
+	func f(%0) {
+	entry:
+		br %0, loop, done
+	loop:
+		br %0, then, else
+	then:
+		jmp join
+	else:
+		jmp join
+	join:
+		br %0, loop, done
+	dead:
+		jmp done
+	done:
+		ret
+	}`)
+	require.NoError(t, err)
+
+	f := program.Func("f")
+	g := NewCFG(f)
+	require.Equal(t, `entry: idom - frontier {}
+loop: idom entry frontier {loop, done}
+else: idom loop frontier {join}
+then: idom loop frontier {join}
+join: idom loop frontier {loop, done}
+done: idom entry frontier {}
+`, g.Format())
+	require.False(t, g.Reachable(f.Block("dead")))
+	require.True(t, g.Dominates(f.Block("loop"), f.Block("join")))
+	require.False(t, g.Dominates(f.Block("then"), f.Block("join")))
+}
+
+func TestSSA(t *testing.T) {
+	// the loop variables get phis at the header, total is read before
+	// it is written on the way around the loop that never runs
+	program, err := Parse(`
+	func f(%0) {
+	entry:
+		%1 = const 1
+		jmp cond
+	cond:
+		%3 = ult %1, %0
+		br %3, body, done
+	body:
+		%4 = add %2, %1
+		%2 = copy %4
+		%5 = const 1
+		%6 = add %1, %5
+		%1 = copy %6
+		jmp cond
+	done:
+		ret %2
+	}`)
+	require.NoError(t, err)
+	require.NoError(t, Verify(program))
+
+	f := program.Func("f")
+	ToSSA(f, nil)
+	require.NoError(t, VerifySSA(program))
+	require.Equal(t, `func f(%0) {
+entry:
+	%2 = const 0
+	%1 = const 1
+	jmp cond
+cond:
+	%7 = phi [%1, entry], [%6, body]
+	%8 = phi [%2, entry], [%4, body]
+	%3 = ult %7, %0
+	br %3, body, done
+body:
+	%4 = add %8, %7
+	%5 = const 1
+	%6 = add %7, %5
+	jmp cond
+done:
+	ret %8
+}
+`, f.String())
+
+	// the textual form of SSA reads back the same
+	parsed, err := Parse(f.String())
+	require.NoError(t, err)
+	require.Equal(t, f.String(), parsed.String())
+
+	FromSSA(f)
+	require.NoError(t, Verify(program))
+	require.Equal(t, `func f(%0) {
+entry:
+	%2 = const 0
+	%1 = const 1
+	%7 = copy %1
+	%8 = copy %2
+	jmp cond
+cond:
+	%3 = ult %7, %0
+	br %3, body, done
+body:
+	%4 = add %8, %7
+	%5 = const 1
+	%6 = add %7, %5
+	%7 = copy %6
+	%8 = copy %4
+	jmp cond
+done:
+	ret %8
+}
+`, f.String())
+}
+
+func TestFromSSA(t *testing.T) {
+	// the phis swap their values around the loop, the copies need a
+	// register of their own, and the branch leaving the loop gets a
+	// block for the copies of its edge
+	program, err := Parse(`
+	func f(%0, %1) {
+	entry:
+		jmp loop
+	loop:
+		%2 = phi [%0, entry], [%3, loop]
+		%3 = phi [%1, entry], [%2, loop]
+		br %2, loop, done
+	done:
+		ret %3
+	}`)
+	require.NoError(t, err)
+	require.NoError(t, VerifySSA(program))
+
+	f := program.Func("f")
+	FromSSA(f)
+	require.NoError(t, Verify(program))
+	require.Equal(t, `func f(%0, %1) {
+entry:
+	%2 = copy %0
+	%3 = copy %1
+	jmp loop
+loop:
+	br %2, loop.loop, done
+loop.loop:
+	%4 = copy %2
+	%2 = copy %3
+	%3 = copy %4
+	jmp loop
+done:
+	ret %3
+}
+`, f.String())
+}
+
+func TestVerifySSA(t *testing.T) {
+	tests := map[string]string{
+		"written twice": `func f() {
+entry:
+	%0 = const 1
+	%0 = const 2
+	ret %0
+}`,
+		"not dominated": `func f(%0) {
+entry:
+	br %0, a, b
+a:
+	%1 = const 1
+	jmp b
+b:
+	ret %1
+}`,
+		"missing argument": `func f(%0) {
+entry:
+	br %0, a, b
+a:
+	jmp b
+b:
+	%1 = phi [%0, entry]
+	ret %1
+}`,
+		"phi after instruction": `func f(%0) {
+entry:
+	jmp a
+a:
+	%1 = const 1
+	%2 = phi [%0, entry]
+	ret %2
+}`,
+		"same target twice": `func f(%0) {
+entry:
+	br %0, a, a
+a:
+	ret
+}`,
+		"undefined callee": `func f() {
+entry:
+	call g()
+	ret
+}`,
+	}
+
+	for name, src := range tests {
+		t.Run(name, func(t *testing.T) {
+			program, err := Parse(src)
+			require.NoError(t, err)
+			require.ErrorIs(t, VerifySSA(program), errInvalid)
+		})
+	}
+
+	// main keeps the registers other functions reach out of SSA form
+	program, err := Parse(`func main() {
+entry:
+	%0 = const 1
+	%1 = call f()
+	%0 = copy %1
+	ret %0
+}
+
+func f() {
+entry:
+	%0 = load @0
+	%1 = const 1
+	%2 = add %0, %1
+	store @0, %2
+	ret %0
+}`)
+	require.NoError(t, err)
+	require.NoError(t, VerifySSA(program))
+}
+
+func TestOptimize(t *testing.T) {
+	sources, err := filepath.Glob("testdata/*.el")
+	require.NoError(t, err)
+
+	for _, source := range sources {
+		input, err := os.ReadFile(source)
+		require.NoError(t, err)
+		require.NoError(t, Optimize(lower(t, string(input))), source)
+	}
+
+	// the error names the pass that broke the program
+	program := lower(t, "let a = 1; let b = a + 2;")
+	breaks := Pass{Name: "breaks", Run: func(f *Func, _ RegSet) {
+		f.Blocks[0].Instrs[0].Dst = f.Blocks[0].Instrs[1].Dst
+	}}
+	err = Optimize(program, breaks)
+	require.ErrorIs(t, err, errInvalid)
+	require.ErrorContains(t, err, "breaks: ")
+}
+
+// runPass runs a pass over every function of a program in SSA form
+// and returns what it made of them
+func runPass(t *testing.T, pass Pass, input string) string {
+	t.Helper()
+
+	program, err := Parse(input)
+	require.NoError(t, err)
+	require.NoError(t, VerifySSA(program))
+
+	globals := program.Globals()
+	if pass.Whole != nil {
+		pass.Whole(program, globals)
+	}
+	for _, f := range program.Funcs {
+		memory := RegSet{}
+		if f.Name == MainFunc {
+			memory = globals
+		}
+		if pass.Run != nil {
+			pass.Run(f, memory)
+		}
+	}
+	require.NoError(t, verify(program, true, globals))
+	return program.String()
+}
+
+func TestSCCP(t *testing.T) {
+	program := lower(t, "let x = 3; let y = x * 4; y")
+	require.NoError(t, Optimize(program, SCCP, DCE))
+	require.Equal(t, `func main() {
+entry:
+	%2 = const 12
+	ret %2
+}
+`, program.String())
+
+	// i stays 0 around the loop, the test after it always holds
+	require.Equal(t, `func f(%0) {
+entry:
+	%1 = const 0
+	%2 = const 1
+	jmp loop
+loop:
+	%3 = const 0
+	%4 = ult %3, %0
+	br %4, body, done
+body:
+	%5 = const 0
+	jmp loop
+done:
+	%6 = const 1
+	jmp yes
+yes:
+	ret %2
+}
+`, runPass(t, SCCP, `
+	func f(%0) {
+	entry:
+		%1 = const 0
+		%2 = const 1
+		jmp loop
+	loop:
+		%3 = phi [%1, entry], [%5, body]
+		%4 = ult %3, %0
+		br %4, body, done
+	body:
+		%5 = mul %3, %2
+		jmp loop
+	done:
+		%6 = eq %3, %1
+		br %6, yes, no
+	yes:
+		ret %2
+	no:
+		ret %0
+	}`))
+}
+
+func TestGVN(t *testing.T) {
+	// the operands of add swap and the constants match by value
+	program := lower(t, "fn f(a: u16) -> u16 { let b = a + 1; let c = 1 + a; return b * c; } f(2)")
+	require.NoError(t, Optimize(program, GVN, DCE))
+	require.Equal(t, `func f(%0) {
+entry:
+	%1 = const 1
+	%2 = add %0, %1
+	%5 = mul %2, %2
+	ret %5
+}
+`, program.Func("f").String())
+
+	// the phis of a block merging the same values are one, a value
+	// of a branch does not stand for the one of the other
+	require.Equal(t, `func f(%0, %1) {
+entry:
+	br %0, a, b
+a:
+	%2 = add %0, %1
+	jmp c
+b:
+	%3 = add %1, %0
+	jmp c
+c:
+	%4 = phi [%2, a], [%3, b]
+	%6 = add %4, %4
+	ret %6
+}
+`, runPass(t, GVN, `
+	func f(%0, %1) {
+	entry:
+		br %0, a, b
+	a:
+		%2 = add %0, %1
+		jmp c
+	b:
+		%3 = add %1, %0
+		jmp c
+	c:
+		%4 = phi [%2, a], [%3, b]
+		%5 = phi [%2, a], [%3, b]
+		%6 = add %4, %5
+		ret %6
+	}`))
+}
+
+func TestDCE(t *testing.T) {
+	// the division by 0 traps and the call may store, both stay
+	require.Equal(t, `func f(%0) {
+entry:
+	%1 = const 0
+	%3 = udiv %0, %1
+	%4 = const 2
+	%5 = udiv %0, %4
+	%6 = call f(%5)
+	ret %0
+}
+`, runPass(t, DCE, `
+	func f(%0) {
+	entry:
+		%1 = const 0
+		%2 = add %0, %0
+		%3 = udiv %0, %1
+		%4 = const 2
+		%5 = udiv %0, %4
+		%6 = call f(%5)
+		%7 = udiv %0, %4
+		ret %0
+	}`))
+}
+
+func TestDSE(t *testing.T) {
+	// a store read by a load stays, main writes %1 twice before
+	// anything reads it and %0 twice after the call
+	require.Equal(t, `func f() {
+entry:
+	%0 = const 1
+	store @0, %0
+	%1 = load @1
+	store @1, %0
+	%2 = load @1
+	store @1, %0
+	ret %2
+}
+
+func main() {
+entry:
+	%0 = const 1
+	%1 = copy %0
+	%2 = call f()
+	%0 = copy %1
+	ret %2
+}
+`, runPass(t, DSE, `
+	func f() {
+	entry:
+		%0 = const 1
+		store @0, %0
+		store @0, %0
+		%1 = load @1
+		store @1, %0
+		%2 = load @1
+		store @1, %0
+		ret %2
+	}
+
+	func main() {
+	entry:
+		%0 = const 1
+		%1 = const 2
+		%1 = copy %0
+		%2 = call f()
+		%0 = copy %1
+		%0 = copy %1
+		ret %2
+	}`))
+}
+
+func TestSimplify(t *testing.T) {
+	// the branch on a constant goes away with the block it skips,
+	// the empty block d splits an edge and stays
+	require.Equal(t, `func f(%0) {
+entry:
+	%1 = const 1
+	br %0, d, e
+d:
+	jmp e
+e:
+	ret %1
+}
+`, runPass(t, Simplify, `
+	func f(%0) {
+	entry:
+		%1 = const 1
+		br %1, a, b
+	a:
+		jmp c
+	b:
+		jmp c
+	c:
+		%2 = phi [%1, a], [%0, b]
+		br %0, d, e
+	d:
+		jmp e
+	e:
+		ret %2
+	}`))
+}
+
+func TestThread(t *testing.T) {
+	// the phi is a constant along each edge into c, the copies of c
+	// jump where its branch would go
+	input := `
+	func f(%0) {
+	entry:
+		br %0, a, b
+	a:
+		%1 = const 1
+		jmp c
+	b:
+		%2 = const 0
+		jmp c
+	c:
+		%3 = phi [%1, a], [%2, b]
+		br %3, yes, no
+	yes:
+		ret %0
+	no:
+		%4 = const 7
+		ret %4
+	}`
+	require.Equal(t, `func f(%0) {
+entry:
+	br %0, a, b
+a:
+	%1 = const 1
+	jmp c.from.a
+c.from.a:
+	jmp yes
+b:
+	%2 = const 0
+	jmp c.from.b
+c.from.b:
+	jmp no
+yes:
+	ret %0
+no:
+	%4 = const 7
+	ret %4
+}
+`, runPass(t, Thread(0), input))
+
+	// and simplify merges what is left
+	require.Equal(t, `func f(%0) {
+entry:
+	br %0, a, b
+a:
+	ret %0
+b:
+	%4 = const 7
+	ret %4
+}
+`, runPass(t, Pass{Name: "both", Run: func(f *Func, memory RegSet) {
+		Thread(0).Run(f, memory)
+		DCE.Run(f, memory)
+		Simplify.Run(f, memory)
+	}}, input))
+}
+
+func TestPipeline(t *testing.T) {
+	passes, err := Pipeline("sccp, gvn,dce", nil)
+	require.NoError(t, err)
+	var names []string
+	for _, pass := range passes {
+		names = append(names, pass.Name)
+	}
+	require.Equal(t, []string{"sccp", "gvn", "dce"}, names)
+
+	_, err = Pipeline("sccp,unroll", nil)
+	require.EqualError(t, err, `unknown pass "unroll", the passes are dce, dse, gvn, inline, sccp, simplify, tailcall, thread`)
+
+	// every level keeps the programs valid
+	sources, err := filepath.Glob("testdata/*.el")
+	require.NoError(t, err)
+	for level, passes := range Levels(nil) {
+		for _, source := range sources {
+			input, err := os.ReadFile(source)
+			require.NoError(t, err)
+			require.NoError(t, Optimize(lower(t, string(input)), passes...), "%s %s", level, source)
+		}
+	}
+}
+
+func TestInline(t *testing.T) {
+	// main passes a register get writes, abs reads the value it had
+	// at the call. Nothing calls the functions once inlined
+	require.Equal(t, `func main() {
+entry:
+	%0 = const 5
+	%4 = copy %0
+	jmp abs.entry
+abs.entry:
+	%5 = const 0
+	%6 = slt %4, %5
+	br %6, abs.neg, abs.pos
+abs.neg:
+	%7 = sub %5, %4
+	jmp entry.ret
+abs.pos:
+	jmp entry.ret
+entry.ret:
+	%1 = phi [%7, abs.neg], [%4, abs.pos]
+	jmp get.entry
+get.entry:
+	%8 = copy %0
+	%9 = const 1
+	%10 = add %8, %9
+	%0 = copy %10
+	jmp entry.ret.ret
+entry.ret.ret:
+	%2 = copy %8
+	%3 = add %1, %2
+	ret %3
+}
+`, runPass(t, Inline(nil, 4), `
+	func abs(%0) {
+	entry:
+		%1 = const 0
+		%2 = slt %0, %1
+		br %2, neg, pos
+	neg:
+		%3 = sub %1, %0
+		ret %3
+	pos:
+		ret %0
+	}
+
+	func get() {
+	entry:
+		%0 = load @0
+		%1 = const 1
+		%2 = add %0, %1
+		store @0, %2
+		ret %0
+	}
+
+	func main() {
+	entry:
+		%0 = const 5
+		%1 = call abs(%0)
+		%2 = call get()
+		%3 = add %1, %2
+		ret %3
+	}`))
+
+	// the recursive functions stay and so do the bodies costing
+	// more than the threshold
+	program := lower(t, `fn fact(n: u16) -> u16 {
+	if n <= 1 {
+		return 1;
+	}
+	return n * fact(n - 1);
+}
+fn big(a: u16) -> u16 {
+	return a * a * a * a * a * a * a * a * a;
+}
+fact(5) + big(2)`)
+	require.NoError(t, Optimize(program, Inline(nil, 4)))
+	require.NotNil(t, program.Func("fact"))
+	require.NotNil(t, program.Func("big"))
+	require.NoError(t, Optimize(program, Inline(nil, 16)))
+	require.NotNil(t, program.Func("fact"))
+	require.Nil(t, program.Func("big"))
+}
+
+func TestTailCalls(t *testing.T) {
+	// count loops back to its start, other can only leave its frame
+	// to count and main keeps its own
+	require.Equal(t, `func count(%0, %1) {
+entry:
+	jmp start
+start:
+	%8 = phi [%0, entry], [%5, more]
+	%9 = phi [%1, entry], [%6, more]
+	%2 = const 0
+	%3 = eq %8, %2
+	br %3, done, more
+done:
+	ret %9
+more:
+	%4 = const 1
+	%5 = sub %8, %4
+	%6 = add %9, %4
+	jmp start
+}
+
+func other(%0) {
+entry:
+	%1 = tail call count(%0, %0)
+	ret %1
+}
+
+func main() {
+entry:
+	%0 = const 3
+	%1 = call other(%0)
+	ret %1
+}
+`, runPass(t, TailCalls, `
+	func count(%0, %1) {
+	entry:
+		%2 = const 0
+		%3 = eq %0, %2
+		br %3, done, more
+	done:
+		ret %1
+	more:
+		%4 = const 1
+		%5 = sub %0, %4
+		%6 = add %1, %4
+		%7 = call count(%5, %6)
+		ret %7
+	}
+
+	func other(%0) {
+	entry:
+		%1 = call count(%0, %0)
+		ret %1
+	}
+
+	func main() {
+	entry:
+		%0 = const 3
+		%1 = call other(%0)
+		ret %1
+	}`))
+
+	// a tail call comes right before the return of its value
+	program, err := Parse(`
+	func f() {
+	entry:
+		%0 = tail call f()
+		%1 = const 1
+		ret %1
+	}`)
+	require.NoError(t, err)
+	require.ErrorContains(t, Verify(program), "%0 = tail call f(): not followed by the return of its value")
+	program, err = Parse(`
+	func f() {
+	entry:
+		%0 = tail call f()
+		ret %0
+	}
+
+	func main() {
+	entry:
+		%0 = tail call f()
+		ret %0
+	}`)
+	require.NoError(t, err)
+	require.ErrorContains(t, Verify(program), "func main, block entry: %0 = tail call f(): only the calls of functions other than main can be tail calls")
+}
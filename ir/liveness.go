@@ -0,0 +1,125 @@
+package ir
+
+import (
+	"slices"
+	"strings"
+)
+
+// RegSet is a set of virtual registers
+type RegSet map[Reg]bool
+
+// Sorted returns the registers of the set in order
+func (s RegSet) Sorted() []Reg {
+	regs := make([]Reg, 0, len(s))
+	for r := range s {
+		regs = append(regs, r)
+	}
+	slices.Sort(regs)
+	return regs
+}
+
+func (s RegSet) String() string {
+	return "{" + regList(s.Sorted()) + "}"
+}
+
+// Uses returns the registers the instruction reads, the register
+// of main read by Load lives in another frame and is not one of them
+func (i *Instr) Uses() []Reg {
+	return i.Args
+}
+
+// Def returns the register the instruction writes, NoReg when none
+func (i *Instr) Def() Reg {
+	return i.Dst
+}
+
+// Uses returns the registers the terminator reads
+func (t *Terminator) Uses() []Reg {
+	switch {
+	case t.Kind == Branch:
+		return []Reg{t.Cond}
+	case t.Kind == Return && t.Value != NoReg:
+		return []Reg{t.Value}
+	}
+	return nil
+}
+
+// Liveness tells which registers hold a value that may still be
+// read when the control enters and leaves each block
+type Liveness struct {
+	In  map[*Block]RegSet
+	Out map[*Block]RegSet
+}
+
+// Live computes the liveness of the registers of f, a register is
+// live at a point when a path from it reads the register before
+// writing it
+func Live(f *Func) *Liveness {
+	live := &Liveness{In: map[*Block]RegSet{}, Out: map[*Block]RegSet{}}
+
+	// what each block reads before writing and what it writes
+	uses := map[*Block]RegSet{}
+	defs := map[*Block]RegSet{}
+	for _, b := range f.Blocks {
+		use, def := RegSet{}, RegSet{}
+		read := func(regs []Reg) {
+			for _, r := range regs {
+				if !def[r] {
+					use[r] = true
+				}
+			}
+		}
+		for _, instr := range b.Instrs {
+			read(instr.Uses())
+			if d := instr.Def(); d != NoReg {
+				def[d] = true
+			}
+		}
+		if b.Term != nil {
+			read(b.Term.Uses())
+		}
+		uses[b], defs[b] = use, def
+		live.In[b], live.Out[b] = RegSet{}, RegSet{}
+	}
+
+	// the sets only grow, going backwards they settle sooner
+	for changed := true; changed; {
+		changed = false
+		for i := len(f.Blocks) - 1; i >= 0; i-- {
+			b := f.Blocks[i]
+			out := live.Out[b]
+			for _, succ := range b.Succs() {
+				for r := range live.In[succ] {
+					if !out[r] {
+						out[r] = true
+						changed = true
+					}
+				}
+			}
+
+			in := live.In[b]
+			for r := range uses[b] {
+				if !in[r] {
+					in[r] = true
+					changed = true
+				}
+			}
+			for r := range out {
+				if !defs[b][r] && !in[r] {
+					in[r] = true
+					changed = true
+				}
+			}
+		}
+	}
+	return live
+}
+
+// Format lists the registers live in and out of each block of f
+func (l *Liveness) Format(f *Func) string {
+	var out strings.Builder
+	for _, b := range f.Blocks {
+		out.WriteString(b.Name + ": in " + l.In[b].String() + " out " + l.Out[b].String() + "\n")
+	}
+	return out.String()
+}
@@ -0,0 +1,420 @@
+package ir
+
+import (
+	"fmt"
+	"slices"
+	"stag/pratt_parser/ast"
+	"stag/types"
+)
+
+// MainFunc is the function that holds the top level statements
+const MainFunc = "main"
+
+type lowerer struct {
+	info *types.Info
+
+	fn    *Func
+	block *Block
+	vars  map[*types.Object]Reg
+	// globals are the registers of main holding the top level
+	// variables, functions reach them with load and store
+	globals map[*types.Object]Reg
+	labels  int
+	line    int // source line of the statement being lowered
+}
+
+// Lower translates a program checked by types.Check into IR. Every
+// function declaration becomes a Func and the remaining top level
+// statements become the body of main, which returns the value of
+// the last top level statement when it produces one
+func Lower(program *ast.Program, info *types.Info) *Program {
+	l := &lowerer{info: info}
+	out := &Program{}
+
+	// main is lowered first so the functions know
+	// the registers of the globals, it still goes last
+	l.begin(MainFunc)
+	result := NoReg
+	for _, stmt := range program.Statements {
+		if _, ok := stmt.(*ast.FunctionStatement); ok {
+			continue
+		}
+		result = l.stmt(stmt)
+	}
+	main := l.end(result)
+	l.globals = l.vars
+
+	for _, stmt := range program.Statements {
+		if fn, ok := stmt.(*ast.FunctionStatement); ok {
+			out.Funcs = append(out.Funcs, l.function(fn))
+		}
+	}
+	out.Funcs = append(out.Funcs, main)
+
+	return out
+}
+
+func (l *lowerer) begin(name string) {
+	l.fn = &Func{Name: name}
+	l.place(&Block{Name: "entry"})
+	l.vars = map[*types.Object]Reg{}
+	l.labels = 0
+}
+
+// end closes the current block with a return of
+// value and drops the blocks nothing jumps to
+func (l *lowerer) end(value Reg) *Func {
+	l.terminate(&Terminator{Kind: Return, Cond: NoReg, Value: value})
+	RemoveUnreachable(l.fn)
+	return l.fn
+}
+
+func (l *lowerer) function(fn *ast.FunctionStatement) *Func {
+	l.begin(fn.Name.Value)
+	for _, p := range fn.Parameters {
+		r := l.fn.NewReg()
+		l.fn.Params = append(l.fn.Params, r)
+		l.vars[l.info.Defs[p.Name]] = r
+	}
+
+	for _, stmt := range fn.Body.Statements {
+		l.stmt(stmt)
+	}
+	// functions with a result always return explicitly,
+	// this return is only reached by the ones without
+	return l.end(NoReg)
+}
+
+// newBlock creates a block that is only added to the
+// function once the lowering places code in it
+func (l *lowerer) newBlock(name string) *Block {
+	l.labels++
+	return &Block{Name: fmt.Sprintf("%s.%d", name, l.labels)}
+}
+
+func (l *lowerer) place(b *Block) {
+	l.fn.Blocks = append(l.fn.Blocks, b)
+	l.block = b
+}
+
+// terminate ends the current block, the statements that follow
+// it are unreachable and go to a block that is never placed
+func (l *lowerer) terminate(term *Terminator) {
+	term.Line = l.line
+	l.block.Term = term
+	l.block = &Block{Name: "dead"}
+}
+
+func (l *lowerer) jump(target *Block) {
+	l.terminate(&Terminator{Kind: Jump, Cond: NoReg, Value: NoReg, Targets: []*Block{target}})
+}
+
+func (l *lowerer) branch(cond Reg, then, els *Block) {
+	l.terminate(&Terminator{Kind: Branch, Cond: cond, Value: NoReg, Targets: []*Block{then, els}})
+}
+
+func (l *lowerer) emit(op Op, args ...Reg) Reg {
+	dst := l.fn.NewReg()
+	l.block.Instrs = append(l.block.Instrs, &Instr{Op: op, Dst: dst, Args: args, Line: l.line})
+	return dst
+}
+
+func (l *lowerer) emitConst(value int64) Reg {
+	dst := l.fn.NewReg()
+	l.block.Instrs = append(l.block.Instrs, &Instr{Op: Const, Dst: dst, Value: value, Line: l.line})
+	return dst
+}
+
+func (l *lowerer) emitCopy(dst, src Reg) {
+	l.block.Instrs = append(l.block.Instrs, &Instr{Op: Copy, Dst: dst, Args: []Reg{src}, Line: l.line})
+}
+
+// stmt lowers the statement and returns the register holding
+// the value it produced, NoReg when it does not produce one
+func (l *lowerer) stmt(stmt ast.Statement) Reg {
+	if _, isBlock := stmt.(*ast.BlockStatement); !isBlock {
+		l.line, _ = ast.Pos(stmt)
+	}
+
+	switch s := stmt.(type) {
+	case *ast.LetStatement:
+		value := l.expr(s.Value)
+		// a variable must not share the register of another one
+		if _, isVar := s.Value.(*ast.Identifier); isVar {
+			dst := l.fn.NewReg()
+			l.emitCopy(dst, value)
+			value = dst
+		}
+		l.vars[l.info.Defs[s.Name]] = value
+		return value
+
+	case *ast.AssignStatement:
+		obj := l.info.Uses[s.Name]
+		value := l.expr(s.Value)
+		if r, ok := l.vars[obj]; ok {
+			l.emitCopy(r, value)
+			break
+		}
+		l.block.Instrs = append(l.block.Instrs, &Instr{Op: Store, Dst: NoReg, Args: []Reg{value}, Global: l.globals[obj], Line: l.line})
+
+	case *ast.ExpressionStatement:
+		return l.expr(s.Expression)
+
+	case *ast.ReturnStatement:
+		value := NoReg
+		if s.ReturnValue != nil {
+			value = l.expr(s.ReturnValue)
+		}
+		l.terminate(&Terminator{Kind: Return, Cond: NoReg, Value: value})
+
+	case *ast.BlockStatement:
+		for _, inner := range s.Statements {
+			l.stmt(inner)
+		}
+
+	case *ast.IfStatement:
+		cond := l.expr(s.Condition)
+
+		then := l.newBlock("if.then")
+		var els *Block
+		if s.Alternative != nil {
+			els = l.newBlock("if.else")
+		}
+		join := l.newBlock("if.end")
+		if els == nil {
+			els = join
+		}
+
+		l.branch(cond, then, els)
+
+		l.place(then)
+		l.stmt(s.Consequence)
+		l.jump(join)
+
+		if s.Alternative != nil {
+			l.place(els)
+			l.stmt(s.Alternative)
+			l.jump(join)
+		}
+		l.place(join)
+
+	case *ast.WhileStatement:
+		cond := l.newBlock("while.cond")
+		body := l.newBlock("while.body")
+		exit := l.newBlock("while.end")
+
+		l.jump(cond)
+		l.place(cond)
+		l.branch(l.expr(s.Condition), body, exit)
+
+		l.place(body)
+		l.stmt(s.Body)
+		l.jump(cond)
+		l.place(exit)
+	}
+
+	return NoReg
+}
+
+func (l *lowerer) expr(e ast.Expression) Reg {
+	switch v := e.(type) {
+	case *ast.IntegerLiteral:
+		return l.emitConst(l.basic(v).Wrap(v.Value))
+
+	case *ast.Boolean:
+		if v.Value {
+			return l.emitConst(1)
+		}
+		return l.emitConst(0)
+
+	case *ast.Identifier:
+		obj := l.info.Uses[v]
+		if r, ok := l.vars[obj]; ok {
+			return r
+		}
+		dst := l.fn.NewReg()
+		l.block.Instrs = append(l.block.Instrs, &Instr{Op: Load, Dst: dst, Global: l.globals[obj], Line: l.line})
+		return dst
+
+	case *ast.PrefixExpression:
+		right := l.expr(v.Right)
+		switch v.Operator {
+		case "!":
+			return l.emit(Xor, right, l.emitConst(1))
+		default:
+			return l.wrap(v, l.emit(Sub, l.emitConst(0), right))
+		}
+
+	case *ast.InfixExpression:
+		if v.Operator == "and" || v.Operator == "or" {
+			return l.logical(v)
+		}
+		return l.infix(v)
+
+	case *ast.CallExpression:
+		args := make([]Reg, 0, len(v.Arguments))
+		for i, arg := range v.Arguments {
+			args = append(args, l.operand(arg, v.Arguments[i+1:]...))
+		}
+
+		instr := &Instr{Op: Call, Dst: NoReg, Args: args, Callee: v.Function.(*ast.Identifier).Value, Line: l.line}
+		if l.info.TypeOf(v) != types.Unit {
+			instr.Dst = l.fn.NewReg()
+		}
+		l.block.Instrs = append(l.block.Instrs, instr)
+		return instr.Dst
+	}
+
+	panic(fmt.Sprintf("ir: unexpected expression %T", e))
+}
+
+// operand lowers e when the expressions after it are still to be
+// evaluated. The globals are read from the registers of main, a
+// call among the later expressions may assign the global e reads
+// so its value is copied first
+func (l *lowerer) operand(e ast.Expression, later ...ast.Expression) Reg {
+	r := l.expr(e)
+	if _, isVar := e.(*ast.Identifier); !isVar || l.fn.Name != MainFunc || !hasCall(later) {
+		return r
+	}
+	dst := l.fn.NewReg()
+	l.emitCopy(dst, r)
+	return dst
+}
+
+func hasCall(exprs []ast.Expression) bool {
+	found := false
+	for _, e := range exprs {
+		ast.Inspect(e, func(node ast.Node) bool {
+			_, isCall := node.(*ast.CallExpression)
+			found = found || isCall
+			return !found
+		})
+	}
+	return found
+}
+
+func (l *lowerer) infix(e *ast.InfixExpression) Reg {
+	left := l.operand(e.Left, e.Right)
+	right := l.expr(e.Right)
+	signed := types.IsSigned(l.info.TypeOf(e.Left))
+
+	switch e.Operator {
+	case "+":
+		return l.wrap(e, l.emit(Add, left, right))
+	case "-":
+		return l.wrap(e, l.emit(Sub, left, right))
+	case "*":
+		return l.wrap(e, l.emit(Mul, left, right))
+	case "/":
+		return l.emit(pick(signed, SDiv, UDiv), left, right)
+	case "<<":
+		return l.wrap(e, l.emit(Shl, left, right))
+	case ">>":
+		return l.emit(pick(signed, Sar, Shr), left, right)
+	case "<":
+		return l.emit(pick(signed, SLt, ULt), left, right)
+	case "<=":
+		return l.emit(pick(signed, SLe, ULe), left, right)
+	case ">":
+		return l.emit(pick(signed, SLt, ULt), right, left)
+	case ">=":
+		return l.emit(pick(signed, SLe, ULe), right, left)
+	case "==":
+		return l.emit(Eq, left, right)
+	case "!=":
+		return l.emit(Ne, left, right)
+	}
+
+	panic(fmt.Sprintf("ir: unexpected operator %s", e.Operator))
+}
+
+// logical lowers and/or evaluating the right
+// operand only when it decides the result
+func (l *lowerer) logical(e *ast.InfixExpression) Reg {
+	result := l.fn.NewReg()
+	l.emitCopy(result, l.expr(e.Left))
+
+	rhs := l.newBlock(e.Operator + ".rhs")
+	join := l.newBlock(e.Operator + ".end")
+	if e.Operator == "and" {
+		l.branch(result, rhs, join)
+	} else {
+		l.branch(result, join, rhs)
+	}
+
+	l.place(rhs)
+	l.emitCopy(result, l.expr(e.Right))
+	l.jump(join)
+
+	l.place(join)
+	return result
+}
+
+// wrap truncates the results of operations on types narrower
+// than the 16 bit registers of the target
+func (l *lowerer) wrap(e ast.Expression, r Reg) Reg {
+	t := l.basic(e)
+	if t.Bits() >= 16 {
+		return r
+	}
+	return l.emit(And, r, l.emitConst(1<<t.Bits()-1))
+}
+
+func (l *lowerer) basic(e ast.Expression) *types.Basic {
+	if b, ok := l.info.TypeOf(e).(*types.Basic); ok && b.IsInteger() {
+		return b
+	}
+	return types.Default
+}
+
+func pick(signed bool, signedOp, unsignedOp Op) Op {
+	if signed {
+		return signedOp
+	}
+	return unsignedOp
+}
+
+// RemoveUnreachable drops the blocks that cannot be
+// reached from the entry of the function and the
+// arguments the phis take from them
+func RemoveUnreachable(f *Func) {
+	if len(f.Blocks) == 0 {
+		return
+	}
+
+	reachable := map[*Block]bool{}
+	var visit func(b *Block)
+	visit = func(b *Block) {
+		if reachable[b] {
+			return
+		}
+		reachable[b] = true
+		for _, succ := range b.Succs() {
+			visit(succ)
+		}
+	}
+	visit(f.Blocks[0])
+
+	kept := f.Blocks[:0]
+	for _, b := range f.Blocks {
+		if reachable[b] {
+			kept = append(kept, b)
+		}
+	}
+	f.Blocks = kept
+
+	for _, b := range f.Blocks {
+		for _, instr := range b.Instrs {
+			if instr.Op != Phi {
+				continue
+			}
+			for k := len(instr.Preds) - 1; k >= 0; k-- {
+				if !reachable[instr.Preds[k]] {
+					instr.Args = slices.Delete(instr.Args, k, k+1)
+					instr.Preds = slices.Delete(instr.Preds, k, k+1)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package ir
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var errSyntax = errors.New("ir syntax error")
+
+type parser struct {
+	lines []string
+	line  int // index of the line being parsed
+
+	fn *Func
+	// terminators reference blocks by name, they are
+	// resolved once the whole function was read
+	pending []pendingTarget
+}
+
+type pendingTarget struct {
+	term  *Terminator
+	phi   *Instr
+	names []string
+	line  int
+}
+
+// Parse reads the textual form produced by Program.String,
+// lines starting with ; are comments
+func Parse(src string) (*Program, error) {
+	p := &parser{lines: strings.Split(src, "\n")}
+	program := &Program{}
+
+	for ; p.line < len(p.lines); p.line++ {
+		text := p.text()
+		if text == "" {
+			continue
+		}
+
+		fn, err := p.parseFunc(text)
+		if err != nil {
+			return nil, err
+		}
+		program.Funcs = append(program.Funcs, fn)
+	}
+
+	return program, nil
+}
+
+// text returns the current line without comments and surrounding spaces
+func (p *parser) text() string {
+	text := p.lines[p.line]
+	if idx := strings.Index(text, ";"); idx >= 0 {
+		text = text[:idx]
+	}
+	return strings.TrimSpace(text)
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return fmt.Errorf("%w: line %d: %s", errSyntax, p.line+1, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) parseFunc(header string) (*Func, error) {
+	rest, ok := strings.CutPrefix(header, "func ")
+	if !ok || !strings.HasSuffix(rest, "{") {
+		return nil, p.errorf("expected func declaration, got %q", header)
+	}
+	rest = strings.TrimSpace(strings.TrimSuffix(rest, "{"))
+
+	p.fn = &Func{}
+	p.pending = nil
+
+	name, params, err := p.parseCallLike(rest)
+	if err != nil {
+		return nil, err
+	}
+	p.fn.Name = name
+	p.fn.Params = params
+
+	var current *Block
+	for p.line++; p.line < len(p.lines); p.line++ {
+		text := p.text()
+		switch {
+		case text == "":
+			continue
+		case text == "}":
+			return p.fn, p.resolveTargets()
+		case strings.HasSuffix(text, ":"):
+			current = p.fn.NewBlock(strings.TrimSuffix(text, ":"))
+		default:
+			if current == nil {
+				return nil, p.errorf("instruction outside of a block")
+			}
+			if current.Term != nil {
+				return nil, p.errorf("instruction after the terminator of block %s", current.Name)
+			}
+			if err := p.parseLine(current, text); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return nil, p.errorf("missing } at the end of func %s", name)
+}
+
+func (p *parser) resolveTargets() error {
+	for _, pending := range p.pending {
+		for _, name := range pending.names {
+			b := p.fn.Block(name)
+			if b == nil {
+				return fmt.Errorf("%w: line %d: undefined block %s", errSyntax, pending.line+1, name)
+			}
+			if pending.phi != nil {
+				pending.phi.Preds = append(pending.phi.Preds, b)
+			} else {
+				pending.term.Targets = append(pending.term.Targets, b)
+			}
+		}
+	}
+	return nil
+}
+
+func (p *parser) parseLine(b *Block, text string) error {
+	fields := strings.Fields(text)
+	switch fields[0] {
+	case "jmp":
+		if len(fields) != 2 {
+			return p.errorf("jmp expects a single target")
+		}
+		b.Term = &Terminator{Kind: Jump, Cond: NoReg, Value: NoReg}
+		p.pending = append(p.pending, pendingTarget{term: b.Term, names: fields[1:], line: p.line})
+		return nil
+
+	case "br":
+		args := splitArgs(strings.TrimPrefix(text, "br"))
+		if len(args) != 3 {
+			return p.errorf("br expects a condition and two targets")
+		}
+		cond, err := p.parseReg(args[0])
+		if err != nil {
+			return err
+		}
+		b.Term = &Terminator{Kind: Branch, Cond: cond, Value: NoReg}
+		p.pending = append(p.pending, pendingTarget{term: b.Term, names: args[1:], line: p.line})
+		return nil
+
+	case "ret":
+		b.Term = &Terminator{Kind: Return, Cond: NoReg, Value: NoReg}
+		if len(fields) == 2 {
+			value, err := p.parseReg(fields[1])
+			if err != nil {
+				return err
+			}
+			b.Term.Value = value
+		} else if len(fields) > 2 {
+			return p.errorf("ret expects at most one value")
+		}
+		return nil
+	}
+
+	instr := &Instr{Dst: NoReg}
+	if lhs, rhs, ok := strings.Cut(text, "="); ok {
+		dst, err := p.parseReg(strings.TrimSpace(lhs))
+		if err != nil {
+			return err
+		}
+		instr.Dst = dst
+		text = strings.TrimSpace(rhs)
+	}
+
+	if rest, ok := strings.CutPrefix(text, "tail "); ok {
+		instr.Tail = true
+		text = strings.TrimSpace(rest)
+	}
+	opName, operands, _ := strings.Cut(text, " ")
+	op, ok := opsByName[opName]
+	if !ok {
+		return p.errorf("unknown operation %q", opName)
+	}
+	instr.Op = op
+	operands = strings.TrimSpace(operands)
+
+	switch {
+	case op == Const:
+		value, err := strconv.ParseInt(operands, 0, 64)
+		if err != nil {
+			return p.errorf("invalid constant %q", operands)
+		}
+		instr.Value = value
+
+	case op == Call:
+		callee, args, err := p.parseCallLike(operands)
+		if err != nil {
+			return err
+		}
+		instr.Callee = callee
+		instr.Args = args
+
+	case op == Load || op == Store:
+		args := splitArgs(operands)
+		want := 1
+		if op == Store {
+			want = 2
+		}
+		if len(args) != want {
+			return p.errorf("%s expects %d operands, got %d", op, want, len(args))
+		}
+		n, err := strconv.Atoi(strings.TrimPrefix(args[0], "@"))
+		if !strings.HasPrefix(args[0], "@") || err != nil || n < 0 {
+			return p.errorf("invalid register of main %q", args[0])
+		}
+		instr.Global = Reg(n)
+		if op == Store {
+			r, err := p.parseReg(args[1])
+			if err != nil {
+				return err
+			}
+			instr.Args = []Reg{r}
+		}
+
+	case op == Phi:
+		pending := pendingTarget{phi: instr, line: p.line}
+		for _, pair := range strings.Split(operands, "]") {
+			pair = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(pair), ","))
+			if pair == "" {
+				continue
+			}
+			inner, ok := strings.CutPrefix(pair, "[")
+			args := splitArgs(inner)
+			if !ok || len(args) != 2 {
+				return p.errorf("phi expects [register, block] pairs, got %q", pair)
+			}
+			r, err := p.parseReg(args[0])
+			if err != nil {
+				return err
+			}
+			instr.Args = append(instr.Args, r)
+			pending.names = append(pending.names, args[1])
+		}
+		if len(instr.Args) == 0 {
+			return p.errorf("phi expects [register, block] pairs")
+		}
+		p.pending = append(p.pending, pending)
+
+	default:
+		for _, arg := range splitArgs(operands) {
+			r, err := p.parseReg(arg)
+			if err != nil {
+				return err
+			}
+			instr.Args = append(instr.Args, r)
+		}
+
+		want := 2
+		if op == Copy {
+			want = 1
+		}
+		if len(instr.Args) != want {
+			return p.errorf("%s expects %d operands, got %d", op, want, len(instr.Args))
+		}
+	}
+
+	if instr.Dst == NoReg && op != Call && op != Store {
+		return p.errorf("%s needs a destination register", op)
+	}
+	if instr.Dst != NoReg && op == Store {
+		return p.errorf("store has no destination register")
+	}
+
+	b.Instrs = append(b.Instrs, instr)
+	return nil
+}
+
+// parseCallLike parses name(%a, %b)
+func (p *parser) parseCallLike(text string) (string, []Reg, error) {
+	open := strings.Index(text, "(")
+	if open <= 0 || !strings.HasSuffix(text, ")") {
+		return "", nil, p.errorf("expected name(registers), got %q", text)
+	}
+
+	regs := []Reg{}
+	for _, arg := range splitArgs(text[open+1 : len(text)-1]) {
+		r, err := p.parseReg(arg)
+		if err != nil {
+			return "", nil, err
+		}
+		regs = append(regs, r)
+	}
+	return strings.TrimSpace(text[:open]), regs, nil
+}
+
+func (p *parser) parseReg(text string) (Reg, error) {
+	n, err := strconv.Atoi(strings.TrimPrefix(text, "%"))
+	if !strings.HasPrefix(text, "%") || err != nil || n < 0 {
+		return NoReg, p.errorf("invalid register %q", text)
+	}
+	p.useReg(Reg(n))
+	return Reg(n), nil
+}
+
+func (p *parser) useReg(r Reg) {
+	if int(r) >= p.fn.NumRegs {
+		p.fn.NumRegs = int(r) + 1
+	}
+}
+
+func splitArgs(text string) []string {
+	var args []string
+	for _, arg := range strings.Split(text, ",") {
+		if arg = strings.TrimSpace(arg); arg != "" {
+			args = append(args, arg)
+		}
+	}
+	return args
+}
@@ -0,0 +1,135 @@
+package ir
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Pass transforms a function in SSA form, memory are the registers
+// of main other functions reach through Load and Store, they are
+// not values of SSA form
+type Pass struct {
+	Name string
+	Run  func(f *Func, memory RegSet)
+	// Whole runs instead of Run for the passes looking across the
+	// functions, globals are the registers in memory of main
+	Whole func(p *Program, globals RegSet)
+}
+
+// Optimize takes the functions of p to SSA form, runs the passes
+// over them in order and translates them back for the backends. The
+// verifier checks the program after every step, the error names the
+// step that broke it
+func Optimize(p *Program, passes ...Pass) error {
+	if err := Verify(p); err != nil {
+		return fmt.Errorf("before optimizing: %w", err)
+	}
+
+	globals := p.Globals()
+	each := func(run func(f *Func, memory RegSet)) {
+		for _, f := range p.Funcs {
+			memory := RegSet{}
+			if f.Name == MainFunc {
+				memory = globals
+			}
+			run(f, memory)
+		}
+	}
+
+	each(ToSSA)
+	if err := verify(p, true, globals); err != nil {
+		return fmt.Errorf("ssa: %w", err)
+	}
+	for _, pass := range passes {
+		if pass.Whole != nil {
+			pass.Whole(p, globals)
+		} else {
+			each(pass.Run)
+		}
+		if err := verify(p, true, globals); err != nil {
+			return fmt.Errorf("%s: %w", pass.Name, err)
+		}
+	}
+	each(func(f *Func, _ RegSet) { FromSSA(f) })
+	if err := Verify(p); err != nil {
+		return fmt.Errorf("out of ssa: %w", err)
+	}
+	return nil
+}
+
+func (f *Func) isParam(r Reg) bool {
+	return slices.Contains(f.Params, r)
+}
+
+// definitions returns the instruction writing each register of a
+// function in SSA form, the registers in memory have none
+func definitions(f *Func, memory RegSet) map[Reg]*Instr {
+	defs := map[Reg]*Instr{}
+	for _, b := range f.Blocks {
+		for _, instr := range b.Instrs {
+			if d := instr.Def(); d != NoReg && !memory[d] {
+				defs[d] = instr
+			}
+		}
+	}
+	return defs
+}
+
+// replace makes every read of a register of repl read its
+// replacement, following chains of replacements
+func replace(f *Func, repl map[Reg]Reg) {
+	if len(repl) == 0 {
+		return
+	}
+	find := func(r Reg) Reg {
+		for {
+			next, ok := repl[r]
+			if !ok {
+				return r
+			}
+			r = next
+		}
+	}
+	for _, b := range f.Blocks {
+		for _, instr := range b.Instrs {
+			for k, r := range instr.Args {
+				instr.Args[k] = find(r)
+			}
+		}
+		if t := b.Term; t != nil {
+			if t.Kind == Branch {
+				t.Cond = find(t.Cond)
+			}
+			if t.Kind == Return && t.Value != NoReg {
+				t.Value = find(t.Value)
+			}
+		}
+	}
+}
+
+// dropEdge forgets the arguments the phis of to take from from
+func dropEdge(from, to *Block) {
+	for _, instr := range to.Instrs {
+		if instr.Op != Phi {
+			continue
+		}
+		if k := slices.Index(instr.Preds, from); k >= 0 {
+			instr.Args = slices.Delete(instr.Args, k, k+1)
+			instr.Preds = slices.Delete(instr.Preds, k, k+1)
+		}
+	}
+}
+
+// foldBranch turns the branch ending b into a jump to the target
+// taken when the condition is not zero or to the other one
+func foldBranch(b *Block, taken bool) {
+	t := b.Term
+	target, dropped := t.Targets[0], t.Targets[1]
+	if !taken {
+		target, dropped = dropped, target
+	}
+	if dropped != target {
+		dropEdge(b, dropped)
+	}
+	b.Term = &Terminator{Kind: Jump, Cond: NoReg, Value: NoReg, Targets: []*Block{target}, Line: t.Line}
+}
@@ -0,0 +1,68 @@
+package ir
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Levels returns the pipelines of the optimization levels of stag
+// build, costs are the estimates of the target the inliner weighs
+// the calls with. O0 compiles the program as it was lowered, Os
+// inlines and threads jumps only where the code does not grow
+func Levels(costs CostModel) map[string][]Pass {
+	return map[string][]Pass{
+		"O0": nil,
+		"O1": {SCCP, DCE, Simplify, TailCalls},
+		"O2": {Inline(costs, 16), SCCP, GVN, DSE, DCE, Thread(4), Simplify, TailCalls, SCCP, GVN, DCE, Simplify},
+		"Os": {Inline(costs, 0), SCCP, GVN, DSE, DCE, Thread(0), Simplify, TailCalls, SCCP, DCE, Simplify},
+	}
+}
+
+// DefaultLevel is the optimization level when none is given
+const DefaultLevel = "O1"
+
+func registry(costs CostModel) map[string]Pass {
+	passes := map[string]Pass{}
+	for _, pass := range []Pass{SCCP, GVN, DCE, DSE, Simplify, Thread(4), Inline(costs, 16), TailCalls} {
+		passes[pass.Name] = pass
+	}
+	return passes
+}
+
+// LookupPass returns the pass with the given name, the inliner
+// weighs the calls with costs
+func LookupPass(name string, costs CostModel) (Pass, error) {
+	pass, ok := registry(costs)[name]
+	if !ok {
+		return Pass{}, fmt.Errorf("unknown pass %q, the passes are %s", name, strings.Join(PassNames(), ", "))
+	}
+	return pass, nil
+}
+
+// PassNames returns the names of the passes in order
+func PassNames() []string {
+	passes := registry(nil)
+	names := make([]string, 0, len(passes))
+	for name := range passes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Pipeline returns the passes named in a comma separated list
+func Pipeline(list string, costs CostModel) ([]Pass, error) {
+	var out []Pass
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		pass, err := LookupPass(name, costs)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, pass)
+	}
+	return out, nil
+}
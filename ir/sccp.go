@@ -0,0 +1,245 @@
+package ir
+
+import (
+	"cmp"
+	"slices"
+)
+
+// SCCP is the sparse conditional constant propagation of Wegman and
+// Zadeck, the registers holding the same constant on every path the
+// control can take become constants and the branches on them jumps
+var SCCP = Pass{Name: "sccp", Run: sccp}
+
+type latticeKind uint8
+
+const (
+	unknown  latticeKind = iota // no write reached yet
+	constant                    // the same word on every path so far
+	varying                     // different words or not known at compile time
+)
+
+type lattice struct {
+	kind  latticeKind
+	value uint16
+}
+
+type edge struct {
+	from, to *Block
+}
+
+type propagator struct {
+	f      *Func
+	memory RegSet
+	values map[Reg]lattice
+
+	blocks map[*Block]bool
+	edges  map[edge]bool
+
+	// where each register is read, the instructions and the
+	// terminators of their blocks
+	users   map[Reg][]*Instr
+	branch  map[Reg][]*Block
+	blockOf map[*Instr]*Block
+
+	flow []edge
+	ssa  []Reg
+}
+
+func sccp(f *Func, memory RegSet) {
+	p := &propagator{
+		f:       f,
+		memory:  memory,
+		values:  map[Reg]lattice{},
+		blocks:  map[*Block]bool{},
+		edges:   map[edge]bool{},
+		users:   map[Reg][]*Instr{},
+		branch:  map[Reg][]*Block{},
+		blockOf: map[*Instr]*Block{},
+	}
+	for _, b := range f.Blocks {
+		for _, instr := range b.Instrs {
+			p.blockOf[instr] = b
+			for _, r := range instr.Args {
+				p.users[r] = append(p.users[r], instr)
+			}
+		}
+		if b.Term != nil && b.Term.Kind == Branch {
+			p.branch[b.Term.Cond] = append(p.branch[b.Term.Cond], b)
+		}
+	}
+
+	p.flow = append(p.flow, edge{nil, f.Blocks[0]})
+	for len(p.flow) > 0 || len(p.ssa) > 0 {
+		for len(p.flow) > 0 {
+			e := p.flow[len(p.flow)-1]
+			p.flow = p.flow[:len(p.flow)-1]
+			p.follow(e)
+		}
+		for len(p.ssa) > 0 {
+			r := p.ssa[len(p.ssa)-1]
+			p.ssa = p.ssa[:len(p.ssa)-1]
+			for _, instr := range p.users[r] {
+				if p.blocks[p.blockOf[instr]] {
+					p.visit(instr)
+				}
+			}
+			for _, b := range p.branch[r] {
+				if p.blocks[b] {
+					p.visitTerm(b)
+				}
+			}
+		}
+	}
+
+	p.rewrite()
+}
+
+// follow takes an edge for the first time, the phis of its target
+// see a new value and a block reached the first time runs whole
+func (p *propagator) follow(e edge) {
+	if p.edges[e] {
+		return
+	}
+	p.edges[e] = true
+
+	b := e.to
+	if p.blocks[b] {
+		for _, instr := range b.Instrs {
+			if instr.Op == Phi {
+				p.visit(instr)
+			}
+		}
+		return
+	}
+	p.blocks[b] = true
+	for _, instr := range b.Instrs {
+		p.visit(instr)
+	}
+	p.visitTerm(b)
+}
+
+func (p *propagator) value(r Reg) lattice {
+	if p.memory[r] || p.f.isParam(r) {
+		return lattice{kind: varying}
+	}
+	return p.values[r]
+}
+
+// set lowers the value of r, the values only go down from unknown
+// to constant to varying so the propagation ends
+func (p *propagator) set(r Reg, v lattice) {
+	if r == NoReg || p.memory[r] {
+		return
+	}
+	old := p.values[r]
+	if old.kind == varying || old == v {
+		return
+	}
+	if old.kind == constant && v.kind == constant {
+		v = lattice{kind: varying}
+	}
+	if v.kind < old.kind {
+		return
+	}
+	p.values[r] = v
+	p.ssa = append(p.ssa, r)
+}
+
+func (p *propagator) visit(instr *Instr) {
+	switch {
+	case instr.Op == Const:
+		p.set(instr.Dst, lattice{kind: constant, value: uint16(instr.Value)})
+
+	case instr.Op == Copy:
+		p.set(instr.Dst, p.value(instr.Args[0]))
+
+	case instr.Op == Phi:
+		b := p.blockOf[instr]
+		merged := lattice{}
+		for k, r := range instr.Args {
+			if !p.edges[edge{instr.Preds[k], b}] {
+				continue
+			}
+			v := p.value(r)
+			switch {
+			case v.kind == unknown:
+			case merged.kind == unknown:
+				merged = v
+			case v != merged:
+				merged = lattice{kind: varying}
+			}
+		}
+		p.set(instr.Dst, merged)
+
+	case instr.Op.IsBinary():
+		a, b := p.value(instr.Args[0]), p.value(instr.Args[1])
+		switch {
+		case a.kind == varying || b.kind == varying:
+			p.set(instr.Dst, lattice{kind: varying})
+		case a.kind == constant && b.kind == constant:
+			if result, ok := instr.Op.Eval(a.value, b.value); ok {
+				p.set(instr.Dst, lattice{kind: constant, value: result})
+			} else {
+				p.set(instr.Dst, lattice{kind: varying})
+			}
+		}
+
+	default:
+		p.set(instr.Dst, lattice{kind: varying})
+	}
+}
+
+func (p *propagator) visitTerm(b *Block) {
+	t := b.Term
+	switch t.Kind {
+	case Jump:
+		p.flow = append(p.flow, edge{b, t.Targets[0]})
+	case Branch:
+		cond := p.value(t.Cond)
+		switch {
+		case cond.kind == constant && cond.value != 0:
+			p.flow = append(p.flow, edge{b, t.Targets[0]})
+		case cond.kind == constant:
+			p.flow = append(p.flow, edge{b, t.Targets[1]})
+		case cond.kind == varying:
+			p.flow = append(p.flow, edge{b, t.Targets[0]}, edge{b, t.Targets[1]})
+		}
+	}
+}
+
+// rewrite turns the instructions computing constants into constants
+// and the branches on them into jumps, the blocks no edge taken
+// reaches go away
+func (p *propagator) rewrite() {
+	for _, b := range p.f.Blocks {
+		if !p.blocks[b] {
+			continue
+		}
+		for _, instr := range b.Instrs {
+			v := p.values[instr.Dst]
+			if instr.Dst == NoReg || p.memory[instr.Dst] || v.kind != constant || instr.Op == Call {
+				continue
+			}
+			*instr = Instr{Op: Const, Dst: instr.Dst, Value: int64(v.value), Line: instr.Line}
+		}
+		// the phis turned constants leave the start of the block
+		// to the ones left
+		slices.SortStableFunc(b.Instrs, func(x, y *Instr) int {
+			return cmp.Compare(phiFirst(x), phiFirst(y))
+		})
+
+		if t := b.Term; t.Kind == Branch {
+			if cond := p.value(t.Cond); cond.kind == constant {
+				foldBranch(b, cond.value != 0)
+			}
+		}
+	}
+	RemoveUnreachable(p.f)
+}
+
+func phiFirst(instr *Instr) int {
+	if instr.Op == Phi {
+		return 0
+	}
+	return 1
+}
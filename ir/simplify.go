@@ -0,0 +1,265 @@
+package ir
+
+import "slices"
+
+// Simplify cleans the graph up: the branches on constants become
+// jumps, the blocks no path reaches go away, a jump to a block with
+// nothing but a jump goes straight to its target and a block jumping
+// to one only it jumps to takes its instructions
+var Simplify = Pass{Name: "simplify", Run: simplify}
+
+// Thread returns the jump threading pass. An edge into a block whose
+// branch takes the same way every time the control comes along it
+// goes to a copy of the block that jumps instead, limit bounds the
+// instructions copied, with none only the blocks made of phis and a
+// branch are threaded
+func Thread(limit int) Pass {
+	return Pass{Name: "thread", Run: func(f *Func, memory RegSet) { thread(f, memory, limit) }}
+}
+
+func simplify(f *Func, memory RegSet) {
+	defs := definitions(f, memory)
+	for _, b := range f.Blocks {
+		if t := b.Term; t.Kind == Branch {
+			if c, ok := defs[t.Cond]; ok && c.Op == Const {
+				foldBranch(b, uint16(c.Value) != 0)
+			}
+		}
+	}
+	RemoveUnreachable(f)
+
+	for changed := true; changed; {
+		changed = forward(f) || merge(f)
+	}
+}
+
+// forward makes the jumps to a block with no instructions that
+// jumps elsewhere go to its target, when the phis there let them
+func forward(f *Func) bool {
+	changed := false
+	preds := Preds(f)
+	for _, b := range f.Blocks[1:] {
+		if len(b.Instrs) > 0 || b.Term.Kind != Jump || b.Term.Targets[0] == b {
+			continue
+		}
+		target := b.Term.Targets[0]
+		for _, p := range preds[b] {
+			// the phis of the target can not tell p from b
+			// when p already jumps there
+			if p.Term.Kind != Jump || slices.Contains(preds[target], p) {
+				continue
+			}
+			for _, phi := range target.Instrs {
+				if phi.Op == Phi {
+					phi.Args = append(phi.Args, phi.Args[slices.Index(phi.Preds, b)])
+					phi.Preds = append(phi.Preds, p)
+				}
+			}
+			p.Term.Targets[0] = target
+			dropEdge(p, b)
+			preds[target] = append(preds[target], p)
+			changed = true
+		}
+	}
+	RemoveUnreachable(f)
+	return changed
+}
+
+// merge appends to a block ending in a jump the block it jumps to
+// when no other block jumps there
+func merge(f *Func) bool {
+	preds := Preds(f)
+	for _, b := range f.Blocks {
+		if b.Term.Kind != Jump {
+			continue
+		}
+		next := b.Term.Targets[0]
+		if next == b || next == f.Blocks[0] || len(preds[next]) != 1 {
+			continue
+		}
+
+		repl := map[Reg]Reg{}
+		for _, instr := range next.Instrs {
+			if instr.Op == Phi {
+				repl[instr.Dst] = instr.Args[0]
+			} else {
+				b.Instrs = append(b.Instrs, instr)
+			}
+		}
+		b.Term = next.Term
+		for _, succ := range next.Succs() {
+			for _, phi := range succ.Instrs {
+				if phi.Op == Phi {
+					phi.Preds[slices.Index(phi.Preds, next)] = b
+				}
+			}
+		}
+		f.Blocks = slices.DeleteFunc(f.Blocks, func(x *Block) bool { return x == next })
+		replace(f, repl)
+		return true
+	}
+	return false
+}
+
+func thread(f *Func, memory RegSet, limit int) {
+	// the copies are not threaded again, the constants they pass
+	// around a loop could unroll it for ever
+	made := map[*Block]bool{}
+	for changed := true; changed; {
+		changed = false
+		preds := Preds(f)
+		for _, b := range f.Blocks {
+			for _, p := range preds[b] {
+				if made[p] {
+					continue
+				}
+				if copy := threadEdge(f, memory, p, b, limit); copy != nil {
+					made[copy] = true
+					changed = true
+					break
+				}
+			}
+			if changed {
+				break
+			}
+		}
+		RemoveUnreachable(f)
+	}
+}
+
+// threadEdge threads the edge from p to b when the branch of b takes
+// the same target every time the control comes from p and returns
+// the copy of b. The values b computes must only be read in b and by
+// the phis of its targets, the copy computes them again
+func threadEdge(f *Func, memory RegSet, p, b *Block, limit int) *Block {
+	t := b.Term
+	if t.Kind != Branch || b == p || b == f.Blocks[0] {
+		return nil
+	}
+	copied := 0
+	for _, instr := range b.Instrs {
+		if instr.Op != Phi {
+			copied++
+		}
+	}
+	if copied > limit || !local(f, b) {
+		return nil
+	}
+
+	// the constants known on the way from p
+	defs := definitions(f, memory)
+	known := map[Reg]uint16{}
+	value := func(r Reg) (uint16, bool) {
+		if v, ok := known[r]; ok {
+			return v, true
+		}
+		if d, ok := defs[r]; ok && d.Op == Const {
+			return uint16(d.Value), true
+		}
+		return 0, false
+	}
+	for _, instr := range b.Instrs {
+		switch {
+		case instr.Op == Phi:
+			if v, ok := value(instr.Args[slices.Index(instr.Preds, p)]); ok {
+				known[instr.Dst] = v
+			}
+		case instr.Op.IsBinary():
+			x, okx := value(instr.Args[0])
+			y, oky := value(instr.Args[1])
+			if okx && oky {
+				if v, ok := instr.Op.Eval(x, y); ok {
+					known[instr.Dst] = v
+				}
+			}
+		case instr.Op == Copy && !memory[instr.Dst] && !memory[instr.Args[0]]:
+			if v, ok := value(instr.Args[0]); ok {
+				known[instr.Dst] = v
+			}
+		case instr.Op == Const:
+			known[instr.Dst] = uint16(instr.Value)
+		}
+	}
+	cond, ok := value(t.Cond)
+	if !ok {
+		return nil
+	}
+	target := t.Targets[0]
+	if cond == 0 {
+		target = t.Targets[1]
+	}
+
+	// the copy of b for the edge, reading the values of p where b
+	// reads its phis and writing new registers
+	repl := map[Reg]Reg{}
+	find := func(r Reg) Reg {
+		if n, ok := repl[r]; ok {
+			return n
+		}
+		return r
+	}
+	thread := &Block{Name: f.uniqueName(b.Name + ".from." + p.Name)}
+	for _, instr := range b.Instrs {
+		if instr.Op == Phi {
+			repl[instr.Dst] = instr.Args[slices.Index(instr.Preds, p)]
+			continue
+		}
+		c := *instr
+		c.Args = make([]Reg, len(instr.Args))
+		for k, r := range instr.Args {
+			c.Args[k] = find(r)
+		}
+		if c.Dst != NoReg && !memory[c.Dst] {
+			c.Dst = f.NewReg()
+			repl[instr.Dst] = c.Dst
+		}
+		thread.Instrs = append(thread.Instrs, &c)
+	}
+	thread.Term = &Terminator{Kind: Jump, Cond: NoReg, Value: NoReg, Targets: []*Block{target}, Line: t.Line}
+
+	for _, phi := range target.Instrs {
+		if phi.Op == Phi {
+			phi.Args = append(phi.Args, find(phi.Args[slices.Index(phi.Preds, b)]))
+			phi.Preds = append(phi.Preds, thread)
+		}
+	}
+	for k, succ := range p.Term.Targets {
+		if succ == b {
+			p.Term.Targets[k] = thread
+		}
+	}
+	dropEdge(p, b)
+	f.Blocks = slices.Insert(f.Blocks, slices.Index(f.Blocks, p)+1, thread)
+	return thread
+}
+
+// local reports if the values b computes are only read in b and by
+// the phis of its targets coming from b
+func local(f *Func, b *Block) bool {
+	mine := RegSet{}
+	for _, instr := range b.Instrs {
+		if d := instr.Def(); d != NoReg {
+			mine[d] = true
+		}
+	}
+	for _, other := range f.Blocks {
+		for _, instr := range other.Instrs {
+			for k, r := range instr.Args {
+				if !mine[r] || other == b {
+					continue
+				}
+				if instr.Op != Phi || instr.Preds[k] != b {
+					return false
+				}
+			}
+		}
+		if other != b && other.Term != nil {
+			for _, r := range other.Term.Uses() {
+				if mine[r] {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
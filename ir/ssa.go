@@ -0,0 +1,298 @@
+package ir
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// Globals returns the registers of main the functions of the
+// program reach through Load and Store, calls may change them so
+// they stay out of SSA form
+func (p *Program) Globals() RegSet {
+	globals := RegSet{}
+	for _, f := range p.Funcs {
+		for _, b := range f.Blocks {
+			for _, instr := range b.Instrs {
+				if instr.Op == Load || instr.Op == Store {
+					globals[instr.Global] = true
+				}
+			}
+		}
+	}
+	return globals
+}
+
+// ToSSA rewrites f so every register but the ones in memory is
+// written once, with the pruned construction of Cytron et al. A phi
+// goes at the start of every block in the iterated dominance
+// frontier of the writes of a register where it is live, the writes
+// are renamed walking the dominator tree and the copies between
+// registers go away. The first write of a register keeps its name,
+// a register read before any write reads 0
+func ToSSA(f *Func, memory RegSet) {
+	if len(f.Blocks) == 0 {
+		return
+	}
+	prepare(f)
+	g := NewCFG(f)
+	live := Live(f)
+
+	// the blocks writing each register
+	defs := map[Reg][]*Block{}
+	for _, p := range f.Params {
+		defs[p] = append(defs[p], f.Blocks[0])
+	}
+	for _, b := range f.Blocks {
+		for _, instr := range b.Instrs {
+			if d := instr.Def(); d != NoReg && !memory[d] && !slices.Contains(defs[d], b) {
+				defs[d] = append(defs[d], b)
+			}
+		}
+	}
+
+	phis := map[*Instr]Reg{}
+	for _, v := range slices.Sorted(maps.Keys(defs)) {
+		placed := map[*Block]bool{}
+		work := slices.Clone(defs[v])
+		for len(work) > 0 {
+			b := work[len(work)-1]
+			work = work[:len(work)-1]
+			for _, join := range g.Frontier[b] {
+				if placed[join] || !live.In[join][v] {
+					continue
+				}
+				placed[join] = true
+				phi := &Instr{Op: Phi, Dst: v, Args: make([]Reg, len(g.Preds[join])), Preds: slices.Clone(g.Preds[join])}
+				n := slices.IndexFunc(join.Instrs, func(i *Instr) bool { return i.Op != Phi })
+				if n < 0 {
+					n = len(join.Instrs)
+				}
+				join.Instrs = slices.Insert(join.Instrs, n, phi)
+				phis[phi] = v
+				work = append(work, join)
+			}
+		}
+	}
+
+	r := &renamer{f: f, g: g, memory: memory, phis: phis, stacks: map[Reg][]Reg{}, taken: RegSet{}, undef: map[Reg]Reg{}}
+	for _, p := range f.Params {
+		r.taken[p] = true
+		r.stacks[p] = []Reg{p}
+	}
+	r.rename(f.Blocks[0])
+
+	// the registers read before any write are set in the entry
+	var zeros []*Instr
+	for _, v := range slices.Sorted(maps.Keys(r.undef)) {
+		zeros = append(zeros, &Instr{Op: Const, Dst: r.undef[v]})
+	}
+	f.Blocks[0].Instrs = append(zeros, f.Blocks[0].Instrs...)
+}
+
+// prepare leaves f in the shape the construction expects, without
+// the blocks the entry can not reach, without branches to the same
+// block twice and with an entry no block jumps back to
+func prepare(f *Func) {
+	RemoveUnreachable(f)
+	for _, b := range f.Blocks {
+		if t := b.Term; t != nil && t.Kind == Branch && t.Targets[0] == t.Targets[1] {
+			b.Term = &Terminator{Kind: Jump, Cond: NoReg, Value: NoReg, Targets: t.Targets[:1], Line: t.Line}
+		}
+	}
+	if len(Preds(f)[f.Blocks[0]]) > 0 {
+		entry := &Block{Name: f.uniqueName("entry"), Term: &Terminator{Kind: Jump, Cond: NoReg, Value: NoReg, Targets: []*Block{f.Blocks[0]}}}
+		f.Blocks = slices.Insert(f.Blocks, 0, entry)
+	}
+}
+
+type renamer struct {
+	f      *Func
+	g      *CFG
+	memory RegSet
+	phis   map[*Instr]Reg // the register each phi placed merges
+	// stacks hold the names of each register, the top one is the
+	// write that reaches the block being renamed
+	stacks map[Reg][]Reg
+	taken  RegSet
+	undef  map[Reg]Reg
+}
+
+// name returns the name of a new write of v
+func (r *renamer) name(v Reg) Reg {
+	if !r.taken[v] {
+		r.taken[v] = true
+		return v
+	}
+	return r.f.NewReg()
+}
+
+// current returns the name of the write of v reaching the block
+func (r *renamer) current(v Reg) Reg {
+	if r.memory[v] {
+		return v
+	}
+	if stack := r.stacks[v]; len(stack) > 0 {
+		return stack[len(stack)-1]
+	}
+	if _, ok := r.undef[v]; !ok {
+		r.undef[v] = r.name(v)
+	}
+	return r.undef[v]
+}
+
+func (r *renamer) rename(b *Block) {
+	var pushed []Reg
+	push := func(v, name Reg) {
+		r.stacks[v] = append(r.stacks[v], name)
+		pushed = append(pushed, v)
+	}
+
+	kept := b.Instrs[:0]
+	for _, instr := range b.Instrs {
+		if v, ok := r.phis[instr]; ok {
+			instr.Dst = r.name(v)
+			push(v, instr.Dst)
+			kept = append(kept, instr)
+			continue
+		}
+		for k, a := range instr.Args {
+			instr.Args[k] = r.current(a)
+		}
+		d := instr.Def()
+		switch {
+		case d == NoReg || r.memory[d]:
+		case instr.Op == Copy && !r.memory[instr.Args[0]]:
+			// the readers of the copy read its source
+			push(d, instr.Args[0])
+			continue
+		default:
+			instr.Dst = r.name(d)
+			push(d, instr.Dst)
+		}
+		kept = append(kept, instr)
+	}
+	b.Instrs = kept
+
+	if t := b.Term; t != nil {
+		if t.Kind == Branch {
+			t.Cond = r.current(t.Cond)
+		}
+		if t.Kind == Return && t.Value != NoReg {
+			t.Value = r.current(t.Value)
+		}
+	}
+
+	for _, succ := range b.Succs() {
+		for _, instr := range succ.Instrs {
+			v, ok := r.phis[instr]
+			if !ok {
+				continue
+			}
+			k := slices.Index(instr.Preds, b)
+			instr.Args[k] = r.current(v)
+		}
+	}
+
+	for _, child := range r.g.Children[b] {
+		r.rename(child)
+	}
+	for _, v := range pushed {
+		r.stacks[v] = r.stacks[v][:len(r.stacks[v])-1]
+	}
+}
+
+// FromSSA replaces the phis of f by copies at the end of the blocks
+// the control comes from. The edges leaving a branch get a block of
+// their own first so the copies only run on their way, and the
+// copies of a block happen at once, the ones reading a register
+// another one writes go first and a cycle goes through a new
+// register
+func FromSSA(f *Func) {
+	for _, b := range slices.Clone(f.Blocks) {
+		if t := b.Term; t != nil && t.Kind == Branch {
+			for k, succ := range t.Targets {
+				if !hasPhi(succ) {
+					continue
+				}
+				edge := &Block{Name: f.uniqueName(b.Name + "." + succ.Name), Term: &Terminator{Kind: Jump, Cond: NoReg, Value: NoReg, Targets: []*Block{succ}}}
+				// the branch falls through to its first target
+				// and jumps to the other one
+				if k == 0 {
+					f.Blocks = slices.Insert(f.Blocks, slices.Index(f.Blocks, b)+1, edge)
+				} else {
+					f.Blocks = append(f.Blocks, edge)
+				}
+				t.Targets[k] = edge
+				for _, phi := range succ.Instrs {
+					if phi.Op == Phi {
+						phi.Preds[slices.Index(phi.Preds, b)] = edge
+					}
+				}
+			}
+		}
+	}
+
+	copies := map[*Block][]*Instr{}
+	for _, b := range f.Blocks {
+		kept := b.Instrs[:0]
+		for _, instr := range b.Instrs {
+			if instr.Op != Phi {
+				kept = append(kept, instr)
+				continue
+			}
+			for k, pred := range instr.Preds {
+				copies[pred] = append(copies[pred], &Instr{Op: Copy, Dst: instr.Dst, Args: []Reg{instr.Args[k]}})
+			}
+		}
+		b.Instrs = kept
+	}
+	for _, b := range f.Blocks {
+		if len(copies[b]) > 0 {
+			b.Instrs = append(b.Instrs, sequence(f, copies[b])...)
+		}
+	}
+}
+
+func hasPhi(b *Block) bool {
+	return len(b.Instrs) > 0 && b.Instrs[0].Op == Phi
+}
+
+// sequence orders copies meant to happen at once
+func sequence(f *Func, pending []*Instr) []*Instr {
+	pending = slices.DeleteFunc(pending, func(c *Instr) bool { return c.Dst == c.Args[0] })
+	read := func(r Reg) bool {
+		return slices.ContainsFunc(pending, func(c *Instr) bool { return c.Args[0] == r })
+	}
+
+	var out []*Instr
+	for len(pending) > 0 {
+		i := slices.IndexFunc(pending, func(c *Instr) bool { return !read(c.Dst) })
+		if i >= 0 {
+			out = append(out, pending[i])
+			pending = slices.Delete(pending, i, i+1)
+			continue
+		}
+		// every register written is read by another copy, the
+		// value of one of them is kept aside to break the cycle
+		saved := pending[0].Dst
+		tmp := f.NewReg()
+		out = append(out, &Instr{Op: Copy, Dst: tmp, Args: []Reg{saved}})
+		for _, c := range pending {
+			if c.Args[0] == saved {
+				c.Args[0] = tmp
+			}
+		}
+	}
+	return out
+}
+
+// uniqueName returns base or base with a number when a block of f
+// already has that name
+func (f *Func) uniqueName(base string) string {
+	name := base
+	for n := 1; f.Block(name) != nil; n++ {
+		name = fmt.Sprintf("%s.%d", base, n)
+	}
+	return name
+}
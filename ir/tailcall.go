@@ -0,0 +1,86 @@
+package ir
+
+import "slices"
+
+// TailCalls handles the calls a function returns the value of. The
+// ones to the function itself jump back to its start with the new
+// arguments in phis of the parameters, the others are marked tail
+// for the backend to reuse the frame of the caller. Main keeps its
+// calls, its frame holds the registers other functions reach
+var TailCalls = Pass{Name: "tailcall", Run: tailCalls}
+
+func tailCalls(f *Func, _ RegSet) {
+	if f.Name == MainFunc {
+		return
+	}
+	var start *Block
+	var params []*Instr
+	for _, b := range slices.Clone(f.Blocks) {
+		n := len(b.Instrs)
+		if n == 0 || b.Term.Kind != Return {
+			continue
+		}
+		call := b.Instrs[n-1]
+		if call.Op != Call || call.Dst != b.Term.Value {
+			continue
+		}
+		// a call without a value returns what b returns only when
+		// they both return nothing, for f that is when it never
+		// returns a value
+		if call.Callee != f.Name {
+			call.Tail = call.Dst != NoReg
+			continue
+		}
+		if call.Dst == NoReg && !void(f) {
+			continue
+		}
+
+		if start == nil {
+			start, params = loopStart(f)
+		}
+		for k, phi := range params {
+			phi.Args = append(phi.Args, call.Args[k])
+			phi.Preds = append(phi.Preds, b)
+		}
+		b.Instrs = b.Instrs[:n-1]
+		b.Term = &Terminator{Kind: Jump, Cond: NoReg, Value: NoReg, Targets: []*Block{start}, Line: call.Line}
+	}
+}
+
+// void reports if no return of f has a value
+func void(f *Func) bool {
+	for _, b := range f.Blocks {
+		if b.Term.Kind == Return && b.Term.Value != NoReg {
+			return false
+		}
+	}
+	return true
+}
+
+// loopStart moves the instructions of the entry of f to a block of
+// their own the entry jumps to, with a phi for each parameter read
+// instead of it
+func loopStart(f *Func) (*Block, []*Instr) {
+	entry := f.Blocks[0]
+	start := &Block{Name: f.uniqueName("start"), Instrs: entry.Instrs, Term: entry.Term}
+	for _, succ := range start.Succs() {
+		for _, phi := range succ.Instrs {
+			if phi.Op == Phi {
+				phi.Preds[slices.Index(phi.Preds, entry)] = start
+			}
+		}
+	}
+	entry.Instrs = nil
+	entry.Term = &Terminator{Kind: Jump, Cond: NoReg, Value: NoReg, Targets: []*Block{start}, Line: start.Term.Line}
+	f.Blocks = slices.Insert(f.Blocks, 1, start)
+
+	repl := map[Reg]Reg{}
+	params := make([]*Instr, len(f.Params))
+	for k, r := range f.Params {
+		params[k] = &Instr{Op: Phi, Dst: f.NewReg(), Args: []Reg{r}, Preds: []*Block{entry}}
+		repl[r] = params[k].Dst
+	}
+	replace(f, repl)
+	start.Instrs = append(params, start.Instrs...)
+	return start, params
+}
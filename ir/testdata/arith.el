@@ -0,0 +1,5 @@
+let x = 55090 + 5;
+let y: u8 = 200;
+let z = y * 2 - 1;
+let s: i16 = -7;
+s / 2 < 3
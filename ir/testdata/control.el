@@ -0,0 +1,18 @@
+fn max(a: i16, b: i16) -> i16 {
+	if a > b {
+		return a;
+	}
+	return b;
+}
+
+fn sum(n: u16) -> u16 {
+	let total = 0;
+	let i = 1;
+	while i <= n {
+		total = total + i;
+		i = i + 1;
+	}
+	return total;
+}
+
+let ok = sum(4) == 10 and max(-1, 2) != 2 or false;
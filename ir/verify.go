@@ -0,0 +1,297 @@
+package ir
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+var errInvalid = errors.New("invalid ir")
+
+type verifier struct {
+	p   *Program
+	f   *Func
+	b   *Block
+	ssa bool
+}
+
+func (v *verifier) errorf(format string, args ...any) error {
+	where := "func " + v.f.Name
+	if v.b != nil {
+		where += ", block " + v.b.Name
+	}
+	return fmt.Errorf("%w: %s: %s", errInvalid, where, fmt.Sprintf(format, args...))
+}
+
+// Verify checks the invariants the backends rely on and reports
+// the first one broken: every block ends with a terminator jumping
+// to blocks of the function, the instructions have the operands
+// their operation expects, the registers are in the function and
+// the calls name a function with as many parameters as arguments,
+// the tail calls right before the return of their value. The phis
+// of SSA form are not allowed
+func Verify(p *Program) error {
+	return verify(p, false, nil)
+}
+
+// VerifySSA checks the invariants of Verify and the ones of SSA
+// form: each register is written once, but the ones of main other
+// functions reach through Load and Store, the writes dominate the
+// reads and the phis start their blocks with an argument for each
+// predecessor. Branches to the same block twice are not allowed,
+// the phis could not tell which of both edges the control took
+func VerifySSA(p *Program) error {
+	return verify(p, true, p.Globals())
+}
+
+// verify takes the globals the program had going into SSA form, a
+// pass dropping the last Load of one leaves it in memory
+func verify(p *Program, ssa bool, globals RegSet) error {
+	names := map[string]bool{}
+	for _, f := range p.Funcs {
+		v := &verifier{p: p, f: f, ssa: ssa}
+		if names[f.Name] {
+			return v.errorf("defined twice")
+		}
+		names[f.Name] = true
+		if err := v.verify(); err != nil {
+			return err
+		}
+		if !ssa {
+			continue
+		}
+		memory := RegSet{}
+		if f.Name == MainFunc {
+			memory = globals
+		}
+		if err := v.verifySSA(memory); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *verifier) verify() error {
+	f := v.f
+	if len(f.Blocks) == 0 {
+		return v.errorf("no blocks")
+	}
+	for _, r := range f.Params {
+		if err := v.reg(r); err != nil {
+			return err
+		}
+	}
+
+	names := map[string]bool{}
+	for _, b := range f.Blocks {
+		v.b = b
+		if names[b.Name] {
+			return v.errorf("block defined twice")
+		}
+		names[b.Name] = true
+
+		for i, instr := range b.Instrs {
+			if err := v.instr(instr); err != nil {
+				return err
+			}
+			if instr.Tail && (i != len(b.Instrs)-1 || b.Term == nil || b.Term.Kind != Return || b.Term.Value != instr.Dst) {
+				return v.errorf("%s: not followed by the return of its value", instr)
+			}
+		}
+		if err := v.term(b.Term); err != nil {
+			return err
+		}
+	}
+	v.b = nil
+	return nil
+}
+
+func (v *verifier) reg(r Reg) error {
+	if r < 0 || int(r) >= v.f.NumRegs {
+		return v.errorf("register %s out of the %d of the function", r, v.f.NumRegs)
+	}
+	return nil
+}
+
+func (v *verifier) instr(instr *Instr) error {
+	want := 0
+	switch {
+	case instr.Op == Copy || instr.Op == Store:
+		want = 1
+	case instr.Op.IsBinary():
+		want = 2
+	case instr.Op == Call:
+		callee := v.p.Func(instr.Callee)
+		if callee == nil {
+			return v.errorf("%s: undefined function %s", instr, instr.Callee)
+		}
+		want = len(callee.Params)
+	case instr.Op == Phi:
+		if !v.ssa {
+			return v.errorf("%s: phi out of SSA form", instr)
+		}
+		if len(instr.Args) == 0 || len(instr.Args) != len(instr.Preds) {
+			return v.errorf("%s: expects a block for each argument", instr)
+		}
+		want = len(instr.Args)
+	case instr.Op == Const || instr.Op == Load:
+	default:
+		return v.errorf("unknown operation %s", instr.Op)
+	}
+	if len(instr.Args) != want {
+		return v.errorf("%s: expects %d operands", instr, want)
+	}
+
+	if instr.Tail && (instr.Op != Call || v.f.Name == MainFunc) {
+		return v.errorf("%s: only the calls of functions other than main can be tail calls", instr)
+	}
+
+	hasDst := instr.Op != Call && instr.Op != Store
+	switch {
+	case hasDst && instr.Dst == NoReg:
+		return v.errorf("%s: needs a destination register", instr)
+	case !hasDst && instr.Op == Store && instr.Dst != NoReg:
+		return v.errorf("%s: has no destination register", instr)
+	}
+	if instr.Dst != NoReg {
+		if err := v.reg(instr.Dst); err != nil {
+			return err
+		}
+	}
+	for _, r := range instr.Args {
+		if err := v.reg(r); err != nil {
+			return err
+		}
+	}
+
+	if instr.Op == Load || instr.Op == Store {
+		main := v.p.Func(MainFunc)
+		if main == nil || instr.Global < 0 || int(instr.Global) >= main.NumRegs {
+			return v.errorf("%s: no such register in main", instr)
+		}
+	}
+	return nil
+}
+
+func (v *verifier) term(t *Terminator) error {
+	if t == nil {
+		return v.errorf("no terminator")
+	}
+	want := map[TermKind]int{Jump: 1, Branch: 2, Return: 0}[t.Kind]
+	if t.Kind > Return || len(t.Targets) != want {
+		return v.errorf("%s: expects %d targets", t, want)
+	}
+	for _, target := range t.Targets {
+		if v.f.Block(target.Name) != target {
+			return v.errorf("%s: jumps out of the function", t)
+		}
+	}
+	switch {
+	case t.Kind == Branch:
+		if v.ssa && t.Targets[0] == t.Targets[1] {
+			return v.errorf("%s: both targets are the same block", t)
+		}
+		return v.reg(t.Cond)
+	case t.Kind == Return && t.Value != NoReg:
+		return v.reg(t.Value)
+	}
+	return nil
+}
+
+// where is the place of the write of a register, the parameters
+// are written before the first instruction of the entry
+type where struct {
+	b *Block
+	i int
+}
+
+func (v *verifier) verifySSA(memory RegSet) error {
+	f := v.f
+	g := NewCFG(f)
+
+	defs := map[Reg]where{}
+	def := func(r Reg, at where) error {
+		if memory[r] {
+			return nil
+		}
+		if _, dup := defs[r]; dup {
+			return v.errorf("%s written twice", r)
+		}
+		defs[r] = at
+		return nil
+	}
+	for _, p := range f.Params {
+		if err := def(p, where{f.Blocks[0], -1}); err != nil {
+			return err
+		}
+	}
+	for _, b := range f.Blocks {
+		v.b = b
+		for i, instr := range b.Instrs {
+			if d := instr.Def(); d != NoReg {
+				if err := def(d, where{b, i}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	// a read at is fine when the write dominates it
+	reaches := func(r Reg, at where) error {
+		if memory[r] {
+			return nil
+		}
+		d, ok := defs[r]
+		switch {
+		case !ok:
+			return v.errorf("%s read but never written", r)
+		case !g.Reachable(at.b):
+		case d.b == at.b && d.i >= at.i, !g.Dominates(d.b, at.b):
+			return v.errorf("%s read where its write does not dominate", r)
+		}
+		return nil
+	}
+
+	preds := Preds(f)
+	for _, b := range f.Blocks {
+		v.b = b
+		phis := true
+		for i, instr := range b.Instrs {
+			if instr.Op != Phi {
+				phis = false
+				for _, r := range instr.Uses() {
+					if err := reaches(r, where{b, i}); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			if !phis {
+				return v.errorf("%s: phi after the other instructions", instr)
+			}
+			if len(instr.Preds) != len(preds[b]) {
+				return v.errorf("%s: %d arguments for %d predecessors", instr, len(instr.Preds), len(preds[b]))
+			}
+			seen := map[*Block]bool{}
+			for k, pred := range instr.Preds {
+				if seen[pred] || !slices.Contains(preds[b], pred) {
+					return v.errorf("%s: %s is not a predecessor", instr, pred.Name)
+				}
+				seen[pred] = true
+				// the argument is read at the end of its block
+				if err := reaches(instr.Args[k], where{pred, len(pred.Instrs)}); err != nil {
+					return err
+				}
+			}
+		}
+		if b.Term != nil {
+			for _, r := range b.Term.Uses() {
+				if err := reaches(r, where{b, len(b.Instrs)}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	v.b = nil
+	return nil
+}
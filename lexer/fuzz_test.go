@@ -0,0 +1,47 @@
+package lexer_test
+
+import (
+	"stag/lexer"
+	"stag/primitives"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/require"
+)
+
+// the seed corpus is in testdata/fuzz, these are the inputs the
+// lexer used to get wrong
+func FuzzLexer(f *testing.F) {
+	for _, src := range []string{"=", "<", ">", "!", "-", "a\x00b", "// c\x00"} {
+		f.Add(src)
+	}
+
+	f.Fuzz(func(t *testing.T, src string) {
+		// every token but EOF takes at least a byte of the source
+		toks := lexer.Tokenize(src, lexer.MaxTokens(len(src)+1))
+		for _, tok := range toks {
+			require.Equal(t, tok.Literal, src[tok.Offset:tok.Offset+len(tok.Literal)])
+		}
+
+		// the reader sees the same tokens whatever the reads
+		var read []primitives.Token
+		r := lexer.NewReader(iotest.OneByteReader(strings.NewReader(src)), lexer.BufferSize(3), lexer.MaxTokens(len(src)+1))
+		for tok := range r.All() {
+			read = append(read, tok)
+		}
+		require.Equal(t, toks, read)
+
+		// and with the trivia the source comes back
+		var text strings.Builder
+		l := lexer.New(src, lexer.WithTrivia(), lexer.MaxTokens(len(src)+1))
+		for tok := l.NextToken(); ; tok = l.NextToken() {
+			leading, trailing := l.Trivia()
+			text.WriteString(primitives.Text(tok, leading, trailing))
+			if tok.Kind == primitives.EOF {
+				break
+			}
+		}
+		require.Equal(t, src, text.String())
+	})
+}
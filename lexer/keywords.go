@@ -9,6 +9,8 @@ var (
 	If     string = "if"
 	Else   string = "else"
 	While  string = "while"
+	True   string = "true"
+	False  string = "false"
 )
 
 var Keywords = map[string]struct{}{
@@ -20,4 +22,6 @@ var Keywords = map[string]struct{}{
 	Return: {},
 	And:    {},
 	Or:     {},
+	True:   {},
+	False:  {},
 }
@@ -1,218 +1,435 @@
 package lexer
 
 import (
+	"errors"
+	"io"
+	"iter"
 	"stag/primitives"
+	"strings"
 )
 
+// DefaultBufferSize is how many bytes a lexer made by NewReader
+// reads at a time
+const DefaultBufferSize = 4096
+
 type Lexer struct {
 	input       string
 	pos         int
 	nextPos     int
 	currentChar byte
+
+	// a lexer reading from r only keeps input from mark on, the
+	// start of what is being scanned, and refills it from buf
+	r    io.Reader
+	buf  []byte
+	mark int
+	err  error
+
+	// base is the offset of input in the whole source and names
+	// interns the literals copied out of the window
+	base  int
+	names map[string]string
+
+	// tokens scanned by Peek and not returned yet
+	ahead []primitives.Token
+
+	// how many tokens NextToken may still return, when limited
+	budget  int
+	limited bool
+
+	// line and column of currentChar, both starting at 1
+	line   int
+	column int
+
+	comments []primitives.Token
+	trivia   bool
+	// the trivia of the tokens in ahead, of the token scan made
+	// last and of the one NextToken returned last, the only ones
+	// kept so the tokens stay small values
+	aheadTrivia []tokenTrivia
+	scanned     tokenTrivia
+	last        tokenTrivia
+}
+
+type tokenTrivia struct {
+	leading, trailing []primitives.Trivia
+}
+
+// Option changes how the lexer scans its input
+type Option func(*Lexer)
+
+// WithTrivia keeps the blanks and the comments around the tokens
+// for Trivia, joining the text of every token up to EOF with its
+// trivia gives back the input
+func WithTrivia() Option {
+	return func(l *Lexer) { l.trivia = true }
+}
+
+// StartAt numbers the offsets, lines and columns as if the input
+// started at offset, line and column of a larger source
+func StartAt(offset, line, column int) Option {
+	return func(l *Lexer) { l.base, l.line, l.column = offset, line, column-1 }
+}
+
+// ErrTokenBudget is what NextToken panics with past the budget
+// set by MaxTokens
+var ErrTokenBudget = errors.New("lexer: token budget exhausted")
+
+// MaxTokens makes NextToken panic with ErrTokenBudget when it is
+// called more than n times, EOF included. It guards against callers
+// that keep asking for tokens without ever getting anywhere
+func MaxTokens(n int) Option {
+	return func(l *Lexer) { l.budget, l.limited = n, true }
+}
+
+// BufferSize sets how many bytes a lexer made by NewReader reads at
+// a time, the window it keeps grows past it only for longer tokens
+func BufferSize(n int) Option {
+	return func(l *Lexer) {
+		if l.r != nil {
+			l.buf = make([]byte, max(n, 1))
+		}
+	}
 }
 
-func New(input string) *Lexer {
-	l := &Lexer{input: input}
+func New(input string, opts ...Option) *Lexer {
+	l := &Lexer{input: input, line: 1}
+	for _, opt := range opts {
+		opt(l)
+	}
 	l.readChar()
 	return l
 }
 
-func (l *Lexer) NextToken() *primitives.Token {
-	var tok *primitives.Token
+// NewReader scans the source read from r without holding all of it,
+// the literals are interned copies out of the buffer. A read error
+// ends the input like EOF and is returned by Err
+func NewReader(r io.Reader, opts ...Option) *Lexer {
+	l := &Lexer{r: r, buf: make([]byte, DefaultBufferSize), names: map[string]string{}, line: 1}
+	for _, opt := range opts {
+		opt(l)
+	}
+	l.readChar()
+	return l
+}
 
-	l.skipWhiteSpace()
+// Tokenize scans the whole input, EOF left out
+func Tokenize(input string, opts ...Option) []primitives.Token {
+	var toks []primitives.Token
+	l := New(input, opts...)
+	for tok := l.NextToken(); tok.Kind != primitives.EOF; tok = l.NextToken() {
+		toks = append(toks, tok)
+	}
+	return toks
+}
 
-	switch l.currentChar {
-	case '=':
-		if l.input[l.nextPos] == '=' {
-			l.readChar()
-			tok = &primitives.Token{
-				Kind:    primitives.Equal,
-				Literal: "==",
-			}
-		} else {
-			tok = &primitives.Token{
-				Kind:    primitives.Assign,
-				Literal: string(l.currentChar),
+// All returns an iterator over the tokens left, EOF left out
+func (l *Lexer) All() iter.Seq[primitives.Token] {
+	return func(yield func(primitives.Token) bool) {
+		for tok := l.NextToken(); tok.Kind != primitives.EOF; tok = l.NextToken() {
+			if !yield(tok) {
+				return
 			}
 		}
-	case '<':
-		if l.input[l.nextPos] == '=' {
+	}
+}
+
+// Err returns the error that stopped reading the input, if any
+func (l *Lexer) Err() error {
+	return l.err
+}
+
+// Peek returns the token n places ahead without consuming it,
+// Peek(0) is the one NextToken returns next
+func (l *Lexer) Peek(n int) primitives.Token {
+	for len(l.ahead) <= n {
+		l.ahead = append(l.ahead, l.scan())
+		if l.trivia {
+			l.aheadTrivia = append(l.aheadTrivia, l.scanned)
+		}
+	}
+	return l.ahead[n]
+}
+
+// NextToken scans the next token and stamps it with the offset,
+// line and column where it starts
+func (l *Lexer) NextToken() primitives.Token {
+	if l.limited {
+		if l.budget == 0 {
+			panic(ErrTokenBudget)
+		}
+		l.budget--
+	}
+	if len(l.ahead) > 0 {
+		tok := l.ahead[0]
+		// shifting keeps the capacity for the next Peek
+		n := copy(l.ahead, l.ahead[1:])
+		l.ahead = l.ahead[:n]
+		if l.trivia {
+			l.last = l.aheadTrivia[0]
+			n := copy(l.aheadTrivia, l.aheadTrivia[1:])
+			l.aheadTrivia = l.aheadTrivia[:n]
+		}
+		return tok
+	}
+	tok := l.scan()
+	l.last = l.scanned
+	return tok
+}
+
+func (l *Lexer) scan() primitives.Token {
+	leading := l.scanTrivia(false)
+
+	tok := primitives.Token{SourceLine: l.line, SourceColumn: l.column, Offset: l.base + l.pos}
+	l.mark = l.pos
+	tok.Kind = l.scanToken()
+	tok.Literal = l.intern()
+	if tok.Kind == primitives.Ident {
+		if _, ok := Keywords[tok.Literal]; ok {
+			tok.Kind = primitives.Keyword
+		}
+	}
+
+	if l.trivia {
+		l.scanned = tokenTrivia{leading: leading}
+		if tok.Kind != primitives.EOF {
+			l.scanned.trailing = l.scanTrivia(true)
+		}
+	}
+	return tok
+}
+
+// Trivia returns the blanks and the comments around the token
+// NextToken returned last, with WithTrivia only. The trailing trivia
+// goes up to the end of the line and the leading one takes the rest
+func (l *Lexer) Trivia() (leading, trailing []primitives.Trivia) {
+	return l.last.leading, l.last.trailing
+}
+
+// scanToken moves past the token at the current char and returns
+// its kind, the literal is the input from mark up to where it
+// stops. Keywords come back as identifiers
+func (l *Lexer) scanToken() primitives.TokenKind {
+	ch := l.currentChar
+	if isLetter(ch) {
+		// digits are allowed after the first char, e.g. u16
+		for isLetter(l.currentChar) || isNumber(l.currentChar) {
 			l.readChar()
-			tok = &primitives.Token{
-				Kind:    primitives.LessOrEqual,
-				Literal: "<=",
-			}
-		} else {
-			tok = &primitives.Token{
-				Kind:    primitives.Less,
-				Literal: string(l.currentChar),
-			}
 		}
-	case '>':
-		if l.input[l.nextPos] == '=' {
+		return primitives.Ident
+	}
+	if isNumber(ch) {
+		for isNumber(l.currentChar) {
 			l.readChar()
-			tok = &primitives.Token{
-				Kind:    primitives.GreaterOrEqual,
-				Literal: ">=",
-			}
-		} else {
-			tok = &primitives.Token{
-				Kind:    primitives.Greater,
-				Literal: string(l.currentChar),
-			}
 		}
+		return primitives.Number
+	}
 
-	case '!':
-		if l.input[l.nextPos] == '=' {
+	kind := primitives.Illegal
+	// pair is the kind when the next char is second
+	pair := func(second byte, double, single primitives.TokenKind) primitives.TokenKind {
+		if l.peekChar() == second {
 			l.readChar()
-			tok = &primitives.Token{
-				Kind:    primitives.NotEqual,
-				Literal: "!=",
-			}
-		} else {
-			tok = &primitives.Token{
-				Kind:    primitives.Bang,
-				Literal: string(l.currentChar),
-			}
+			return double
 		}
+		return single
+	}
 
-	case '+':
-		tok = &primitives.Token{
-			Kind:    primitives.Plus,
-			Literal: string(l.currentChar),
+	switch ch {
+	case 0:
+		// a NUL in the source is not the end of it
+		if l.eof() {
+			return primitives.EOF
 		}
+	case '=':
+		kind = pair('=', primitives.Equal, primitives.Assign)
+	case '!':
+		kind = pair('=', primitives.NotEqual, primitives.Bang)
 	case '-':
-		tok = &primitives.Token{
-			Kind:    primitives.Minus,
-			Literal: string(l.currentChar),
+		kind = pair('>', primitives.Arrow, primitives.Minus)
+	case '<':
+		if kind = pair('=', primitives.LessOrEqual, primitives.Less); kind == primitives.Less {
+			kind = pair('<', primitives.ShiftLeft, primitives.Less)
 		}
-	case '*':
-		tok = &primitives.Token{
-			Kind:    primitives.Star,
-			Literal: string(l.currentChar),
+	case '>':
+		if kind = pair('=', primitives.GreaterOrEqual, primitives.Greater); kind == primitives.Greater {
+			kind = pair('>', primitives.ShiftRight, primitives.Greater)
 		}
+	case '+':
+		kind = primitives.Plus
+	case '*':
+		kind = primitives.Star
 	case '/':
-		tok = &primitives.Token{
-			Kind:    primitives.Slash,
-			Literal: string(l.currentChar),
-		}
+		kind = primitives.Slash
 	case '^':
-		tok = &primitives.Token{
-			Kind:    primitives.Carrot,
-			Literal: string(l.currentChar),
-		}
+		kind = primitives.Carrot
 	case '{':
-		tok = &primitives.Token{
-			Kind:    primitives.OpenCurlyBrace,
-			Literal: string(l.currentChar),
-		}
+		kind = primitives.OpenCurlyBrace
 	case '}':
-		tok = &primitives.Token{
-			Kind:    primitives.CloseCurlyBrace,
-			Literal: string(l.currentChar),
-		}
+		kind = primitives.CloseCurlyBrace
 	case '(':
-		tok = &primitives.Token{
-			Kind:    primitives.OpenParen,
-			Literal: string(l.currentChar),
-		}
+		kind = primitives.OpenParen
 	case ')':
-		tok = &primitives.Token{
-			Kind:    primitives.CloseParen,
-			Literal: string(l.currentChar),
-		}
+		kind = primitives.CloseParen
 	case '[':
-		tok = &primitives.Token{
-			Kind:    primitives.OpenBrackets,
-			Literal: string(l.currentChar),
-		}
+		kind = primitives.OpenBrackets
 	case ']':
-		tok = &primitives.Token{
-			Kind:    primitives.CloseBrackets,
-			Literal: string(l.currentChar),
-		}
+		kind = primitives.CloseBrackets
 	case ',':
-		tok = &primitives.Token{
-			Kind:    primitives.Comma,
-			Literal: string(l.currentChar),
-		}
+		kind = primitives.Comma
 	case ';':
-		tok = &primitives.Token{
-			Kind:    primitives.Semicolon,
-			Literal: string(l.currentChar),
-		}
-	case 0:
-		tok = &primitives.Token{
-			Kind: primitives.EOF,
-		}
+		kind = primitives.Semicolon
+	case ':':
+		kind = primitives.Colon
+	}
+	l.readChar()
+	return kind
+}
 
-	default:
-		if isLetter(l.currentChar) {
-			return l.readIdentifierOrKeyword()
-		}
+func (l *Lexer) readChar() {
+	if l.nextPos >= len(l.input) {
+		l.fill()
+	}
 
-		if isNumber(l.currentChar) {
-			return l.readNumber()
-		}
+	// already past the end of the input
+	if l.nextPos > len(l.input) {
+		return
+	}
 
-		return &primitives.Token{Kind: primitives.Illegal, Literal: string(l.currentChar)}
+	if l.currentChar == '\n' {
+		l.line++
+		l.column = 1
+	} else {
+		l.column++
 	}
 
-	l.readChar()
+	if l.nextPos >= len(l.input) {
+		l.currentChar = 0
+	} else {
+		l.currentChar = l.input[l.nextPos]
+	}
 
-	return tok
+	l.pos = l.nextPos
+	l.nextPos++
+}
+
+func (l *Lexer) peekChar() byte {
+	if l.nextPos >= len(l.input) {
+		l.fill()
+	}
+	if l.nextPos >= len(l.input) {
+		return 0
+	}
+	return l.input[l.nextPos]
 }
 
-func (l *Lexer) readIdentifierOrKeyword() *primitives.Token {
-	literal := l.readLiteral(isLetter)
+// eof reports if the current char is past the end of the input,
+// readChar refills the input before it gets there
+func (l *Lexer) eof() bool {
+	return l.pos >= len(l.input)
+}
 
-	if _, ok := Keywords[literal]; ok {
-		return &primitives.Token{
-			Kind:    primitives.Keyword,
-			Literal: literal,
-		}
+// fill drops the input before mark and appends the next chunk
+// of the reader to it, the positions move along
+func (l *Lexer) fill() {
+	if l.r == nil {
+		return
 	}
 
-	return &primitives.Token{
-		Kind:    primitives.Ident,
-		Literal: literal,
+	n, err := 0, error(nil)
+	for n == 0 && err == nil {
+		n, err = l.r.Read(l.buf)
+	}
+	if err != nil {
+		if err != io.EOF {
+			l.err = err
+		}
+		l.r = nil
 	}
-}
 
-func (l *Lexer) readNumber() *primitives.Token {
-	literal := l.readLiteral(isNumber)
+	l.input = l.input[l.mark:] + string(l.buf[:n])
+	l.base += l.mark
+	l.pos -= l.mark
+	l.nextPos -= l.mark
+	l.mark = 0
+}
 
-	return &primitives.Token{
-		Kind:    primitives.Number,
-		Literal: literal,
+// slice returns the input from mark to the current char, it is a
+// copy when the input is a window that would be kept alive by it
+func (l *Lexer) slice() string {
+	if l.names == nil {
+		return l.input[l.mark:l.pos]
 	}
+	return strings.Clone(l.input[l.mark:l.pos])
 }
 
-func (l *Lexer) readChar() {
-	if l.nextPos >= len(l.input) {
-		l.currentChar = 0
-	} else {
-		l.currentChar = l.input[l.nextPos]
+// intern is slice for the literals of tokens, a source repeats
+// them so each one is only copied out of the window once
+func (l *Lexer) intern() string {
+	if l.names == nil {
+		return l.input[l.mark:l.pos]
 	}
+	literal, ok := l.names[l.input[l.mark:l.pos]]
+	if !ok {
+		literal = strings.Clone(l.input[l.mark:l.pos])
+		l.names[literal] = literal
+	}
+	return literal
+}
 
-	l.pos = l.nextPos
-	l.nextPos++
+// Comments returns the comments skipped so far, the parser
+// never sees them but the tools keeping the source do. A lexer
+// made by NewReader only keeps them with WithTrivia
+func (l *Lexer) Comments() []primitives.Token {
+	return l.comments
 }
 
-func (l *Lexer) skipWhiteSpace() {
-	for l.currentChar == ' ' || l.currentChar == '\t' || l.currentChar == '\n' || l.currentChar == '\r' {
-		l.readChar()
+// scanTrivia skips the blanks and the comments between tokens,
+// they are only returned in trivia mode. The trailing trivia of
+// a token stops at the end of its line
+func (l *Lexer) scanTrivia(trailing bool) []primitives.Trivia {
+	var trivia []primitives.Trivia
+	for {
+		l.mark = l.pos
+		var kind primitives.TriviaKind
+
+		switch {
+		case l.currentChar == ' ' || l.currentChar == '\t' || l.currentChar == '\r':
+			for l.currentChar == ' ' || l.currentChar == '\t' || l.currentChar == '\r' {
+				l.readChar()
+			}
+			kind = primitives.Whitespace
+		case l.currentChar == '\n':
+			if trailing {
+				return trivia
+			}
+			l.readChar()
+			kind = primitives.Newline
+		case l.currentChar == '/' && l.peekChar() == '/':
+			l.readComment()
+			kind = primitives.LineComment
+		default:
+			return trivia
+		}
+
+		if l.trivia {
+			trivia = append(trivia, primitives.Trivia{Kind: kind, Text: l.slice()})
+		}
 	}
 }
 
-func (l *Lexer) readLiteral(cond func(ch byte) bool) string {
-	startPos := l.pos
-	for cond(l.currentChar) {
+func (l *Lexer) readComment() {
+	tok := primitives.Token{Kind: primitives.Comment, SourceLine: l.line, SourceColumn: l.column, Offset: l.base + l.pos}
+	l.mark = l.pos
+	for l.currentChar != '\n' && !l.eof() {
 		l.readChar()
 	}
-
-	return l.input[startPos:l.pos]
+	// a lexer reading from r keeps them only in trivia mode,
+	// the list would hold every comment of the source otherwise
+	if l.r == nil || l.trivia {
+		tok.Literal = l.slice()
+		l.comments = append(l.comments, tok)
+	}
 }
 
 func isLetter(ch byte) bool {
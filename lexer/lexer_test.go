@@ -1,9 +1,12 @@
 package lexer_test
 
 import (
+	"io"
 	"stag/lexer"
 	"stag/primitives"
+	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/stretchr/testify/require"
 )
@@ -22,8 +25,11 @@ func TestNextToken(t *testing.T) {
 		l := lexer.New(input)
 
 		tok := l.NextToken()
-		expected := &primitives.Token{
-			Kind: primitives.EOF,
+		expected := primitives.Token{
+			Kind:         primitives.EOF,
+			Offset:       0,
+			SourceColumn: 1,
+			SourceLine:   1,
 		}
 		require.Equal(t, expected, tok)
 
@@ -39,175 +45,259 @@ func TestNextToken(t *testing.T) {
 		input := "=+ abc   let x = 5 + 5; [] {} () - * / ^ , < > ! != == <= >="
 
 		tests := []struct {
-			expected *primitives.Token
+			expected primitives.Token
 		}{
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.Assign,
-					Literal: "=",
+				expected: primitives.Token{
+					Kind:         primitives.Assign,
+					Literal:      "=",
+					Offset:       0,
+					SourceColumn: 1,
+					SourceLine:   1,
 				},
 			},
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.Plus,
-					Literal: "+",
+				expected: primitives.Token{
+					Kind:         primitives.Plus,
+					Literal:      "+",
+					Offset:       1,
+					SourceColumn: 2,
+					SourceLine:   1,
 				},
 			},
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.Ident,
-					Literal: "abc",
+				expected: primitives.Token{
+					Kind:         primitives.Ident,
+					Literal:      "abc",
+					Offset:       3,
+					SourceColumn: 4,
+					SourceLine:   1,
 				},
 			},
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.Keyword,
-					Literal: "let",
+				expected: primitives.Token{
+					Kind:         primitives.Keyword,
+					Literal:      "let",
+					Offset:       9,
+					SourceColumn: 10,
+					SourceLine:   1,
 				},
 			},
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.Ident,
-					Literal: "x",
+				expected: primitives.Token{
+					Kind:         primitives.Ident,
+					Literal:      "x",
+					Offset:       13,
+					SourceColumn: 14,
+					SourceLine:   1,
 				},
 			},
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.Assign,
-					Literal: "=",
+				expected: primitives.Token{
+					Kind:         primitives.Assign,
+					Literal:      "=",
+					Offset:       15,
+					SourceColumn: 16,
+					SourceLine:   1,
 				},
 			},
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.Number,
-					Literal: "5",
+				expected: primitives.Token{
+					Kind:         primitives.Number,
+					Literal:      "5",
+					Offset:       17,
+					SourceColumn: 18,
+					SourceLine:   1,
 				},
 			},
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.Plus,
-					Literal: "+",
+				expected: primitives.Token{
+					Kind:         primitives.Plus,
+					Literal:      "+",
+					Offset:       19,
+					SourceColumn: 20,
+					SourceLine:   1,
 				},
 			},
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.Number,
-					Literal: "5",
+				expected: primitives.Token{
+					Kind:         primitives.Number,
+					Literal:      "5",
+					Offset:       21,
+					SourceColumn: 22,
+					SourceLine:   1,
 				},
 			},
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.Semicolon,
-					Literal: ";",
+				expected: primitives.Token{
+					Kind:         primitives.Semicolon,
+					Literal:      ";",
+					Offset:       22,
+					SourceColumn: 23,
+					SourceLine:   1,
 				},
 			},
 
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.OpenBrackets,
-					Literal: "[",
+				expected: primitives.Token{
+					Kind:         primitives.OpenBrackets,
+					Literal:      "[",
+					Offset:       24,
+					SourceColumn: 25,
+					SourceLine:   1,
 				},
 			},
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.CloseBrackets,
-					Literal: "]",
+				expected: primitives.Token{
+					Kind:         primitives.CloseBrackets,
+					Literal:      "]",
+					Offset:       25,
+					SourceColumn: 26,
+					SourceLine:   1,
 				},
 			},
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.OpenCurlyBrace,
-					Literal: "{",
+				expected: primitives.Token{
+					Kind:         primitives.OpenCurlyBrace,
+					Literal:      "{",
+					Offset:       27,
+					SourceColumn: 28,
+					SourceLine:   1,
 				},
 			},
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.CloseCurlyBrace,
-					Literal: "}",
+				expected: primitives.Token{
+					Kind:         primitives.CloseCurlyBrace,
+					Literal:      "}",
+					Offset:       28,
+					SourceColumn: 29,
+					SourceLine:   1,
 				},
 			},
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.OpenParen,
-					Literal: "(",
+				expected: primitives.Token{
+					Kind:         primitives.OpenParen,
+					Literal:      "(",
+					Offset:       30,
+					SourceColumn: 31,
+					SourceLine:   1,
 				},
 			},
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.CloseParen,
-					Literal: ")",
+				expected: primitives.Token{
+					Kind:         primitives.CloseParen,
+					Literal:      ")",
+					Offset:       31,
+					SourceColumn: 32,
+					SourceLine:   1,
 				},
 			},
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.Minus,
-					Literal: "-",
+				expected: primitives.Token{
+					Kind:         primitives.Minus,
+					Literal:      "-",
+					Offset:       33,
+					SourceColumn: 34,
+					SourceLine:   1,
 				},
 			},
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.Star,
-					Literal: "*",
+				expected: primitives.Token{
+					Kind:         primitives.Star,
+					Literal:      "*",
+					Offset:       35,
+					SourceColumn: 36,
+					SourceLine:   1,
 				},
 			},
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.Slash,
-					Literal: "/",
+				expected: primitives.Token{
+					Kind:         primitives.Slash,
+					Literal:      "/",
+					Offset:       37,
+					SourceColumn: 38,
+					SourceLine:   1,
 				},
 			},
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.Carrot,
-					Literal: "^",
+				expected: primitives.Token{
+					Kind:         primitives.Carrot,
+					Literal:      "^",
+					Offset:       39,
+					SourceColumn: 40,
+					SourceLine:   1,
 				},
 			},
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.Comma,
-					Literal: ",",
+				expected: primitives.Token{
+					Kind:         primitives.Comma,
+					Literal:      ",",
+					Offset:       41,
+					SourceColumn: 42,
+					SourceLine:   1,
 				},
 			},
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.Less,
-					Literal: "<",
+				expected: primitives.Token{
+					Kind:         primitives.Less,
+					Literal:      "<",
+					Offset:       43,
+					SourceColumn: 44,
+					SourceLine:   1,
 				},
 			},
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.Greater,
-					Literal: ">",
+				expected: primitives.Token{
+					Kind:         primitives.Greater,
+					Literal:      ">",
+					Offset:       45,
+					SourceColumn: 46,
+					SourceLine:   1,
 				},
 			},
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.Bang,
-					Literal: "!",
+				expected: primitives.Token{
+					Kind:         primitives.Bang,
+					Literal:      "!",
+					Offset:       47,
+					SourceColumn: 48,
+					SourceLine:   1,
 				},
 			},
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.NotEqual,
-					Literal: "!=",
+				expected: primitives.Token{
+					Kind:         primitives.NotEqual,
+					Literal:      "!=",
+					Offset:       49,
+					SourceColumn: 50,
+					SourceLine:   1,
 				},
 			},
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.Equal,
-					Literal: "==",
+				expected: primitives.Token{
+					Kind:         primitives.Equal,
+					Literal:      "==",
+					Offset:       52,
+					SourceColumn: 53,
+					SourceLine:   1,
 				},
 			},
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.LessOrEqual,
-					Literal: "<=",
+				expected: primitives.Token{
+					Kind:         primitives.LessOrEqual,
+					Literal:      "<=",
+					Offset:       55,
+					SourceColumn: 56,
+					SourceLine:   1,
 				},
 			},
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.GreaterOrEqual,
-					Literal: ">=",
+				expected: primitives.Token{
+					Kind:         primitives.GreaterOrEqual,
+					Literal:      ">=",
+					Offset:       58,
+					SourceColumn: 59,
+					SourceLine:   1,
 				},
 			},
 		}
@@ -224,144 +314,213 @@ func TestNextToken(t *testing.T) {
 		input := "fn; return while let x = 5; if 5 > 1 and x < 10 or x == 10; else"
 
 		tests := []struct {
-			expected *primitives.Token
+			expected primitives.Token
 		}{
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.Keyword,
-					Literal: "fn",
+				expected: primitives.Token{
+					Kind:         primitives.Keyword,
+					Literal:      "fn",
+					Offset:       0,
+					SourceColumn: 1,
+					SourceLine:   1,
 				},
 			},
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.Semicolon,
-					Literal: ";",
+				expected: primitives.Token{
+					Kind:         primitives.Semicolon,
+					Literal:      ";",
+					Offset:       2,
+					SourceColumn: 3,
+					SourceLine:   1,
 				},
 			},
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.Keyword,
-					Literal: "return",
+				expected: primitives.Token{
+					Kind:         primitives.Keyword,
+					Literal:      "return",
+					Offset:       4,
+					SourceColumn: 5,
+					SourceLine:   1,
 				},
 			},
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.Keyword,
-					Literal: "while",
+				expected: primitives.Token{
+					Kind:         primitives.Keyword,
+					Literal:      "while",
+					Offset:       11,
+					SourceColumn: 12,
+					SourceLine:   1,
 				},
 			},
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.Keyword,
-					Literal: "let",
+				expected: primitives.Token{
+					Kind:         primitives.Keyword,
+					Literal:      "let",
+					Offset:       17,
+					SourceColumn: 18,
+					SourceLine:   1,
 				},
 			},
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.Ident,
-					Literal: "x",
+				expected: primitives.Token{
+					Kind:         primitives.Ident,
+					Literal:      "x",
+					Offset:       21,
+					SourceColumn: 22,
+					SourceLine:   1,
 				},
 			},
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.Assign,
-					Literal: "=",
+				expected: primitives.Token{
+					Kind:         primitives.Assign,
+					Literal:      "=",
+					Offset:       23,
+					SourceColumn: 24,
+					SourceLine:   1,
 				},
 			},
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.Number,
-					Literal: "5",
+				expected: primitives.Token{
+					Kind:         primitives.Number,
+					Literal:      "5",
+					Offset:       25,
+					SourceColumn: 26,
+					SourceLine:   1,
 				},
 			},
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.Semicolon,
-					Literal: ";",
+				expected: primitives.Token{
+					Kind:         primitives.Semicolon,
+					Literal:      ";",
+					Offset:       26,
+					SourceColumn: 27,
+					SourceLine:   1,
 				},
 			},
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.Keyword,
-					Literal: "if",
+				expected: primitives.Token{
+					Kind:         primitives.Keyword,
+					Literal:      "if",
+					Offset:       28,
+					SourceColumn: 29,
+					SourceLine:   1,
 				},
 			},
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.Number,
-					Literal: "5",
+				expected: primitives.Token{
+					Kind:         primitives.Number,
+					Literal:      "5",
+					Offset:       31,
+					SourceColumn: 32,
+					SourceLine:   1,
 				},
 			},
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.Greater,
-					Literal: ">",
+				expected: primitives.Token{
+					Kind:         primitives.Greater,
+					Literal:      ">",
+					Offset:       33,
+					SourceColumn: 34,
+					SourceLine:   1,
 				},
 			},
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.Number,
-					Literal: "1",
+				expected: primitives.Token{
+					Kind:         primitives.Number,
+					Literal:      "1",
+					Offset:       35,
+					SourceColumn: 36,
+					SourceLine:   1,
 				},
 			},
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.Keyword,
-					Literal: "and",
+				expected: primitives.Token{
+					Kind:         primitives.Keyword,
+					Literal:      "and",
+					Offset:       37,
+					SourceColumn: 38,
+					SourceLine:   1,
 				},
 			},
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.Ident,
-					Literal: "x",
+				expected: primitives.Token{
+					Kind:         primitives.Ident,
+					Literal:      "x",
+					Offset:       41,
+					SourceColumn: 42,
+					SourceLine:   1,
 				},
 			},
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.Less,
-					Literal: "<",
+				expected: primitives.Token{
+					Kind:         primitives.Less,
+					Literal:      "<",
+					Offset:       43,
+					SourceColumn: 44,
+					SourceLine:   1,
 				},
 			},
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.Number,
-					Literal: "10",
+				expected: primitives.Token{
+					Kind:         primitives.Number,
+					Literal:      "10",
+					Offset:       45,
+					SourceColumn: 46,
+					SourceLine:   1,
 				},
 			},
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.Keyword,
-					Literal: "or",
+				expected: primitives.Token{
+					Kind:         primitives.Keyword,
+					Literal:      "or",
+					Offset:       48,
+					SourceColumn: 49,
+					SourceLine:   1,
 				},
 			},
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.Ident,
-					Literal: "x",
+				expected: primitives.Token{
+					Kind:         primitives.Ident,
+					Literal:      "x",
+					Offset:       51,
+					SourceColumn: 52,
+					SourceLine:   1,
 				},
 			},
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.Equal,
-					Literal: "==",
+				expected: primitives.Token{
+					Kind:         primitives.Equal,
+					Literal:      "==",
+					Offset:       53,
+					SourceColumn: 54,
+					SourceLine:   1,
 				},
 			},
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.Number,
-					Literal: "10",
+				expected: primitives.Token{
+					Kind:         primitives.Number,
+					Literal:      "10",
+					Offset:       56,
+					SourceColumn: 57,
+					SourceLine:   1,
 				},
 			},
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.Semicolon,
-					Literal: ";",
+				expected: primitives.Token{
+					Kind:         primitives.Semicolon,
+					Literal:      ";",
+					Offset:       58,
+					SourceColumn: 59,
+					SourceLine:   1,
 				},
 			},
 			{
-				expected: &primitives.Token{
-					Kind:    primitives.Keyword,
-					Literal: "else",
+				expected: primitives.Token{
+					Kind:         primitives.Keyword,
+					Literal:      "else",
+					Offset:       60,
+					SourceColumn: 61,
+					SourceLine:   1,
 				},
 			},
 		}
@@ -374,3 +533,286 @@ func TestNextToken(t *testing.T) {
 		}
 	})
 }
+
+func TestTokenPositions(t *testing.T) {
+	input := "fn f(a: u16) -> u16 {\n  return a;\n}"
+
+	tests := []struct {
+		kind    primitives.TokenKind
+		literal string
+		line    int
+		column  int
+	}{
+		{primitives.Keyword, "fn", 1, 1},
+		{primitives.Ident, "f", 1, 4},
+		{primitives.OpenParen, "(", 1, 5},
+		{primitives.Ident, "a", 1, 6},
+		{primitives.Colon, ":", 1, 7},
+		{primitives.Ident, "u16", 1, 9},
+		{primitives.CloseParen, ")", 1, 12},
+		{primitives.Arrow, "->", 1, 14},
+		{primitives.Ident, "u16", 1, 17},
+		{primitives.OpenCurlyBrace, "{", 1, 21},
+		{primitives.Keyword, "return", 2, 3},
+		{primitives.Ident, "a", 2, 10},
+		{primitives.Semicolon, ";", 2, 11},
+		{primitives.CloseCurlyBrace, "}", 3, 1},
+		{primitives.EOF, "", 3, 2},
+	}
+
+	l := lexer.New(input)
+	for _, tt := range tests {
+		tok := l.NextToken()
+		require.Equal(t, tt.kind, tok.Kind, tok.String())
+		require.Equal(t, tt.literal, tok.Literal)
+		require.Equal(t, tt.literal, input[tok.Offset:tok.Offset+len(tok.Literal)])
+		require.Equal(t, tt.line, tok.SourceLine, tok.String())
+		require.Equal(t, tt.column, tok.SourceColumn, tok.String())
+	}
+}
+
+func TestShiftTokens(t *testing.T) {
+	input := "a << 2 >> b < c > d"
+
+	tests := []struct {
+		kind    primitives.TokenKind
+		literal string
+		line    int
+		column  int
+	}{
+		{primitives.Ident, "a", 1, 1},
+		{primitives.ShiftLeft, "<<", 1, 3},
+		{primitives.Number, "2", 1, 6},
+		{primitives.ShiftRight, ">>", 1, 8},
+		{primitives.Ident, "b", 1, 11},
+		{primitives.Less, "<", 1, 13},
+		{primitives.Ident, "c", 1, 15},
+		{primitives.Greater, ">", 1, 17},
+		{primitives.Ident, "d", 1, 19},
+	}
+
+	l := lexer.New(input)
+	for _, tt := range tests {
+		tok := l.NextToken()
+		require.Equal(t, tt.kind, tok.Kind, tok.String())
+		require.Equal(t, tt.literal, tok.Literal)
+		require.Equal(t, tt.line, tok.SourceLine, tok.String())
+		require.Equal(t, tt.column, tok.SourceColumn, tok.String())
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := "// sum\nlet x = 1 / 2; // half\n//\nx"
+
+	tests := []struct {
+		kind    primitives.TokenKind
+		literal string
+		line    int
+		column  int
+	}{
+		{primitives.Keyword, "let", 2, 1},
+		{primitives.Ident, "x", 2, 5},
+		{primitives.Assign, "=", 2, 7},
+		{primitives.Number, "1", 2, 9},
+		{primitives.Slash, "/", 2, 11},
+		{primitives.Number, "2", 2, 13},
+		{primitives.Semicolon, ";", 2, 14},
+		{primitives.Ident, "x", 4, 1},
+		{primitives.EOF, "", 4, 2},
+	}
+
+	l := lexer.New(input)
+	for _, tt := range tests {
+		tok := l.NextToken()
+		require.Equal(t, tt.kind, tok.Kind, tok.String())
+		require.Equal(t, tt.literal, tok.Literal)
+		require.Equal(t, tt.line, tok.SourceLine, tok.String())
+		require.Equal(t, tt.column, tok.SourceColumn, tok.String())
+	}
+
+	require.Equal(t, []primitives.Token{
+		{Kind: primitives.Comment, Literal: "// sum", Offset: 0, SourceLine: 1, SourceColumn: 1},
+		{Kind: primitives.Comment, Literal: "// half", Offset: 22, SourceLine: 2, SourceColumn: 16},
+		{Kind: primitives.Comment, Literal: "//", Offset: 30, SourceLine: 3, SourceColumn: 1},
+	}, l.Comments())
+}
+
+func TestTrivia(t *testing.T) {
+	input := "// sum\nlet x = 1;  // one\r\n\n\tx\t"
+
+	l := lexer.New(input, lexer.WithTrivia())
+	var toks []primitives.Token
+	var leading, trailing [][]primitives.Trivia
+	var text string
+	for {
+		tok := l.NextToken()
+		before, after := l.Trivia()
+		toks = append(toks, tok)
+		leading, trailing = append(leading, before), append(trailing, after)
+		text += primitives.Text(tok, before, after)
+		if tok.Kind == primitives.EOF {
+			break
+		}
+	}
+	require.Equal(t, input, text)
+
+	require.Equal(t, []primitives.Trivia{
+		{Kind: primitives.LineComment, Text: "// sum"},
+		{Kind: primitives.Newline, Text: "\n"},
+	}, leading[0])
+	require.Equal(t, []primitives.Trivia{{Kind: primitives.Whitespace, Text: " "}}, trailing[0])
+
+	require.Equal(t, primitives.Semicolon, toks[4].Kind)
+	require.Equal(t, []primitives.Trivia{
+		{Kind: primitives.Whitespace, Text: "  "},
+		{Kind: primitives.LineComment, Text: "// one\r"},
+	}, trailing[4])
+
+	x := toks[5]
+	require.Equal(t, []primitives.Trivia{
+		{Kind: primitives.Newline, Text: "\n"},
+		{Kind: primitives.Newline, Text: "\n"},
+		{Kind: primitives.Whitespace, Text: "\t"},
+	}, leading[5])
+	require.Equal(t, 4, x.SourceLine)
+	require.Equal(t, 2, x.SourceColumn)
+	require.Equal(t, []primitives.Trivia{{Kind: primitives.Whitespace, Text: "\t"}}, trailing[5])
+
+	require.Empty(t, leading[6])
+	require.Len(t, l.Comments(), 2)
+
+	// the trivia follows the tokens through Peek
+	l = lexer.New("a  b // c", lexer.WithTrivia())
+	require.Equal(t, "b", l.Peek(1).Literal)
+	l.NextToken()
+	_, after := l.Trivia()
+	require.Equal(t, []primitives.Trivia{{Kind: primitives.Whitespace, Text: "  "}}, after)
+	l.NextToken()
+	_, after = l.Trivia()
+	require.Equal(t, []primitives.Trivia{
+		{Kind: primitives.Whitespace, Text: " "},
+		{Kind: primitives.LineComment, Text: "// c"},
+	}, after)
+
+	// without the option nothing is kept
+	bare := lexer.New(input)
+	bare.NextToken()
+	before, after := bare.Trivia()
+	require.Nil(t, before)
+	require.Nil(t, after)
+}
+
+func TestStartAt(t *testing.T) {
+	l := lexer.New("a\n b", lexer.StartAt(20, 3, 7))
+
+	tok := l.NextToken()
+	require.Equal(t, 20, tok.Offset)
+	require.Equal(t, 3, tok.SourceLine)
+	require.Equal(t, 7, tok.SourceColumn)
+
+	tok = l.NextToken()
+	require.Equal(t, 23, tok.Offset)
+	require.Equal(t, 4, tok.SourceLine)
+	require.Equal(t, 2, tok.SourceColumn)
+}
+
+func TestReader(t *testing.T) {
+	inputs := []string{
+		"",
+		"let x = 1 == 2;",
+		"// sum\nfn sum(a: u16, b: u16) -> u16 {\n\treturn a + b; // done\n}\r\nsum(1, 2) <= 3 >> 1 != 0",
+		strings.Repeat("let identifier_longer_than_the_buffer = 12345 <<= !x;\n", 20),
+		"a >",
+		"x !",
+	}
+
+	for _, input := range inputs {
+		for _, opts := range [][]lexer.Option{nil, {lexer.WithTrivia()}} {
+			want := lexer.New(input, opts...)
+			for _, size := range []int{1, 2, 7, lexer.DefaultBufferSize} {
+				got := lexer.NewReader(iotest.HalfReader(strings.NewReader(input)), append(opts, lexer.BufferSize(size))...)
+				for {
+					tok := got.NextToken()
+					require.Equal(t, want.NextToken(), tok, "%q with %d bytes", input, size)
+					if tok.Kind == primitives.EOF {
+						break
+					}
+				}
+				if opts == nil {
+					require.Empty(t, got.Comments())
+				} else {
+					require.Equal(t, want.Comments(), got.Comments())
+				}
+				require.NoError(t, got.Err())
+				want = lexer.New(input, opts...)
+			}
+		}
+	}
+
+	// a read error ends the input
+	l := lexer.NewReader(io.MultiReader(strings.NewReader("x + "), iotest.ErrReader(io.ErrUnexpectedEOF)))
+	require.Equal(t, []primitives.TokenKind{primitives.Ident, primitives.Plus, primitives.EOF},
+		[]primitives.TokenKind{l.NextToken().Kind, l.NextToken().Kind, l.NextToken().Kind})
+	require.ErrorIs(t, l.Err(), io.ErrUnexpectedEOF)
+}
+
+func TestAll(t *testing.T) {
+	var literals []string
+	for tok := range lexer.New("let x = y;").All() {
+		literals = append(literals, tok.Literal)
+	}
+	require.Equal(t, []string{"let", "x", "=", "y", ";"}, literals)
+
+	// the tokens after a break are left for the next call
+	l := lexer.New("a b c")
+	for tok := range l.All() {
+		require.Equal(t, "a", tok.Literal)
+		break
+	}
+	require.Equal(t, "b", l.NextToken().Literal)
+}
+
+func TestTokenize(t *testing.T) {
+	require.Empty(t, lexer.Tokenize(""))
+	require.Equal(t, []primitives.Token{
+		{Kind: primitives.Number, Literal: "1", Offset: 0, SourceLine: 1, SourceColumn: 1},
+		{Kind: primitives.Plus, Literal: "+", Offset: 2, SourceLine: 1, SourceColumn: 3},
+		{Kind: primitives.Ident, Literal: "x", Offset: 4, SourceLine: 2, SourceColumn: 1},
+	}, lexer.Tokenize("1 +\nx"))
+}
+
+func TestPeek(t *testing.T) {
+	l := lexer.New("a = b")
+	require.Equal(t, "b", l.Peek(2).Literal)
+	require.Equal(t, primitives.EOF, l.Peek(5).Kind)
+	require.Equal(t, "a", l.Peek(0).Literal)
+
+	require.Equal(t, "a", l.NextToken().Literal)
+	require.Equal(t, "b", l.Peek(1).Literal)
+	require.Equal(t, "=", l.NextToken().Literal)
+	require.Equal(t, "b", l.NextToken().Literal)
+	require.Equal(t, primitives.EOF, l.NextToken().Kind)
+	require.Equal(t, primitives.EOF, l.NextToken().Kind)
+}
+
+func TestNul(t *testing.T) {
+	input := "a\x00b // c\x00d\n\x00"
+	var kinds []primitives.TokenKind
+	for tok := range lexer.New(input).All() {
+		kinds = append(kinds, tok.Kind)
+	}
+	require.Equal(t, []primitives.TokenKind{primitives.Ident, primitives.Illegal, primitives.Ident, primitives.Illegal}, kinds)
+
+	l := lexer.New(input)
+	for range l.All() {
+	}
+	require.Equal(t, "// c\x00d", l.Comments()[0].Literal)
+}
+
+func TestMaxTokens(t *testing.T) {
+	l := lexer.New("a", lexer.MaxTokens(3))
+	require.Equal(t, primitives.Ident, l.NextToken().Kind)
+	require.Equal(t, primitives.EOF, l.NextToken().Kind)
+	require.Equal(t, primitives.EOF, l.NextToken().Kind)
+	require.PanicsWithValue(t, lexer.ErrTokenBudget, func() { l.NextToken() })
+}
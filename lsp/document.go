@@ -0,0 +1,487 @@
+package lsp
+
+import (
+	"fmt"
+	"sort"
+	"stag/frontend"
+	"stag/lexer"
+	"stag/pratt_parser/ast"
+	"stag/primitives"
+	"stag/types"
+	"strconv"
+	"strings"
+)
+
+// document is an open file and what the front end found in it,
+// it is analyzed again from scratch on every change
+type document struct {
+	uri     string
+	version int
+	text    string
+
+	tokens []primitives.Token
+	// program is what the parser recovered from the text even
+	// with syntax errors, info is nil when there are any
+	program *ast.Program
+	info    *types.Info
+
+	diagnostics []Diagnostic
+}
+
+func analyze(uri string, version int, text string) *document {
+	d := &document{uri: uri, version: version, text: text, diagnostics: []Diagnostic{}}
+	d.lex()
+
+	program, info, errs := frontend.Source(text, frontend.Options{Unfolded: true})
+	d.program, d.info = program, info
+	for _, err := range errs {
+		if info != nil {
+			d.errorAt(err.Line, err.Column, "checker", err.Msg)
+			continue
+		}
+		// illegal characters are already reported by the lexer
+		if !d.reported(err.Line, err.Column) {
+			d.errorAt(err.Line, err.Column, "parser", err.Msg)
+		}
+	}
+	if len(errs) > 0 {
+		return d
+	}
+
+	// folding rewrites the tree, it runs over a copy so the
+	// navigation still finds every identifier of the text
+	_, _, errs = frontend.Source(text, frontend.Options{})
+	for _, err := range errs {
+		d.errorAt(err.Line, err.Column, "checker", err.Msg)
+	}
+	return d
+}
+
+// lex reports the illegal characters and keeps the tokens
+func (d *document) lex() {
+	l := lexer.New(d.text)
+	for {
+		tok := l.NextToken()
+		d.tokens = append(d.tokens, tok)
+		switch tok.Kind {
+		case primitives.Illegal:
+			d.errorAt(tok.SourceLine, tok.SourceColumn, "lexer", "illegal character "+strconv.Quote(tok.Literal))
+		case primitives.EOF:
+			return
+		}
+	}
+}
+
+// errorAt reports an error over the token at line and column
+func (d *document) errorAt(line, column int, source, msg string) {
+	r := Range{Start: position(line, column), End: position(line, column)}
+	if tok := d.tokenAt(r.Start); tok != nil {
+		r = tokenRange(*tok)
+	}
+	d.diagnostics = append(d.diagnostics, Diagnostic{Range: r, Severity: SeverityError, Source: source, Message: msg})
+}
+
+func (d *document) reported(line, column int) bool {
+	pos := position(line, column)
+	for _, diag := range d.diagnostics {
+		if diag.Range.Start == pos {
+			return true
+		}
+	}
+	return false
+}
+
+// position converts the one based line and column of the lexer
+func position(line, column int) Position {
+	return Position{Line: line - 1, Character: column - 1}
+}
+
+func tokenRange(tok primitives.Token) Range {
+	start := position(tok.SourceLine, tok.SourceColumn)
+	end := start
+	end.Character += len(tok.Literal)
+	return Range{Start: start, End: end}
+}
+
+func before(a, b Position) bool {
+	return a.Line < b.Line || a.Line == b.Line && a.Character < b.Character
+}
+
+// contains reports if pos is in r, the end counts only when
+// touching is set so a cursor right after a name finds it
+func (r Range) contains(pos Position, touching bool) bool {
+	if before(pos, r.Start) {
+		return false
+	}
+	return before(pos, r.End) || touching && pos == r.End
+}
+
+// tokenIndex returns the index of the token starting at pos or -1
+func (d *document) tokenIndex(pos Position) int {
+	i := sort.Search(len(d.tokens), func(i int) bool {
+		return !before(position(d.tokens[i].SourceLine, d.tokens[i].SourceColumn), pos)
+	})
+	if i < len(d.tokens) && position(d.tokens[i].SourceLine, d.tokens[i].SourceColumn) == pos {
+		return i
+	}
+	return -1
+}
+
+func (d *document) tokenAt(pos Position) *primitives.Token {
+	if i := d.tokenIndex(pos); i >= 0 {
+		return &d.tokens[i]
+	}
+	return nil
+}
+
+// closing returns the brace closing the block or nil when the
+// block is still open at the end of the text
+func (d *document) closing(block *ast.BlockStatement) *primitives.Token {
+	i := d.tokenIndex(position(block.Token.SourceLine, block.Token.SourceColumn))
+	if i < 0 {
+		return nil
+	}
+
+	open := 0
+	for j := range d.tokens[i:] {
+		tok := &d.tokens[i+j]
+		switch tok.Kind {
+		case primitives.OpenCurlyBrace:
+			open++
+		case primitives.CloseCurlyBrace:
+			open--
+			if open == 0 {
+				return tok
+			}
+		}
+	}
+	return nil
+}
+
+// inside reports if pos is between the braces of the block
+func (d *document) inside(block *ast.BlockStatement, pos Position) bool {
+	if block == nil || !before(position(block.Token.SourceLine, block.Token.SourceColumn), pos) {
+		return false
+	}
+	end := d.closing(block)
+	return end == nil || !before(position(end.SourceLine, end.SourceColumn), pos)
+}
+
+// declRange spans a let up to its semicolon and a function up
+// to the brace closing its body
+func (d *document) declRange(stmt ast.Statement) Range {
+	line, column := ast.Pos(stmt)
+	r := Range{Start: position(line, column), End: position(line, column)}
+
+	switch s := stmt.(type) {
+	case *ast.LetStatement:
+		r.End = tokenRange(s.Name.Token).End
+		if i := d.tokenIndex(r.Start); i >= 0 {
+			for _, tok := range d.tokens[i:] {
+				if tok.Kind == primitives.Semicolon {
+					r.End = tokenRange(tok).End
+					break
+				}
+			}
+		}
+	case *ast.FunctionStatement:
+		r.End = tokenRange(s.Name.Token).End
+		if s.Body != nil {
+			if end := d.closing(s.Body); end != nil {
+				r.End = tokenRange(*end).End
+			}
+		}
+	}
+	return r
+}
+
+// nodeAt returns the identifier, literal or operator under pos,
+// a node starting at pos wins over one ending there
+func (d *document) nodeAt(pos Position) (ast.Node, Range) {
+	if d.program == nil {
+		return nil, Range{}
+	}
+
+	var found, touching ast.Node
+	var foundRange, touchingRange Range
+	ast.Inspect(d.program, func(node ast.Node) bool {
+		var r Range
+		switch n := node.(type) {
+		case *ast.Identifier:
+			r = tokenRange(n.Token)
+		case *ast.IntegerLiteral:
+			r = tokenRange(n.Token)
+		case *ast.Boolean:
+			r = tokenRange(n.Token)
+		case *ast.PrefixExpression:
+			r = tokenRange(n.Token)
+		case *ast.InfixExpression:
+			r = tokenRange(n.Token)
+		default:
+			return true
+		}
+
+		if r.contains(pos, false) {
+			found, foundRange = node, r
+		} else if touching == nil && r.contains(pos, true) {
+			touching, touchingRange = node, r
+		}
+		return true
+	})
+
+	if found != nil {
+		return found, foundRange
+	}
+	return touching, touchingRange
+}
+
+// scopeAt returns the declarations visible at pos by name,
+// the inner ones shadow the outer ones
+func (d *document) scopeAt(pos Position) map[string]*ast.Identifier {
+	scope := map[string]*ast.Identifier{}
+	if d.program == nil {
+		return scope
+	}
+
+	// functions can be called before their declaration
+	for _, stmt := range d.program.Statements {
+		if fn, ok := stmt.(*ast.FunctionStatement); ok {
+			scope[fn.Name.Value] = fn.Name
+		}
+	}
+	d.declare(scope, d.program.Statements, pos)
+	return scope
+}
+
+func (d *document) declare(scope map[string]*ast.Identifier, stmts []ast.Statement, pos Position) {
+	for _, stmt := range stmts {
+		switch s := stmt.(type) {
+		case *ast.LetStatement:
+			if !before(pos, d.declRange(s).End) {
+				scope[s.Name.Value] = s.Name
+			}
+		case *ast.FunctionStatement:
+			if d.inside(s.Body, pos) {
+				for _, p := range s.Parameters {
+					scope[p.Name.Value] = p.Name
+				}
+				d.declare(scope, s.Body.Statements, pos)
+			}
+		case *ast.BlockStatement:
+			if d.inside(s, pos) {
+				d.declare(scope, s.Statements, pos)
+			}
+		case *ast.IfStatement:
+			if d.inside(s.Consequence, pos) {
+				d.declare(scope, s.Consequence.Statements, pos)
+			}
+			if d.inside(s.Alternative, pos) {
+				d.declare(scope, s.Alternative.Statements, pos)
+			}
+		case *ast.WhileStatement:
+			if d.inside(s.Body, pos) {
+				d.declare(scope, s.Body.Statements, pos)
+			}
+		}
+	}
+}
+
+// declaration returns the identifier declaring the name under
+// pos, the checker knows it and the scope is the fallback while
+// the text does not parse
+func (d *document) declaration(pos Position) *ast.Identifier {
+	id, ok := d.identAt(pos)
+	if !ok {
+		return nil
+	}
+	if d.info != nil {
+		if obj := d.info.ObjectOf(id); obj != nil {
+			return obj.Decl
+		}
+		return nil
+	}
+	if decl, ok := d.scopeAt(pos)[id.Value]; ok {
+		return decl
+	}
+	return nil
+}
+
+func (d *document) identAt(pos Position) (*ast.Identifier, bool) {
+	node, _ := d.nodeAt(pos)
+	id, ok := node.(*ast.Identifier)
+	return id, ok
+}
+
+func (d *document) function(decl *ast.Identifier) *ast.FunctionStatement {
+	for _, stmt := range d.program.Statements {
+		if fn, ok := stmt.(*ast.FunctionStatement); ok && fn.Name == decl {
+			return fn
+		}
+	}
+	return nil
+}
+
+// signature is the header of the function as written
+func signature(fn *ast.FunctionStatement) string {
+	params := make([]string, 0, len(fn.Parameters))
+	for _, p := range fn.Parameters {
+		params = append(params, p.String())
+	}
+
+	out := fmt.Sprintf("fn %s(%s)", fn.Name.Value, strings.Join(params, ", "))
+	if fn.ReturnType != nil {
+		out += " -> " + fn.ReturnType.Value
+	}
+	return out
+}
+
+// constant returns the value a variable keeps for its whole life,
+// the ones initialized with a constant and never assigned
+func (d *document) constant(obj *types.Object) (int64, bool) {
+	var init ast.Expression
+	assigned := false
+	ast.Inspect(d.program, func(node ast.Node) bool {
+		switch n := node.(type) {
+		case *ast.LetStatement:
+			if n.Name == obj.Decl {
+				init = n.Value
+			}
+		case *ast.AssignStatement:
+			if d.info.ObjectOf(n.Name) == obj {
+				assigned = true
+			}
+		}
+		return true
+	})
+	if init == nil || assigned {
+		return 0, false
+	}
+	value, ok := d.info.Values[init]
+	return value, ok
+}
+
+// hover describes the node under pos, nil when there is nothing
+// to say or the program does not type check
+func (d *document) hover(pos Position) *Hover {
+	node, r := d.nodeAt(pos)
+	if node == nil || d.info == nil {
+		return nil
+	}
+
+	var text string
+	switch n := node.(type) {
+	case *ast.Identifier:
+		obj := d.info.ObjectOf(n)
+		if obj == nil {
+			return nil
+		}
+		switch obj.Kind {
+		case types.Func:
+			text = signature(d.function(obj.Decl))
+		case types.Param:
+			text = fmt.Sprintf("parameter %s: %s", obj.Name, obj.Type)
+		default:
+			text = fmt.Sprintf("variable %s: %s", obj.Name, obj.Type)
+			if value, ok := d.constant(obj); ok {
+				text += " = " + strconv.FormatInt(value, 10)
+			}
+		}
+	case ast.Expression:
+		t := d.info.TypeOf(n)
+		if t == nil {
+			return nil
+		}
+		text = t.String()
+		if value, ok := d.info.Values[n]; ok {
+			text += " = " + strconv.FormatInt(value, 10)
+		}
+	}
+
+	return &Hover{Contents: MarkupContent{Kind: "plaintext", Value: text}, Range: &r}
+}
+
+func (d *document) typeOf(decl *ast.Identifier) string {
+	if d.info == nil {
+		return ""
+	}
+	if obj := d.info.ObjectOf(decl); obj != nil && obj.Type != nil {
+		return obj.Type.String()
+	}
+	return ""
+}
+
+// symbols lists the functions with their parameters and locals
+// and the top level variables
+func (d *document) symbols() []DocumentSymbol {
+	symbols := []DocumentSymbol{}
+	if d.program == nil {
+		return symbols
+	}
+
+	for _, stmt := range d.program.Statements {
+		switch s := stmt.(type) {
+		case *ast.LetStatement:
+			symbols = append(symbols, d.variable(s.Name, d.declRange(s)))
+		case *ast.FunctionStatement:
+			fn := DocumentSymbol{
+				Name:           s.Name.Value,
+				Detail:         d.typeOf(s.Name),
+				Kind:           SymbolFunction,
+				Range:          d.declRange(s),
+				SelectionRange: tokenRange(s.Name.Token),
+			}
+			for _, p := range s.Parameters {
+				fn.Children = append(fn.Children, d.variable(p.Name, tokenRange(p.Name.Token)))
+			}
+			ast.Inspect(s.Body, func(node ast.Node) bool {
+				if let, ok := node.(*ast.LetStatement); ok {
+					fn.Children = append(fn.Children, d.variable(let.Name, d.declRange(let)))
+				}
+				return true
+			})
+			symbols = append(symbols, fn)
+		}
+	}
+	return symbols
+}
+
+func (d *document) variable(name *ast.Identifier, r Range) DocumentSymbol {
+	return DocumentSymbol{
+		Name:           name.Value,
+		Detail:         d.typeOf(name),
+		Kind:           SymbolVariable,
+		Range:          r,
+		SelectionRange: tokenRange(name.Token),
+	}
+}
+
+// completion offers the names in scope at pos and the keywords
+func (d *document) completion(pos Position) *CompletionList {
+	list := &CompletionList{Items: []CompletionItem{}}
+
+	scope := d.scopeAt(pos)
+	names := make([]string, 0, len(scope))
+	for name := range scope {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		item := CompletionItem{Label: name, Kind: CompletionVariable, Detail: d.typeOf(scope[name])}
+		if fn := d.function(scope[name]); fn != nil {
+			item.Kind = CompletionFunction
+			item.Detail = signature(fn)
+		}
+		list.Items = append(list.Items, item)
+	}
+
+	keywords := make([]string, 0, len(lexer.Keywords))
+	for keyword := range lexer.Keywords {
+		keywords = append(keywords, keyword)
+	}
+	sort.Strings(keywords)
+	for _, keyword := range keywords {
+		list.Items = append(list.Items, CompletionItem{Label: keyword, Kind: CompletionKeyword})
+	}
+	return list
+}
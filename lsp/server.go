@@ -0,0 +1,190 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// ErrNoShutdown is returned by Serve when the client asks the
+// server to exit without shutting it down first
+var ErrNoShutdown = errors.New("lsp: exit without shutdown")
+
+// Server answers the requests of one client, the requests are
+// handled one at a time in the order they arrive
+type Server struct {
+	in  *bufio.Reader
+	out io.Writer
+
+	docs        map[string]*document
+	initialized bool
+	shutdown    bool
+}
+
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{in: bufio.NewReader(in), out: out, docs: map[string]*document{}}
+}
+
+// Serve handles messages until the client sends exit or closes
+// the input, which ends the session as well
+func (s *Server) Serve() error {
+	for {
+		body, err := readMessage(s.in)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var msg message
+		if err := json.Unmarshal(body, &msg); err != nil {
+			if err := s.replyError(nil, codeParseError, err.Error()); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return ErrNoShutdown
+			}
+			return nil
+		}
+		if err := s.handle(&msg); err != nil {
+			return err
+		}
+	}
+}
+
+// handle dispatches a message, only the errors writing to the
+// client are returned, the others are sent as responses
+func (s *Server) handle(msg *message) error {
+	if msg.ID == nil {
+		return s.notification(msg)
+	}
+
+	if msg.Method == "" {
+		return s.replyError(msg.ID, codeInvalidRequest, "missing method")
+	}
+	if !s.initialized && msg.Method != "initialize" {
+		return s.replyError(msg.ID, codeNotInitialized, "server not initialized")
+	}
+	if s.shutdown {
+		return s.replyError(msg.ID, codeInvalidRequest, "server is shut down")
+	}
+
+	var result any
+	var params TextDocumentPositionParams
+	switch msg.Method {
+	case "initialize":
+		s.initialized = true
+		result = InitializeResult{
+			Capabilities: ServerCapabilities{
+				TextDocumentSync:       syncFull,
+				HoverProvider:          true,
+				DefinitionProvider:     true,
+				DocumentSymbolProvider: true,
+				CompletionProvider:     &CompletionOptions{},
+			},
+			ServerInfo: ServerInfo{Name: "stag"},
+		}
+
+	case "shutdown":
+		s.shutdown = true
+
+	case "textDocument/documentSymbol":
+		var params DocumentSymbolParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return s.replyError(msg.ID, codeInvalidParams, err.Error())
+		}
+		if doc, ok := s.docs[params.TextDocument.URI]; ok {
+			result = doc.symbols()
+		}
+
+	case "textDocument/hover", "textDocument/definition", "textDocument/completion":
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return s.replyError(msg.ID, codeInvalidParams, err.Error())
+		}
+		doc, ok := s.docs[params.TextDocument.URI]
+		if !ok {
+			break
+		}
+
+		switch msg.Method {
+		case "textDocument/hover":
+			if hover := doc.hover(params.Position); hover != nil {
+				result = hover
+			}
+		case "textDocument/definition":
+			if decl := doc.declaration(params.Position); decl != nil {
+				result = Location{URI: doc.uri, Range: tokenRange(decl.Token)}
+			}
+		case "textDocument/completion":
+			result = doc.completion(params.Position)
+		}
+
+	default:
+		return s.replyError(msg.ID, codeMethodNotFound, "method not found: "+msg.Method)
+	}
+
+	return writeMessage(s.out, response{JSONRPC: "2.0", ID: msg.ID, Result: result})
+}
+
+// notification handles the messages that expect no response,
+// the unknown ones are ignored as the protocol asks
+func (s *Server) notification(msg *message) error {
+	if !s.initialized {
+		return nil
+	}
+
+	switch msg.Method {
+	case "textDocument/didOpen":
+		var params DidOpenTextDocumentParams
+		if json.Unmarshal(msg.Params, &params) != nil {
+			return nil
+		}
+		item := params.TextDocument
+		return s.update(analyze(item.URI, item.Version, item.Text))
+
+	case "textDocument/didChange":
+		var params DidChangeTextDocumentParams
+		if json.Unmarshal(msg.Params, &params) != nil || len(params.ContentChanges) == 0 {
+			return nil
+		}
+		// with full synchronization the last change has the whole text
+		text := params.ContentChanges[len(params.ContentChanges)-1].Text
+		return s.update(analyze(params.TextDocument.URI, params.TextDocument.Version, text))
+
+	case "textDocument/didClose":
+		var params DidCloseTextDocumentParams
+		if json.Unmarshal(msg.Params, &params) != nil {
+			return nil
+		}
+		delete(s.docs, params.TextDocument.URI)
+		return s.publish(params.TextDocument.URI, 0, []Diagnostic{})
+	}
+	return nil
+}
+
+func (s *Server) update(doc *document) error {
+	s.docs[doc.uri] = doc
+	return s.publish(doc.uri, doc.version, doc.diagnostics)
+}
+
+func (s *Server) publish(uri string, version int, diagnostics []Diagnostic) error {
+	return writeMessage(s.out, notification{
+		JSONRPC: "2.0",
+		Method:  "textDocument/publishDiagnostics",
+		Params:  PublishDiagnosticsParams{URI: uri, Version: version, Diagnostics: diagnostics},
+	})
+}
+
+func (s *Server) replyError(id *json.RawMessage, code int, msg string) error {
+	return writeMessage(s.out, errorResponse{
+		JSONRPC: "2.0",
+		ID:      id,
+		Error:   &responseError{Code: code, Message: msg},
+	})
+}
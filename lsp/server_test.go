@@ -0,0 +1,346 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const uri = "file:///sum.el"
+
+// client talks to a server running in the same process, the
+// messages of the server are read as they come so it never blocks
+type client struct {
+	t        *testing.T
+	out      io.WriteCloser
+	messages chan *message
+	done     chan error
+	id       int
+}
+
+func newClient(t *testing.T) *client {
+	t.Helper()
+
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+
+	c := &client{t: t, out: clientOut, messages: make(chan *message, 64), done: make(chan error, 1)}
+	go func() {
+		c.done <- NewServer(serverIn, serverOut).Serve()
+		serverOut.Close()
+	}()
+	go func() {
+		r := bufio.NewReader(clientIn)
+		for {
+			body, err := readMessage(r)
+			if err != nil {
+				close(c.messages)
+				return
+			}
+			var msg message
+			if json.Unmarshal(body, &msg) == nil {
+				c.messages <- &msg
+			}
+		}
+	}()
+
+	c.call("initialize", map[string]any{"capabilities": map[string]any{}}, nil)
+	c.notify("initialized", map[string]any{})
+	t.Cleanup(func() { clientOut.Close() })
+	return c
+}
+
+func (c *client) send(v any) {
+	c.t.Helper()
+	require.NoError(c.t, writeMessage(c.out, v))
+}
+
+func (c *client) notify(method string, params any) {
+	c.t.Helper()
+	c.send(notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+// call sends a request and decodes the result of its response,
+// it fails on the error responses
+func (c *client) call(method string, params any, result any) {
+	c.t.Helper()
+	require.NoError(c.t, c.callErr(method, params, result))
+}
+
+func (c *client) callErr(method string, params any, result any) error {
+	c.t.Helper()
+
+	c.id++
+	c.send(map[string]any{"jsonrpc": "2.0", "id": c.id, "method": method, "params": params})
+
+	msg := c.next()
+	require.Empty(c.t, msg.Method, "expected the response to %s", method)
+	var id int
+	require.NoError(c.t, json.Unmarshal(*msg.ID, &id))
+	require.Equal(c.t, c.id, id)
+
+	if msg.Error != nil {
+		return msg.Error
+	}
+	if result != nil {
+		require.NoError(c.t, json.Unmarshal(msg.Result, result))
+	}
+	return nil
+}
+
+func (c *client) next() *message {
+	c.t.Helper()
+	msg, ok := <-c.messages
+	require.True(c.t, ok, "server closed the connection")
+	return msg
+}
+
+// diagnostics waits for the next diagnostics the server publishes
+func (c *client) diagnostics() PublishDiagnosticsParams {
+	c.t.Helper()
+
+	msg := c.next()
+	require.Equal(c.t, "textDocument/publishDiagnostics", msg.Method)
+	var params PublishDiagnosticsParams
+	require.NoError(c.t, json.Unmarshal(msg.Params, &params))
+	return params
+}
+
+func (c *client) open(text string) PublishDiagnosticsParams {
+	c.t.Helper()
+	c.notify("textDocument/didOpen", DidOpenTextDocumentParams{
+		TextDocument: TextDocumentItem{URI: uri, LanguageID: "stag", Version: 1, Text: text},
+	})
+	return c.diagnostics()
+}
+
+func at(line, character int) TextDocumentPositionParams {
+	return TextDocumentPositionParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Position:     Position{Line: line, Character: character},
+	}
+}
+
+func span(line, start, end int) Range {
+	return Range{Start: Position{Line: line, Character: start}, End: Position{Line: line, Character: end}}
+}
+
+const program = `fn sum(n: u16) -> u16 {
+	let total: u16 = 0;
+	while n > 0 {
+		total = total + n;
+		n = n - 1;
+	}
+	return total;
+}
+let limit = 255 + 5;
+sum(limit);
+`
+
+func TestDiagnostics(t *testing.T) {
+	c := newClient(t)
+
+	published := c.open(program)
+	require.Equal(t, uri, published.URI)
+	require.Equal(t, 1, published.Version)
+	require.Empty(t, published.Diagnostics)
+
+	tests := []struct {
+		text     string
+		expected []Diagnostic
+	}{
+		{"let x = 1 $ 2;", []Diagnostic{
+			{Range: span(0, 10, 11), Severity: SeverityError, Source: "lexer", Message: `illegal character "$"`},
+		}},
+		{"let = 5;", []Diagnostic{
+			{Range: span(0, 4, 5), Severity: SeverityError, Source: "parser", Message: "expected next token to be Ident, got Equals instead"},
+		}},
+		{"let x: u8 = 1;\nlet y: u16 = x + 300;", []Diagnostic{
+			{Range: span(1, 17, 20), Severity: SeverityError, Source: "checker", Message: "constant 300 overflows u8"},
+		}},
+		{"let x = 1;\nlet y = x / 0;", []Diagnostic{
+			{Range: span(1, 12, 13), Severity: SeverityError, Source: "checker", Message: "division by zero"},
+		}},
+		{"let x = 1 =", []Diagnostic{
+			{Range: span(0, 10, 11), Severity: SeverityError, Source: "parser", Message: "no prefix parse function for Equals found"},
+		}},
+		{program, []Diagnostic{}},
+	}
+
+	for i, tt := range tests {
+		c.notify("textDocument/didChange", DidChangeTextDocumentParams{
+			TextDocument:   VersionedTextDocumentIdentifier{URI: uri, Version: i + 2},
+			ContentChanges: []TextDocumentContentChangeEvent{{Text: tt.text}},
+		})
+		published := c.diagnostics()
+		require.Equal(t, i+2, published.Version)
+
+		// the parser may go on reporting, the first ones are checked
+		require.GreaterOrEqual(t, len(published.Diagnostics), len(tt.expected), tt.text)
+		require.Equal(t, tt.expected, published.Diagnostics[:len(tt.expected)], tt.text)
+	}
+
+	c.notify("textDocument/didClose", DidCloseTextDocumentParams{TextDocument: TextDocumentIdentifier{URI: uri}})
+	require.Empty(t, c.diagnostics().Diagnostics)
+}
+
+func TestDocumentSymbols(t *testing.T) {
+	c := newClient(t)
+	c.open(program)
+
+	var symbols []DocumentSymbol
+	c.call("textDocument/documentSymbol", DocumentSymbolParams{TextDocument: TextDocumentIdentifier{URI: uri}}, &symbols)
+
+	require.Equal(t, []DocumentSymbol{
+		{
+			Name: "sum", Detail: "fn(u16) -> u16", Kind: SymbolFunction,
+			Range:          Range{Start: Position{0, 0}, End: Position{7, 1}},
+			SelectionRange: span(0, 3, 6),
+			Children: []DocumentSymbol{
+				{Name: "n", Detail: "u16", Kind: SymbolVariable, Range: span(0, 7, 8), SelectionRange: span(0, 7, 8)},
+				{Name: "total", Detail: "u16", Kind: SymbolVariable, Range: span(1, 1, 20), SelectionRange: span(1, 5, 10)},
+			},
+		},
+		{Name: "limit", Detail: "u16", Kind: SymbolVariable, Range: span(8, 0, 20), SelectionRange: span(8, 4, 9)},
+	}, symbols)
+}
+
+func TestHover(t *testing.T) {
+	c := newClient(t)
+	c.open(program)
+
+	tests := []struct {
+		pos      TextDocumentPositionParams
+		expected string
+		r        Range
+	}{
+		{at(9, 1), "fn sum(n: u16) -> u16", span(9, 0, 3)},
+		{at(9, 4), "variable limit: u16 = 260", span(9, 4, 9)},
+		{at(9, 9), "variable limit: u16 = 260", span(9, 4, 9)},
+		{at(3, 3), "variable total: u16", span(3, 2, 7)},
+		{at(2, 7), "parameter n: u16", span(2, 7, 8)},
+		{at(8, 12), "u16 = 255", span(8, 12, 15)},
+		{at(8, 16), "u16 = 260", span(8, 16, 17)},
+		{at(2, 9), "bool", span(2, 9, 10)},
+	}
+
+	for _, tt := range tests {
+		var hover Hover
+		c.call("textDocument/hover", tt.pos, &hover)
+		require.Equal(t, "plaintext", hover.Contents.Kind)
+		require.Equal(t, tt.expected, hover.Contents.Value, tt.pos.Position)
+		require.Equal(t, tt.r, *hover.Range, tt.pos.Position)
+	}
+
+	var hover *Hover
+	c.call("textDocument/hover", at(5, 0), &hover)
+	require.Nil(t, hover)
+}
+
+func TestDefinition(t *testing.T) {
+	c := newClient(t)
+	c.open(program)
+
+	tests := []struct {
+		pos      TextDocumentPositionParams
+		expected Range
+	}{
+		{at(9, 0), span(0, 3, 6)},
+		{at(9, 6), span(8, 4, 9)},
+		{at(3, 12), span(1, 5, 10)},
+		{at(4, 6), span(0, 7, 8)},
+	}
+
+	for _, tt := range tests {
+		var loc Location
+		c.call("textDocument/definition", tt.pos, &loc)
+		require.Equal(t, Location{URI: uri, Range: tt.expected}, loc, tt.pos.Position)
+	}
+
+	// the scope finds the declarations while the text does not parse
+	c.notify("textDocument/didChange", DidChangeTextDocumentParams{
+		TextDocument:   VersionedTextDocumentIdentifier{URI: uri, Version: 2},
+		ContentChanges: []TextDocumentContentChangeEvent{{Text: "let a = 1;\nlet b = a;\nlet = 2;"}},
+	})
+	require.NotEmpty(t, c.diagnostics().Diagnostics)
+
+	var loc Location
+	c.call("textDocument/definition", at(1, 8), &loc)
+	require.Equal(t, span(0, 4, 5), loc.Range)
+}
+
+func TestCompletion(t *testing.T) {
+	c := newClient(t)
+	c.open(program)
+
+	labels := func(pos TextDocumentPositionParams) []string {
+		var list CompletionList
+		c.call("textDocument/completion", pos, &list)
+
+		var names []string
+		for _, item := range list.Items {
+			if item.Kind != CompletionKeyword {
+				names = append(names, item.Label)
+			}
+		}
+		return names
+	}
+
+	require.Equal(t, []string{"n", "sum", "total"}, labels(at(3, 0)))
+	require.Equal(t, []string{"n", "sum"}, labels(at(1, 0)))
+	require.Equal(t, []string{"sum"}, labels(at(8, 0)))
+	require.Equal(t, []string{"limit", "sum"}, labels(at(9, 0)))
+
+	var list CompletionList
+	c.call("textDocument/completion", at(9, 0), &list)
+	require.Equal(t, CompletionItem{Label: "limit", Kind: CompletionVariable, Detail: "u16"}, list.Items[0])
+	require.Equal(t, CompletionItem{Label: "sum", Kind: CompletionFunction, Detail: "fn sum(n: u16) -> u16"}, list.Items[1])
+	require.Equal(t, CompletionItem{Label: "and", Kind: CompletionKeyword}, list.Items[2])
+	require.Len(t, list.Items, 2+10)
+}
+
+func TestLifecycle(t *testing.T) {
+	c := newClient(t)
+
+	err := c.callErr("textDocument/rename", at(0, 0), nil)
+	require.EqualError(t, err, "jsonrpc error -32601: method not found: textDocument/rename")
+
+	c.call("shutdown", nil, nil)
+	err = c.callErr("textDocument/hover", at(0, 0), nil)
+	require.EqualError(t, err, "jsonrpc error -32600: server is shut down")
+
+	c.notify("exit", nil)
+	require.NoError(t, <-c.done)
+}
+
+func TestNotInitialized(t *testing.T) {
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+	done := make(chan error, 1)
+	go func() { done <- NewServer(serverIn, serverOut).Serve() }()
+
+	go writeMessage(clientOut, map[string]any{"jsonrpc": "2.0", "id": 1, "method": "shutdown"})
+	body, err := readMessage(bufio.NewReader(clientIn))
+	require.NoError(t, err)
+	require.JSONEq(t, `{"jsonrpc":"2.0","id":1,"error":{"code":-32002,"message":"server not initialized"}}`, string(body))
+
+	go writeMessage(clientOut, map[string]any{"jsonrpc": "2.0", "method": "exit"})
+	require.ErrorIs(t, <-done, ErrNoShutdown)
+}
+
+func TestContentLength(t *testing.T) {
+	for _, length := range []string{"-1", "67108865", "1e9", "x"} {
+		r := bufio.NewReader(strings.NewReader("Content-Length: " + length + "\r\n\r\n{}"))
+		_, err := readMessage(r)
+		require.ErrorIs(t, err, errBadLength, length)
+	}
+
+	r := bufio.NewReader(strings.NewReader("Content-Length: 2\r\n\r\n{}"))
+	body, err := readMessage(r)
+	require.NoError(t, err)
+	require.Equal(t, "{}", string(body))
+}
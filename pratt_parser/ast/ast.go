@@ -3,6 +3,7 @@ package ast
 import (
 	"bytes"
 	"stag/primitives"
+	"strings"
 )
 
 type Node interface {
@@ -31,8 +32,9 @@ func (p *Program) TokenLiteral() string {
 }
 
 type LetStatement struct {
-	Token *primitives.Token
+	Token primitives.Token
 	Name  *Identifier
+	Type  *Identifier // optional annotation, e.g. let x: u16 = 1;
 	Value Expression
 }
 
@@ -42,7 +44,7 @@ func (ls *LetStatement) TokenLiteral() string {
 }
 
 type Identifier struct {
-	Token *primitives.Token
+	Token primitives.Token
 	Value string
 }
 
@@ -83,6 +85,9 @@ func (ls *LetStatement) String() string {
 
 	out.WriteString(ls.TokenLiteral() + " ")
 	out.WriteString(ls.Name.String())
+	if ls.Type != nil {
+		out.WriteString(": " + ls.Type.String())
+	}
 	out.WriteString(" = ")
 
 	if ls.Value != nil {
@@ -158,3 +163,256 @@ func (oe *InfixExpression) String() string {
 	out.WriteString(")")
 	return out.String()
 }
+
+type Boolean struct {
+	Token primitives.Token
+	Value bool
+}
+
+func (b *Boolean) ExpressionNode()      {}
+func (b *Boolean) TokenLiteral() string { return b.Token.Literal }
+func (b *Boolean) String() string       { return b.Token.Literal }
+
+type CallExpression struct {
+	Token     primitives.Token // The '(' token
+	Function  Expression
+	Arguments []Expression
+}
+
+func (ce *CallExpression) ExpressionNode()      {}
+func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
+func (ce *CallExpression) String() string {
+	var out bytes.Buffer
+
+	args := make([]string, 0, len(ce.Arguments))
+	for _, a := range ce.Arguments {
+		args = append(args, a.String())
+	}
+
+	out.WriteString(ce.Function.String())
+	out.WriteString("(")
+	out.WriteString(strings.Join(args, ", "))
+	out.WriteString(")")
+	return out.String()
+}
+
+type AssignStatement struct {
+	Token primitives.Token // The '=' token
+	Name  *Identifier
+	Value Expression
+}
+
+func (as *AssignStatement) StatementNode()       {}
+func (as *AssignStatement) TokenLiteral() string { return as.Token.Literal }
+func (as *AssignStatement) String() string {
+	return as.Name.String() + " = " + as.Value.String() + ";"
+}
+
+type BlockStatement struct {
+	Token      primitives.Token // The '{' token
+	Statements []Statement
+	Rbrace     primitives.Token // The '}' token
+}
+
+func (bs *BlockStatement) StatementNode()       {}
+func (bs *BlockStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BlockStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("{ ")
+	for _, s := range bs.Statements {
+		out.WriteString(s.String())
+		out.WriteString(" ")
+	}
+	out.WriteString("}")
+	return out.String()
+}
+
+type IfStatement struct {
+	Token       primitives.Token // The 'if' token
+	Condition   Expression
+	Consequence *BlockStatement
+	Alternative *BlockStatement
+}
+
+func (is *IfStatement) StatementNode()       {}
+func (is *IfStatement) TokenLiteral() string { return is.Token.Literal }
+func (is *IfStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("if ")
+	out.WriteString(is.Condition.String())
+	out.WriteString(" ")
+	out.WriteString(is.Consequence.String())
+	if is.Alternative != nil {
+		out.WriteString(" else ")
+		out.WriteString(is.Alternative.String())
+	}
+	return out.String()
+}
+
+type WhileStatement struct {
+	Token     primitives.Token // The 'while' token
+	Condition Expression
+	Body      *BlockStatement
+}
+
+func (ws *WhileStatement) StatementNode()       {}
+func (ws *WhileStatement) TokenLiteral() string { return ws.Token.Literal }
+func (ws *WhileStatement) String() string {
+	return "while " + ws.Condition.String() + " " + ws.Body.String()
+}
+
+type Parameter struct {
+	Name *Identifier
+	Type *Identifier
+}
+
+func (p *Parameter) TokenLiteral() string { return p.Name.TokenLiteral() }
+func (p *Parameter) String() string {
+	return p.Name.String() + ": " + p.Type.String()
+}
+
+type FunctionStatement struct {
+	Token      primitives.Token // The 'fn' token
+	Name       *Identifier
+	Parameters []*Parameter
+	ReturnType *Identifier // nil when the function returns unit
+	Body       *BlockStatement
+}
+
+func (fs *FunctionStatement) StatementNode()       {}
+func (fs *FunctionStatement) TokenLiteral() string { return fs.Token.Literal }
+func (fs *FunctionStatement) String() string {
+	var out bytes.Buffer
+
+	params := make([]string, 0, len(fs.Parameters))
+	for _, p := range fs.Parameters {
+		params = append(params, p.String())
+	}
+
+	out.WriteString("fn ")
+	out.WriteString(fs.Name.String())
+	out.WriteString("(")
+	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(")")
+	if fs.ReturnType != nil {
+		out.WriteString(" -> " + fs.ReturnType.String())
+	}
+	out.WriteString(" ")
+	out.WriteString(fs.Body.String())
+	return out.String()
+}
+
+// Pos returns the line and column where the source of
+// the node starts, for infix expressions that is the
+// start of the left operand
+func Pos(node Node) (line, column int) {
+	switch n := node.(type) {
+	case *Program:
+		if len(n.Statements) > 0 {
+			return Pos(n.Statements[0])
+		}
+		return 1, 1
+	case *LetStatement:
+		return n.Token.SourceLine, n.Token.SourceColumn
+	case *Identifier:
+		return n.Token.SourceLine, n.Token.SourceColumn
+	case *ReturnStatement:
+		return n.Token.SourceLine, n.Token.SourceColumn
+	case *ExpressionStatement:
+		if n.Expression != nil {
+			return Pos(n.Expression)
+		}
+		return n.Token.SourceLine, n.Token.SourceColumn
+	case *IntegerLiteral:
+		return n.Token.SourceLine, n.Token.SourceColumn
+	case *Boolean:
+		return n.Token.SourceLine, n.Token.SourceColumn
+	case *PrefixExpression:
+		return n.Token.SourceLine, n.Token.SourceColumn
+	case *InfixExpression:
+		return Pos(n.Left)
+	case *CallExpression:
+		return Pos(n.Function)
+	case *AssignStatement:
+		return Pos(n.Name)
+	case *BlockStatement:
+		return n.Token.SourceLine, n.Token.SourceColumn
+	case *IfStatement:
+		return n.Token.SourceLine, n.Token.SourceColumn
+	case *WhileStatement:
+		return n.Token.SourceLine, n.Token.SourceColumn
+	case *FunctionStatement:
+		return n.Token.SourceLine, n.Token.SourceColumn
+	case *Parameter:
+		return Pos(n.Name)
+	}
+	return 0, 0
+}
+
+// End returns the line and column right after the last token held
+// by the node, the semicolons and the closing parenthesis of a call
+// are not in the tree so the span stops before them
+func End(node Node) (line, column int) {
+	switch n := node.(type) {
+	case *Program:
+		if len(n.Statements) > 0 {
+			return End(n.Statements[len(n.Statements)-1])
+		}
+		return 1, 1
+	case *LetStatement:
+		if n.Value != nil {
+			return End(n.Value)
+		}
+		if n.Type != nil {
+			return End(n.Type)
+		}
+		return End(n.Name)
+	case *Identifier:
+		return tokenEnd(&n.Token)
+	case *ReturnStatement:
+		if n.ReturnValue != nil {
+			return End(n.ReturnValue)
+		}
+		return tokenEnd(&n.Token)
+	case *ExpressionStatement:
+		if n.Expression != nil {
+			return End(n.Expression)
+		}
+		return tokenEnd(&n.Token)
+	case *IntegerLiteral:
+		return tokenEnd(&n.Token)
+	case *Boolean:
+		return tokenEnd(&n.Token)
+	case *PrefixExpression:
+		return End(n.Right)
+	case *InfixExpression:
+		return End(n.Right)
+	case *CallExpression:
+		if len(n.Arguments) > 0 {
+			return End(n.Arguments[len(n.Arguments)-1])
+		}
+		return tokenEnd(&n.Token)
+	case *AssignStatement:
+		return End(n.Value)
+	case *BlockStatement:
+		return tokenEnd(&n.Rbrace)
+	case *IfStatement:
+		if n.Alternative != nil {
+			return End(n.Alternative)
+		}
+		return End(n.Consequence)
+	case *WhileStatement:
+		return End(n.Body)
+	case *FunctionStatement:
+		return End(n.Body)
+	case *Parameter:
+		return End(n.Type)
+	}
+	return 0, 0
+}
+
+func tokenEnd(tok *primitives.Token) (line, column int) {
+	return tok.SourceLine, tok.SourceColumn + len(tok.Literal)
+}
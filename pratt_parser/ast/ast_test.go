@@ -9,13 +9,13 @@ func TestString(t *testing.T) {
 	program := &Program{
 		Statements: []Statement{
 			&LetStatement{
-				Token: &primitives.Token{Kind: primitives.Keyword, Literal: "let"},
+				Token: primitives.Token{Kind: primitives.Keyword, Literal: "let"},
 				Name: &Identifier{
-					Token: &primitives.Token{Kind: primitives.Ident, Literal: "myVar"},
+					Token: primitives.Token{Kind: primitives.Ident, Literal: "myVar"},
 					Value: "myVar",
 				},
 				Value: &Identifier{
-					Token: &primitives.Token{Kind: primitives.Ident, Literal: "anotherVar"},
+					Token: primitives.Token{Kind: primitives.Ident, Literal: "anotherVar"},
 					Value: "anotherVar",
 				},
 			},
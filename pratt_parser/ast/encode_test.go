@@ -0,0 +1,143 @@
+package ast_test
+
+import (
+	"reflect"
+	"stag/cst"
+	"stag/pratt_parser/ast"
+	"stag/primitives"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var corpus = []string{
+	everything,
+	"",
+	"let x = 0x10 + -y;",
+	"(1 + 2) * 3;\n(f)(x);",
+	"fn f() {}\nif a { } else if b { c; } else if d { e = 1; }",
+	"while x >= 10 and !done or y != 2 { x = x >> 1 << 2; }",
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	for _, src := range corpus {
+		program := parse(t, src)
+		data, err := ast.EncodeJSON(program)
+		require.NoError(t, err)
+		decoded, err := ast.DecodeJSON(data)
+		require.NoError(t, err)
+		require.Equal(t, program, decoded, src)
+
+		// and so do the trees derived from the concrete one
+		derived := cst.Parse(src).AST()
+		data, err = ast.EncodeJSON(derived)
+		require.NoError(t, err)
+		decoded, err = ast.DecodeJSON(data)
+		require.NoError(t, err)
+		require.Equal(t, derived, decoded, src)
+	}
+
+	data, err := ast.EncodeJSON(parse(t, "x = 1;").Statements[0])
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"node": "AssignStatement",
+		"token": {"kind": "Equals", "literal": "=", "offset": 2, "line": 1, "column": 3},
+		"name": {"node": "Identifier", "token": {"kind": "Ident", "literal": "x", "offset": 0, "line": 1, "column": 1}, "value": "x"},
+		"value": {"node": "IntegerLiteral", "token": {"kind": "Number", "literal": "1", "offset": 4, "line": 1, "column": 5}, "value": 1}
+	}`, string(data))
+}
+
+func TestSexpRoundTrip(t *testing.T) {
+	for _, src := range corpus {
+		program := withoutOffsets(parse(t, src))
+		decoded, err := ast.DecodeSexp(ast.EncodeSexp(program, true))
+		require.NoError(t, err)
+		require.Equal(t, program, decoded, src)
+
+		// without positions the shape is kept
+		text := ast.EncodeSexp(program, false)
+		decoded, err = ast.DecodeSexp(text)
+		require.NoError(t, err)
+		require.Equal(t, text, ast.EncodeSexp(decoded, false))
+		require.Equal(t, program.String(), decoded.String())
+	}
+
+	// every node reads back on its own
+	ast.Inspect(withoutOffsets(parse(t, everything)), func(node ast.Node) bool {
+		if node != nil {
+			decoded, err := ast.DecodeSexp(ast.EncodeSexp(node, true))
+			require.NoError(t, err)
+			require.Equal(t, node, decoded)
+		}
+		return true
+	})
+}
+
+// withoutOffsets zeroes the byte offsets of the tokens in the
+// tree, the s-expressions only keep lines and columns
+func withoutOffsets(node ast.Node) ast.Node {
+	var clear func(v reflect.Value)
+	clear = func(v reflect.Value) {
+		switch v.Kind() {
+		case reflect.Pointer, reflect.Interface:
+			if !v.IsNil() {
+				clear(v.Elem())
+			}
+		case reflect.Slice:
+			for i := 0; i < v.Len(); i++ {
+				clear(v.Index(i))
+			}
+		case reflect.Struct:
+			if tok, ok := v.Addr().Interface().(*primitives.Token); ok {
+				tok.Offset = 0
+				return
+			}
+			for i := 0; i < v.NumField(); i++ {
+				clear(v.Field(i))
+			}
+		}
+	}
+	clear(reflect.ValueOf(node))
+	return node
+}
+
+func TestSexp(t *testing.T) {
+	program := parse(t, "let x: u16 = 1 + 2 * 3;\nfn f(a: u16) -> u16 { return a; }")
+	require.Equal(t, `(program
+  (let@1:1 x@1:5 u16@1:8 (+@1:16 1@1:14 (*@1:20 2@1:18 3@1:22)))
+  (fn@2:1 f@2:4 ((a@2:6 u16@2:9)) u16@2:17 (block@2:21
+    (return@2:23 a@2:30) }@2:33)))`, ast.EncodeSexp(program, true))
+
+	// the expected trees can be written without the positions
+	sexp := func(src string) string {
+		return ast.EncodeSexp(parse(t, src), false)
+	}
+	require.Equal(t, "(program\n  (call (call h (- f)) (! true) (call g)))", sexp("h(-f)(!true, g())"))
+	require.Equal(t, "(program\n  (expr \"(\" (and x y)))", sexp("(x and y)"))
+	require.Equal(t, "(program\n  (if c (block\n    (= x 1)) (block \"if\"\n    (if d (block)))))", sexp("if c { x = 1; } else if d {}"))
+	require.Equal(t, "(program\n  (while (<= x 10) (block\n    (return))))", sexp("while x <= 10 { return; }"))
+
+	node, err := ast.DecodeSexp("(+ 1 (call f x))")
+	require.NoError(t, err)
+	require.Equal(t, "(1 + f(x))", node.String())
+	node, err = ast.DecodeSexp(`(expr "("@1:1 x@1:2)`)
+	require.NoError(t, err)
+	require.Equal(t, &ast.ExpressionStatement{
+		Token:      primitives.Token{Kind: primitives.OpenParen, Literal: "(", SourceLine: 1, SourceColumn: 1},
+		Expression: &ast.Identifier{Token: primitives.Token{Kind: primitives.Ident, Literal: "x", SourceLine: 1, SourceColumn: 2}, Value: "x"},
+	}, node)
+
+	for src, msg := range map[string]string{
+		"(+ 1":           "ast: 1:1: missing )",
+		"(+ 1 2) x":      "ast: 1:9: unexpected text after the expression",
+		"(let 1 2)":      `ast: 1:6: expected an identifier, got "1"`,
+		"(+ 1 2 3)":      "ast: 1:1: + takes 1 to 2 arguments, got 3",
+		"(+ let 1)":      `ast: 1:4: unexpected "let" in expression`,
+		"(call)":         "ast: 1:1: call takes a function",
+		"(x@1 1)":        "ast: 1:3: bad position",
+		"(+ (return) 1)": "ast: 1:4: unexpected return in expression",
+	} {
+		_, err := ast.DecodeSexp(src)
+		require.EqualError(t, err, msg, src)
+	}
+}
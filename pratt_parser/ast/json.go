@@ -0,0 +1,329 @@
+package ast
+
+import (
+	"encoding/json"
+	"fmt"
+	"stag/primitives"
+)
+
+// jsonToken is a token in the JSON encoding, the kinds are
+// written with their names
+type jsonToken struct {
+	Kind    string `json:"kind"`
+	Literal string `json:"literal"`
+	Offset  int    `json:"offset"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+}
+
+// jsonNode holds the fields of every node type, the node field
+// names the type and value is a node, a string, a number or a
+// boolean depending on it
+type jsonNode struct {
+	Node        string          `json:"node"`
+	Token       *jsonToken      `json:"token,omitempty"`
+	Name        *jsonNode       `json:"name,omitempty"`
+	Type        *jsonNode       `json:"type,omitempty"`
+	Operator    string          `json:"operator,omitempty"`
+	Left        *jsonNode       `json:"left,omitempty"`
+	Right       *jsonNode       `json:"right,omitempty"`
+	Value       json.RawMessage `json:"value,omitempty"`
+	ReturnValue *jsonNode       `json:"returnValue,omitempty"`
+	Expression  *jsonNode       `json:"expression,omitempty"`
+	Condition   *jsonNode       `json:"condition,omitempty"`
+	Consequence *jsonNode       `json:"consequence,omitempty"`
+	Alternative *jsonNode       `json:"alternative,omitempty"`
+	Function    *jsonNode       `json:"function,omitempty"`
+	Arguments   []*jsonNode     `json:"arguments,omitempty"`
+	Parameters  []*jsonNode     `json:"parameters,omitempty"`
+	ReturnType  *jsonNode       `json:"returnType,omitempty"`
+	Statements  []*jsonNode     `json:"statements,omitempty"`
+	Body        *jsonNode       `json:"body,omitempty"`
+	Rbrace      *jsonToken      `json:"rbrace,omitempty"`
+}
+
+var tokenKinds = map[string]primitives.TokenKind{}
+
+func init() {
+	for k := primitives.Keyword; k <= primitives.EOF; k++ {
+		tokenKinds[k.String()] = k
+	}
+}
+
+// EncodeJSON writes the tree as indented JSON, every token is kept
+// with its position
+func EncodeJSON(node Node) ([]byte, error) {
+	n, err := toJSON(node)
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(n, "", "  ")
+}
+
+// DecodeJSON reads back a tree written by EncodeJSON
+func DecodeJSON(data []byte) (Node, error) {
+	var n jsonNode
+	if err := json.Unmarshal(data, &n); err != nil {
+		return nil, err
+	}
+	return fromJSON(&n)
+}
+
+func tokenToJSON(tok *primitives.Token) *jsonToken {
+	return &jsonToken{Kind: tok.Kind.String(), Literal: tok.Literal, Offset: tok.Offset, Line: tok.SourceLine, Column: tok.SourceColumn}
+}
+
+func tokenFromJSON(tok *jsonToken) (primitives.Token, error) {
+	if tok == nil {
+		return primitives.Token{}, fmt.Errorf("ast: missing token")
+	}
+	kind, ok := tokenKinds[tok.Kind]
+	if !ok {
+		return primitives.Token{}, fmt.Errorf("ast: unknown token kind %q", tok.Kind)
+	}
+	return primitives.Token{Kind: kind, Literal: tok.Literal, Offset: tok.Offset, SourceLine: tok.Line, SourceColumn: tok.Column}, nil
+}
+
+// toJSON converts the node, a nil node stays nil
+func toJSON(node Node) (*jsonNode, error) {
+	if node == nil {
+		return nil, nil
+	}
+
+	var err error
+	// convert keeps the first error so the cases stay short
+	convert := func(node Node) *jsonNode {
+		n, e := toJSON(node)
+		if err == nil {
+			err = e
+		}
+		return n
+	}
+	raw := func(v any) json.RawMessage {
+		data, _ := json.Marshal(v)
+		return data
+	}
+	list := func(stmts []Statement) []*jsonNode {
+		out := make([]*jsonNode, 0, len(stmts))
+		for _, stmt := range stmts {
+			out = append(out, convert(stmt))
+		}
+		return out
+	}
+
+	var out *jsonNode
+	switch n := node.(type) {
+	case *Program:
+		out = &jsonNode{Node: "Program", Statements: list(n.Statements)}
+	case *LetStatement:
+		out = &jsonNode{Node: "LetStatement", Token: tokenToJSON(&n.Token), Name: convert(n.Name)}
+		if n.Type != nil {
+			out.Type = convert(n.Type)
+		}
+		if n.Value != nil {
+			out.Value = raw(convert(n.Value))
+		}
+	case *AssignStatement:
+		out = &jsonNode{Node: "AssignStatement", Token: tokenToJSON(&n.Token), Name: convert(n.Name)}
+		if n.Value != nil {
+			out.Value = raw(convert(n.Value))
+		}
+	case *ReturnStatement:
+		out = &jsonNode{Node: "ReturnStatement", Token: tokenToJSON(&n.Token), ReturnValue: convert(n.ReturnValue)}
+	case *ExpressionStatement:
+		out = &jsonNode{Node: "ExpressionStatement", Token: tokenToJSON(&n.Token), Expression: convert(n.Expression)}
+	case *BlockStatement:
+		out = &jsonNode{Node: "BlockStatement", Token: tokenToJSON(&n.Token), Statements: list(n.Statements), Rbrace: tokenToJSON(&n.Rbrace)}
+	case *IfStatement:
+		out = &jsonNode{Node: "IfStatement", Token: tokenToJSON(&n.Token), Condition: convert(n.Condition)}
+		if n.Consequence != nil {
+			out.Consequence = convert(n.Consequence)
+		}
+		if n.Alternative != nil {
+			out.Alternative = convert(n.Alternative)
+		}
+	case *WhileStatement:
+		out = &jsonNode{Node: "WhileStatement", Token: tokenToJSON(&n.Token), Condition: convert(n.Condition)}
+		if n.Body != nil {
+			out.Body = convert(n.Body)
+		}
+	case *FunctionStatement:
+		out = &jsonNode{Node: "FunctionStatement", Token: tokenToJSON(&n.Token), Name: convert(n.Name), Parameters: []*jsonNode{}}
+		for _, p := range n.Parameters {
+			out.Parameters = append(out.Parameters, convert(p))
+		}
+		if n.ReturnType != nil {
+			out.ReturnType = convert(n.ReturnType)
+		}
+		if n.Body != nil {
+			out.Body = convert(n.Body)
+		}
+	case *Parameter:
+		out = &jsonNode{Node: "Parameter", Name: convert(n.Name), Type: convert(n.Type)}
+	case *Identifier:
+		out = &jsonNode{Node: "Identifier", Token: tokenToJSON(&n.Token), Value: raw(n.Value)}
+	case *IntegerLiteral:
+		out = &jsonNode{Node: "IntegerLiteral", Token: tokenToJSON(&n.Token), Value: raw(n.Value)}
+	case *Boolean:
+		out = &jsonNode{Node: "Boolean", Token: tokenToJSON(&n.Token), Value: raw(n.Value)}
+	case *PrefixExpression:
+		out = &jsonNode{Node: "PrefixExpression", Token: tokenToJSON(&n.Token), Operator: n.Operator, Right: convert(n.Right)}
+	case *InfixExpression:
+		out = &jsonNode{Node: "InfixExpression", Token: tokenToJSON(&n.Token), Operator: n.Operator, Left: convert(n.Left), Right: convert(n.Right)}
+	case *CallExpression:
+		out = &jsonNode{Node: "CallExpression", Token: tokenToJSON(&n.Token), Function: convert(n.Function), Arguments: []*jsonNode{}}
+		for _, arg := range n.Arguments {
+			out.Arguments = append(out.Arguments, convert(arg))
+		}
+	default:
+		return nil, fmt.Errorf("ast: can not encode %T", node)
+	}
+	return out, err
+}
+
+// fromJSON builds the typed node back, a nil node stays nil
+func fromJSON(n *jsonNode) (Node, error) {
+	if n == nil {
+		return nil, nil
+	}
+
+	var err error
+	// the helpers keep the first error so the cases stay short
+	check := func(e error) {
+		if err == nil {
+			err = e
+		}
+	}
+	token := func(tok *jsonToken) primitives.Token {
+		t, e := tokenFromJSON(tok)
+		check(e)
+		return t
+	}
+	value := func(v any) {
+		if len(n.Value) == 0 {
+			check(fmt.Errorf("ast: %s without a value", n.Node))
+			return
+		}
+		check(json.Unmarshal(n.Value, v))
+	}
+	expression := func(n *jsonNode) Expression {
+		node, e := fromJSON(n)
+		check(e)
+		if node == nil {
+			return nil
+		}
+		expr, ok := node.(Expression)
+		if !ok {
+			check(fmt.Errorf("ast: %T is not an expression", node))
+		}
+		return expr
+	}
+	valueExpression := func() Expression {
+		if len(n.Value) == 0 {
+			return nil
+		}
+		var v jsonNode
+		check(json.Unmarshal(n.Value, &v))
+		return expression(&v)
+	}
+	identifier := func(n *jsonNode) *Identifier {
+		node, e := fromJSON(n)
+		check(e)
+		id, ok := node.(*Identifier)
+		if !ok {
+			check(fmt.Errorf("ast: expected an identifier, got %T", node))
+		}
+		return id
+	}
+	block := func(n *jsonNode) *BlockStatement {
+		if n == nil {
+			return nil
+		}
+		node, e := fromJSON(n)
+		check(e)
+		b, ok := node.(*BlockStatement)
+		if !ok {
+			check(fmt.Errorf("ast: expected a block, got %T", node))
+		}
+		return b
+	}
+	statements := func() []Statement {
+		out := []Statement{}
+		for _, s := range n.Statements {
+			node, e := fromJSON(s)
+			check(e)
+			stmt, ok := node.(Statement)
+			if !ok {
+				check(fmt.Errorf("ast: %T is not a statement", node))
+				continue
+			}
+			out = append(out, stmt)
+		}
+		return out
+	}
+
+	var out Node
+	switch n.Node {
+	case "Program":
+		out = &Program{Statements: statements()}
+	case "LetStatement":
+		tok := token(n.Token)
+		s := &LetStatement{Token: tok, Name: identifier(n.Name), Value: valueExpression()}
+		if n.Type != nil {
+			s.Type = identifier(n.Type)
+		}
+		out = s
+	case "AssignStatement":
+		out = &AssignStatement{Token: token(n.Token), Name: identifier(n.Name), Value: valueExpression()}
+	case "ReturnStatement":
+		out = &ReturnStatement{Token: token(n.Token), ReturnValue: expression(n.ReturnValue)}
+	case "ExpressionStatement":
+		out = &ExpressionStatement{Token: token(n.Token), Expression: expression(n.Expression)}
+	case "BlockStatement":
+		out = &BlockStatement{Token: token(n.Token), Statements: statements(), Rbrace: token(n.Rbrace)}
+	case "IfStatement":
+		out = &IfStatement{Token: token(n.Token), Condition: expression(n.Condition), Consequence: block(n.Consequence), Alternative: block(n.Alternative)}
+	case "WhileStatement":
+		out = &WhileStatement{Token: token(n.Token), Condition: expression(n.Condition), Body: block(n.Body)}
+	case "FunctionStatement":
+		s := &FunctionStatement{Token: token(n.Token), Name: identifier(n.Name), Parameters: []*Parameter{}, Body: block(n.Body)}
+		for _, p := range n.Parameters {
+			s.Parameters = append(s.Parameters, &Parameter{Name: identifier(p.Name), Type: identifier(p.Type)})
+		}
+		if n.ReturnType != nil {
+			s.ReturnType = identifier(n.ReturnType)
+		}
+		out = s
+	case "Parameter":
+		out = &Parameter{Name: identifier(n.Name), Type: identifier(n.Type)}
+	case "Identifier":
+		tok := token(n.Token)
+		id := &Identifier{Token: tok}
+		value(&id.Value)
+		out = id
+	case "IntegerLiteral":
+		lit := &IntegerLiteral{Token: token(n.Token)}
+		value(&lit.Value)
+		out = lit
+	case "Boolean":
+		b := &Boolean{Token: token(n.Token)}
+		value(&b.Value)
+		out = b
+	case "PrefixExpression":
+		out = &PrefixExpression{Token: token(n.Token), Operator: n.Operator, Right: expression(n.Right)}
+	case "InfixExpression":
+		out = &InfixExpression{Token: token(n.Token), Operator: n.Operator, Left: expression(n.Left), Right: expression(n.Right)}
+	case "CallExpression":
+		call := &CallExpression{Token: token(n.Token), Function: expression(n.Function), Arguments: []Expression{}}
+		for _, arg := range n.Arguments {
+			call.Arguments = append(call.Arguments, expression(arg))
+		}
+		out = call
+	default:
+		return nil, fmt.Errorf("ast: unknown node %q", n.Node)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,46 @@
+package ast_test
+
+import (
+	"fmt"
+	"stag/pratt_parser/ast"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpans(t *testing.T) {
+	program := parse(t, "let x: u16 = -a + f(1, b);\nfn g(a: u8) {\n\treturn;\n}\ng()")
+
+	spans := []string{}
+	ast.Inspect(program, func(node ast.Node) bool {
+		if node != nil {
+			line, column := ast.Pos(node)
+			endLine, endColumn := ast.End(node)
+			spans = append(spans, fmt.Sprintf("%T %d:%d-%d:%d", node, line, column, endLine, endColumn))
+		}
+		return true
+	})
+	require.Equal(t, []string{
+		"*ast.Program 1:1-5:3",
+		"*ast.LetStatement 1:1-1:25",
+		"*ast.Identifier 1:5-1:6",
+		"*ast.Identifier 1:8-1:11",
+		"*ast.InfixExpression 1:14-1:25",
+		"*ast.PrefixExpression 1:14-1:16",
+		"*ast.Identifier 1:15-1:16",
+		"*ast.CallExpression 1:19-1:25",
+		"*ast.Identifier 1:19-1:20",
+		"*ast.IntegerLiteral 1:21-1:22",
+		"*ast.Identifier 1:24-1:25",
+		"*ast.FunctionStatement 2:1-4:2",
+		"*ast.Identifier 2:4-2:5",
+		"*ast.Parameter 2:6-2:11",
+		"*ast.Identifier 2:6-2:7",
+		"*ast.Identifier 2:9-2:11",
+		"*ast.BlockStatement 2:13-4:2",
+		"*ast.ReturnStatement 3:2-3:8",
+		"*ast.ExpressionStatement 5:1-5:3",
+		"*ast.CallExpression 5:1-5:3",
+		"*ast.Identifier 5:1-5:2",
+	}, spans)
+}
@@ -0,0 +1,674 @@
+package ast
+
+import (
+	"fmt"
+	"stag/lexer"
+	"stag/primitives"
+	"strconv"
+	"strings"
+)
+
+// The S-expression encoding writes the operators and keywords as the
+// heads of the lists and the identifiers and literals as atoms, each
+// token followed by @line:column when the positions are kept:
+//
+//	(program
+//	  (let@1:1 x@1:5 u16@1:8 (+@1:16 1@1:14 (*@1:20 2@1:18 3@1:22)))
+//	  (fn@2:1 f@2:4 ((a@2:6 u16@2:9)) u16@2:17 (block@2:21
+//	    (return@2:23 a@2:30) }@2:33)))
+//
+// Calls are (call f args...) and blocks (block stmts...), the
+// closing brace of a block comes last when it has a position. An
+// expression statement is the bare expression, its token is the
+// first one of the expression unless it is given as in (expr "(" e).
+// The token kinds are recovered from the literals and () stands for
+// a missing node
+
+// EncodeSexp writes the tree as an S-expression, positions keeps
+// the line and column of the tokens but not their byte offset
+func EncodeSexp(node Node, positions bool) string {
+	w := &sexpWriter{positions: positions}
+	if stmt, ok := node.(*ExpressionStatement); ok {
+		// a bare expression would read back as the expression
+		w.expressionStatement(stmt, false)
+		return w.out.String()
+	}
+	w.node(node)
+	return w.out.String()
+}
+
+type sexpWriter struct {
+	out       strings.Builder
+	positions bool
+	depth     int
+}
+
+// atom writes text as is when it reads back as one atom
+func (w *sexpWriter) atom(text string) {
+	if text == "" || text == "()" || strings.ContainsAny(text, " \t\r\n()\"@") {
+		text = strconv.Quote(text)
+	}
+	w.out.WriteString(text)
+}
+
+func (w *sexpWriter) pos(tok *primitives.Token) {
+	if w.positions {
+		fmt.Fprintf(&w.out, "@%d:%d", tok.SourceLine, tok.SourceColumn)
+	}
+}
+
+func (w *sexpWriter) token(text string, tok *primitives.Token) {
+	w.atom(text)
+	w.pos(tok)
+}
+
+// quoted writes a token that differs from the one its form implies
+func (w *sexpWriter) quoted(tok *primitives.Token) {
+	w.out.WriteString(" " + strconv.Quote(tok.Literal))
+	w.pos(tok)
+}
+
+func (w *sexpWriter) node(node Node) {
+	switch n := node.(type) {
+	case nil:
+		w.out.WriteString("()")
+	case Statement:
+		w.statement(n)
+	case Expression:
+		w.expression(n)
+	case *Program:
+		w.out.WriteString("(program")
+		w.statements(n.Statements)
+		w.out.WriteString(")")
+	case *Parameter:
+		w.out.WriteString("(param ")
+		w.parameter(n)
+		w.out.WriteString(")")
+	default:
+		panic(fmt.Sprintf("ast.EncodeSexp: unexpected node type %T", n))
+	}
+}
+
+// statements writes each statement on a line of its own
+func (w *sexpWriter) statements(stmts []Statement) {
+	w.depth++
+	for _, stmt := range stmts {
+		w.out.WriteString("\n" + strings.Repeat("  ", w.depth))
+		w.statement(stmt)
+	}
+	w.depth--
+}
+
+func (w *sexpWriter) parameter(p *Parameter) {
+	w.identifier(p.Name)
+	w.out.WriteString(" ")
+	w.identifier(p.Type)
+}
+
+func (w *sexpWriter) identifier(id *Identifier) {
+	if id == nil {
+		w.out.WriteString("()")
+		return
+	}
+	w.token(id.Value, &id.Token)
+}
+
+func (w *sexpWriter) block(b *BlockStatement) {
+	if b == nil {
+		w.out.WriteString("()")
+		return
+	}
+	w.out.WriteString("(block")
+	// a quoted closing brace needs the opening one quoted as well
+	// to tell them apart in an empty block
+	if b.Token.Literal == "{" && b.Rbrace.Literal == "}" {
+		w.pos(&b.Token)
+	} else {
+		w.quoted(&b.Token)
+	}
+	w.statements(b.Statements)
+	if b.Rbrace.Literal != "}" {
+		w.quoted(&b.Rbrace)
+	} else if w.positions {
+		w.out.WriteString(" }")
+		w.pos(&b.Rbrace)
+	}
+	w.out.WriteString(")")
+}
+
+func (w *sexpWriter) statement(stmt Statement) {
+	switch n := stmt.(type) {
+	case nil:
+		w.out.WriteString("()")
+	case *LetStatement:
+		w.out.WriteString("(")
+		w.token(n.Token.Literal, &n.Token)
+		w.out.WriteString(" ")
+		w.identifier(n.Name)
+		if n.Type != nil {
+			w.out.WriteString(" ")
+			w.identifier(n.Type)
+		}
+		w.out.WriteString(" ")
+		w.expression(n.Value)
+		w.out.WriteString(")")
+	case *AssignStatement:
+		w.out.WriteString("(")
+		w.token(n.Token.Literal, &n.Token)
+		w.out.WriteString(" ")
+		w.identifier(n.Name)
+		w.out.WriteString(" ")
+		w.expression(n.Value)
+		w.out.WriteString(")")
+	case *ReturnStatement:
+		w.out.WriteString("(")
+		w.token(n.Token.Literal, &n.Token)
+		if n.ReturnValue != nil {
+			w.out.WriteString(" ")
+			w.expression(n.ReturnValue)
+		}
+		w.out.WriteString(")")
+	case *ExpressionStatement:
+		w.expressionStatement(n, true)
+	case *BlockStatement:
+		w.block(n)
+	case *IfStatement:
+		w.out.WriteString("(")
+		w.token(n.Token.Literal, &n.Token)
+		w.out.WriteString(" ")
+		w.expression(n.Condition)
+		w.out.WriteString(" ")
+		w.block(n.Consequence)
+		if n.Alternative != nil {
+			w.out.WriteString(" ")
+			w.block(n.Alternative)
+		}
+		w.out.WriteString(")")
+	case *WhileStatement:
+		w.out.WriteString("(")
+		w.token(n.Token.Literal, &n.Token)
+		w.out.WriteString(" ")
+		w.expression(n.Condition)
+		w.out.WriteString(" ")
+		w.block(n.Body)
+		w.out.WriteString(")")
+	case *FunctionStatement:
+		w.out.WriteString("(")
+		w.token(n.Token.Literal, &n.Token)
+		w.out.WriteString(" ")
+		w.identifier(n.Name)
+		w.out.WriteString(" (")
+		for i, p := range n.Parameters {
+			if i > 0 {
+				w.out.WriteString(" ")
+			}
+			w.out.WriteString("(")
+			w.parameter(p)
+			w.out.WriteString(")")
+		}
+		w.out.WriteString(")")
+		if n.ReturnType != nil {
+			w.out.WriteString(" ")
+			w.identifier(n.ReturnType)
+		}
+		w.out.WriteString(" ")
+		w.block(n.Body)
+		w.out.WriteString(")")
+	default:
+		panic(fmt.Sprintf("ast.EncodeSexp: unexpected node type %T", n))
+	}
+}
+
+// expressionStatement writes the bare expression when it is allowed
+// and the token of the statement is the first one of the expression
+func (w *sexpWriter) expressionStatement(n *ExpressionStatement, bare bool) {
+	first := firstToken(n.Expression)
+	implied := first != nil && first.Kind == n.Token.Kind && first.Literal == n.Token.Literal &&
+		first.SourceLine == n.Token.SourceLine && first.SourceColumn == n.Token.SourceColumn
+	if bare && implied {
+		w.expression(n.Expression)
+		return
+	}
+
+	w.out.WriteString("(expr")
+	if !implied {
+		w.quoted(&n.Token)
+	}
+	w.out.WriteString(" ")
+	w.expression(n.Expression)
+	w.out.WriteString(")")
+}
+
+func (w *sexpWriter) expression(e Expression) {
+	switch n := e.(type) {
+	case nil:
+		w.out.WriteString("()")
+	case *Identifier:
+		w.identifier(n)
+	case *IntegerLiteral:
+		text := n.Token.Literal
+		if v, err := strconv.ParseInt(text, 0, 64); err != nil || v != n.Value {
+			text = strconv.FormatInt(n.Value, 10)
+		}
+		w.token(text, &n.Token)
+	case *Boolean:
+		w.token(strconv.FormatBool(n.Value), &n.Token)
+	case *PrefixExpression:
+		w.out.WriteString("(")
+		w.token(n.Operator, &n.Token)
+		w.out.WriteString(" ")
+		w.expression(n.Right)
+		w.out.WriteString(")")
+	case *InfixExpression:
+		w.out.WriteString("(")
+		w.token(n.Operator, &n.Token)
+		w.out.WriteString(" ")
+		w.expression(n.Left)
+		w.out.WriteString(" ")
+		w.expression(n.Right)
+		w.out.WriteString(")")
+	case *CallExpression:
+		w.out.WriteString("(call")
+		if n.Token.Literal == "(" {
+			w.pos(&n.Token)
+		} else {
+			w.quoted(&n.Token)
+		}
+		w.out.WriteString(" ")
+		w.expression(n.Function)
+		for _, arg := range n.Arguments {
+			w.out.WriteString(" ")
+			w.expression(arg)
+		}
+		w.out.WriteString(")")
+	default:
+		panic(fmt.Sprintf("ast.EncodeSexp: unexpected node type %T", n))
+	}
+}
+
+// firstToken returns the token the source of the expression starts
+// with when it has no parentheses around
+func firstToken(e Expression) *primitives.Token {
+	switch n := e.(type) {
+	case *Identifier:
+		return &n.Token
+	case *IntegerLiteral:
+		return &n.Token
+	case *Boolean:
+		return &n.Token
+	case *PrefixExpression:
+		return &n.Token
+	case *InfixExpression:
+		return firstToken(n.Left)
+	case *CallExpression:
+		return firstToken(n.Function)
+	}
+	return nil
+}
+
+// sexp is a list or an atom read from the source
+type sexp struct {
+	list   []*sexp
+	isList bool
+
+	text   string
+	quoted bool
+	line   int // position of the token, 0 when the atom has none
+	column int
+
+	at string // line:column in the S-expression for the errors
+}
+
+func (s *sexp) isNil() bool {
+	return s.isList && len(s.list) == 0
+}
+
+// DecodeSexp reads back a tree written by EncodeSexp, the tokens
+// without a position get line and column 0
+func DecodeSexp(src string) (node Node, err error) {
+	r := &sexpReader{src: src, line: 1, column: 1}
+	s, err := r.read()
+	if err != nil {
+		return nil, err
+	}
+	if r.skip(); r.pos < len(r.src) {
+		return nil, fmt.Errorf("ast: %s: unexpected text after the expression", r.at())
+	}
+
+	defer func() {
+		// the decoder panics with the errors to keep it short
+		if r := recover(); r != nil {
+			e, ok := r.(sexpError)
+			if !ok {
+				panic(r)
+			}
+			node, err = nil, e
+		}
+	}()
+
+	if s.isList && len(s.list) > 0 && !s.list[0].isList {
+		switch s.list[0].text {
+		case "program":
+			return &Program{Statements: statementList(s.list[1:])}, nil
+		case "param":
+			args := arity(s, 2, 2)
+			return &Parameter{Name: decodeIdentifier(args[0]), Type: decodeIdentifier(args[1])}, nil
+		case "expr":
+			return decodeStatement(s), nil
+		}
+		if isStatementHead(s.list[0].text) {
+			return decodeStatement(s), nil
+		}
+	}
+	return decodeExpression(s), nil
+}
+
+type sexpError string
+
+func (e sexpError) Error() string { return string(e) }
+
+func fail(s *sexp, format string, args ...any) {
+	panic(sexpError(fmt.Sprintf("ast: %s: ", s.at) + fmt.Sprintf(format, args...)))
+}
+
+type sexpReader struct {
+	src          string
+	pos          int
+	line, column int
+}
+
+func (r *sexpReader) at() string {
+	return fmt.Sprintf("%d:%d", r.line, r.column)
+}
+
+func (r *sexpReader) advance(n int) {
+	for _, ch := range r.src[r.pos : r.pos+n] {
+		if ch == '\n' {
+			r.line, r.column = r.line+1, 1
+		} else {
+			r.column++
+		}
+	}
+	r.pos += n
+}
+
+func (r *sexpReader) skip() {
+	for r.pos < len(r.src) && strings.IndexByte(" \t\r\n", r.src[r.pos]) >= 0 {
+		r.advance(1)
+	}
+}
+
+func (r *sexpReader) read() (*sexp, error) {
+	r.skip()
+	s := &sexp{at: r.at()}
+	if r.pos == len(r.src) {
+		return nil, fmt.Errorf("ast: %s: unexpected end of input", s.at)
+	}
+
+	switch r.src[r.pos] {
+	case '(':
+		r.advance(1)
+		s.isList = true
+		for {
+			r.skip()
+			if r.pos == len(r.src) {
+				return nil, fmt.Errorf("ast: %s: missing )", s.at)
+			}
+			if r.src[r.pos] == ')' {
+				r.advance(1)
+				return s, nil
+			}
+			item, err := r.read()
+			if err != nil {
+				return nil, err
+			}
+			s.list = append(s.list, item)
+		}
+	case ')':
+		return nil, fmt.Errorf("ast: %s: unexpected )", s.at)
+	case '"':
+		text, err := strconv.QuotedPrefix(r.src[r.pos:])
+		if err != nil {
+			return nil, fmt.Errorf("ast: %s: bad string", s.at)
+		}
+		s.text, _ = strconv.Unquote(text)
+		s.quoted = true
+		r.advance(len(text))
+	default:
+		end := r.pos
+		for end < len(r.src) && strings.IndexByte(" \t\r\n()\"@", r.src[end]) < 0 {
+			end++
+		}
+		s.text = r.src[r.pos:end]
+		r.advance(end - r.pos)
+	}
+
+	if r.pos < len(r.src) && r.src[r.pos] == '@' {
+		end := r.pos + 1
+		for end < len(r.src) && strings.IndexByte("0123456789:", r.src[end]) >= 0 {
+			end++
+		}
+		line, column, ok := strings.Cut(r.src[r.pos+1:end], ":")
+		l, err1 := strconv.Atoi(line)
+		c, err2 := strconv.Atoi(column)
+		if !ok || err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("ast: %s: bad position", r.at())
+		}
+		s.line, s.column = l, c
+		r.advance(end - r.pos)
+	}
+	return s, nil
+}
+
+// token builds the token of an atom, the kind comes from lexing
+// the literal
+func token(s *sexp) primitives.Token {
+	if s.isList {
+		fail(s, "expected a token")
+	}
+	tok := lexer.New(s.text).NextToken()
+	return primitives.Token{Kind: tok.Kind, Literal: s.text, SourceLine: s.line, SourceColumn: s.column}
+}
+
+// arity returns the arguments after the head of the list
+func arity(s *sexp, min, max int) []*sexp {
+	if !s.isList || len(s.list) == 0 {
+		fail(s, "expected a list")
+	}
+	args := s.list[1:]
+	if len(args) < min || len(args) > max {
+		fail(s, "%s takes %d to %d arguments, got %d", s.list[0].text, min, max, len(args))
+	}
+	return args
+}
+
+func isStatementHead(head string) bool {
+	switch head {
+	case lexer.Let, lexer.Return, lexer.If, lexer.While, lexer.Fn, "=", "block", "expr":
+		return true
+	}
+	return false
+}
+
+func statementList(list []*sexp) []Statement {
+	stmts := []Statement{}
+	for _, s := range list {
+		stmts = append(stmts, decodeStatement(s))
+	}
+	return stmts
+}
+
+func decodeStatement(s *sexp) Statement {
+	if s.isNil() {
+		return nil
+	}
+	if !s.isList || s.list[0].isList || !isStatementHead(s.list[0].text) {
+		e := decodeExpression(s)
+		first := firstToken(e)
+		if first == nil {
+			fail(s, "missing the token of the expression statement")
+		}
+		return &ExpressionStatement{Token: *first, Expression: e}
+	}
+
+	head := s.list[0]
+	switch head.text {
+	case lexer.Let:
+		args := arity(s, 2, 3)
+		tok := token(head)
+		stmt := &LetStatement{Token: tok, Name: decodeIdentifier(args[0])}
+		if len(args) == 3 {
+			stmt.Type = decodeIdentifier(args[1])
+		}
+		stmt.Value = decodeExpression(args[len(args)-1])
+		return stmt
+	case "=":
+		args := arity(s, 2, 2)
+		return &AssignStatement{Token: token(head), Name: decodeIdentifier(args[0]), Value: decodeExpression(args[1])}
+	case lexer.Return:
+		args := arity(s, 0, 1)
+		stmt := &ReturnStatement{Token: token(head)}
+		if len(args) == 1 {
+			stmt.ReturnValue = decodeExpression(args[0])
+		}
+		return stmt
+	case "expr":
+		args := arity(s, 1, 2)
+		e := decodeExpression(args[len(args)-1])
+		if len(args) == 2 {
+			return &ExpressionStatement{Token: token(args[0]), Expression: e}
+		}
+		first := firstToken(e)
+		if first == nil {
+			fail(s, "missing the token of the expression statement")
+		}
+		return &ExpressionStatement{Token: *first, Expression: e}
+	case "block":
+		return decodeBlock(s)
+	case lexer.If:
+		args := arity(s, 2, 3)
+		stmt := &IfStatement{Token: token(head), Condition: decodeExpression(args[0]), Consequence: decodeBlock(args[1])}
+		if len(args) == 3 {
+			stmt.Alternative = decodeBlock(args[2])
+		}
+		return stmt
+	case lexer.While:
+		args := arity(s, 2, 2)
+		return &WhileStatement{Token: token(head), Condition: decodeExpression(args[0]), Body: decodeBlock(args[1])}
+	}
+
+	// fn name (params...) [type] block
+	args := arity(s, 3, 4)
+	stmt := &FunctionStatement{Token: token(head), Name: decodeIdentifier(args[0]), Parameters: []*Parameter{}}
+	if !args[1].isList {
+		fail(args[1], "expected the parameter list")
+	}
+	for _, p := range args[1].list {
+		if !p.isList || len(p.list) != 2 {
+			fail(p, "expected a parameter")
+		}
+		stmt.Parameters = append(stmt.Parameters, &Parameter{Name: decodeIdentifier(p.list[0]), Type: decodeIdentifier(p.list[1])})
+	}
+	if len(args) == 4 {
+		stmt.ReturnType = decodeIdentifier(args[2])
+	}
+	stmt.Body = decodeBlock(args[len(args)-1])
+	return stmt
+}
+
+func decodeBlock(s *sexp) *BlockStatement {
+	if s.isNil() {
+		return nil
+	}
+	if !s.isList || s.list[0].isList || s.list[0].text != "block" {
+		fail(s, "expected a block")
+	}
+
+	head := s.list[0]
+	b := &BlockStatement{
+		Token:  primitives.Token{Kind: primitives.OpenCurlyBrace, Literal: "{", SourceLine: head.line, SourceColumn: head.column},
+		Rbrace: primitives.Token{Kind: primitives.CloseCurlyBrace, Literal: "}"},
+	}
+	items := s.list[1:]
+	if len(items) > 0 && items[0].quoted {
+		b.Token = token(items[0])
+		items = items[1:]
+	}
+	if n := len(items); n > 0 && !items[n-1].isList && (items[n-1].quoted || items[n-1].text == "}") {
+		b.Rbrace = token(items[n-1])
+		items = items[:n-1]
+	}
+	b.Statements = statementList(items)
+	return b
+}
+
+func decodeIdentifier(s *sexp) *Identifier {
+	if s.isNil() {
+		return nil
+	}
+	tok := token(s)
+	if tok.Kind != primitives.Ident {
+		fail(s, "expected an identifier, got %q", s.text)
+	}
+	return &Identifier{Token: tok, Value: s.text}
+}
+
+func decodeExpression(s *sexp) Expression {
+	if s.isNil() {
+		return nil
+	}
+
+	if !s.isList {
+		tok := token(s)
+		switch {
+		case s.text == lexer.True || s.text == lexer.False:
+			return &Boolean{Token: tok, Value: s.text == lexer.True}
+		case tok.Kind == primitives.Ident:
+			return &Identifier{Token: tok, Value: s.text}
+		}
+
+		// a folded constant can be negative
+		value, err := strconv.ParseInt(s.text, 0, 64)
+		if err != nil {
+			fail(s, "unexpected %q in expression", s.text)
+		}
+		tok.Kind = primitives.Number
+		return &IntegerLiteral{Token: tok, Value: value}
+	}
+
+	if s.isNil() || s.list[0].isList {
+		fail(s, "expected an operator")
+	}
+	head := s.list[0]
+	if head.text == "call" {
+		if len(s.list) < 2 {
+			fail(s, "call takes a function")
+		}
+		call := &CallExpression{
+			Token:     primitives.Token{Kind: primitives.OpenParen, Literal: "(", SourceLine: head.line, SourceColumn: head.column},
+			Arguments: []Expression{},
+		}
+		args := s.list[1:]
+		if args[0].quoted {
+			call.Token = token(args[0])
+			args = args[1:]
+		}
+		if len(args) == 0 {
+			fail(s, "call takes a function")
+		}
+		call.Function = decodeExpression(args[0])
+		for _, arg := range args[1:] {
+			call.Arguments = append(call.Arguments, decodeExpression(arg))
+		}
+		return call
+	}
+	if isStatementHead(head.text) {
+		fail(s, "unexpected %s in expression", head.text)
+	}
+
+	args := arity(s, 1, 2)
+	if len(args) == 1 {
+		return &PrefixExpression{Token: token(head), Operator: head.text, Right: decodeExpression(args[0])}
+	}
+	return &InfixExpression{Token: token(head), Operator: head.text, Left: decodeExpression(args[0]), Right: decodeExpression(args[1])}
+}
@@ -0,0 +1,247 @@
+package ast
+
+import "fmt"
+
+// A Visitor's Visit method is called by Walk for every node, when
+// the returned visitor w is not nil the children of the node are
+// walked with it and then w.Visit(nil) is called
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses the tree in depth-first order starting at node,
+// the missing nodes of a partial tree are skipped
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	switch n := node.(type) {
+	case *Program:
+		walkStatements(v, n.Statements)
+	case *LetStatement:
+		Walk(v, n.Name)
+		if n.Type != nil {
+			Walk(v, n.Type)
+		}
+		if n.Value != nil {
+			Walk(v, n.Value)
+		}
+	case *AssignStatement:
+		Walk(v, n.Name)
+		if n.Value != nil {
+			Walk(v, n.Value)
+		}
+	case *ReturnStatement:
+		if n.ReturnValue != nil {
+			Walk(v, n.ReturnValue)
+		}
+	case *ExpressionStatement:
+		if n.Expression != nil {
+			Walk(v, n.Expression)
+		}
+	case *BlockStatement:
+		walkStatements(v, n.Statements)
+	case *IfStatement:
+		if n.Condition != nil {
+			Walk(v, n.Condition)
+		}
+		if n.Consequence != nil {
+			Walk(v, n.Consequence)
+		}
+		if n.Alternative != nil {
+			Walk(v, n.Alternative)
+		}
+	case *WhileStatement:
+		if n.Condition != nil {
+			Walk(v, n.Condition)
+		}
+		if n.Body != nil {
+			Walk(v, n.Body)
+		}
+	case *FunctionStatement:
+		Walk(v, n.Name)
+		for _, p := range n.Parameters {
+			Walk(v, p)
+		}
+		if n.ReturnType != nil {
+			Walk(v, n.ReturnType)
+		}
+		if n.Body != nil {
+			Walk(v, n.Body)
+		}
+	case *Parameter:
+		Walk(v, n.Name)
+		Walk(v, n.Type)
+	case *PrefixExpression:
+		if n.Right != nil {
+			Walk(v, n.Right)
+		}
+	case *InfixExpression:
+		if n.Left != nil {
+			Walk(v, n.Left)
+		}
+		if n.Right != nil {
+			Walk(v, n.Right)
+		}
+	case *CallExpression:
+		Walk(v, n.Function)
+		for _, arg := range n.Arguments {
+			Walk(v, arg)
+		}
+	case *Identifier, *IntegerLiteral, *Boolean:
+		// leaves
+	default:
+		panic(fmt.Sprintf("ast.Walk: unexpected node type %T", n))
+	}
+
+	v.Visit(nil)
+}
+
+func walkStatements(v Visitor, stmts []Statement) {
+	for _, stmt := range stmts {
+		if stmt != nil {
+			Walk(v, stmt)
+		}
+	}
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses the tree in depth-first order calling f for
+// every node, the children are skipped when f returns false. After
+// the children f is called with nil
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
+
+// Rewrite traverses the tree in depth-first order and replaces every
+// node with what f returns for it, the children of a node are
+// rewritten before it. The nodes are changed in place and the new
+// root is returned. A nil from f drops a statement from its list
+// and clears any other field, a node that does not fit its field
+// makes Rewrite panic
+func Rewrite(node Node, f func(Node) Node) Node {
+	switch n := node.(type) {
+	case *Program:
+		n.Statements = rewriteStatements(n.Statements, f)
+	case *LetStatement:
+		n.Name = rewrite(n.Name, f)
+		if n.Type != nil {
+			n.Type = rewrite(n.Type, f)
+		}
+		if n.Value != nil {
+			n.Value = rewrite(n.Value, f)
+		}
+	case *AssignStatement:
+		n.Name = rewrite(n.Name, f)
+		if n.Value != nil {
+			n.Value = rewrite(n.Value, f)
+		}
+	case *ReturnStatement:
+		if n.ReturnValue != nil {
+			n.ReturnValue = rewrite(n.ReturnValue, f)
+		}
+	case *ExpressionStatement:
+		if n.Expression != nil {
+			n.Expression = rewrite(n.Expression, f)
+		}
+	case *BlockStatement:
+		n.Statements = rewriteStatements(n.Statements, f)
+	case *IfStatement:
+		if n.Condition != nil {
+			n.Condition = rewrite(n.Condition, f)
+		}
+		if n.Consequence != nil {
+			n.Consequence = rewrite(n.Consequence, f)
+		}
+		if n.Alternative != nil {
+			n.Alternative = rewrite(n.Alternative, f)
+		}
+	case *WhileStatement:
+		if n.Condition != nil {
+			n.Condition = rewrite(n.Condition, f)
+		}
+		if n.Body != nil {
+			n.Body = rewrite(n.Body, f)
+		}
+	case *FunctionStatement:
+		n.Name = rewrite(n.Name, f)
+		params := n.Parameters[:0]
+		for _, p := range n.Parameters {
+			if p = rewrite(p, f); p != nil {
+				params = append(params, p)
+			}
+		}
+		n.Parameters = params
+		if n.ReturnType != nil {
+			n.ReturnType = rewrite(n.ReturnType, f)
+		}
+		if n.Body != nil {
+			n.Body = rewrite(n.Body, f)
+		}
+	case *Parameter:
+		n.Name = rewrite(n.Name, f)
+		n.Type = rewrite(n.Type, f)
+	case *PrefixExpression:
+		if n.Right != nil {
+			n.Right = rewrite(n.Right, f)
+		}
+	case *InfixExpression:
+		if n.Left != nil {
+			n.Left = rewrite(n.Left, f)
+		}
+		if n.Right != nil {
+			n.Right = rewrite(n.Right, f)
+		}
+	case *CallExpression:
+		n.Function = rewrite(n.Function, f)
+		args := n.Arguments[:0]
+		for _, arg := range n.Arguments {
+			if arg = rewrite(arg, f); arg != nil {
+				args = append(args, arg)
+			}
+		}
+		n.Arguments = args
+	case *Identifier, *IntegerLiteral, *Boolean:
+		// leaves
+	default:
+		panic(fmt.Sprintf("ast.Rewrite: unexpected node type %T", n))
+	}
+
+	return f(node)
+}
+
+func rewriteStatements(stmts []Statement, f func(Node) Node) []Statement {
+	out := stmts[:0]
+	for _, stmt := range stmts {
+		if stmt == nil {
+			continue
+		}
+		if stmt = rewrite(stmt, f); stmt != nil {
+			out = append(out, stmt)
+		}
+	}
+	return out
+}
+
+// rewrite rewrites n and checks the result fits where n was
+func rewrite[T Node](n T, f func(Node) Node) T {
+	var zero T
+	out := Rewrite(n, f)
+	if out == nil {
+		return zero
+	}
+	t, ok := out.(T)
+	if !ok {
+		panic(fmt.Sprintf("ast.Rewrite: %T does not fit in place of %T", out, n))
+	}
+	return t
+}
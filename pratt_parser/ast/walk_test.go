@@ -0,0 +1,196 @@
+package ast_test
+
+import (
+	goast "go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"sort"
+	"stag/lexer"
+	"stag/pratt_parser"
+	"stag/pratt_parser/ast"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// every node type shows up in this program
+const everything = `
+let x: u16 = -(1 + 2);
+x = x * 3;
+fn f(a: u16, b: u16) -> u16 {
+	if a < b { return a; } else if !true { return b; } else { return 0; }
+}
+while false { f(x, 2); }
+fn g() { return; }
+`
+
+func parse(t *testing.T, src string) *ast.Program {
+	t.Helper()
+	p := pratt_parser.New(lexer.New(src))
+	program := p.ParseProgram()
+	require.Empty(t, p.Errors())
+	return program
+}
+
+// nodeTypes lists the types declared in ast.go with a TokenLiteral method
+func nodeTypes(t *testing.T) []string {
+	t.Helper()
+	file, err := parser.ParseFile(token.NewFileSet(), "ast.go", nil, 0)
+	require.NoError(t, err)
+
+	names := []string{}
+	for _, decl := range file.Decls {
+		fn, ok := decl.(*goast.FuncDecl)
+		if !ok || fn.Recv == nil || fn.Name.Name != "TokenLiteral" {
+			continue
+		}
+		star := fn.Recv.List[0].Type.(*goast.StarExpr)
+		names = append(names, "*ast."+star.X.(*goast.Ident).Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// children finds the child nodes through reflection, it is what
+// Walk has to visit for the node
+func children(node ast.Node) []ast.Node {
+	nodeType := reflect.TypeOf((*ast.Node)(nil)).Elem()
+	var out []ast.Node
+	add := func(v reflect.Value) {
+		if v.Kind() == reflect.Interface || v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return
+			}
+		}
+		if v.Type().Implements(nodeType) {
+			out = append(out, v.Interface().(ast.Node))
+		}
+	}
+
+	v := reflect.ValueOf(node).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		if field.Kind() == reflect.Slice {
+			for j := 0; j < field.Len(); j++ {
+				add(field.Index(j))
+			}
+			continue
+		}
+		add(field)
+	}
+	return out
+}
+
+func TestWalkExhaustive(t *testing.T) {
+	program := parse(t, everything)
+
+	seen := map[string]bool{}
+	var stack []ast.Node
+	visited := map[ast.Node][]ast.Node{}
+	ast.Inspect(program, func(node ast.Node) bool {
+		if node == nil {
+			stack = stack[:len(stack)-1]
+			return true
+		}
+		seen[reflect.TypeOf(node).String()] = true
+		if len(stack) > 0 {
+			parent := stack[len(stack)-1]
+			visited[parent] = append(visited[parent], node)
+		}
+		stack = append(stack, node)
+		return true
+	})
+	require.Empty(t, stack)
+
+	// a new node type has to be added to the program above, Walk
+	// panics on it when it is not handled
+	types := []string{}
+	for name := range seen {
+		types = append(types, name)
+	}
+	sort.Strings(types)
+	require.Equal(t, nodeTypes(t), types)
+
+	// and every field holding nodes has to be walked
+	ast.Inspect(program, func(node ast.Node) bool {
+		if node != nil {
+			require.Equal(t, children(node), visited[node], "children of %T", node)
+		}
+		return true
+	})
+}
+
+func TestInspectSkip(t *testing.T) {
+	program := parse(t, everything)
+
+	names := []string{}
+	ast.Inspect(program, func(node ast.Node) bool {
+		switch n := node.(type) {
+		case *ast.FunctionStatement:
+			names = append(names, n.Name.Value)
+			return false
+		case *ast.Identifier:
+			names = append(names, n.Value)
+		}
+		return true
+	})
+	require.Equal(t, []string{"x", "u16", "x", "x", "f", "f", "x", "g"}, names)
+}
+
+func TestRewrite(t *testing.T) {
+	program := parse(t, "let x = 1 + 2 * 3; x = x + 0; 7; fn f(a: u16) { return a + 0; }")
+
+	// fold the literals, drop x + 0 and the expression statements
+	out := ast.Rewrite(program, func(node ast.Node) ast.Node {
+		switch n := node.(type) {
+		case *ast.InfixExpression:
+			l, lok := n.Left.(*ast.IntegerLiteral)
+			r, rok := n.Right.(*ast.IntegerLiteral)
+			switch {
+			case lok && rok && n.Operator == "+":
+				return &ast.IntegerLiteral{Token: l.Token, Value: l.Value + r.Value}
+			case lok && rok && n.Operator == "*":
+				return &ast.IntegerLiteral{Token: l.Token, Value: l.Value * r.Value}
+			case rok && r.Value == 0:
+				return n.Left
+			}
+		case *ast.IntegerLiteral:
+			n.Token.Literal = ""
+		case *ast.ExpressionStatement:
+			return nil
+		}
+		return node
+	})
+	require.Same(t, program, out)
+
+	values := []int64{}
+	ast.Inspect(program, func(node ast.Node) bool {
+		if n, ok := node.(*ast.IntegerLiteral); ok {
+			values = append(values, n.Value)
+		}
+		return true
+	})
+	require.Equal(t, []int64{7}, values)
+	require.Len(t, program.Statements, 3)
+	require.Equal(t, "x = x;", program.Statements[1].String())
+	require.Equal(t, "return a;", program.Statements[2].(*ast.FunctionStatement).Body.Statements[0].String())
+
+	// the children are rewritten before their parent
+	order := []string{}
+	ast.Rewrite(parse(t, "f(-a, b)"), func(node ast.Node) ast.Node {
+		order = append(order, reflect.TypeOf(node).Elem().Name())
+		return node
+	})
+	require.Equal(t, []string{"Identifier", "Identifier", "PrefixExpression", "Identifier", "CallExpression", "ExpressionStatement", "Program"}, order)
+
+	// a let can not be named by a literal
+	require.PanicsWithValue(t, "ast.Rewrite: *ast.IntegerLiteral does not fit in place of *ast.Identifier", func() {
+		ast.Rewrite(parse(t, "let a = 1;"), func(node ast.Node) ast.Node {
+			if _, ok := node.(*ast.Identifier); ok {
+				return &ast.IntegerLiteral{Value: 1}
+			}
+			return node
+		})
+	})
+}
@@ -0,0 +1,37 @@
+package pratt_parser_test
+
+import (
+	"stag/format"
+	"stag/lexer"
+	"stag/pratt_parser"
+	"stag/pratt_parser/ast"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// parse gives the parser twice the tokens of the source, a parser
+// asking for more is going around in circles
+func parse(src string) (*ast.Program, []string) {
+	budget := 2*len(lexer.Tokenize(src)) + 8
+	p := pratt_parser.New(lexer.New(src, lexer.MaxTokens(budget)))
+	program := p.ParseProgram()
+	return program, p.Errors()
+}
+
+// the seed corpus in testdata/fuzz comes from examples and the tests
+func FuzzParser(f *testing.F) {
+	f.Fuzz(func(t *testing.T, src string) {
+		program, errs := parse(src)
+		if len(errs) > 0 {
+			return
+		}
+
+		// parse, print and parse again gives back the same tree
+		printed := format.Program(program, nil)
+		again, errs := parse(printed)
+		require.Empty(t, errs, "%q printed as %q", src, printed)
+		require.Equal(t, program.String(), again.String(), "%q printed as %q", src, printed)
+		require.Equal(t, printed, format.Program(again, nil))
+	})
+}
@@ -11,8 +11,11 @@ import (
 const (
 	_           int = iota
 	LOWEST          // 	-
+	OR              //	or
+	AND             //	and
 	EQUALS          //	==
 	LESSGREATER     // 	> or <
+	SHIFT           //	<< or >>
 	SUM             //	+
 	PRODUCT         // 	*
 	PREFIX          //	-X or !X
@@ -20,37 +23,69 @@ const (
 )
 
 var precedences = map[primitives.TokenKind]int{
-	primitives.Equal:    EQUALS,
-	primitives.NotEqual: EQUALS,
-	primitives.Less:     LESSGREATER,
-	primitives.Greater:  LESSGREATER,
-	primitives.Plus:     SUM,
-	primitives.Minus:    SUM,
-	primitives.Slash:    PRODUCT,
-	primitives.Star:     PRODUCT,
+	primitives.Equal:          EQUALS,
+	primitives.NotEqual:       EQUALS,
+	primitives.Less:           LESSGREATER,
+	primitives.Greater:        LESSGREATER,
+	primitives.LessOrEqual:    LESSGREATER,
+	primitives.GreaterOrEqual: LESSGREATER,
+	primitives.ShiftLeft:      SHIFT,
+	primitives.ShiftRight:     SHIFT,
+	primitives.Plus:           SUM,
+	primitives.Minus:          SUM,
+	primitives.Slash:          PRODUCT,
+	primitives.Star:           PRODUCT,
+	primitives.OpenParen:      CALL,
+}
+
+// keywordPrecedences holds the binding power of the
+// keywords that behave as infix operators
+var keywordPrecedences = map[string]int{
+	lexer.Or:  OR,
+	lexer.And: AND,
+}
+
+// Error is a syntax error at the token where it was found,
+// Code tells what kind of error it is, e.g. E0102
+type Error struct {
+	Line   int
+	Column int
+	Code   string
+	Msg    string
+}
+
+// the codes of the syntax errors, the E01 block
+const (
+	CodeExpected   = "E0101" // the grammar needs another token
+	CodeNoPrefix   = "E0102" // no expression starts with the token
+	CodeKeyword    = "E0103" // no expression starts with the keyword
+	CodeUnclosed   = "E0104" // the input ends inside a block
+	CodeBadInteger = "E0105" // the number does not fit in 64 bits
+)
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Msg)
 }
 
 type Parser struct {
 	l            *lexer.Lexer
-	errors       []string
-	currentToken *primitives.Token
-	peekToken    *primitives.Token
+	errorList    []*Error
+	currentToken primitives.Token
 
 	prefixParseFns map[primitives.TokenKind]prefixParseFn
 	infixParseFns  map[primitives.TokenKind]infixParseFn
 }
 
 func New(l *lexer.Lexer) *Parser {
-	p := &Parser{
-		l:      l,
-		errors: []string{},
-	}
+	p := &Parser{l: l}
 
 	p.prefixParseFns = make(map[primitives.TokenKind]prefixParseFn)
 	p.registerPrefix(primitives.Ident, p.parseIdentifier)
 	p.registerPrefix(primitives.Number, p.parseIntegerLiteral)
 	p.registerPrefix(primitives.Bang, p.parsePrefixExpression)
 	p.registerPrefix(primitives.Minus, p.parsePrefixExpression)
+	p.registerPrefix(primitives.OpenParen, p.parseGroupedExpression)
+	p.registerPrefix(primitives.Keyword, p.parseKeywordExpression)
 
 	p.infixParseFns = make(map[primitives.TokenKind]infixParseFn)
 	p.registerInfix(primitives.Plus, p.parseInfixExpression)
@@ -61,31 +96,42 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(primitives.NotEqual, p.parseInfixExpression)
 	p.registerInfix(primitives.Less, p.parseInfixExpression)
 	p.registerInfix(primitives.Greater, p.parseInfixExpression)
+	p.registerInfix(primitives.LessOrEqual, p.parseInfixExpression)
+	p.registerInfix(primitives.GreaterOrEqual, p.parseInfixExpression)
+	p.registerInfix(primitives.ShiftLeft, p.parseInfixExpression)
+	p.registerInfix(primitives.ShiftRight, p.parseInfixExpression)
+	p.registerInfix(primitives.Keyword, p.parseInfixExpression)
+	p.registerInfix(primitives.OpenParen, p.parseCallExpression)
 
-	p.nextToken()
 	p.nextToken()
 	return p
 }
 
 func (p *Parser) parsePrefixExpression() ast.Expression {
 	expression := &ast.PrefixExpression{
-		Token:    *p.currentToken,
+		Token:    p.currentToken,
 		Operator: p.currentToken.Literal,
 	}
 	p.nextToken()
 	expression.Right = p.parseExpression(PREFIX)
+	if expression.Right == nil {
+		return nil
+	}
 	return expression
 }
 
 func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	expression := &ast.InfixExpression{
-		Token:    *p.currentToken,
+		Token:    p.currentToken,
 		Operator: p.currentToken.Literal,
 		Left:     left,
 	}
 	precedence := p.curPrecedence()
 	p.nextToken()
 	expression.Right = p.parseExpression(precedence)
+	if expression.Right == nil {
+		return nil
+	}
 	return expression
 }
 
@@ -93,19 +139,101 @@ func (p *Parser) parseIdentifier() ast.Expression {
 	return &ast.Identifier{Token: p.currentToken, Value: p.currentToken.Literal}
 }
 
+func (p *Parser) parseGroupedExpression() ast.Expression {
+	p.nextToken()
+	exp := p.parseExpression(LOWEST)
+	if !p.expectPeek(primitives.CloseParen) {
+		return nil
+	}
+	return exp
+}
+
+// parseKeywordExpression handles the keywords that can start
+// an expression, currently only the boolean literals
+func (p *Parser) parseKeywordExpression() ast.Expression {
+	switch p.currentToken.Literal {
+	case lexer.True:
+		return &ast.Boolean{Token: p.currentToken, Value: true}
+	case lexer.False:
+		return &ast.Boolean{Token: p.currentToken, Value: false}
+	}
+
+	msg := fmt.Sprintf("unexpected keyword %q in expression", p.currentToken.Literal)
+	p.errorAt(p.currentToken, CodeKeyword, msg)
+	return nil
+}
+
+func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
+	exp := &ast.CallExpression{Token: p.currentToken, Function: function}
+	exp.Arguments = p.parseCallArguments()
+	if exp.Arguments == nil {
+		return nil
+	}
+	return exp
+}
+
+func (p *Parser) parseCallArguments() []ast.Expression {
+	args := []ast.Expression{}
+
+	if p.peekTokenIs(primitives.CloseParen) {
+		p.nextToken()
+		return args
+	}
+
+	p.nextToken()
+	arg := p.parseExpression(LOWEST)
+	if arg == nil {
+		return nil
+	}
+	args = append(args, arg)
+
+	for p.peekTokenIs(primitives.Comma) {
+		p.nextToken()
+		p.nextToken()
+		arg := p.parseExpression(LOWEST)
+		if arg == nil {
+			return nil
+		}
+		args = append(args, arg)
+	}
+
+	if !p.expectPeek(primitives.CloseParen) {
+		return nil
+	}
+	return args
+}
+
 func (p *Parser) Errors() []string {
-	return p.errors
+	errs := make([]string, 0, len(p.errorList))
+	for _, err := range p.errorList {
+		errs = append(errs, err.Msg)
+	}
+	return errs
 }
 
-func (p *Parser) peekError(t primitives.TokenKind) {
-	msg := fmt.Sprintf("expected next token to be %s, got %s instead", t, p.peekToken.Kind)
-	p.errors = append(p.errors, msg)
+// ErrorList returns the errors with their positions, Errors only
+// has their messages
+func (p *Parser) ErrorList() []*Error {
+	return p.errorList
+}
 
+func (p *Parser) errorAt(tok primitives.Token, code, msg string) {
+	p.errorList = append(p.errorList, &Error{Line: tok.SourceLine, Column: tok.SourceColumn, Code: code, Msg: msg})
+}
+
+func (p *Parser) peekError(t primitives.TokenKind) {
+	msg := fmt.Sprintf("expected next token to be %s, got %s instead", t, p.peekToken().Kind)
+	p.errorAt(p.peekToken(), CodeExpected, msg)
 }
 
 func (p *Parser) nextToken() {
-	p.currentToken = p.peekToken
-	p.peekToken = p.l.NextToken()
+	p.currentToken = p.l.NextToken()
+}
+
+// peekToken is the token after the current one, the lexer holds
+// it until nextToken takes it
+func (p *Parser) peekToken() primitives.Token {
+	return p.l.Peek(0)
 }
 
 func (p *Parser) ParserProgram() *ast.Program {
@@ -126,17 +254,52 @@ func (p *Parser) ParseProgram() *ast.Program {
 	return program
 }
 
+// parseStatement leaves the current token at the last
+// token of the statement it parsed
 func (p *Parser) parseStatement() ast.Statement {
+	// the helpers return typed nil pointers on failure, which must not
+	// leak into the ast.Statement interface as non-nil values
 	switch p.currentToken.Kind {
 	case primitives.Keyword:
-		if p.currentToken.Literal == "let" {
-			return p.parseLetStatement()
-		}
-		if p.currentToken.Literal == "return" {
+		switch p.currentToken.Literal {
+		case lexer.Let:
+			if stmt := p.parseLetStatement(); stmt != nil {
+				return stmt
+			}
+			return nil
+		case lexer.Return:
 			return p.parseReturnStatement()
+		case lexer.Fn:
+			if stmt := p.parseFunctionStatement(); stmt != nil {
+				return stmt
+			}
+			return nil
+		case lexer.If:
+			if stmt := p.parseIfStatement(); stmt != nil {
+				return stmt
+			}
+			return nil
+		case lexer.While:
+			if stmt := p.parseWhileStatement(); stmt != nil {
+				return stmt
+			}
+			return nil
 		}
-	default:
-		return p.parseExpressionStatement()
+	case primitives.Ident:
+		if p.peekTokenIs(primitives.Assign) {
+			if stmt := p.parseAssignStatement(); stmt != nil {
+				return stmt
+			}
+			return nil
+		}
+	case primitives.OpenCurlyBrace:
+		return p.parseBlockStatement()
+	case primitives.Semicolon:
+		return nil
+	}
+
+	if stmt := p.parseExpressionStatement(); stmt != nil {
+		return stmt
 	}
 	return nil
 }
@@ -149,31 +312,223 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 
 	stmt.Name = &ast.Identifier{Token: p.currentToken, Value: p.currentToken.Literal}
 
+	if p.peekTokenIs(primitives.Colon) {
+		p.nextToken()
+		if !p.expectPeek(primitives.Ident) {
+			return nil
+		}
+		stmt.Type = &ast.Identifier{Token: p.currentToken, Value: p.currentToken.Literal}
+	}
+
 	if !p.expectPeek(primitives.Assign) {
 		return nil
 	}
 
-	for !p.currentTokenIs(primitives.Semicolon) {
+	p.nextToken()
+	stmt.Value = p.parseExpression(LOWEST)
+	if stmt.Value == nil {
+		return nil
+	}
+
+	if p.peekTokenIs(primitives.Semicolon) {
 		p.nextToken()
 	}
 	return stmt
 }
 
 func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
-	stmt := &ast.ReturnStatement{Token: *p.currentToken}
+	stmt := &ast.ReturnStatement{Token: p.currentToken}
+
+	if p.peekTokenIs(primitives.Semicolon) {
+		p.nextToken()
+		return stmt
+	}
+
+	if p.peekTokenIs(primitives.CloseCurlyBrace) || p.peekTokenIs(primitives.EOF) {
+		return stmt
+	}
+
+	p.nextToken()
+	stmt.ReturnValue = p.parseExpression(LOWEST)
+
+	if p.peekTokenIs(primitives.Semicolon) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseAssignStatement() *ast.AssignStatement {
+	name := &ast.Identifier{Token: p.currentToken, Value: p.currentToken.Literal}
+	p.nextToken()
+
+	stmt := &ast.AssignStatement{Token: p.currentToken, Name: name}
+	p.nextToken()
+	stmt.Value = p.parseExpression(LOWEST)
+	if stmt.Value == nil {
+		return nil
+	}
+
+	if p.peekTokenIs(primitives.Semicolon) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+func (p *Parser) parseBlockStatement() *ast.BlockStatement {
+	block := &ast.BlockStatement{Token: p.currentToken}
+	block.Statements = []ast.Statement{}
+
+	p.nextToken()
+	for !p.currentTokenIs(primitives.CloseCurlyBrace) {
+		if p.currentTokenIs(primitives.EOF) {
+			p.errorAt(p.currentToken, CodeUnclosed, "expected } to close the block, got EOF instead")
+			return block
+		}
+
+		stmt := p.parseStatement()
+		if stmt != nil {
+			block.Statements = append(block.Statements, stmt)
+		}
+		p.nextToken()
+	}
+	block.Rbrace = p.currentToken
+	return block
+}
+
+func (p *Parser) parseIfStatement() *ast.IfStatement {
+	stmt := &ast.IfStatement{Token: p.currentToken}
+
+	p.nextToken()
+	stmt.Condition = p.parseExpression(LOWEST)
+	if stmt.Condition == nil {
+		return nil
+	}
+
+	if !p.expectPeek(primitives.OpenCurlyBrace) {
+		return nil
+	}
+	stmt.Consequence = p.parseBlockStatement()
 
+	if !p.peekKeywordIs(lexer.Else) {
+		return stmt
+	}
 	p.nextToken()
-	for !p.currentTokenIs(primitives.Semicolon) {
+
+	// else if ... is sugar for else { if ... }
+	if p.peekKeywordIs(lexer.If) {
 		p.nextToken()
+		block := &ast.BlockStatement{Token: p.currentToken}
+		nested := p.parseIfStatement()
+		if nested == nil {
+			return nil
+		}
+		block.Statements = []ast.Statement{nested}
+		block.Rbrace = p.currentToken
+		stmt.Alternative = block
+		return stmt
 	}
 
+	if !p.expectPeek(primitives.OpenCurlyBrace) {
+		return nil
+	}
+	stmt.Alternative = p.parseBlockStatement()
 	return stmt
 }
 
+func (p *Parser) parseWhileStatement() *ast.WhileStatement {
+	stmt := &ast.WhileStatement{Token: p.currentToken}
+
+	p.nextToken()
+	stmt.Condition = p.parseExpression(LOWEST)
+	if stmt.Condition == nil {
+		return nil
+	}
+
+	if !p.expectPeek(primitives.OpenCurlyBrace) {
+		return nil
+	}
+	stmt.Body = p.parseBlockStatement()
+	return stmt
+}
+
+func (p *Parser) parseFunctionStatement() *ast.FunctionStatement {
+	stmt := &ast.FunctionStatement{Token: p.currentToken}
+
+	if !p.expectPeek(primitives.Ident) {
+		return nil
+	}
+	stmt.Name = &ast.Identifier{Token: p.currentToken, Value: p.currentToken.Literal}
+
+	if !p.expectPeek(primitives.OpenParen) {
+		return nil
+	}
+
+	stmt.Parameters = p.parseFunctionParameters()
+	if stmt.Parameters == nil {
+		return nil
+	}
+
+	if p.peekTokenIs(primitives.Arrow) {
+		p.nextToken()
+		if !p.expectPeek(primitives.Ident) {
+			return nil
+		}
+		stmt.ReturnType = &ast.Identifier{Token: p.currentToken, Value: p.currentToken.Literal}
+	}
+
+	if !p.expectPeek(primitives.OpenCurlyBrace) {
+		return nil
+	}
+	stmt.Body = p.parseBlockStatement()
+	return stmt
+}
+
+func (p *Parser) parseFunctionParameters() []*ast.Parameter {
+	params := []*ast.Parameter{}
+
+	if p.peekTokenIs(primitives.CloseParen) {
+		p.nextToken()
+		return params
+	}
+
+	for {
+		if !p.expectPeek(primitives.Ident) {
+			return nil
+		}
+		param := &ast.Parameter{
+			Name: &ast.Identifier{Token: p.currentToken, Value: p.currentToken.Literal},
+		}
+
+		if !p.expectPeek(primitives.Colon) {
+			return nil
+		}
+		if !p.expectPeek(primitives.Ident) {
+			return nil
+		}
+		param.Type = &ast.Identifier{Token: p.currentToken, Value: p.currentToken.Literal}
+		params = append(params, param)
+
+		if !p.peekTokenIs(primitives.Comma) {
+			break
+		}
+		p.nextToken()
+	}
+
+	if !p.expectPeek(primitives.CloseParen) {
+		return nil
+	}
+	return params
+}
+
 func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
-	stmt := &ast.ExpressionStatement{Token: *p.currentToken}
+	stmt := &ast.ExpressionStatement{Token: p.currentToken}
 
 	stmt.Expression = p.parseExpression(LOWEST)
+	if stmt.Expression == nil {
+		return nil
+	}
+
 	if p.peekTokenIs(primitives.Semicolon) {
 		p.nextToken()
 	}
@@ -182,7 +537,7 @@ func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 
 func (p *Parser) noPrefixParseFnError(t primitives.TokenKind) {
 	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+	p.errorAt(p.currentToken, CodeNoPrefix, msg)
 }
 
 func (p *Parser) parseExpression(precedence int) ast.Expression {
@@ -192,8 +547,8 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 		return nil
 	}
 	leftExp := prefix()
-	for !p.peekTokenIs(primitives.Semicolon) && precedence < p.peekPrecedence() {
-		infix := p.infixParseFns[p.peekToken.Kind]
+	for leftExp != nil && !p.peekTokenIs(primitives.Semicolon) && precedence < p.peekPrecedence() {
+		infix := p.infixParseFns[p.peekToken().Kind]
 		if infix == nil {
 			return leftExp
 		}
@@ -208,7 +563,12 @@ func (p *Parser) currentTokenIs(t primitives.TokenKind) bool {
 }
 
 func (p *Parser) peekTokenIs(t primitives.TokenKind) bool {
-	return p.peekToken.Kind == t
+	return p.peekToken().Kind == t
+}
+
+func (p *Parser) peekKeywordIs(keyword string) bool {
+	tok := p.peekToken()
+	return tok.Kind == primitives.Keyword && tok.Literal == keyword
 }
 
 func (p *Parser) expectPeek(t primitives.TokenKind) bool {
@@ -234,11 +594,11 @@ func (p *Parser) registerInfix(tokenType primitives.TokenKind, fn infixParseFn)
 }
 
 func (p *Parser) parseIntegerLiteral() ast.Expression {
-	lit := &ast.IntegerLiteral{Token: *p.currentToken}
+	lit := &ast.IntegerLiteral{Token: p.currentToken}
 	value, err := strconv.ParseInt(p.currentToken.Literal, 0, 64)
 	if err != nil {
 		msg := fmt.Sprintf("could not parse %q as integer", p.currentToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.errorAt(p.currentToken, CodeBadInteger, msg)
 		return nil
 	}
 	lit.Value = value
@@ -247,13 +607,26 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 }
 
 func (p *Parser) peekPrecedence() int {
-	if p, ok := precedences[p.peekToken.Kind]; ok {
-		return p
-	}
-	return LOWEST
+	return precedenceOf(p.peekToken())
 }
 func (p *Parser) curPrecedence() int {
-	if p, ok := precedences[p.currentToken.Kind]; ok {
+	return precedenceOf(p.currentToken)
+}
+
+// Precedence returns the binding power of the token
+// as an infix operator, LOWEST when it is not one
+func Precedence(tok primitives.Token) int {
+	return precedenceOf(tok)
+}
+
+func precedenceOf(tok primitives.Token) int {
+	if tok.Kind == primitives.Keyword {
+		if p, ok := keywordPrecedences[tok.Literal]; ok {
+			return p
+		}
+		return LOWEST
+	}
+	if p, ok := precedences[tok.Kind]; ok {
 		return p
 	}
 	return LOWEST
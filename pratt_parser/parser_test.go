@@ -335,3 +335,206 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 		}
 	}
 }
+
+func TestLetStatementValues(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedIdent string
+		expectedType  string
+		expected      string
+	}{
+		{"let x = 5;", "x", "", "let x = 5;"},
+		{"let y: u16 = 10 + 2", "y", "u16", "let y: u16 = (10 + 2);"},
+		{"let ok: bool = true and x < 3;", "ok", "bool", "let ok: bool = (true and (x < 3));"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statement. got=%d", len(program.Statements))
+		}
+		if !testLetStatement(t, program.Statements[0], tt.expectedIdent) {
+			return
+		}
+
+		letStmt := program.Statements[0].(*ast.LetStatement)
+		if tt.expectedType == "" && letStmt.Type != nil {
+			t.Errorf("letStmt.Type not nil. got=%s", letStmt.Type)
+		}
+		if tt.expectedType != "" && (letStmt.Type == nil || letStmt.Type.Value != tt.expectedType) {
+			t.Errorf("letStmt.Type not %s. got=%v", tt.expectedType, letStmt.Type)
+		}
+		if letStmt.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, letStmt.String())
+		}
+	}
+}
+
+func TestFunctionStatement(t *testing.T) {
+	input := `fn add(a: u16, b: u16) -> u16 {
+		return a + b;
+	}
+	fn nothing() {}`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	if len(program.Statements) != 2 {
+		t.Fatalf("program.Statements does not contain 2 statements. got=%d", len(program.Statements))
+	}
+
+	fn, ok := program.Statements[0].(*ast.FunctionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.FunctionStatement. got=%T", program.Statements[0])
+	}
+	if fn.Name.Value != "add" {
+		t.Errorf("fn.Name not 'add'. got=%s", fn.Name.Value)
+	}
+	if len(fn.Parameters) != 2 {
+		t.Fatalf("fn.Parameters wrong. want 2, got=%d", len(fn.Parameters))
+	}
+	if fn.Parameters[0].String() != "a: u16" || fn.Parameters[1].String() != "b: u16" {
+		t.Errorf("fn.Parameters wrong. got=%s, %s", fn.Parameters[0], fn.Parameters[1])
+	}
+	if fn.ReturnType == nil || fn.ReturnType.Value != "u16" {
+		t.Errorf("fn.ReturnType not u16. got=%v", fn.ReturnType)
+	}
+	if len(fn.Body.Statements) != 1 {
+		t.Fatalf("fn.Body.Statements has not 1 statement. got=%d", len(fn.Body.Statements))
+	}
+	if fn.Body.String() != "{ return (a + b); }" {
+		t.Errorf("fn.Body wrong. got=%q", fn.Body.String())
+	}
+
+	nothing := program.Statements[1].(*ast.FunctionStatement)
+	if len(nothing.Parameters) != 0 || nothing.ReturnType != nil {
+		t.Errorf("nothing signature wrong. got=%s", nothing.String())
+	}
+}
+
+func TestControlFlowStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"if x < y { x = y; }", "if (x < y) { x = y; }"},
+		{"if x { 1 } else { 2 }", "if x { 1 } else { 2 }"},
+		{"if a { 1 } else if b { 2 } else { 3 }", "if a { 1 } else { if b { 2 } else { 3 } }"},
+		{"while i > 0 { i = i - 1; }", "while (i > 0) { i = (i - 1); }"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statement. got=%d", len(program.Statements))
+		}
+		if actual := program.String(); actual != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, actual)
+		}
+	}
+}
+
+func TestCallAndGroupedExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"add(1, 2 * 3, f(x))", "add(1, (2 * 3), f(x))"},
+		{"a + add(b * c) + d", "((a + add((b * c))) + d)"},
+		{"(5 + 5) * 2", "((5 + 5) * 2)"},
+		{"-(5 + 5)", "(-(5 + 5))"},
+		{"a <= b or c >= d and !e", "((a <= b) or ((c >= d) and (!e)))"},
+		{"true == false", "(true == false)"},
+		{"a << 2 + 1 < b >> c", "((a << (2 + 1)) < (b >> c))"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if actual := program.String(); actual != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, actual)
+		}
+	}
+}
+
+func TestParserErrors(t *testing.T) {
+	tests := []string{
+		"let = 5;",
+		"let x: = 5;",
+		"fn f(a u16) {}",
+		"if x { 1",
+		"(1 + 2",
+	}
+
+	for _, input := range tests {
+		l := lexer.New(input)
+		p := New(l)
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("expected parser errors for %q", input)
+		}
+	}
+}
+
+func TestParserErrorPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"let = 5;", []string{
+			"1:5: expected next token to be Ident, got Equals instead",
+			"1:5: no prefix parse function for Equals found",
+		}},
+		{"let x = 1;\nfn f(a u16) {}", []string{
+			"2:8: expected next token to be Colon, got Ident instead",
+		}},
+		{"if x {\n1", []string{"2:2: expected } to close the block, got EOF instead"}},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		var got []string
+		for _, err := range p.ErrorList() {
+			got = append(got, err.Error())
+		}
+		if len(got) < len(tt.expected) {
+			t.Fatalf("%q: expected errors %q, got %q", tt.input, tt.expected, got)
+		}
+		for i := range tt.expected {
+			if got[i] != tt.expected[i] {
+				t.Errorf("%q: expected error %q, got %q", tt.input, tt.expected[i], got[i])
+			}
+		}
+		if len(got) != len(p.Errors()) {
+			t.Errorf("%q: ErrorList and Errors differ", tt.input)
+		}
+	}
+}
+
+func TestParserErrorCodes(t *testing.T) {
+	tests := map[string]string{
+		"let x = ;":             CodeNoPrefix,
+		"let = 5;":              CodeExpected,
+		"let x = let;":          CodeKeyword,
+		"if x {\n1":             CodeUnclosed,
+		"99999999999999999999;": CodeBadInteger,
+	}
+	for input, code := range tests {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.ErrorList()) == 0 || p.ErrorList()[0].Code != code {
+			t.Errorf("%q: expected the first error to be %s, got %v", input, code, p.ErrorList())
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package primitives
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 type TokenKind uint8
 
@@ -38,6 +41,14 @@ func (tk TokenKind) String() string {
 		return "LessOrEqual"
 	case GreaterOrEqual:
 		return "GreaterOrEqual"
+	case ShiftLeft:
+		return "ShiftLeft"
+	case ShiftRight:
+		return "ShiftRight"
+	case OpenCurlyBrace:
+		return "OpenCurlyBrace"
+	case CloseCurlyBrace:
+		return "CloseCurlyBrace"
 	case OpenBrackets:
 		return "OpenBrackets"
 	case CloseBrackets:
@@ -50,6 +61,12 @@ func (tk TokenKind) String() string {
 		return "Comma"
 	case Semicolon:
 		return "Semicolon"
+	case Colon:
+		return "Colon"
+	case Arrow:
+		return "Arrow"
+	case Comment:
+		return "Comment"
 	case Illegal:
 		return "Illegal"
 	case EOF:
@@ -78,6 +95,8 @@ const (
 	Equal          // ==
 	LessOrEqual    // <=
 	GreaterOrEqual // >=
+	ShiftLeft      // <<
+	ShiftRight     // >>
 
 	OpenCurlyBrace  // {
 	CloseCurlyBrace // }
@@ -90,19 +109,67 @@ const (
 
 	Comma     // ,
 	Semicolon // ;
+	Colon     // :
+	Arrow     // ->
+
+	Comment // // up to the end of the line
 
 	Illegal
 
 	EOF
 )
 
+// Token is a span of the source, Literal is the text of the span
+// and Offset the byte where it starts. Tokens are small values, the
+// lexer hands them out by value
 type Token struct {
 	Kind         TokenKind
 	Literal      string
+	Offset       int
 	SourceColumn int
 	SourceLine   int
 }
 
+type TriviaKind uint8
+
+const (
+	Whitespace TriviaKind = iota // spaces, tabs and carriage returns
+	Newline
+	LineComment
+)
+
+func (k TriviaKind) String() string {
+	switch k {
+	case Whitespace:
+		return "Whitespace"
+	case Newline:
+		return "Newline"
+	case LineComment:
+		return "LineComment"
+	default:
+		return fmt.Sprintf("Unknown(%d)", k)
+	}
+}
+
+// Trivia is source text between tokens the parser does not see
+type Trivia struct {
+	Kind TriviaKind
+	Text string
+}
+
+// Text returns the source of a token with its trivia
+func Text(tok Token, leading, trailing []Trivia) string {
+	var out strings.Builder
+	for _, trivia := range leading {
+		out.WriteString(trivia.Text)
+	}
+	out.WriteString(tok.Literal)
+	for _, trivia := range trailing {
+		out.WriteString(trivia.Text)
+	}
+	return out.String()
+}
+
 func (t *Token) String() string {
 	return fmt.Sprintf("Token (Kind: %s, Literal: %s)", t.Kind, t.Literal)
 }
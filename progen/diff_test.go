@@ -0,0 +1,185 @@
+package progen_test
+
+import (
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"stag/codegen"
+	"stag/codegen/rust16vm"
+	_ "stag/codegen/rust16vm/asm"
+	_ "stag/codegen/stack"
+	"stag/eval"
+	"stag/frontend"
+	"stag/ir"
+	"stag/progen"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	seed     = flag.Int64("seed", 1, "seed of the first generated program")
+	programs = flag.Int("programs", 300, "how many programs to generate")
+	size     = flag.Int("size", progen.DefaultConfig.Size, "statements of the generated programs")
+	depth    = flag.Int("depth", progen.DefaultConfig.Depth, "nesting of the generated programs")
+)
+
+// maxSteps bounds both sides, the shrinker can make loops
+// that never end out of the ones that did
+const maxSteps = 1_000_000
+
+// run returns what the interpreter and the compiled program make
+// of src, checked is false when it does not type check and done is
+// false when one of the sides ran out of steps. The program runs on
+// every target at every level, when they disagree compiled lists
+// each result
+func run(src string) (interpreted, compiled string, checked, done bool) {
+	program, info, errs := frontend.Source(src, frontend.Options{})
+	if len(errs) > 0 {
+		return "", "", false, false
+	}
+
+	// the program is compiled at every optimization level
+	// for every target
+	var results []string
+	agree := true
+	levels := ir.Levels(rust16vm.RV16)
+	for _, level := range slices.Sorted(maps.Keys(levels)) {
+		code := ir.Lower(program, info)
+		if passes := levels[level]; passes != nil {
+			if err := ir.Optimize(code, passes...); err != nil {
+				return "", level + ": " + err.Error(), true, true
+			}
+		}
+		for _, name := range codegen.Names() {
+			result, ok := runTarget(name, code)
+			if !ok {
+				return "", "", true, false
+			}
+			results = append(results, level+" "+name+": "+result)
+			if len(results) == 1 {
+				compiled = result
+			}
+			agree = agree && result == compiled
+		}
+	}
+	if !agree {
+		compiled = strings.Join(results, ", ")
+	}
+
+	in := eval.New()
+	in.MaxSteps = maxSteps
+	value, err := in.Run(program)
+	switch {
+	case err != nil && strings.HasSuffix(err.Error(), "step limit exceeded"):
+		return "", "", true, false
+	case err != nil:
+		interpreted = "error"
+	default:
+		interpreted = fmt.Sprint(eval.Uint16(value))
+	}
+	return interpreted, compiled, true, true
+}
+
+// runTarget compiles the program for the target and runs it,
+// ok is false when it runs out of steps
+func runTarget(name string, code *ir.Program) (result string, ok bool) {
+	backend, err := codegen.Lookup(name)
+	if err != nil {
+		return "lookup: " + err.Error(), true
+	}
+	artifact, err := backend.Compile(code, codegen.Options{})
+	if err != nil {
+		return "compile: " + err.Error(), true
+	}
+	value, err := artifact.Run(maxSteps)
+	switch {
+	case err != nil && strings.HasSuffix(err.Error(), "step limit exceeded"):
+		return "", false
+	case err != nil:
+		return "error", true
+	}
+	return fmt.Sprint(value), true
+}
+
+// disagree reports if src type checks and the two sides
+// end with different results
+func disagree(src string) bool {
+	interpreted, compiled, checked, done := run(src)
+	return checked && done && interpreted != compiled
+}
+
+// TestDifferential runs the generated programs in the interpreter
+// and on every target, a program they disagree on is shrunk and
+// written to testdata where TestReproducers keeps running it
+func TestDifferential(t *testing.T) {
+	cfg := progen.DefaultConfig
+	cfg.Size, cfg.Depth = *size, *depth
+
+	for i := range int64(*programs) {
+		cfg.Seed = *seed + i
+		src := progen.Generate(cfg)
+
+		interpreted, compiled, checked, done := run(src)
+		require.True(t, checked, "seed %d does not type check:\n%s", cfg.Seed, src)
+		if !done || interpreted == compiled {
+			continue
+		}
+
+		small := progen.Shrink(src, disagree)
+		interpreted, compiled, _, _ = run(small)
+		path := filepath.Join("testdata", fmt.Sprintf("seed-%d.el", cfg.Seed))
+		header := fmt.Sprintf("// found with -seed %d -size %d -depth %d\n// interpreter: %s\n// compiled: %s\n",
+			cfg.Seed, cfg.Size, cfg.Depth, interpreted, compiled)
+		require.NoError(t, os.MkdirAll("testdata", 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(header+small), 0o644))
+		t.Errorf("seed %d: the interpreter gives %s and the compiled program %s, reproducer in %s:\n%s",
+			cfg.Seed, interpreted, compiled, path, small)
+	}
+}
+
+// TestReproducers runs the programs the differential test shrunk,
+// once their bug is fixed both sides agree on them
+func TestReproducers(t *testing.T) {
+	files, err := filepath.Glob("testdata/*.el")
+	require.NoError(t, err)
+
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		require.NoError(t, err)
+
+		interpreted, compiled, checked, done := run(string(src))
+		require.True(t, checked && done, file)
+		require.Equal(t, interpreted, compiled, file)
+	}
+}
+
+func TestShrink(t *testing.T) {
+	cfg := progen.DefaultConfig
+	cfg.Seed = 7
+	src := progen.Generate(cfg)
+
+	// a program is still failing while it has a division
+	divides := func(src string) bool {
+		_, _, checked, _ := run(src)
+		return checked && strings.Contains(src, " / ")
+	}
+	require.True(t, divides(src))
+
+	small := progen.Shrink(src, divides)
+	require.Less(t, len(small), len(src)/4)
+	require.True(t, divides(small))
+	require.Equal(t, 1, strings.Count(small, "\n"), small)
+}
+
+func TestGenerateIsDeterministic(t *testing.T) {
+	cfg := progen.DefaultConfig
+	cfg.Seed = 42
+	require.Equal(t, progen.Generate(cfg), progen.Generate(cfg))
+	cfg.Seed++
+	require.NotEqual(t, progen.Generate(progen.DefaultConfig), progen.Generate(cfg))
+}
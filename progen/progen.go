@@ -0,0 +1,378 @@
+package progen
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+)
+
+// Config are the knobs of Generate, the same config always
+// generates the same program
+type Config struct {
+	Seed int64
+	// Size is about how many statements the program has
+	Size int
+	// Depth bounds the nesting of expressions and blocks
+	Depth int
+	// Loop is the most iterations a while loop runs
+	Loop int
+}
+
+// DefaultConfig generates programs of a few dozen lines
+var DefaultConfig = Config{Size: 20, Depth: 4, Loop: 6}
+
+type typ string
+
+const (
+	u8      typ = "u8"
+	u16     typ = "u16"
+	i16     typ = "i16"
+	boolean typ = "bool"
+)
+
+var integers = []typ{u8, u16, i16}
+
+type variable struct {
+	name string
+	typ  typ
+	// counters drive the loops, nothing else assigns them
+	counter bool
+}
+
+type function struct {
+	name   string
+	params []typ
+	result typ
+	// heavy functions loop or call one that does, the bodies
+	// of loops do not call them so the loops do not multiply
+	heavy bool
+}
+
+type generator struct {
+	cfg Config
+	r   *rand.Rand
+	out strings.Builder
+
+	tabs  int
+	names int
+	// scopes holds the variables in scope, the first one
+	// are the globals the functions see
+	scopes [][]variable
+	funcs  []*function
+
+	fn    *function // the function being generated, nil at the top level
+	loops int       // the loops around the statement being generated
+	size  int       // the statements generated so far
+}
+
+// Generate writes a random program the checker accepts. Every
+// loop runs a bounded number of times and there is no recursion,
+// so the program ends. The last statement is a let whose value
+// is the result of the program
+func Generate(cfg Config) string {
+	g := &generator{cfg: cfg, r: rand.New(rand.NewSource(cfg.Seed))}
+	g.push()
+	// a variable of each type so expressions always have one
+	for _, t := range append(integers, boolean) {
+		g.let(t)
+	}
+
+	for g.size < cfg.Size {
+		if g.r.Intn(4) == 0 {
+			g.function()
+		} else {
+			g.stmt(cfg.Depth)
+		}
+	}
+
+	t := g.pick()
+	g.line("let result: %s = %s;", t, g.operand(t, cfg.Depth))
+	return g.out.String()
+}
+
+func (g *generator) line(format string, args ...any) {
+	g.out.WriteString(strings.Repeat("\t", g.tabs))
+	fmt.Fprintf(&g.out, format, args...)
+	g.out.WriteString("\n")
+}
+
+func (g *generator) name(prefix string) string {
+	g.names++
+	return fmt.Sprintf("%s%d", prefix, g.names)
+}
+
+func (g *generator) push() { g.scopes = append(g.scopes, nil) }
+func (g *generator) pop()  { g.scopes = g.scopes[:len(g.scopes)-1] }
+
+func (g *generator) declare(v variable) {
+	g.scopes[len(g.scopes)-1] = append(g.scopes[len(g.scopes)-1], v)
+}
+
+// visible returns the variables of type t in scope, assign
+// leaves out the loop counters
+func (g *generator) visible(t typ, assign bool) []variable {
+	var out []variable
+	for _, scope := range g.scopes {
+		for _, v := range scope {
+			if v.typ == t && !(assign && v.counter) {
+				out = append(out, v)
+			}
+		}
+	}
+	return out
+}
+
+func (g *generator) pick() typ {
+	if g.r.Intn(5) == 0 {
+		return boolean
+	}
+	return integers[g.r.Intn(len(integers))]
+}
+
+func (g *generator) let(t typ) {
+	// the first variable of a type starts from a constant
+	value := g.literal(t)
+	if len(g.visible(t, false)) > 0 {
+		value = g.operand(t, g.cfg.Depth)
+	}
+	v := variable{name: g.name("v"), typ: t}
+	g.line("let %s: %s = %s;", v.name, t, value)
+	g.declare(v)
+	g.size++
+}
+
+func (g *generator) function() {
+	if g.fn != nil || len(g.scopes) > 1 {
+		return
+	}
+
+	fn := &function{name: g.name("f"), result: g.pick()}
+	params := make([]string, 0)
+	g.push()
+	for i := g.r.Intn(4); i > 0; i-- {
+		v := variable{name: g.name("p"), typ: g.pick()}
+		fn.params = append(fn.params, v.typ)
+		params = append(params, v.name+": "+string(v.typ))
+		g.declare(v)
+	}
+
+	g.line("fn %s(%s) -> %s {", fn.name, strings.Join(params, ", "), fn.result)
+	g.tabs++
+	g.fn = fn
+	// the parameters may leave some types without a variable
+	for _, t := range append(integers, boolean) {
+		if len(g.visible(t, false)) == 0 {
+			g.let(t)
+		}
+	}
+	for i := g.r.Intn(4) + 1; i > 0; i-- {
+		g.stmt(g.cfg.Depth - 1)
+	}
+	g.line("return %s;", g.operand(fn.result, g.cfg.Depth))
+	g.fn = nil
+	g.tabs--
+	g.line("}")
+	g.pop()
+
+	g.funcs = append(g.funcs, fn)
+}
+
+func (g *generator) stmt(depth int) {
+	switch n := g.r.Intn(10); {
+	case n < 3 || depth <= 0:
+		g.let(g.pick())
+
+	case n < 6:
+		t := g.pick()
+		vars := g.visible(t, true)
+		if len(vars) == 0 {
+			g.let(t)
+			return
+		}
+		v := vars[g.r.Intn(len(vars))]
+		g.line("%s = %s;", v.name, g.operand(t, g.cfg.Depth))
+		g.size++
+
+	case n < 8:
+		g.line("if %s {", g.operand(boolean, g.cfg.Depth))
+		g.block(depth)
+		if g.r.Intn(2) == 0 {
+			g.line("} else {")
+			g.block(depth)
+		}
+		g.line("}")
+		g.size++
+
+	case n < 9:
+		if g.fn != nil {
+			g.fn.heavy = true
+		}
+		counter := variable{name: g.name("i"), typ: u16, counter: true}
+		g.line("let %s: u16 = 0;", counter.name)
+		g.declare(counter)
+		g.line("while %s < %d {", counter.name, g.r.Intn(g.cfg.Loop)+1)
+		g.loops++
+		g.block(depth, fmt.Sprintf("%s = %s + 1;", counter.name, counter.name))
+		g.loops--
+		g.line("}")
+		g.size++
+
+	default:
+		if g.fn != nil && g.r.Intn(2) == 0 {
+			g.line("return %s;", g.operand(g.fn.result, g.cfg.Depth))
+		} else {
+			t := g.pick()
+			g.line("%s;", g.operand(t, g.cfg.Depth))
+		}
+		g.size++
+	}
+}
+
+// block generates a few statements after the first ones
+func (g *generator) block(depth int, first ...string) {
+	g.push()
+	g.tabs++
+	for _, line := range first {
+		g.line("%s", line)
+	}
+	for i := g.r.Intn(3) + 1; i > 0; i-- {
+		g.stmt(depth - 1)
+	}
+	g.tabs--
+	g.pop()
+}
+
+// operand is an expression of type t that is not a constant,
+// two constants would make a constant expression the checker
+// may reject for not fitting its type
+func (g *generator) operand(t typ, depth int) string {
+	e, constant := g.expr(t, depth)
+	if constant {
+		return g.variable(t)
+	}
+	return e
+}
+
+func (g *generator) variable(t typ) string {
+	vars := g.visible(t, false)
+	return vars[g.r.Intn(len(vars))].name
+}
+
+// expr returns an expression of type t and if it is a constant
+func (g *generator) expr(t typ, depth int) (string, bool) {
+	if depth <= 0 || g.r.Intn(4) == 0 {
+		if g.r.Intn(3) == 0 {
+			return g.literal(t), true
+		}
+		return g.variable(t), false
+	}
+
+	if calls := g.callable(t); len(calls) > 0 && g.r.Intn(6) == 0 {
+		fn := calls[g.r.Intn(len(calls))]
+		args := make([]string, 0, len(fn.params))
+		for _, p := range fn.params {
+			e, _ := g.expr(p, depth-1)
+			args = append(args, e)
+		}
+		if g.fn != nil && fn.heavy {
+			g.fn.heavy = true
+		}
+		return fmt.Sprintf("%s(%s)", fn.name, strings.Join(args, ", ")), false
+	}
+
+	if t == boolean {
+		switch g.r.Intn(4) {
+		case 0:
+			e, constant := g.expr(boolean, depth-1)
+			return "!(" + e + ")", constant
+		case 1:
+			op := []string{"and", "or", "==", "!="}[g.r.Intn(4)]
+			return g.binary(boolean, op, depth), false
+		default:
+			operand := integers[g.r.Intn(len(integers))]
+			op := []string{"<", "<=", ">", ">=", "==", "!="}[g.r.Intn(6)]
+			return g.binary(operand, op, depth), false
+		}
+	}
+
+	if t == i16 && g.r.Intn(8) == 0 {
+		return "-(" + g.operand(t, depth-1) + ")", false
+	}
+	op := []string{"+", "-", "*", "/", "<<", ">>"}[g.r.Intn(6)]
+	return g.binary(t, op, depth), false
+}
+
+// binary is never a constant, the right operand of two
+// constants is replaced by a variable
+func (g *generator) binary(t typ, op string, depth int) string {
+	left, lconst := g.expr(t, depth-1)
+	right, rconst := g.expr(t, depth-1)
+	switch {
+	case op == "/":
+		right = g.divisor(t, lconst)
+	case lconst && rconst:
+		right = g.variable(t)
+	case (op == "<<" || op == ">>") && rconst:
+		right = g.count(t)
+	}
+	return fmt.Sprintf("(%s %s %s)", left, op, right)
+}
+
+// count is a constant shift count below the width of t, the
+// checker rejects the constant counts past it
+func (g *generator) count(t typ) string {
+	bits := 16
+	if t == u8 {
+		bits = 8
+	}
+	return fmt.Sprint(g.r.Intn(bits))
+}
+
+// divisor is a constant other than zero or a variable, fold
+// reports the divisions by an expression it simplifies to zero
+func (g *generator) divisor(t typ, lconst bool) string {
+	if !lconst && g.r.Intn(4) != 0 {
+		return fmt.Sprint(g.r.Intn(9) + 1)
+	}
+	return g.variable(t)
+}
+
+// callable returns the functions returning t the expression
+// can call, loops do not call the heavy ones
+func (g *generator) callable(t typ) []*function {
+	var out []*function
+	for _, fn := range g.funcs {
+		if fn.result == t && !(g.loops > 0 && fn.heavy) {
+			out = append(out, fn)
+		}
+	}
+	return out
+}
+
+// literal is a constant of type t, the values at the ends of
+// the ranges come up more often than the others
+func (g *generator) literal(t typ) string {
+	if t == boolean {
+		return []string{"true", "false"}[g.r.Intn(2)]
+	}
+
+	var edges []int
+	var low, high int
+	switch t {
+	case u8:
+		edges, low, high = []int{0, 1, 2, 127, 128, 255}, 0, 255
+	case u16:
+		edges, low, high = []int{0, 1, 2, 255, 256, 511, 512, 32767, 32768, 65535}, 0, 65535
+	case i16:
+		edges, low, high = []int{0, 1, -1, 2, -2, 32767, -32768, 511, -512}, -32768, 32767
+	}
+
+	value := edges[g.r.Intn(len(edges))]
+	if g.r.Intn(2) == 0 {
+		value = low + g.r.Intn(high-low+1)
+	}
+	if value < 0 {
+		return fmt.Sprintf("-%d", -value)
+	}
+	return fmt.Sprint(value)
+}
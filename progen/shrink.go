@@ -0,0 +1,189 @@
+package progen
+
+import (
+	"stag/format"
+	"stag/lexer"
+	"stag/pratt_parser"
+	"stag/pratt_parser/ast"
+	"stag/primitives"
+)
+
+// Shrink returns the smallest program it finds for which fails
+// still holds, src must fail to begin with. It deletes statements,
+// replaces ifs and loops by their blocks and expressions by their
+// operands or by constants, keeping every change that still fails
+// until none does. fails gets programs that may not type check
+func Shrink(src string, fails func(src string) bool) string {
+	for {
+		program, ok := parse(src)
+		if !ok {
+			return src
+		}
+		smaller, ok := shrinkOnce(program, fails)
+		if !ok {
+			return src
+		}
+		src = smaller
+	}
+}
+
+func parse(src string) (*ast.Program, bool) {
+	p := pratt_parser.New(lexer.New(src))
+	program := p.ParseProgram()
+	return program, len(p.Errors()) == 0
+}
+
+// shrinkOnce tries the changes one at a time undoing the ones
+// that do not fail, it returns the first program that does
+func shrinkOnce(program *ast.Program, fails func(string) bool) (string, bool) {
+	var lists []*[]ast.Statement
+	var slots []*ast.Expression
+	collect(program, &lists, &slots)
+
+	try := func(apply, undo func()) (string, bool) {
+		apply()
+		src := format.Program(program, nil)
+		undo()
+		return src, fails(src)
+	}
+
+	for _, list := range lists {
+		for i := range *list {
+			stmts := *list
+			removed := append(append([]ast.Statement(nil), stmts[:i]...), stmts[i+1:]...)
+			if src, ok := try(func() { *list = removed }, func() { *list = stmts }); ok {
+				return src, true
+			}
+
+			for _, replacement := range unwrap(stmts[i]) {
+				replaced := append([]ast.Statement(nil), stmts...)
+				replaced[i] = replacement
+				if src, ok := try(func() { *list = replaced }, func() { *list = stmts }); ok {
+					return src, true
+				}
+			}
+		}
+	}
+
+	for _, slot := range slots {
+		e := *slot
+		for _, replacement := range simpler(e) {
+			if src, ok := try(func() { *slot = replacement }, func() { *slot = e }); ok {
+				return src, true
+			}
+		}
+	}
+	return "", false
+}
+
+// unwrap returns the statements that can take the place of stmt
+func unwrap(stmt ast.Statement) []ast.Statement {
+	switch s := stmt.(type) {
+	case *ast.IfStatement:
+		out := []ast.Statement{s.Consequence}
+		if s.Alternative != nil {
+			out = append(out, s.Alternative, &ast.IfStatement{Token: s.Token, Condition: s.Condition, Consequence: s.Consequence})
+		}
+		return out
+	case *ast.WhileStatement:
+		return []ast.Statement{s.Body}
+	case *ast.BlockStatement:
+		if len(s.Statements) == 1 {
+			return []ast.Statement{s.Statements[0]}
+		}
+	}
+	return nil
+}
+
+// simpler returns the expressions that can take the place of e,
+// its operands and small constants
+func simpler(e ast.Expression) []ast.Expression {
+	var out []ast.Expression
+	switch v := e.(type) {
+	case *ast.InfixExpression:
+		out = append(out, v.Left, v.Right)
+	case *ast.PrefixExpression:
+		out = append(out, v.Right)
+	case *ast.CallExpression:
+		out = append(out, v.Arguments...)
+	case *ast.IntegerLiteral:
+		if v.Value == 0 {
+			return nil
+		}
+		if v.Value != 1 {
+			out = append(out, intLiteral(1))
+		}
+		return append(out, intLiteral(0))
+	case *ast.Boolean:
+		return nil
+	}
+	return append(out, intLiteral(0), intLiteral(1), boolLiteral(false), boolLiteral(true))
+}
+
+func intLiteral(value int64) ast.Expression {
+	literal := "0"
+	if value == 1 {
+		literal = "1"
+	}
+	return &ast.IntegerLiteral{Token: primitives.Token{Kind: primitives.Number, Literal: literal}, Value: value}
+}
+
+func boolLiteral(value bool) ast.Expression {
+	literal := lexer.False
+	if value {
+		literal = lexer.True
+	}
+	return &ast.Boolean{Token: primitives.Token{Kind: primitives.Keyword, Literal: literal}, Value: value}
+}
+
+// collect gathers the statement lists and the expression fields of
+// the tree, the changes of the shrinker are made through them
+func collect(node ast.Node, lists *[]*[]ast.Statement, slots *[]*ast.Expression) {
+	expr := func(slot *ast.Expression) {
+		if *slot != nil {
+			*slots = append(*slots, slot)
+			collect(*slot, lists, slots)
+		}
+	}
+
+	switch n := node.(type) {
+	case *ast.Program:
+		*lists = append(*lists, &n.Statements)
+		for _, s := range n.Statements {
+			collect(s, lists, slots)
+		}
+	case *ast.BlockStatement:
+		*lists = append(*lists, &n.Statements)
+		for _, s := range n.Statements {
+			collect(s, lists, slots)
+		}
+	case *ast.FunctionStatement:
+		collect(n.Body, lists, slots)
+	case *ast.IfStatement:
+		expr(&n.Condition)
+		collect(n.Consequence, lists, slots)
+		if n.Alternative != nil {
+			collect(n.Alternative, lists, slots)
+		}
+	case *ast.WhileStatement:
+		expr(&n.Condition)
+		collect(n.Body, lists, slots)
+	case *ast.LetStatement:
+		expr(&n.Value)
+	case *ast.AssignStatement:
+		expr(&n.Value)
+	case *ast.ReturnStatement:
+		expr(&n.ReturnValue)
+	case *ast.ExpressionStatement:
+		expr(&n.Expression)
+	case *ast.InfixExpression:
+		expr(&n.Left)
+		expr(&n.Right)
+	case *ast.PrefixExpression:
+		expr(&n.Right)
+	case *ast.CallExpression:
+		for i := range n.Arguments {
+			expr(&n.Arguments[i])
+		}
+	}
+}
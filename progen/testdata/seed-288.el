@@ -0,0 +1,10 @@
+// found with -seed 288 -size 20 -depth 4
+// interpreter: 0
+// compiled: 1
+let v2: u16 = 0;
+fn f7() -> u16 {
+	v2 = 1;
+	return 0;
+}
+
+let result: u16 = v2 + f7();
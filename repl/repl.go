@@ -0,0 +1,280 @@
+package repl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"stag/codegen/rust16vm"
+	"stag/eval"
+	"stag/frontend"
+	"stag/ir"
+	"stag/lexer"
+	"stag/pratt_parser"
+	"stag/pratt_parser/ast"
+	"stag/primitives"
+	"stag/types"
+	"strings"
+)
+
+const (
+	prompt       = ">> "
+	continuation = ".. "
+)
+
+// Options configure a session
+type Options struct {
+	// Interactive prints the prompts, when stdin is a pipe
+	// only the results and the errors are written
+	Interactive bool
+	// HistoryFile keeps the inputs across sessions when set
+	HistoryFile string
+}
+
+type session struct {
+	out    io.Writer
+	opts   Options
+	interp *eval.Interpreter
+
+	// inputs that were accepted by the checker and ran without
+	// errors, the compiler meta commands need all of them for
+	// the bindings they use
+	inputs  []string
+	last    string
+	history []string
+}
+
+// Start reads inputs from in until it ends or :quit is entered.
+// Every input runs in the same interpreter so the bindings persist,
+// an input goes on in the next lines while its braces or parens
+// are not balanced. Lines starting with : are meta commands
+func Start(in io.Reader, out io.Writer, opts Options) error {
+	s := &session{out: out, opts: opts, interp: eval.New()}
+	s.loadHistory()
+
+	scanner := bufio.NewScanner(in)
+	var pending []string
+
+	for {
+		if opts.Interactive {
+			if len(pending) == 0 {
+				fmt.Fprint(out, prompt)
+			} else {
+				fmt.Fprint(out, continuation)
+			}
+		}
+		if !scanner.Scan() {
+			break
+		}
+		line := scanner.Text()
+
+		if len(pending) == 0 && strings.HasPrefix(strings.TrimSpace(line), ":") {
+			if quit := s.meta(strings.TrimSpace(line)); quit {
+				return nil
+			}
+			continue
+		}
+
+		pending = append(pending, line)
+		input := strings.Join(pending, "\n")
+		if depth(input) > 0 {
+			continue
+		}
+		pending = nil
+
+		if strings.TrimSpace(input) == "" {
+			continue
+		}
+		s.addHistory(input)
+		s.run(input)
+	}
+
+	if len(pending) > 0 {
+		fmt.Fprintln(out, "error: unexpected end of input, unbalanced braces")
+	}
+	return scanner.Err()
+}
+
+// depth returns how many braces and parens are left open
+func depth(input string) (open int) {
+	l := lexer.New(input)
+	for tok := l.NextToken(); tok.Kind != primitives.EOF; tok = l.NextToken() {
+		switch tok.Kind {
+		case primitives.OpenCurlyBrace, primitives.OpenParen:
+			open++
+		case primitives.CloseCurlyBrace, primitives.CloseParen:
+			open--
+		}
+	}
+	return open
+}
+
+func (s *session) errorf(format string, args ...any) {
+	fmt.Fprintf(s.out, "error: "+format+"\n", args...)
+}
+
+func (s *session) run(input string) {
+	s.last = input
+
+	program, err := parse(input)
+	if err != nil {
+		s.errorf("%v", err)
+		return
+	}
+
+	// the input goes on the program of the inputs accepted so far,
+	// so it sees their bindings and can not declare them again
+	_, _, errs := check(append(slices.Clone(s.inputs), input))
+	for _, err := range errs {
+		s.errorf("%v", err)
+	}
+	if len(errs) > 0 {
+		return
+	}
+
+	value, err := s.interp.Run(program)
+	if err != nil {
+		s.errorf("%v", err)
+		return
+	}
+	s.inputs = append(s.inputs, input)
+
+	if _, isUnit := value.(eval.Unit); !isUnit {
+		fmt.Fprintln(s.out, value)
+	}
+}
+
+// parse reports the parser errors as a single error
+func parse(input string) (program *ast.Program, err error) {
+	p := pratt_parser.New(lexer.New(input))
+	program = p.ParseProgram()
+	if errs := p.Errors(); len(errs) > 0 {
+		return nil, errors.New(strings.Join(errs, "\nerror: "))
+	}
+	return program, nil
+}
+
+var metaHelp = `:tokens [input]  tokens of the input, the last one by default
+:ast [input]     syntax tree of the input, the last one by default
+:ir              IR of the session up to the last input
+:asm             rust16vm assembly of the session up to the last input
+:history         inputs entered so far
+:help            this help
+:quit            leave the session`
+
+// meta runs a meta command and reports if the session ends
+func (s *session) meta(line string) bool {
+	name, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+	input := arg
+	if input == "" {
+		input = s.last
+	}
+
+	switch name {
+	case ":quit", ":q":
+		return true
+	case ":help":
+		fmt.Fprintln(s.out, metaHelp)
+	case ":history":
+		for i, h := range s.history {
+			fmt.Fprintf(s.out, "%4d  %s\n", i+1, strings.ReplaceAll(h, "\n", "\n      "))
+		}
+	case ":tokens":
+		s.tokens(input)
+	case ":ast":
+		program, err := parse(input)
+		if err != nil {
+			s.errorf("%v", err)
+			return false
+		}
+		for _, stmt := range program.Statements {
+			fmt.Fprintln(s.out, stmt.String())
+		}
+	case ":ir", ":asm":
+		s.compile(name)
+	default:
+		s.errorf("unknown command %s, :help lists them", name)
+	}
+	return false
+}
+
+func (s *session) tokens(input string) {
+	l := lexer.New(input)
+	for tok := l.NextToken(); ; tok = l.NextToken() {
+		fmt.Fprintf(s.out, "%d:%d\t%s\t%q\n", tok.SourceLine, tok.SourceColumn, tok.Kind, tok.Literal)
+		if tok.Kind == primitives.EOF {
+			return
+		}
+	}
+}
+
+// compile shows the IR or the assembly of all the inputs that
+// ran so far, the last input depends on the bindings they made
+func (s *session) compile(what string) {
+	if len(s.inputs) == 0 {
+		s.errorf("nothing to compile yet")
+		return
+	}
+
+	// every input was checked with the ones before it
+	program, info, _ := check(s.inputs)
+	lowered := ir.Lower(program, info)
+	if what == ":ir" {
+		fmt.Fprint(s.out, lowered)
+		return
+	}
+
+	asm, err := rust16vm.Generate(lowered)
+	if err != nil {
+		s.errorf("%v", err)
+		return
+	}
+	fmt.Fprint(s.out, asm)
+}
+
+// check type checks and folds the inputs as a single program, each
+// input is parsed on its own so the errors have its positions
+func check(inputs []string) (*ast.Program, *types.Info, []*frontend.Error) {
+	program := &ast.Program{}
+	for _, input := range inputs {
+		p := pratt_parser.New(lexer.New(input))
+		program.Statements = append(program.Statements, p.ParseProgram().Statements...)
+	}
+
+	info, errs := frontend.Program(program, frontend.Options{})
+	return program, info, errs
+}
+
+func (s *session) loadHistory() {
+	if s.opts.HistoryFile == "" {
+		return
+	}
+	data, err := os.ReadFile(s.opts.HistoryFile)
+	if err != nil {
+		return
+	}
+	for _, entry := range strings.Split(string(data), "\x00\n") {
+		if entry != "" {
+			s.history = append(s.history, entry)
+		}
+	}
+}
+
+// addHistory records the input, the entries of the history file
+// end with a NUL so the multi line inputs stay together
+func (s *session) addHistory(input string) {
+	s.history = append(s.history, input)
+	if s.opts.HistoryFile == "" {
+		return
+	}
+
+	f, err := os.OpenFile(s.opts.HistoryFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	f.WriteString(input + "\x00\n")
+}
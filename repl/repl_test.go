@@ -0,0 +1,113 @@
+package repl
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func runSession(t *testing.T, input string, opts Options) string {
+	t.Helper()
+
+	var out bytes.Buffer
+	require.NoError(t, Start(strings.NewReader(input), &out, opts))
+	return out.String()
+}
+
+func TestScript(t *testing.T) {
+	out := runSession(t, `let x: u8 = 250;
+fn inc(n: u8) -> u8 {
+	return n + 1;
+}
+inc(x) + 10
+x / 0
+if x > 100 {
+	x = 1;
+}
+x
+`, Options{})
+
+	require.Equal(t, "250\n5\nerror: 1:5: division by zero\n1\n", out)
+}
+
+func TestPrompts(t *testing.T) {
+	out := runSession(t, "let x = 1;\nif true {\nx = 2;\n}\n:quit\nx\n", Options{Interactive: true})
+	require.Equal(t, ">> 1\n>> .. .. >> ", out)
+}
+
+func TestMetaCommands(t *testing.T) {
+	out := runSession(t, "let x = 2;\nx * 3\n:tokens\n:ast\n:ir\n:tokens 1 +\n", Options{})
+
+	require.Equal(t, `2
+6
+1:1	Ident	"x"
+1:3	Star	"*"
+1:5	Number	"3"
+1:6	EOF	""
+(x * 3)
+func main() {
+entry:
+	%0 = const 2
+	%1 = const 3
+	%2 = mul %0, %1
+	ret %2
+}
+1:1	Number	"1"
+1:3	Plus	"+"
+1:4	EOF	""
+`, out)
+
+	out = runSession(t, "fn f(a: u16) -> u16 { return a; }\nf(1)\n:asm\n", Options{})
+	require.Contains(t, out, "f:\nPUSH BP\n")
+	require.Contains(t, out, "CALL f\n")
+
+	out = runSession(t, ":ir\n:nope\n", Options{})
+	require.Equal(t, "error: nothing to compile yet\nerror: unknown command :nope, :help lists them\n", out)
+}
+
+func TestErrors(t *testing.T) {
+	out := runSession(t, "let = 1;\ny\nlet z =\n{\n", Options{})
+
+	require.Equal(t, "error: expected next token to be Ident, got Equals instead\n"+
+		"error: no prefix parse function for Equals found\n"+
+		"error: 1:1: undefined: y\n"+
+		"error: no prefix parse function for EOF found\n"+
+		"error: unexpected end of input, unbalanced braces\n", out)
+}
+
+func TestHistory(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "history")
+
+	runSession(t, "let x = 1;\nfn f() {\n}\n", Options{HistoryFile: file})
+	out := runSession(t, "2\n:history\n", Options{HistoryFile: file})
+
+	require.Equal(t, "2\n   1  let x = 1;\n   2  fn f() {\n      }\n   3  2\n", out)
+
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	require.Equal(t, "let x = 1;\x00\nfn f() {\n}\x00\n2\x00\n", string(data))
+}
+
+func TestCheckedInputs(t *testing.T) {
+	// the rejected inputs are left out of the program :ir lowers
+	out := runSession(t, "let x: u8 = 1;\nlet y: bool = x;\nlet x = 2;\nx + 1\n:ir\n", Options{})
+
+	require.Equal(t, `1
+error: 1:15: cannot use x (type u8) as bool value in variable declaration
+error: 1:5: x redeclared in this block
+2
+func main() {
+entry:
+	%0 = const 1
+	%1 = const 1
+	%2 = add %0, %1
+	%3 = const 255
+	%4 = and %2, %3
+	ret %4
+}
+`, out)
+}
@@ -0,0 +1,81 @@
+package shunting_yard
+
+import (
+	"fmt"
+	"stag/lexer"
+	"stag/pratt_parser"
+	"stag/primitives"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// source writes the expression with every operation in parentheses,
+// the way the pratt parser prints its tree
+func source(e Expression) string {
+	switch e := e.(type) {
+	case *Number:
+		return fmt.Sprint(e.Value)
+	case *BinaryOperation:
+		op := map[Operation]string{Add: "+", Sub: "-", Mul: "*", Div: "/"}[e.Op]
+		return "(" + source(e.Lhs) + " " + op + " " + source(e.Rhs) + ")"
+	}
+	panic(fmt.Sprintf("source: unexpected expression %T", e))
+}
+
+// arithmetic tells if the tokens are only numbers without leading
+// zeros, the four operations and parentheses that do not make a
+// call, the input both parsers print the same way
+func arithmetic(tokens []primitives.Token) bool {
+	for i, tok := range tokens {
+		switch tok.Kind {
+		case primitives.Number:
+			if len(tok.Literal) > 1 && tok.Literal[0] == '0' {
+				return false
+			}
+		case primitives.OpenParen:
+			if i > 0 && (tokens[i-1].Kind == primitives.Number || tokens[i-1].Kind == primitives.CloseParen) {
+				return false
+			}
+		case primitives.Plus, primitives.Minus, primitives.Star, primitives.Slash,
+			primitives.CloseParen:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// the seed corpus in testdata/fuzz comes from the tests, what is
+// accepted prints and reads back to the same statements, and the
+// arithmetic is grouped the way the pratt parser groups it
+func FuzzShuntingYard(f *testing.F) {
+	f.Fuzz(func(t *testing.T, src string) {
+		tokens := lexer.Tokenize(src)
+		stmts, err := ShuntingYard(tokens)
+		if err != nil {
+			return
+		}
+
+		printed := make([]string, 0, len(stmts))
+		for _, stmt := range stmts {
+			printed = append(printed, source(stmt.(Expression)))
+		}
+		again, err := ShuntingYard(lexer.Tokenize(strings.Join(printed, " ")))
+		require.NoError(t, err, src)
+		require.Equal(t, stmts, again, src)
+
+		if len(stmts) != 1 || !arithmetic(tokens) {
+			return
+		}
+		// the shunting yard does not check the operators go between
+		// their operands, only the input the pratt parser takes as
+		// a single expression is compared
+		p := pratt_parser.New(lexer.New(src + ";"))
+		program := p.ParseProgram()
+		if len(p.Errors()) == 0 && len(program.Statements) == 1 {
+			require.Equal(t, printed[0], program.String(), src)
+		}
+	})
+}
@@ -1,6 +1,8 @@
 package shunting_yard
 
 import (
+	"errors"
+	"fmt"
 	"stag/primitives"
 	"strconv"
 )
@@ -19,14 +21,43 @@ var precedencia = map[primitives.TokenKind]int{
 	primitives.Carrot:         5,
 }
 
+var operations = map[primitives.TokenKind]Operation{
+	primitives.Plus:  Add,
+	primitives.Minus: Sub,
+	primitives.Star:  Mul,
+	primitives.Slash: Div,
+}
+
+var ErrUnbalanced = errors.New("Parêntese desbalanceado")
+
 func isOperator(kind primitives.TokenKind) bool {
 	_, ok := precedencia[kind]
 	return ok
 }
 
-func ShuntingYard(tokens []*primitives.Token) []Statement {
+// ShuntingYard builds the expressions of the tokens, malformed
+// input is an error instead of a panic
+func ShuntingYard(tokens []primitives.Token) ([]Statement, error) {
 	var outputQueue []Statement
-	var operatorStack []*primitives.Token
+	var operatorStack []primitives.Token
+
+	// apply pops the operands of op from the output and pushes
+	// the operation back
+	apply := func(op primitives.Token) error {
+		operation, ok := operations[op.Kind]
+		if !ok {
+			return fmt.Errorf("%d:%d: unsupported operator %q", op.SourceLine, op.SourceColumn, op.Literal)
+		}
+		if len(outputQueue) < 2 {
+			return fmt.Errorf("%d:%d: missing operand of %q", op.SourceLine, op.SourceColumn, op.Literal)
+		}
+
+		rhs := outputQueue[len(outputQueue)-1]
+		lhs := outputQueue[len(outputQueue)-2]
+		outputQueue = outputQueue[:len(outputQueue)-2]
+		outputQueue = append(outputQueue, &BinaryOperation{Rhs: rhs.(Expression), Lhs: lhs.(Expression), Op: operation})
+		return nil
+	}
 
 	for _, token := range tokens {
 		switch token.Kind {
@@ -34,11 +65,11 @@ func ShuntingYard(tokens []*primitives.Token) []Statement {
 		// outputQueue = append(outputQueue, token)
 
 		case primitives.Number:
-			n, err := strconv.Atoi(token.Literal)
+			n, err := strconv.ParseInt(token.Literal, 10, 64)
 			if err != nil {
-				panic(err)
+				return nil, err
 			}
-			outputQueue = append(outputQueue, &Number{Value: int64(n)})
+			outputQueue = append(outputQueue, &Number{Value: n})
 
 		case primitives.OpenParen:
 			operatorStack = append(operatorStack, token)
@@ -47,25 +78,13 @@ func ShuntingYard(tokens []*primitives.Token) []Statement {
 			for len(operatorStack) > 0 && operatorStack[len(operatorStack)-1].Kind != primitives.OpenParen {
 				top := operatorStack[len(operatorStack)-1]
 				operatorStack = operatorStack[:len(operatorStack)-1]
-
-				rhs := outputQueue[len(outputQueue)-1]
-				lhs := outputQueue[len(outputQueue)-2]
-				outputQueue = outputQueue[:len(outputQueue)-2]
-
-				switch top.Literal {
-				case "+":
-					outputQueue = append(outputQueue, &BinaryOperation{Rhs: rhs.(Expression), Lhs: lhs.(Expression), Op: Add})
-				case "-":
-					outputQueue = append(outputQueue, &BinaryOperation{Rhs: rhs.(Expression), Lhs: lhs.(Expression), Op: Sub})
-				case "*":
-					outputQueue = append(outputQueue, &BinaryOperation{Rhs: rhs.(Expression), Lhs: lhs.(Expression), Op: Mul})
-				case "/":
-					outputQueue = append(outputQueue, &BinaryOperation{Rhs: rhs.(Expression), Lhs: lhs.(Expression), Op: Div})
+				if err := apply(top); err != nil {
+					return nil, err
 				}
 			}
 
 			if len(operatorStack) == 0 {
-				panic("Parêntese desbalanceado")
+				return nil, ErrUnbalanced
 			}
 
 			operatorStack = operatorStack[:len(operatorStack)-1]
@@ -74,26 +93,13 @@ func ShuntingYard(tokens []*primitives.Token) []Statement {
 			if isOperator(token.Kind) {
 				for len(operatorStack) > 0 {
 					top := operatorStack[len(operatorStack)-1]
-					if isOperator(top.Kind) && precedencia[top.Kind] >= precedencia[token.Kind] {
-						rhs := outputQueue[len(outputQueue)-1]
-						lhs := outputQueue[len(outputQueue)-2]
-						outputQueue = outputQueue[:len(outputQueue)-2]
-						switch top.Literal {
-						case "+":
-							outputQueue = append(outputQueue, &BinaryOperation{Rhs: rhs.(Expression), Lhs: lhs.(Expression), Op: Add})
-						case "-":
-							outputQueue = append(outputQueue, &BinaryOperation{Rhs: rhs.(Expression), Lhs: lhs.(Expression), Op: Sub})
-						case "*":
-							outputQueue = append(outputQueue, &BinaryOperation{Rhs: rhs.(Expression), Lhs: lhs.(Expression), Op: Mul})
-						case "/":
-							outputQueue = append(outputQueue, &BinaryOperation{Rhs: rhs.(Expression), Lhs: lhs.(Expression), Op: Div})
-						}
-
-						operatorStack = operatorStack[:len(operatorStack)-1]
-
-					} else {
+					if !isOperator(top.Kind) || precedencia[top.Kind] < precedencia[token.Kind] {
 						break
 					}
+					operatorStack = operatorStack[:len(operatorStack)-1]
+					if err := apply(top); err != nil {
+						return nil, err
+					}
 				}
 				operatorStack = append(operatorStack, token)
 			}
@@ -103,24 +109,13 @@ func ShuntingYard(tokens []*primitives.Token) []Statement {
 	for len(operatorStack) > 0 {
 		op := operatorStack[len(operatorStack)-1]
 		if op.Kind == primitives.OpenParen {
-			panic("Parêntese desbalanceado")
-		}
-		rhs := outputQueue[len(outputQueue)-1]
-		lhs := outputQueue[len(outputQueue)-2]
-		outputQueue = outputQueue[:len(outputQueue)-2]
-
-		switch op.Literal {
-		case "+":
-			outputQueue = append(outputQueue, &BinaryOperation{Rhs: rhs.(Expression), Lhs: lhs.(Expression), Op: Add})
-		case "-":
-			outputQueue = append(outputQueue, &BinaryOperation{Rhs: rhs.(Expression), Lhs: lhs.(Expression), Op: Sub})
-		case "*":
-			outputQueue = append(outputQueue, &BinaryOperation{Rhs: rhs.(Expression), Lhs: lhs.(Expression), Op: Mul})
-		case "/":
-			outputQueue = append(outputQueue, &BinaryOperation{Rhs: rhs.(Expression), Lhs: lhs.(Expression), Op: Div})
+			return nil, ErrUnbalanced
 		}
 		operatorStack = operatorStack[:len(operatorStack)-1]
+		if err := apply(op); err != nil {
+			return nil, err
+		}
 	}
 
-	return outputQueue
+	return outputQueue, nil
 }
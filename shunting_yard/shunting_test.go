@@ -2,7 +2,6 @@ package shunting_yard
 
 import (
 	"stag/lexer"
-	"stag/primitives"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -10,18 +9,8 @@ import (
 
 func TestSimple(t *testing.T) {
 	input := "3 + 4"
-	l := lexer.New(input)
-
-	var tokens []*primitives.Token
-	for {
-		tok := l.NextToken()
-		if tok.Kind == primitives.EOF {
-			break
-		}
-		tokens = append(tokens, tok)
-	}
-
-	rpn := ShuntingYard(tokens)
+	rpn, err := ShuntingYard(lexer.Tokenize(input))
+	require.NoError(t, err)
 
 	expected := []Statement{
 		&BinaryOperation{Op: Add, Lhs: &Number{Value: 3}, Rhs: &Number{Value: 4}},
@@ -31,18 +20,8 @@ func TestSimple(t *testing.T) {
 
 func TestPrecendence(t *testing.T) {
 	input := "3 + 4 * 2"
-	l := lexer.New(input)
-
-	var tokens []*primitives.Token
-	for {
-		tok := l.NextToken()
-		if tok.Kind == primitives.EOF {
-			break
-		}
-		tokens = append(tokens, tok)
-	}
-
-	rpn := ShuntingYard(tokens)
+	rpn, err := ShuntingYard(lexer.Tokenize(input))
+	require.NoError(t, err)
 
 	expected := []Statement{
 		&BinaryOperation{Op: Add, Lhs: &Number{Value: 3},
@@ -53,21 +32,25 @@ func TestPrecendence(t *testing.T) {
 
 func TestOpenCloseParen(t *testing.T) {
 	input := "(3 + 4 * 2) * 2"
-	l := lexer.New(input)
-
-	var tokens []*primitives.Token
-	for {
-		tok := l.NextToken()
-		if tok.Kind == primitives.EOF {
-			break
-		}
-		tokens = append(tokens, tok)
-	}
-
-	rpn := ShuntingYard(tokens)
+	rpn, err := ShuntingYard(lexer.Tokenize(input))
+	require.NoError(t, err)
 
 	expected := []Statement{
 		&BinaryOperation{Op: Mul, Lhs: &BinaryOperation{Op: Add, Lhs: &Number{Value: 3}, Rhs: &BinaryOperation{Op: Mul, Lhs: &Number{Value: 4}, Rhs: &Number{Value: 2}}}, Rhs: &Number{Value: 2}},
 	}
 	require.Equal(t, expected, rpn)
 }
+
+func TestMalformed(t *testing.T) {
+	for input, msg := range map[string]string{
+		"(3 + 4":               "Parêntese desbalanceado",
+		"3 + 4)":               "Parêntese desbalanceado",
+		"3 +":                  `1:3: missing operand of "+"`,
+		"* 2":                  `1:1: missing operand of "*"`,
+		"1 == 2":               `1:3: unsupported operator "=="`,
+		"99999999999999999999": `strconv.ParseInt: parsing "99999999999999999999": value out of range`,
+	} {
+		_, err := ShuntingYard(lexer.Tokenize(input))
+		require.EqualError(t, err, msg, input)
+	}
+}
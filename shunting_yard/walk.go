@@ -0,0 +1,119 @@
+package shunting_yard
+
+import "fmt"
+
+// Node is any Expression or Statement of the tree
+type Node interface{}
+
+// A Visitor's Visit method is called by Walk for every node, when
+// the returned visitor w is not nil the children of the node are
+// walked with it and then w.Visit(nil) is called
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses the tree in depth-first order starting at node
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	switch n := node.(type) {
+	case *BinaryOperation:
+		Walk(v, n.Lhs)
+		Walk(v, n.Rhs)
+	case VarAssing:
+		if n.value != nil {
+			Walk(v, n.value)
+		}
+	case Conditional:
+		if n.condition != nil {
+			Walk(v, n.condition)
+		}
+		for _, stmt := range n.truePath {
+			Walk(v, stmt)
+		}
+		for _, stmt := range n.elsePath {
+			Walk(v, stmt)
+		}
+	case Ident, *Number, Loop, FuncDeclaration, FuncCall:
+		// leaves
+	default:
+		panic(fmt.Sprintf("shunting_yard.Walk: unexpected node type %T", n))
+	}
+
+	v.Visit(nil)
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses the tree in depth-first order calling f for
+// every node, the children are skipped when f returns false. After
+// the children f is called with nil
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
+
+// Rewrite traverses the tree in depth-first order and replaces every
+// node with what f returns for it, the children of a node are
+// rewritten before it. A nil from f drops a statement from its list
+// and clears any other field, a node that does not fit its field
+// makes Rewrite panic
+func Rewrite(node Node, f func(Node) Node) Node {
+	switch n := node.(type) {
+	case *BinaryOperation:
+		n.Lhs = rewrite(n.Lhs, f)
+		n.Rhs = rewrite(n.Rhs, f)
+	case VarAssing:
+		if n.value != nil {
+			n.value = rewrite(n.value, f)
+		}
+		node = n
+	case Conditional:
+		if n.condition != nil {
+			n.condition = rewrite(n.condition, f)
+		}
+		n.truePath = rewriteStatements(n.truePath, f)
+		n.elsePath = rewriteStatements(n.elsePath, f)
+		node = n
+	case Ident, *Number, Loop, FuncDeclaration, FuncCall:
+		// leaves
+	default:
+		panic(fmt.Sprintf("shunting_yard.Rewrite: unexpected node type %T", n))
+	}
+
+	return f(node)
+}
+
+// rewriteStatements returns a new list, the conditionals are
+// values and share their lists with the copies
+func rewriteStatements(stmts []Statement, f func(Node) Node) []Statement {
+	var out []Statement
+	for _, stmt := range stmts {
+		if stmt = rewrite(stmt, f); stmt != nil {
+			out = append(out, stmt)
+		}
+	}
+	return out
+}
+
+// rewrite rewrites n and checks the result fits where n was
+func rewrite[T Node](n T, f func(Node) Node) T {
+	var zero T
+	out := Rewrite(n, f)
+	if out == nil {
+		return zero
+	}
+	t, ok := out.(T)
+	if !ok {
+		panic(fmt.Sprintf("shunting_yard.Rewrite: %T does not fit in place of %T", out, n))
+	}
+	return t
+}
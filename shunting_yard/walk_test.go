@@ -0,0 +1,122 @@
+package shunting_yard
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// one of each node type, a new type has to be added here
+var samples = []Node{
+	Ident{varNome: "x"},
+	&Number{Value: 1},
+	&BinaryOperation{Op: Add, Lhs: &Number{Value: 1}, Rhs: Ident{varNome: "x"}},
+	VarAssing{v: "x", value: &Number{Value: 2}},
+	Conditional{condition: Ident{varNome: "c"}, truePath: []Statement{&Number{Value: 3}}, elsePath: []Statement{Loop{}}},
+	Loop{},
+	FuncDeclaration{},
+	FuncCall{},
+}
+
+// nodeTypes lists the types declared in ast.go with an isExpression
+// or an isStatement method
+func nodeTypes(t *testing.T) []string {
+	t.Helper()
+	file, err := parser.ParseFile(token.NewFileSet(), "ast.go", nil, 0)
+	require.NoError(t, err)
+
+	seen := map[string]bool{}
+	for _, decl := range file.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Recv == nil || (fn.Name.Name != "isExpression" && fn.Name.Name != "isStatement") {
+			continue
+		}
+		switch recv := fn.Recv.List[0].Type.(type) {
+		case *ast.StarExpr:
+			seen["*shunting_yard."+recv.X.(*ast.Ident).Name] = true
+		case *ast.Ident:
+			seen["shunting_yard."+recv.Name] = true
+		}
+	}
+
+	names := []string{}
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestWalkExhaustive(t *testing.T) {
+	names := []string{}
+	for _, sample := range samples {
+		names = append(names, reflect.TypeOf(sample).String())
+
+		// Walk and Rewrite panic on the types they do not handle
+		Inspect(sample, func(Node) bool { return true })
+		Rewrite(sample, func(node Node) Node { return node })
+	}
+	sort.Strings(names)
+	require.Equal(t, nodeTypes(t), names)
+}
+
+func TestInspect(t *testing.T) {
+	tree := Conditional{
+		condition: &BinaryOperation{Op: Sub, Lhs: Ident{varNome: "a"}, Rhs: &Number{Value: 1}},
+		truePath:  []Statement{VarAssing{v: "a", value: &Number{Value: 2}}},
+		elsePath:  []Statement{&BinaryOperation{Op: Mul, Lhs: &Number{Value: 3}, Rhs: &Number{Value: 4}}},
+	}
+
+	visited := []string{}
+	Inspect(tree, func(node Node) bool {
+		if node != nil {
+			visited = append(visited, reflect.TypeOf(node).String())
+		}
+		_, isVar := node.(VarAssing)
+		return !isVar
+	})
+	require.Equal(t, []string{
+		"shunting_yard.Conditional",
+		"*shunting_yard.BinaryOperation", "shunting_yard.Ident", "*shunting_yard.Number",
+		"shunting_yard.VarAssing",
+		"*shunting_yard.BinaryOperation", "*shunting_yard.Number", "*shunting_yard.Number",
+	}, visited)
+}
+
+func TestRewrite(t *testing.T) {
+	tree := Conditional{
+		condition: &BinaryOperation{Op: Add, Lhs: &Number{Value: 1}, Rhs: &Number{Value: 2}},
+		truePath:  []Statement{&Number{Value: 5}, Loop{}},
+	}
+
+	// fold the sums and drop the loops
+	out := Rewrite(tree, func(node Node) Node {
+		switch n := node.(type) {
+		case *BinaryOperation:
+			l, lok := n.Lhs.(*Number)
+			r, rok := n.Rhs.(*Number)
+			if lok && rok && n.Op == Add {
+				return &Number{Value: l.Value + r.Value}
+			}
+		case Loop:
+			return nil
+		}
+		return node
+	})
+	require.Equal(t, Conditional{condition: &Number{Value: 3}, truePath: []Statement{&Number{Value: 5}}}, out)
+	require.Len(t, tree.truePath, 2)
+
+	require.PanicsWithValue(t, "shunting_yard.Rewrite: shunting_yard.Loop does not fit in place of *shunting_yard.Number", func() {
+		Rewrite(&BinaryOperation{Lhs: &Number{}, Rhs: &Number{}}, func(node Node) Node {
+			if _, ok := node.(*Number); ok {
+				return Loop{}
+			}
+			return node
+		})
+	})
+}
@@ -0,0 +1,656 @@
+package types
+
+import (
+	"fmt"
+	"math"
+	"stag/pratt_parser/ast"
+)
+
+type ObjectKind uint8
+
+const (
+	Var ObjectKind = iota
+	Param
+	Func
+)
+
+func (k ObjectKind) String() string {
+	switch k {
+	case Var:
+		return "variable"
+	case Param:
+		return "parameter"
+	case Func:
+		return "function"
+	default:
+		return fmt.Sprintf("ObjectKind(%d)", k)
+	}
+}
+
+// Object is a named entity declared by the program
+type Object struct {
+	Kind ObjectKind
+	Name string
+	Type Type
+	Decl *ast.Identifier
+}
+
+// Info holds the results of type checking a program
+type Info struct {
+	// Types maps every checked expression to its type, untyped
+	// constants get the type they were converted to
+	Types map[ast.Expression]Type
+
+	// Values holds the value of constant integer expressions
+	Values map[ast.Expression]int64
+
+	// Defs maps the identifiers that declare something to the
+	// declared object, Uses maps every other identifier to it
+	Defs map[*ast.Identifier]*Object
+	Uses map[*ast.Identifier]*Object
+}
+
+// TypeOf returns the type of the expression or nil if unknown
+func (info *Info) TypeOf(e ast.Expression) Type {
+	return info.Types[e]
+}
+
+// ObjectOf returns the object the identifier declares or refers to
+func (info *Info) ObjectOf(id *ast.Identifier) *Object {
+	if obj, ok := info.Defs[id]; ok {
+		return obj
+	}
+	return info.Uses[id]
+}
+
+type Error struct {
+	Line   int
+	Column int
+	Code   string
+	Msg    string
+}
+
+// the codes of the errors of the checker, the E02 block, and of
+// the constants, the E03 block the folding reports as well
+const (
+	CodeRedeclared     = "E0201" // a name declared twice in a block
+	CodeUnknownType    = "E0202" // a type name that does not exist
+	CodeNoValue        = "E0203" // a call without result used as a value
+	CodeUndefined      = "E0204" // a name that is not declared
+	CodeAssignFunction = "E0205" // a function on the left of =
+	CodeReturn         = "E0206" // a return that does not fit its function
+	CodeNested         = "E0207" // a function inside a block
+	CodeMissingReturn  = "E0208" // a function that can end without return
+	CodeCondition      = "E0209" // a condition that is not a bool
+	CodeFunctionValue  = "E0210" // a function used as a value
+	CodeOperator       = "E0211" // an operator not defined on its operands
+	CodeShiftCount     = "E0212" // a constant shift past the width
+	CodeMismatched     = "E0213" // operands of different types
+	CodeNotFunction    = "E0214" // a call of something else
+	CodeArguments      = "E0215" // a call with the wrong number of arguments
+	CodeAssignable     = "E0216" // a value of the wrong type
+	CodeOverflow       = "E0217" // a constant that does not fit its type
+	CodeInternal       = "E0299" // a node the checker does not know
+
+	CodeDivisionByZero = "E0301" // a division by a constant zero
+)
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Msg)
+}
+
+type scope struct {
+	parent  *scope
+	objects map[string]*Object
+}
+
+func newScope(parent *scope) *scope {
+	return &scope{parent: parent, objects: map[string]*Object{}}
+}
+
+func (s *scope) lookup(name string) *Object {
+	for ; s != nil; s = s.parent {
+		if obj, ok := s.objects[name]; ok {
+			return obj
+		}
+	}
+	return nil
+}
+
+type checker struct {
+	info   *Info
+	errors []*Error
+	scope  *scope
+
+	// fn is the signature of the function being checked,
+	// nil while checking top level statements
+	fn *Signature
+}
+
+// Check type checks the program, the returned Info is
+// filled even when errors are found
+func Check(program *ast.Program) (*Info, []*Error) {
+	c := &checker{
+		info: &Info{
+			Types:  map[ast.Expression]Type{},
+			Values: map[ast.Expression]int64{},
+			Defs:   map[*ast.Identifier]*Object{},
+			Uses:   map[*ast.Identifier]*Object{},
+		},
+		scope: newScope(nil),
+	}
+
+	// functions can be called before their declaration
+	for _, stmt := range program.Statements {
+		if fn, ok := stmt.(*ast.FunctionStatement); ok {
+			c.declare(&Object{Kind: Func, Name: fn.Name.Value, Type: c.signature(fn), Decl: fn.Name})
+		}
+	}
+
+	for _, stmt := range program.Statements {
+		c.stmt(stmt)
+	}
+
+	return c.info, c.errors
+}
+
+func (c *checker) errorf(node ast.Node, code, format string, args ...any) {
+	line, column := ast.Pos(node)
+	c.errors = append(c.errors, &Error{Line: line, Column: column, Code: code, Msg: fmt.Sprintf(format, args...)})
+}
+
+func (c *checker) declare(obj *Object) {
+	if _, exists := c.scope.objects[obj.Name]; exists {
+		c.errorf(obj.Decl, CodeRedeclared, "%s redeclared in this block", obj.Name)
+	}
+	c.scope.objects[obj.Name] = obj
+	c.info.Defs[obj.Decl] = obj
+}
+
+func (c *checker) typeName(id *ast.Identifier) Type {
+	t, ok := Lookup(id.Value)
+	if !ok {
+		c.errorf(id, CodeUnknownType, "unknown type %s", id.Value)
+		return Invalid
+	}
+	return t
+}
+
+func (c *checker) signature(fn *ast.FunctionStatement) *Signature {
+	sig := &Signature{Result: Unit}
+	for _, p := range fn.Parameters {
+		sig.Params = append(sig.Params, c.typeName(p.Type))
+	}
+	if fn.ReturnType != nil {
+		sig.Result = c.typeName(fn.ReturnType)
+	}
+	return sig
+}
+
+func (c *checker) openScope()  { c.scope = newScope(c.scope) }
+func (c *checker) closeScope() { c.scope = c.scope.parent }
+
+func (c *checker) stmt(stmt ast.Statement) {
+	switch s := stmt.(type) {
+	case *ast.LetStatement:
+		t := c.expr(s.Value)
+		if s.Type != nil {
+			declared := c.typeName(s.Type)
+			c.assignable(s.Value, t, declared, "variable declaration")
+			t = declared
+		} else {
+			t = c.defaultType(s.Value, t)
+		}
+
+		if t == Unit {
+			c.errorf(s.Value, CodeNoValue, "%s (no value) used as value", s.Value)
+			t = Invalid
+		}
+		c.declare(&Object{Kind: Var, Name: s.Name.Value, Type: t, Decl: s.Name})
+
+	case *ast.AssignStatement:
+		t := c.expr(s.Value)
+		obj := c.scope.lookup(s.Name.Value)
+		if obj == nil {
+			c.errorf(s.Name, CodeUndefined, "undefined: %s", s.Name.Value)
+			return
+		}
+		c.info.Uses[s.Name] = obj
+		if obj.Kind == Func {
+			c.errorf(s.Name, CodeAssignFunction, "cannot assign to function %s", s.Name.Value)
+			return
+		}
+		c.assignable(s.Value, t, obj.Type, "assignment")
+
+	case *ast.ReturnStatement:
+		if c.fn == nil {
+			c.errorf(s, CodeReturn, "return outside function")
+			if s.ReturnValue != nil {
+				c.defaultType(s.ReturnValue, c.expr(s.ReturnValue))
+			}
+			return
+		}
+
+		if s.ReturnValue == nil {
+			if c.fn.Result != Unit {
+				c.errorf(s, CodeReturn, "missing return value, want %s", c.fn.Result)
+			}
+			return
+		}
+
+		t := c.expr(s.ReturnValue)
+		if c.fn.Result == Unit {
+			c.errorf(s.ReturnValue, CodeReturn, "too many return values, function returns no value")
+			return
+		}
+		c.assignable(s.ReturnValue, t, c.fn.Result, "return statement")
+
+	case *ast.ExpressionStatement:
+		c.defaultType(s.Expression, c.expr(s.Expression))
+
+	case *ast.BlockStatement:
+		c.openScope()
+		for _, inner := range s.Statements {
+			c.stmt(inner)
+		}
+		c.closeScope()
+
+	case *ast.IfStatement:
+		c.condition(s.Condition, "if")
+		c.stmt(s.Consequence)
+		if s.Alternative != nil {
+			c.stmt(s.Alternative)
+		}
+
+	case *ast.WhileStatement:
+		c.condition(s.Condition, "while")
+		c.stmt(s.Body)
+
+	case *ast.FunctionStatement:
+		c.function(s)
+
+	default:
+		c.errorf(stmt, CodeInternal, "unexpected statement %T", stmt)
+	}
+}
+
+func (c *checker) function(fn *ast.FunctionStatement) {
+	if c.fn != nil || c.scope.parent != nil {
+		c.errorf(fn, CodeNested, "functions must be declared at the top level")
+		return
+	}
+
+	sig := c.info.Defs[fn.Name].Type.(*Signature)
+
+	c.fn = sig
+	c.openScope()
+	for i, p := range fn.Parameters {
+		c.declare(&Object{Kind: Param, Name: p.Name.Value, Type: sig.Params[i], Decl: p.Name})
+	}
+	// the body shares the scope of the parameters
+	for _, stmt := range fn.Body.Statements {
+		c.stmt(stmt)
+	}
+	c.closeScope()
+	c.fn = nil
+
+	if sig.Result != Unit && !terminates(fn.Body) {
+		c.errorf(fn, CodeMissingReturn, "missing return at the end of function %s", fn.Name.Value)
+	}
+}
+
+// terminates reports if the statement always ends with a return
+func terminates(stmt ast.Statement) bool {
+	switch s := stmt.(type) {
+	case *ast.ReturnStatement:
+		return true
+	case *ast.BlockStatement:
+		return len(s.Statements) > 0 && terminates(s.Statements[len(s.Statements)-1])
+	case *ast.IfStatement:
+		return s.Alternative != nil && terminates(s.Consequence) && terminates(s.Alternative)
+	}
+	return false
+}
+
+func (c *checker) condition(e ast.Expression, context string) {
+	t := c.expr(e)
+	if t != Bool && t != Invalid {
+		c.errorf(e, CodeCondition, "non-bool %s (type %s) used as %s condition", e, t, context)
+		c.defaultType(e, t)
+	}
+}
+
+func (c *checker) record(e ast.Expression, t Type) Type {
+	c.info.Types[e] = t
+	return t
+}
+
+func (c *checker) constant(e ast.Expression, value int64) Type {
+	c.info.Values[e] = value
+	return c.record(e, UntypedInt)
+}
+
+func (c *checker) expr(e ast.Expression) Type {
+	switch v := e.(type) {
+	case *ast.IntegerLiteral:
+		return c.constant(v, v.Value)
+
+	case *ast.Boolean:
+		return c.record(v, Bool)
+
+	case *ast.Identifier:
+		obj := c.scope.lookup(v.Value)
+		if obj == nil {
+			c.errorf(v, CodeUndefined, "undefined: %s", v.Value)
+			return c.record(v, Invalid)
+		}
+		c.info.Uses[v] = obj
+		if obj.Kind == Func {
+			c.errorf(v, CodeFunctionValue, "function %s used as value", v.Value)
+			return c.record(v, Invalid)
+		}
+		return c.record(v, obj.Type)
+
+	case *ast.PrefixExpression:
+		return c.prefix(v)
+
+	case *ast.InfixExpression:
+		return c.infix(v)
+
+	case *ast.CallExpression:
+		return c.call(v)
+	}
+
+	c.errorf(e, CodeInternal, "unexpected expression %T", e)
+	return Invalid
+}
+
+func (c *checker) prefix(e *ast.PrefixExpression) Type {
+	t := c.expr(e.Right)
+	if t == Invalid {
+		return c.record(e, Invalid)
+	}
+
+	switch e.Operator {
+	case "!":
+		if t != Bool {
+			c.errorf(e, CodeOperator, "operator ! not defined on %s (type %s)", e.Right, t)
+			c.defaultType(e.Right, t)
+			return c.record(e, Invalid)
+		}
+		return c.record(e, Bool)
+
+	case "-":
+		if t == UntypedInt {
+			value := c.info.Values[e.Right]
+			if value == math.MinInt64 {
+				c.errorf(e, CodeOverflow, "constant %s overflows 64 bits", e)
+				return c.record(e, Invalid)
+			}
+			return c.constant(e, -value)
+		}
+		if !IsSigned(t) {
+			c.errorf(e, CodeOperator, "operator - not defined on %s (type %s)", e.Right, t)
+			return c.record(e, Invalid)
+		}
+		return c.record(e, t)
+	}
+
+	c.errorf(e, CodeInternal, "unknown operator %s", e.Operator)
+	return c.record(e, Invalid)
+}
+
+func (c *checker) infix(e *ast.InfixExpression) Type {
+	lt := c.expr(e.Left)
+	rt := c.expr(e.Right)
+
+	switch e.Operator {
+	case "and", "or":
+		for _, side := range []struct {
+			e ast.Expression
+			t Type
+		}{{e.Left, lt}, {e.Right, rt}} {
+			if side.t != Bool && side.t != Invalid {
+				c.errorf(side.e, CodeOperator, "operator %s not defined on %s (type %s)", e.Operator, side.e, side.t)
+				c.defaultType(side.e, side.t)
+			}
+		}
+		return c.record(e, Bool)
+	}
+
+	if lt == Invalid || rt == Invalid {
+		c.defaultType(e.Left, lt)
+		c.defaultType(e.Right, rt)
+		return c.record(e, Invalid)
+	}
+
+	if lt == UntypedInt && rt == UntypedInt && isArithmetic(e.Operator) {
+		l, r := c.info.Values[e.Left], c.info.Values[e.Right]
+		switch {
+		case (e.Operator == "<<" || e.Operator == ">>") && (r < 0 || r >= 64):
+			c.errorf(e.Right, CodeShiftCount, "invalid shift count %d", r)
+			return c.record(e, Invalid)
+		case e.Operator == "/" && r == 0:
+			c.errorf(e.Right, CodeDivisionByZero, "division by zero")
+			return c.record(e, Invalid)
+		}
+		value, ok := fold(e.Operator, l, r)
+		if !ok {
+			c.errorf(e, CodeOverflow, "constant %s overflows 64 bits", e)
+			return c.record(e, Invalid)
+		}
+		return c.constant(e, value)
+	}
+
+	t := c.unify(e, lt, rt)
+	if t == Invalid {
+		return c.record(e, Invalid)
+	}
+
+	switch e.Operator {
+	case "+", "-", "*", "/", "<<", ">>":
+		if !IsInteger(t) {
+			c.errorf(e, CodeOperator, "operator %s not defined on %s (type %s)", e.Operator, e.Left, t)
+			return c.record(e, Invalid)
+		}
+		// a constant count shifts every bit out of the operand
+		// or goes the other way
+		count, constant := c.info.Values[e.Right]
+		if (e.Operator == "<<" || e.Operator == ">>") && constant && (count < 0 || count >= int64(t.(*Basic).Bits())) {
+			c.errorf(e.Right, CodeShiftCount, "shift count %d out of range for %s", count, t)
+			return c.record(e, Invalid)
+		}
+		return c.record(e, t)
+
+	case "<", ">", "<=", ">=":
+		if !IsInteger(t) {
+			c.errorf(e, CodeOperator, "operator %s not defined on %s (type %s)", e.Operator, e.Left, t)
+		}
+		return c.record(e, Bool)
+
+	case "==", "!=":
+		if !IsInteger(t) && t != Bool {
+			c.errorf(e, CodeOperator, "operator %s not defined on %s (type %s)", e.Operator, e.Left, t)
+		}
+		return c.record(e, Bool)
+	}
+
+	c.errorf(e, CodeInternal, "unknown operator %s", e.Operator)
+	return c.record(e, Invalid)
+}
+
+// fold computes the arithmetic of two untyped constants, ok is false
+// when the exact result does not fit in 64 bits
+func fold(op string, l, r int64) (int64, bool) {
+	switch op {
+	case "+":
+		sum := l + r
+		return sum, (sum > l) == (r > 0)
+	case "-":
+		diff := l - r
+		return diff, (diff < l) == (r > 0)
+	case "*":
+		if l == 0 || r == 0 {
+			return 0, true
+		}
+		product := l * r
+		return product, product/r == l && !(l == -1 && r == math.MinInt64) && !(r == -1 && l == math.MinInt64)
+	case "/":
+		return l / r, !(l == math.MinInt64 && r == -1)
+	case "<<":
+		shifted := l << r
+		return shifted, shifted>>r == l
+	case ">>":
+		return l >> r, true
+	}
+	return 0, false
+}
+
+func isArithmetic(op string) bool {
+	switch op {
+	case "+", "-", "*", "/", "<<", ">>":
+		return true
+	}
+	return false
+}
+
+// unify returns the type both operands of e share, untyped
+// constants take the type of the other operand
+func (c *checker) unify(e *ast.InfixExpression, lt, rt Type) Type {
+	switch {
+	case lt == UntypedInt && rt == UntypedInt:
+		c.defaultType(e.Left, lt)
+		c.defaultType(e.Right, rt)
+		return Default
+	case lt == UntypedInt:
+		if !c.convertUntyped(e.Left, rt) {
+			return Invalid
+		}
+		return rt
+	case rt == UntypedInt:
+		if !c.convertUntyped(e.Right, lt) {
+			return Invalid
+		}
+		return lt
+	}
+
+	if !Identical(lt, rt) {
+		c.errorf(e, CodeMismatched, "mismatched types %s and %s in %s", lt, rt, e)
+		return Invalid
+	}
+	return lt
+}
+
+func (c *checker) call(e *ast.CallExpression) Type {
+	for _, arg := range e.Arguments {
+		c.expr(arg)
+	}
+
+	id, ok := e.Function.(*ast.Identifier)
+	if !ok {
+		c.errorf(e, CodeNotFunction, "cannot call non-function %s", e.Function)
+		return c.record(e, Invalid)
+	}
+
+	obj := c.scope.lookup(id.Value)
+	if obj == nil {
+		c.errorf(id, CodeUndefined, "undefined: %s", id.Value)
+		return c.record(e, Invalid)
+	}
+	c.info.Uses[id] = obj
+
+	sig, ok := obj.Type.(*Signature)
+	if !ok {
+		c.errorf(id, CodeNotFunction, "cannot call non-function %s (%s of type %s)", id.Value, obj.Kind, obj.Type)
+		return c.record(e, Invalid)
+	}
+	c.record(id, sig)
+
+	if len(e.Arguments) != len(sig.Params) {
+		have := make([]Type, 0, len(e.Arguments))
+		for _, arg := range e.Arguments {
+			have = append(have, c.defaultType(arg, c.info.Types[arg]))
+		}
+		which := "not enough"
+		if len(e.Arguments) > len(sig.Params) {
+			which = "too many"
+		}
+		c.errorf(e, CodeArguments, "%s arguments in call to %s\n\thave %s\n\twant %s",
+			which, id.Value, tupleString(have), tupleString(sig.Params))
+		return c.record(e, sig.Result)
+	}
+
+	for i, arg := range e.Arguments {
+		c.assignable(arg, c.info.Types[arg], sig.Params[i], "argument to "+id.Value)
+	}
+	return c.record(e, sig.Result)
+}
+
+func tupleString(ts []Type) string {
+	return (&Signature{Params: ts, Result: Unit}).String()[len("fn"):]
+}
+
+// assignable reports an error when a value of type t cannot be used
+// where target is expected, untyped constants are converted to target
+func (c *checker) assignable(e ast.Expression, t, target Type, context string) {
+	if t == Invalid || target == Invalid {
+		return
+	}
+
+	if t == UntypedInt {
+		c.convertUntyped(e, target)
+		return
+	}
+
+	if !Identical(t, target) {
+		c.errorf(e, CodeAssignable, "cannot use %s (type %s) as %s value in %s", e, t, target, context)
+	}
+}
+
+// convertUntyped gives the untyped constant e, and the untyped
+// operands it was computed from, the type target
+func (c *checker) convertUntyped(e ast.Expression, target Type) bool {
+	if c.info.Types[e] != UntypedInt {
+		return true
+	}
+
+	value := c.info.Values[e]
+	if !IsInteger(target) {
+		c.errorf(e, CodeAssignable, "cannot use %s (untyped int constant) as %s value", e, target)
+		c.setUntypedType(e, Default)
+		return false
+	}
+
+	if b := target.(*Basic); !b.Representable(value) {
+		c.errorf(e, CodeOverflow, "constant %d overflows %s", value, target)
+		c.setUntypedType(e, target)
+		return false
+	}
+
+	c.setUntypedType(e, target)
+	return true
+}
+
+func (c *checker) setUntypedType(e ast.Expression, target Type) {
+	if c.info.Types[e] != UntypedInt {
+		return
+	}
+
+	c.info.Types[e] = target
+	switch v := e.(type) {
+	case *ast.InfixExpression:
+		c.setUntypedType(v.Left, target)
+		c.setUntypedType(v.Right, target)
+	case *ast.PrefixExpression:
+		c.setUntypedType(v.Right, target)
+	}
+}
+
+// defaultType converts an untyped constant to the Default type
+func (c *checker) defaultType(e ast.Expression, t Type) Type {
+	if t != UntypedInt {
+		return t
+	}
+	c.convertUntyped(e, Default)
+	return Default
+}
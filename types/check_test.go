@@ -0,0 +1,191 @@
+package types
+
+import (
+	"stag/lexer"
+	"stag/pratt_parser"
+	"stag/pratt_parser/ast"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+
+	p := pratt_parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	require.Empty(t, p.Errors())
+	return program
+}
+
+func errorStrings(errs []*Error) []string {
+	out := make([]string, 0, len(errs))
+	for _, err := range errs {
+		out = append(out, err.Error())
+	}
+	return out
+}
+
+func TestCheckValidPrograms(t *testing.T) {
+	tests := []string{
+		"let x = 55090 + 5;",
+		"let x: u8 = 255; let y = x + 1;",
+		"let x: i16 = -5; let y: i16 = x * -2 / 3;",
+		"let ok = 1 < 2 and true != false;",
+		"let big: u16 = 300 - 100; let small: u8 = 300 - 100;",
+		`fn add(a: u16, b: u16) -> u16 {
+			return a + b;
+		}
+		let r = add(1, 2);`,
+		`let r = fact(5);
+		fn fact(n: u16) -> u16 {
+			if n <= 1 {
+				return 1;
+			} else {
+				return n * fact(n - 1);
+			}
+		}`,
+		`fn count(n: u8) {
+			let i: u8 = 0;
+			while i < n {
+				i = i + 1;
+			}
+		}
+		count(3);`,
+		"let x = 1; { let x = true; }",
+		"let s: i16 = -8 >> 1; let u: u8 = 1 << 7;",
+	}
+
+	for _, input := range tests {
+		_, errs := Check(parse(t, input))
+		require.Empty(t, errorStrings(errs), input)
+	}
+}
+
+func TestCheckErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"let x: u8 = 256;", []string{"1:13: constant 256 overflows u8"}},
+		{"let x = -1;", []string{"1:9: constant -1 overflows u16"}},
+		{"let x: u8 = 1; let y: u16 = 2; x + y;", []string{"1:32: mismatched types u8 and u16 in (x + y)"}},
+		{"let x: u16 = true;", []string{"1:14: cannot use true (type bool) as u16 value in variable declaration"}},
+		{"let b: bool = 1;", []string{"1:15: cannot use 1 (untyped int constant) as bool value"}},
+		{"let x: u32 = 1;", []string{"1:8: unknown type u32"}},
+		{"y + 1;", []string{"1:1: undefined: y"}},
+		{"let x: u16 = 1; -x;", []string{"1:17: operator - not defined on x (type u16)"}},
+		{"!5;", []string{"1:1: operator ! not defined on 5 (type untyped int)"}},
+		{"if 1 { }", []string{"1:4: non-bool 1 (type untyped int) used as if condition"}},
+		{"while 1 + 1 { }", []string{"1:7: non-bool (1 + 1) (type untyped int) used as while condition"}},
+		{"let x = 1 / 0;", []string{"1:13: division by zero"}},
+		{"let x = 1 << 70;", []string{"1:14: invalid shift count 70"}},
+		{"true << 1;", []string{"1:9: cannot use 1 (untyped int constant) as bool value"}},
+		{"let x = 1; let x = 2;", []string{"1:16: x redeclared in this block"}},
+		{"let x = 1; x = true;", []string{"1:16: cannot use true (type bool) as u16 value in assignment"}},
+		{"return 1;", []string{"1:1: return outside function"}},
+		{
+			"fn f(a: u16) -> bool { return a; }",
+			[]string{"1:31: cannot use a (type u16) as bool value in return statement"},
+		},
+		{
+			"fn f() -> u16 { let x = 1; }",
+			[]string{"1:1: missing return at the end of function f"},
+		},
+		{
+			"fn f() { return 1; }",
+			[]string{"1:17: too many return values, function returns no value"},
+		},
+		{
+			"fn f(a: u16, b: bool) -> u16 { return a; } f(1);",
+			[]string{"1:44: not enough arguments in call to f\n\thave (u16)\n\twant (u16, bool)"},
+		},
+		{
+			"fn f(a: u8) -> u8 { return a; } f(300);",
+			[]string{"1:35: constant 300 overflows u8"},
+		},
+		{
+			"let x = 1; x(2);",
+			[]string{"1:12: cannot call non-function x (variable of type u16)"},
+		},
+		{
+			"fn f() {} let x = f();",
+			[]string{"1:19: f() (no value) used as value"},
+		},
+		{
+			"fn f() { fn g() {} }",
+			[]string{"1:10: functions must be declared at the top level"},
+		},
+	}
+
+	for _, tt := range tests {
+		_, errs := Check(parse(t, tt.input))
+		require.Equal(t, tt.expected, errorStrings(errs), tt.input)
+	}
+}
+
+func TestErrorCodes(t *testing.T) {
+	tests := map[string]string{
+		"let x: u8 = 256;":             CodeOverflow,
+		"y + 1;":                       CodeUndefined,
+		"if 1 { }":                     CodeCondition,
+		"let x = 1 / 0;":               CodeDivisionByZero,
+		"let x = 1; let x = 2;":        CodeRedeclared,
+		"fn f() -> u16 { let x = 1; }": CodeMissingReturn,
+		"fn f() { fn g() {} }":         CodeNested,
+		"let x: u16 = 4294967296 * 4294967296 + 7;": CodeOverflow,
+		"let x = 9223372036854775807 + 1;":          CodeOverflow,
+		"let x = 0 - 9223372036854775807 - 2;":      CodeOverflow,
+		"let x = 3 << 62;":                          CodeOverflow,
+		"let a: u16 = 1; a << 16;":                  CodeShiftCount,
+		"let a: i16 = 1; a >> 16;":                  CodeShiftCount,
+		"1 << 64;":                                  CodeShiftCount,
+	}
+	for input, code := range tests {
+		_, errs := Check(parse(t, input))
+		require.Len(t, errs, 1, input)
+		require.Equal(t, code, errs[0].Code, input)
+	}
+}
+
+func TestCheckInfo(t *testing.T) {
+	program := parse(t, `
+		let a: u8 = 3;
+		let b = a + 1;
+		let c: i16 = 0 - 6;
+		let d = c / 2;
+		let e = 5 * 2;
+		let f = d < -1;
+	`)
+
+	info, errs := Check(program)
+	require.Empty(t, errs)
+
+	typeOf := func(i int) Type {
+		return info.TypeOf(program.Statements[i].(*ast.LetStatement).Value)
+	}
+
+	require.Equal(t, U8, typeOf(0))
+	require.Equal(t, U8, typeOf(1))
+	require.Equal(t, I16, typeOf(2))
+	require.Equal(t, I16, typeOf(3))
+	require.Equal(t, U16, typeOf(4))
+	require.Equal(t, Bool, typeOf(5))
+
+	// the untyped operand takes the type of the typed one
+	b := program.Statements[1].(*ast.LetStatement).Value.(*ast.InfixExpression)
+	require.Equal(t, U8, info.TypeOf(b.Right))
+
+	e := program.Statements[4].(*ast.LetStatement)
+	require.Equal(t, int64(10), info.Values[e.Value])
+	require.Equal(t, Var, info.ObjectOf(e.Name).Kind)
+	require.Equal(t, U16, info.ObjectOf(e.Name).Type)
+}
+
+func TestWrap(t *testing.T) {
+	require.Equal(t, int64(0), U16.Wrap(65536))
+	require.Equal(t, int64(55095), U16.Wrap(55095))
+	require.Equal(t, int64(-1), I16.Wrap(65535))
+	require.Equal(t, int64(32767), I16.Wrap(-32769))
+	require.Equal(t, int64(44), U8.Wrap(300))
+}
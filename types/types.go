@@ -0,0 +1,143 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Type interface {
+	String() string
+}
+
+// Basic is one of the predeclared types, they are compared by identity
+type Basic struct {
+	name   string
+	bits   int
+	signed bool
+}
+
+var (
+	// Invalid is the type of expressions that failed to type check,
+	// it is compatible with everything to avoid cascading errors
+	Invalid = &Basic{name: "invalid"}
+
+	// UntypedInt is the type of integer constants that were not bound
+	// to a concrete type yet, e.g. the literal 5 in 5 + 1
+	UntypedInt = &Basic{name: "untyped int", signed: true}
+
+	Unit = &Basic{name: "unit"}
+	Bool = &Basic{name: "bool", bits: 1}
+	U8   = &Basic{name: "u8", bits: 8}
+	U16  = &Basic{name: "u16", bits: 16}
+	I16  = &Basic{name: "i16", bits: 16, signed: true}
+)
+
+// Default is the type given to untyped constants when
+// the context does not ask for any specific type
+var Default = U16
+
+var named = map[string]*Basic{
+	"u8":   U8,
+	"u16":  U16,
+	"i16":  I16,
+	"bool": Bool,
+}
+
+// Lookup returns the type declared by a type name such as u16
+func Lookup(name string) (Type, bool) {
+	t, ok := named[name]
+	return t, ok
+}
+
+func (b *Basic) String() string { return b.name }
+
+func (b *Basic) Bits() int { return b.bits }
+
+func (b *Basic) IsSigned() bool { return b.signed }
+
+func (b *Basic) IsInteger() bool {
+	return b == U8 || b == U16 || b == I16 || b == UntypedInt
+}
+
+// Representable reports if the constant value fits in the type
+func (b *Basic) Representable(value int64) bool {
+	switch {
+	case b == UntypedInt:
+		return true
+	case !b.IsInteger():
+		return false
+	case b.signed:
+		return value >= -(1<<(b.bits-1)) && value < 1<<(b.bits-1)
+	default:
+		return value >= 0 && value < 1<<b.bits
+	}
+}
+
+// Wrap truncates the value to the width of the type the same way
+// the target machine does, signed types are sign extended back
+func (b *Basic) Wrap(value int64) int64 {
+	if !b.IsInteger() || b == UntypedInt {
+		return value
+	}
+
+	mask := int64(1)<<b.bits - 1
+	value &= mask
+	if b.signed && value&(1<<(b.bits-1)) != 0 {
+		value -= 1 << b.bits
+	}
+	return value
+}
+
+// Signature is the type of a function declaration
+type Signature struct {
+	Params []Type
+	Result Type
+}
+
+func (s *Signature) String() string {
+	params := make([]string, 0, len(s.Params))
+	for _, p := range s.Params {
+		params = append(params, p.String())
+	}
+
+	out := fmt.Sprintf("fn(%s)", strings.Join(params, ", "))
+	if s.Result != Unit {
+		out += " -> " + s.Result.String()
+	}
+	return out
+}
+
+// IsInteger reports if t is one of the integer types
+func IsInteger(t Type) bool {
+	b, ok := t.(*Basic)
+	return ok && b.IsInteger()
+}
+
+// IsSigned reports if t is a signed integer type
+func IsSigned(t Type) bool {
+	b, ok := t.(*Basic)
+	return ok && b.IsInteger() && b.signed
+}
+
+// Identical reports if both types are the same
+func Identical(a, b Type) bool {
+	if a == b {
+		return true
+	}
+
+	sa, ok := a.(*Signature)
+	if !ok {
+		return false
+	}
+	sb, ok := b.(*Signature)
+	if !ok || len(sa.Params) != len(sb.Params) || !Identical(sa.Result, sb.Result) {
+		return false
+	}
+
+	for i := range sa.Params {
+		if !Identical(sa.Params[i], sb.Params[i]) {
+			return false
+		}
+	}
+	return true
+}